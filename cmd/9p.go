@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"immufs/pkg/p9"
+
+	"github.com/spf13/cobra"
+)
+
+var p9Cmd = &cobra.Command{
+	Use:   "9p",
+	Short: "serve the filesystem over 9P",
+	Long: `expose the filesystem over the 9P2000.L protocol, without FUSE: it can be mounted by the v9fs client
+of Linux, e.g. inside a container or WSL2, with:
+
+  mount -t 9p -o trans=unix,version=9p2000.L /run/immufs/9p.sock <mountpoint>`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := readFlags(cmd.Flags()); err != nil {
+			return err
+		}
+		logger := newLogger()
+		if cfg.LogFile != "" {
+			fh, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			defer fh.Close()
+			logger.SetOutput(fh)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		_, filesystem, err := newFileSystem(ctx, &cfg, logger)
+		if err != nil {
+			return err
+		}
+		defer filesystem.Destroy()
+
+		return p9.NewServer(filesystem, logger).Serve(ctx, cfg.P9Listen)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(p9Cmd)
+}
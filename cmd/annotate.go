@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"immufs/pkg/fs"
+	"immufs/pkg/historyapi"
+
+	"github.com/spf13/cobra"
+)
+
+var annotateCmd = &cobra.Command{
+	Use:   "annotate <path> [<point>]",
+	Short: "attribute each line of a text file to the transaction which last wrote it",
+	Long: `print each line of a text file, currently or as of a transaction number or a snapshot name, together with the
+transaction which last wrote it, its date and who wrote it, if recorded in the audit table, by replaying the revisions
+of the file. The lines of the oldest revision kept in the history (see gc) are attributed to it. The lines written by
+journaled writes not yet compacted (see --journal) are attributed to the journal, and the contents inlined in the
+inodes (see --inline-threshold) have no revisions to replay.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(ctx)
+
+		var tx uint64
+		if len(args) > 1 {
+			if tx, err = resolveTx(ctx, idb, args[1]); err != nil {
+				return err
+			}
+		} else if tx, err = idb.CurrentTx(ctx); err != nil {
+			return err
+		}
+		inode, err := idb.LookUpPathAsOf(ctx, args[0], tx)
+		if err != nil {
+			return err
+		}
+		if inode.Attributes().Mode.IsDir() {
+			return fmt.Errorf("%s is a directory", args[0])
+		}
+
+		revs, err := idb.RevisionsBetween(ctx, inode.Inumber, false, 1, tx)
+		if err != nil {
+			return err
+		}
+		contents := make([][]byte, len(revs))
+		for i, rev := range revs {
+			if contents[i], err = idb.ReadContentAt(ctx, inode.Inumber, rev.Tx); err != nil {
+				return err
+			}
+		}
+		// The writes journaled since the last revision, and the contents inlined in the inodes (see
+		// --inline-threshold), are not revisions of their own.
+		content, err := idb.ReadContentAt(ctx, inode.Inumber, tx)
+		if err != nil {
+			return err
+		}
+		if len(contents) == 0 || !bytes.Equal(content, contents[len(contents)-1]) {
+			contents = append(contents, content)
+		}
+
+		lines, origins, binary := historyapi.Annotate(contents)
+		if binary {
+			return fmt.Errorf("%s is not a text file", args[0])
+		}
+
+		unrecorded := "journal"
+		if len(revs) == 0 {
+			unrecorded = "inline"
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+		for i, line := range lines {
+			fmt.Fprintf(w, "%s\t%d)\t%s\n", annotation(revs, origins[i], unrecorded), i+1, strings.TrimSuffix(line, "\n"))
+		}
+
+		return w.Flush()
+	},
+}
+
+// annotation describes the revision a line comes from, given its index in revs: past them, the content is not a
+// revision of its own, and is described by unrecorded.
+func annotation(revs []fs.Revision, origin int, unrecorded string) string {
+	if origin >= len(revs) {
+		return unrecorded + "\t\t"
+	}
+	rev := revs[origin]
+	writer := "-"
+	if rev.Provenance != nil {
+		writer = fmt.Sprintf("uid=%d", rev.Provenance.Uid)
+	}
+
+	return fmt.Sprintf("TX=%d\t%s\t%s", rev.Tx, rev.Time.Format(time.RFC3339), writer)
+}
+
+func init() {
+	rootCmd.AddCommand(annotateCmd)
+}
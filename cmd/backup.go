@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup <file>",
+	Short: "dump all the tables, together with the immudb state they have been verified against",
+	Long: `write every row of the immufs tables to a file, after verifying it with immudb proofs.
+The digest of the dump is recorded in the backup table, so that it can later be proven unaltered.
+No mount should be writing the database meanwhile.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(ctx)
+
+		f, err := os.OpenFile(args[0], os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		info, err := idb.Backup(ctx, f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(args[0])
+
+			return err
+		}
+		fmt.Printf("%d rows backed up at TX=%d, digest %s\n", info.Rows, info.Tx, info.Digest)
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+}
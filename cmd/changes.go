@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+const flagChangesSinceTx = "since-tx"
+
+var changesCmd = &cobra.Command{
+	Use:   "changes",
+	Short: "list the files changed after a transaction",
+	Long: `list the files and directories created, modified or deleted after a transaction, by scanning the history
+of the tables. The last transaction listed is printed last: pass it as --since-tx to the next run to list changes incrementally.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		sinceTx, err := cmd.Flags().GetUint64(flagChangesSinceTx)
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(ctx)
+
+		changes, current, err := idb.Changes(ctx, sinceTx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "CHANGE\tINUMBER\tTYPE\tPATH")
+		for _, c := range changes {
+			kind := "file"
+			if c.Dir {
+				kind = "dir"
+			}
+			path := c.Path
+			if path == "" {
+				path = "<unreachable>"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", c.Kind, c.Inumber, kind, path)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		fmt.Printf("changes listed up to TX=%d\n", current)
+
+		return nil
+	},
+}
+
+func init() {
+	changesCmd.Flags().Uint64(flagChangesSinceTx, 0, "list the changes committed after this transaction")
+	changesCmd.MarkFlagRequired(flagChangesSinceTx)
+
+	rootCmd.AddCommand(changesCmd)
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+const flagCompactMinDeltas = "min-deltas"
+
+var compactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "fold the journaled writes into the content of the files",
+	Long: `fold the deltas journaled by the mounts (see --journal) into the content of their file, so that reading
+the files no longer needs to apply them. The content of the files is left unchanged.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		minDeltas, err := cmd.Flags().GetInt(flagCompactMinDeltas)
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(ctx)
+
+		report, err := idb.CompactJournal(ctx, minDeltas)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d deltas of %d files folded, %d left\n", report.Deltas, report.Files, report.Pending)
+
+		return nil
+	},
+}
+
+func init() {
+	compactCmd.Flags().Int(flagCompactMinDeltas, 1, "only fold the files with at least this many deltas")
+
+	rootCmd.AddCommand(compactCmd)
+}
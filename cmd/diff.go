@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"immufs/pkg/fs"
+
+	"github.com/spf13/cobra"
+)
+
+// Digits of the checksums printed by diff.
+const diffHashLen = 12
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <from> [<to>]",
+	Short: "list the paths added, removed or modified between two transactions or snapshots",
+	Long: `compare the namespace as of two points in history, each given as a transaction number or a snapshot name,
+and list the paths added, removed or modified from the first to the second, with their size and checksum (SHA-256).
+Without <to>, the first point is compared with the current state.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(ctx)
+
+		fromTx, err := resolveTx(ctx, idb, args[0])
+		if err != nil {
+			return err
+		}
+		var toTx uint64
+		if len(args) > 1 {
+			if toTx, err = resolveTx(ctx, idb, args[1]); err != nil {
+				return err
+			}
+		}
+
+		diff, err := idb.Diff(ctx, fromTx, toTx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "CHANGE\tPATH\tSIZE\tSHA256")
+		for _, e := range diff {
+			path := e.Path
+			if e.Dir {
+				path += "/"
+			}
+			var size, hash string
+			switch e.Kind {
+			case fs.DiffAdded:
+				size, hash = diffSize(e.Dir, e.NewSize), shortHash(e.NewHash)
+			case fs.DiffRemoved:
+				size, hash = diffSize(e.Dir, e.OldSize), shortHash(e.OldHash)
+			case fs.DiffModified:
+				size = fmt.Sprintf("%d -> %d", e.OldSize, e.NewSize)
+				hash = shortHash(e.OldHash) + " -> " + shortHash(e.NewHash)
+				if e.OldHash == e.NewHash {
+					hash = shortHash(e.NewHash)
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Kind, path, size, hash)
+		}
+
+		return w.Flush()
+	},
+}
+
+// resolveTx returns the transaction of a point in history: a transaction number, or the name of a snapshot.
+func resolveTx(ctx context.Context, idb *fs.ImmuDbClient, point string) (uint64, error) {
+	if tx, err := strconv.ParseUint(point, 10, 64); err == nil {
+		return tx, nil
+	}
+	snap, err := idb.GetSnapshot(ctx, point)
+	if err != nil {
+		return 0, fmt.Errorf("%s is neither a transaction nor a snapshot: %w", point, err)
+	}
+
+	return snap.Tx, nil
+}
+
+func diffSize(dir bool, size int64) string {
+	if dir {
+		return "-"
+	}
+
+	return strconv.FormatInt(size, 10)
+}
+
+func shortHash(hash string) string {
+	if len(hash) > diffHashLen {
+		return hash[:diffHashLen]
+	}
+
+	return hash
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+}
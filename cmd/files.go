@@ -0,0 +1,274 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"immufs/pkg/fs"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	flagLsLong       = "long"
+	flagRmRecursive  = "recursive"
+	flagMkdirParents = "parents"
+	flagPutMode      = "mode"
+)
+
+// The subcommands below access the filesystem by path without mounting it, e.g. where FUSE is not
+// available. They are served by the same code as a mount, so quotas, WORM, trash and auditing apply.
+var (
+	lsCmd = &cobra.Command{
+		Use:   "ls [path]",
+		Short: "list a directory",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			long, err := cmd.Flags().GetBool(flagLsLong)
+			if err != nil {
+				return err
+			}
+			name := "/"
+			if len(args) > 0 {
+				name = args[0]
+			}
+
+			return withSession(cmd.Flags(), func(ctx context.Context, s *fs.Session) error {
+				fi, err := s.Stat(ctx, name)
+				if err != nil {
+					return err
+				}
+				entries := []*fs.FileInfo{fi}
+				if fi.IsDir() {
+					if entries, err = s.ReadDir(ctx, name); err != nil {
+						return err
+					}
+				}
+
+				if !long {
+					for _, e := range entries {
+						fmt.Println(e.Name())
+					}
+
+					return nil
+				}
+				w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', tabwriter.AlignRight)
+				for _, e := range entries {
+					fmt.Fprintf(w, "%s\t%d\t%d\t%d\t %s\t %s\n", e.Mode(), e.Attributes.Uid, e.Attributes.Gid, e.Size(),
+						e.ModTime().Format(time.RFC3339), e.Name())
+				}
+
+				return w.Flush()
+			})
+		},
+	}
+
+	catCmd = &cobra.Command{
+		Use:   "cat <path>...",
+		Short: "print the content of files",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withSession(cmd.Flags(), func(ctx context.Context, s *fs.Session) error {
+				for _, name := range args {
+					content, err := s.ReadFile(ctx, name)
+					if err != nil {
+						return err
+					}
+					if _, err := os.Stdout.Write(content); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			})
+		},
+	}
+
+	putCmd = &cobra.Command{
+		Use:   "put <local file> <path>",
+		Short: "copy a local file into the filesystem",
+		Long: `copy a local file, or the standard input if "-", into the filesystem. If path is a directory, the file
+is copied into it under its local name. The content of an existing file is replaced.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			modeFlag, err := cmd.Flags().GetString(flagPutMode)
+			if err != nil {
+				return err
+			}
+			mode, err := strconv.ParseUint(modeFlag, 8, 32)
+			if err != nil {
+				return fmt.Errorf("invalid mode %q", modeFlag)
+			}
+			local, name := args[0], args[1]
+
+			var content []byte
+			if local == "-" {
+				content, err = io.ReadAll(os.Stdin)
+			} else {
+				content, err = os.ReadFile(local)
+			}
+			if err != nil {
+				return err
+			}
+			if !cmd.Flags().Changed(flagPutMode) && local != "-" {
+				fi, err := os.Stat(local)
+				if err != nil {
+					return err
+				}
+				mode = uint64(fi.Mode().Perm())
+			}
+
+			return withSession(cmd.Flags(), func(ctx context.Context, s *fs.Session) error {
+				if fi, err := s.Stat(ctx, name); err == nil && fi.IsDir() {
+					if local == "-" {
+						return fmt.Errorf("%s is a directory", name)
+					}
+					name = path.Join(name, filepath.Base(local))
+				}
+
+				return s.WriteFile(ctx, name, content, os.FileMode(mode).Perm())
+			})
+		},
+	}
+
+	getCmd = &cobra.Command{
+		Use:   "get <path> [local file]",
+		Short: "copy a file out of the filesystem",
+		Long: `copy a file out of the filesystem to a local file, named as the file by default, or to the standard
+output if "-". If the local file is a directory, the file is copied into it.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			local := path.Base(name)
+			if len(args) > 1 {
+				local = args[1]
+			}
+			if fi, err := os.Stat(local); err == nil && fi.IsDir() {
+				local = filepath.Join(local, path.Base(name))
+			}
+
+			return withSession(cmd.Flags(), func(ctx context.Context, s *fs.Session) error {
+				fi, err := s.Stat(ctx, name)
+				if err != nil {
+					return err
+				}
+				content, err := s.ReadFile(ctx, name)
+				if err != nil {
+					return err
+				}
+				if local == "-" {
+					_, err = os.Stdout.Write(content)
+
+					return err
+				}
+
+				return os.WriteFile(local, content, fi.Mode().Perm())
+			})
+		},
+	}
+
+	rmCmd = &cobra.Command{
+		Use:   "rm <path>...",
+		Short: "remove files or directories",
+		Long:  `remove files and empty directories, or directories together with their content with --recursive`,
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			recursive, err := cmd.Flags().GetBool(flagRmRecursive)
+			if err != nil {
+				return err
+			}
+
+			return withSession(cmd.Flags(), func(ctx context.Context, s *fs.Session) error {
+				for _, name := range args {
+					if recursive {
+						// Unlike rm -r, a missing path is an error.
+						if _, err := s.Stat(ctx, name); err != nil {
+							return err
+						}
+						err = s.RemoveAll(ctx, name)
+					} else {
+						err = s.Remove(ctx, name)
+					}
+					if err != nil {
+						return err
+					}
+				}
+
+				return nil
+			})
+		},
+	}
+
+	mkdirCmd = &cobra.Command{
+		Use:   "mkdir <path>...",
+		Short: "create directories",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parents, err := cmd.Flags().GetBool(flagMkdirParents)
+			if err != nil {
+				return err
+			}
+
+			return withSession(cmd.Flags(), func(ctx context.Context, s *fs.Session) error {
+				for _, name := range args {
+					if parents {
+						err = s.MkdirAll(ctx, name, 0755)
+					} else {
+						err = s.Mkdir(ctx, name, 0755)
+					}
+					if err != nil {
+						return err
+					}
+				}
+
+				return nil
+			})
+		},
+	}
+)
+
+// withSession runs fn on a session of the filesystem, for the subcommands which access files by path.
+// Only warnings are logged, unless to a log file, not to clutter the output.
+func withSession(flags *pflag.FlagSet, fn func(ctx context.Context, s *fs.Session) error) error {
+	if err := readFlags(flags); err != nil {
+		return err
+	}
+	logger := newLogger()
+	if cfg.LogFile != "" {
+		fh, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer fh.Close()
+		logger.SetOutput(fh)
+	} else {
+		logger.SetLevel(logrus.WarnLevel)
+	}
+
+	ctx := context.Background()
+	_, filesystem, err := newFileSystem(ctx, &cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer filesystem.Destroy()
+
+	return fn(ctx, fs.NewSession(filesystem))
+}
+
+func init() {
+	lsCmd.Flags().BoolP(flagLsLong, "l", false, "print the mode, owner, size and modification time of the files")
+	putCmd.Flags().String(flagPutMode, "0644", "permissions of the file, in octal (those of the local file by default)")
+	rmCmd.Flags().BoolP(flagRmRecursive, "r", false, "remove directories together with their content")
+	mkdirCmd.Flags().Bool(flagMkdirParents, false, "create the missing parent directories, and don't fail if the directory exists")
+
+	rootCmd.AddCommand(lsCmd, catCmd, putCmd, getCmd, rmCmd, mkdirCmd)
+}
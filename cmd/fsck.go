@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+const flagFsckRepair = "repair"
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "check the consistency of the filesystem",
+	Long: `look for dangling directory entries, orphan inodes, size/content mismatches and duplicate inumbers.
+With --repair they are fixed, and every repair is recorded in the audit table. No mount should be writing meanwhile.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		repair, err := cmd.Flags().GetBool(flagFsckRepair)
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(ctx)
+
+		problems, err := idb.Fsck(ctx, repair)
+		if err != nil {
+			return err
+		}
+		if len(problems) == 0 {
+			fmt.Println("no problems found")
+
+			return nil
+		}
+
+		var left int
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "PROBLEM\tINUMBER\tPARENT\tNAME\tDETAIL\tREPAIRED")
+		for _, p := range problems {
+			repaired := "no"
+			if p.Repaired {
+				repaired = "yes"
+			} else {
+				left++
+			}
+			fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\n", p.Kind, p.Inumber, p.Parent, p.Name, p.Detail, repaired)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if left > 0 {
+			return fmt.Errorf("%d problems left", left)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	fsckCmd.Flags().Bool(flagFsckRepair, false, "fix the problems found")
+
+	rootCmd.AddCommand(fsckCmd)
+}
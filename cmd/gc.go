@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"immufs/pkg/fs"
+
+	"github.com/codenotary/immudb/embedded/store"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagGCRetention = "retention"
+	flagGCInterval  = "interval"
+	flagGCDryRun    = "dry-run"
+)
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "discard the history older than the retention period",
+	Long: `rewrite the data unchanged since the retention period, then truncate the immudb history before it.
+The current content of the filesystem is preserved: only the revisions superseded before the retention
+period are discarded. Snapshots prevent the truncation. With --dry-run, nothing is written: the rules applied, the
+revisions which would be discarded and the space reclaimed are reported instead.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		retention, err := cmd.Flags().GetDuration(flagGCRetention)
+		if err != nil {
+			return err
+		}
+		interval, err := cmd.Flags().GetDuration(flagGCInterval)
+		if err != nil {
+			return err
+		}
+		dryRun, err := cmd.Flags().GetBool(flagGCDryRun)
+		if err != nil {
+			return err
+		}
+		if dryRun && interval != 0 {
+			return fmt.Errorf("--%s and --%s are exclusive", flagGCDryRun, flagGCInterval)
+		}
+
+		ctx := context.Background()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(ctx)
+
+		if dryRun {
+			return planGarbageCollection(ctx, idb, retention)
+		}
+		if interval == 0 {
+			return collectGarbage(ctx, idb, retention)
+		}
+
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			// Failed runs are retried at the next tick.
+			if err := collectGarbage(ctx, idb, retention); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			select {
+			case <-c:
+				return nil
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+func collectGarbage(ctx context.Context, idb *fs.ImmuDbClient, retention time.Duration) error {
+	report, err := idb.CollectGarbage(ctx, retention)
+	if err != nil {
+		return err
+	}
+	if len(report.HeldBy) > 0 {
+		fmt.Printf("history not truncated: held by snapshots %s\n", strings.Join(report.HeldBy, ", "))
+
+		return nil
+	}
+
+	var rewritten int
+	for _, n := range report.Rewritten {
+		rewritten += n
+	}
+	fmt.Printf("history truncated before %s (%d rows rewritten)\n", report.Cutoff.Format(time.RFC3339), rewritten)
+
+	return nil
+}
+
+// planGarbageCollection prints what a garbage collection would discard.
+func planGarbageCollection(ctx context.Context, idb *fs.ImmuDbClient, retention time.Duration) error {
+	report, err := idb.PlanGarbageCollection(ctx, retention)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("retention %s (at least %s): cutoff %s\n", retention, store.MinimumRetentionPeriod, report.Cutoff.Format(time.RFC3339))
+	if len(report.HeldBy) > 0 {
+		fmt.Printf("history would not be truncated: held by snapshots %s\n", strings.Join(report.HeldBy, ", "))
+
+		return nil
+	}
+	if report.CutoffTx == 0 {
+		fmt.Printf("no transaction before %s (cutoff less %s of clock margin): nothing to discard\n", report.Cutoff.Add(-fs.GCClockMargin).Format(time.RFC3339), fs.GCClockMargin)
+
+		return nil
+	}
+	fmt.Printf("history would be truncated up to TX=%d, committed before the cutoff less %s of clock margin\n", report.CutoffTx, fs.GCClockMargin)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "INODE\tPATH\tTX\tTIME\tSIZE\tSHA256\tACTION")
+	for _, rev := range report.Revisions {
+		path := rev.Path
+		if path == "" {
+			path = "(deleted)"
+		}
+		action := "keep (rewritten)"
+		if rev.Superseded {
+			action = "discard"
+			if rev.Locked {
+				action += " (locked file)"
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%d\t%s\t%s\n", rev.Inumber, path, rev.Tx, rev.Time.Format(time.RFC3339), rev.Size, shortHash(hex.EncodeToString(rev.SHA256[:])), action)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	var tables []string
+	var rewritten int
+	for table, n := range report.Rewritten {
+		if n > 0 {
+			tables = append(tables, table)
+			rewritten += n
+		}
+	}
+	sort.Strings(tables)
+	fmt.Printf("%d rows would be rewritten, being unchanged since the cutoff:", rewritten)
+	for _, table := range tables {
+		fmt.Printf(" %s=%d", table, report.Rewritten[table])
+	}
+	fmt.Println()
+	fmt.Printf("%d bytes of content would be reclaimed\n", report.ReclaimedBytes)
+
+	return nil
+}
+
+func init() {
+	gcCmd.Flags().Duration(flagGCRetention, 30*24*time.Hour, "history to keep (at least 24h)")
+	gcCmd.Flags().Duration(flagGCInterval, 0, "run again at every interval, until interrupted (0 runs once)")
+	gcCmd.Flags().Bool(flagGCDryRun, false, "report what would be discarded, without writing anything")
+
+	rootCmd.AddCommand(gcCmd)
+}
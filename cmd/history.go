@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"immufs/pkg/fs"
+	"immufs/pkg/timemachine"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	flagHistoryInumber  = "inumber"
+	flagHistoryTx       = "tx"
+	flagHistoryFormat   = "format"
+	flagHistoryText     = "text"
+	flagHistoryVerify   = "verify"
+	flagHistoryFrom     = "from"
+	flagHistoryTo       = "to"
+	flagHistoryInterval = "interval"
+	flagHistoryDiff     = "diff"
+)
+
+// The subcommands below are a time machine, for file content verification: they read the files as of past
+// transactions from immudb directly, without mounting the filesystem. The content is printed in the log
+// (on the standard error) in hexadecimal by default, or on the standard output with --format.
+var (
+	historyCmd = &cobra.Command{
+		Use:   "history",
+		Short: "read the files as of past transactions",
+		Long: `retrieve the content of a file, or the entries of a directory, given its inumber, as of any transaction,
+list and watch its revisions, or browse the namespace interactively`,
+	}
+
+	historyShowCmd = &cobra.Command{
+		Use:   "show",
+		Short: "print the content of a file before a transaction",
+		Long: `print the content of a file, or the entries of a directory in JSON format, before a transaction, i.e. as
+of the previous one, together with the operation which wrote it if recorded in the audit table`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inumber, err := cmd.Flags().GetInt64(flagHistoryInumber)
+			if err != nil {
+				return err
+			}
+			tx, err := cmd.Flags().GetInt64(flagHistoryTx)
+			if err != nil {
+				return err
+			}
+			opts, err := historyOptions(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if opts.Verify, err = cmd.Flags().GetBool(flagHistoryVerify); err != nil {
+				return err
+			}
+
+			return withTimeMachine(cmd.Flags(), os.Stderr, func(ctx context.Context, m *timemachine.TimeMachine) error {
+				return m.Show(ctx, inumber, tx, opts)
+			})
+		},
+	}
+
+	historyRevisionsCmd = &cobra.Command{
+		Use:   "revisions",
+		Short: "list the revisions of a file written in a window",
+		Long: `list the revisions of a file, or of the entries of a directory, written between two transactions or times
+(RFC 3339 or YYYY-MM-DD), with their size, the SHA-256 digest of their content and who wrote them`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inumber, err := cmd.Flags().GetInt64(flagHistoryInumber)
+			if err != nil {
+				return err
+			}
+			from, err := cmd.Flags().GetString(flagHistoryFrom)
+			if err != nil {
+				return err
+			}
+			to, err := cmd.Flags().GetString(flagHistoryTo)
+			if err != nil {
+				return err
+			}
+			opts, err := historyOptions(cmd.Flags())
+			if err != nil {
+				return err
+			}
+
+			return withTimeMachine(cmd.Flags(), os.Stderr, func(ctx context.Context, m *timemachine.TimeMachine) error {
+				return m.ListRevisions(ctx, inumber, from, to, opts)
+			})
+		},
+	}
+
+	historyWatchCmd = &cobra.Command{
+		Use:   "watch",
+		Short: "print the revisions of a file as they are committed",
+		Long: `poll immudb and print each revision of a file as it is committed, its content or its diff against the
+previous revision, until interrupted`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inumber, err := cmd.Flags().GetInt64(flagHistoryInumber)
+			if err != nil {
+				return err
+			}
+			interval, err := cmd.Flags().GetDuration(flagHistoryInterval)
+			if err != nil {
+				return err
+			}
+			if interval <= 0 {
+				return fmt.Errorf("invalid interval %s", interval)
+			}
+			opts, err := historyOptions(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			if opts.Diff, err = cmd.Flags().GetBool(flagHistoryDiff); err != nil {
+				return err
+			}
+
+			return withTimeMachine(cmd.Flags(), os.Stderr, func(ctx context.Context, m *timemachine.TimeMachine) error {
+				return m.Watch(ctx, inumber, interval, opts)
+			})
+		},
+	}
+
+	historyBrowseCmd = &cobra.Command{
+		Use:   "browse",
+		Short: "browse the namespace, the revisions of the files and their content interactively",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// The log would garble the screen.
+			return withTimeMachine(cmd.Flags(), io.Discard, func(ctx context.Context, m *timemachine.TimeMachine) error {
+				return m.Browse(ctx)
+			})
+		},
+	}
+)
+
+// historyOptions reads the flags shared by the subcommands printing contents.
+func historyOptions(flags *pflag.FlagSet) (timemachine.Options, error) {
+	var opts timemachine.Options
+	var err error
+	if opts.Format, err = flags.GetString(flagHistoryFormat); err != nil {
+		return opts, err
+	}
+	if !timemachine.ValidFormat(opts.Format) {
+		return opts, fmt.Errorf("unknown format %s, expecting one of: %s", opts.Format, strings.Join(timemachine.Formats, ", "))
+	}
+	if flags.Lookup(flagHistoryText) != nil {
+		if opts.Text, err = flags.GetBool(flagHistoryText); err != nil {
+			return opts, err
+		}
+	}
+
+	return opts, nil
+}
+
+// withTimeMachine connects to immudb and runs fn with a time machine logging to logOutput, until interrupted.
+func withTimeMachine(flags *pflag.FlagSet, logOutput io.Writer, fn func(ctx context.Context, m *timemachine.TimeMachine) error) error {
+	if err := readFlags(flags); err != nil {
+		return err
+	}
+	logger := newLogger()
+	logger.SetOutput(logOutput)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	idb, err := fs.NewImmuDbClient(ctx, &cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer idb.Destroy(context.Background())
+
+	return fn(ctx, timemachine.New(idb, logger))
+}
+
+func init() {
+	formatUsage := "format of the content: " + strings.Join(timemachine.Formats, ", ") + " (only hex is written to the log, with --text for text)"
+	for _, cmd := range []*cobra.Command{historyShowCmd, historyRevisionsCmd, historyWatchCmd} {
+		cmd.Flags().Int64(flagHistoryInumber, 1, "inumber of the file")
+		cmd.Flags().String(flagHistoryFormat, timemachine.FormatHex, formatUsage)
+	}
+	for _, cmd := range []*cobra.Command{historyShowCmd, historyWatchCmd} {
+		cmd.Flags().Bool(flagHistoryText, false, "interpret the content as text")
+	}
+	historyShowCmd.Flags().Int64(flagHistoryTx, 0, "transaction before which the content is printed")
+	historyShowCmd.Flags().Bool(flagHistoryVerify, false, "verify the content with immudb proofs, and print the hash of the transaction which wrote it")
+	_ = historyShowCmd.MarkFlagRequired(flagHistoryTx)
+	historyRevisionsCmd.Flags().String(flagHistoryFrom, "", "list the revisions since a transaction, or a time (RFC 3339 or YYYY-MM-DD)")
+	historyRevisionsCmd.Flags().String(flagHistoryTo, "", "list the revisions until a transaction, or a time (RFC 3339 or YYYY-MM-DD)")
+	historyWatchCmd.Flags().Duration(flagHistoryInterval, time.Second, "interval between the polls of immudb")
+	historyWatchCmd.Flags().Bool(flagHistoryDiff, false, "print the diff of each revision against the previous one instead of its content")
+
+	historyCmd.AddCommand(historyShowCmd, historyRevisionsCmd, historyWatchCmd, historyBrowseCmd)
+	rootCmd.AddCommand(historyCmd)
+}
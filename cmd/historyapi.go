@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"immufs/pkg/fs"
+	"immufs/pkg/historyapi"
+
+	"github.com/spf13/cobra"
+)
+
+var historyAPICmd = &cobra.Command{
+	Use:   "history-api",
+	Short: "serve the namespace and its history as JSON over HTTP",
+	Long: `expose a read-only HTTP API listing directories, serving files and diffing their revisions, as of any
+transaction. It works on immudb directly, without mounting the filesystem, e.g. for the web UI of auditors.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := readFlags(cmd.Flags()); err != nil {
+			return err
+		}
+		logger := newLogger()
+		if cfg.LogFile != "" {
+			fh, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			defer fh.Close()
+			logger.SetOutput(fh)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		idb, err := fs.NewImmuDbClient(ctx, &cfg, logger)
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(context.Background())
+
+		return historyapi.NewServer(idb, logger).Serve(ctx, cfg.HistoryListen)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyAPICmd)
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"immufs/pkg/fs"
+
+	"github.com/spf13/cobra"
+)
+
+const flagLockUntil = "until"
+
+var (
+	lockCmd = &cobra.Command{
+		Use:   "lock",
+		Short: "manage retention periods and legal holds",
+		Long:  `prevent files from being modified or deleted until a given time, or until a legal hold is released; paths are relative to the root of the filesystem`,
+	}
+
+	lockShowCmd = &cobra.Command{
+		Use:   "show <path>",
+		Short: "show the lock of a file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			file, _, err := idb.LookUpPath(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			l, err := idb.GetLock(ctx, file.Inumber)
+			if errors.Is(err, fs.ErrLockNotFound) {
+				l = &fs.FileLock{Inumber: file.Inumber}
+			} else if err != nil {
+				return err
+			}
+
+			retainUntil := "-"
+			if !l.RetainUntil.IsZero() {
+				retainUntil = l.RetainUntil.Format(time.RFC3339)
+			}
+			fmt.Printf("retain until: %s\nlegal hold:   %t\nactive:       %t\n", retainUntil, l.LegalHold, l.Active())
+
+			return nil
+		},
+	}
+
+	lockRetainCmd = &cobra.Command{
+		Use:   "retain <path>",
+		Short: "prevent changes to a file until the given time (retention periods can only be extended)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			until, err := cmd.Flags().GetString(flagLockUntil)
+			if err != nil {
+				return err
+			}
+			t, err := time.Parse(time.RFC3339, until)
+			if err != nil {
+				return fmt.Errorf("invalid time %q: %w", until, err)
+			}
+
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			file, _, err := idb.LookUpPath(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			return idb.Retain(ctx, file.Inumber, t)
+		},
+	}
+
+	lockHoldCmd = &cobra.Command{
+		Use:   "hold <path>",
+		Short: "place a legal hold on a file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return setLegalHold(cmd, args[0], true)
+		},
+	}
+
+	lockReleaseCmd = &cobra.Command{
+		Use:   "release <path>",
+		Short: "release the legal hold of a file",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return setLegalHold(cmd, args[0], false)
+		},
+	}
+)
+
+func setLegalHold(cmd *cobra.Command, path string, hold bool) error {
+	ctx := context.Background()
+	idb, err := newClient(ctx, cmd.Flags())
+	if err != nil {
+		return err
+	}
+	defer idb.Destroy(ctx)
+
+	file, _, err := idb.LookUpPath(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	return idb.SetLegalHold(ctx, file.Inumber, hold)
+}
+
+func init() {
+	lockRetainCmd.Flags().String(flagLockUntil, "", "end of the retention period, in RFC 3339 format (e.g. 2030-01-01T00:00:00Z)")
+	_ = lockRetainCmd.MarkFlagRequired(flagLockUntil)
+
+	lockCmd.AddCommand(lockShowCmd, lockRetainCmd, lockHoldCmd, lockReleaseCmd)
+	rootCmd.AddCommand(lockCmd)
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagLogMaxCount = "max-count"
+	flagLogFollow   = "follow"
+)
+
+var logCmd = &cobra.Command{
+	Use:   "log <path>",
+	Short: "print the revision history of a file",
+	Long: `print the revisions of the content of a file, or of the entries of a directory, newest first, one per transaction:
+its time, the change of size, the SHA-256 digest of the content and who wrote it, if recorded in the audit table. Only
+the revisions written while the file was at the path are listed, unless --follow lists those written before it was
+renamed too, with the path it had then.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		maxCount, err := cmd.Flags().GetInt(flagLogMaxCount)
+		if err != nil {
+			return err
+		}
+		if maxCount < 0 {
+			return fmt.Errorf("invalid --%s %d", flagLogMaxCount, maxCount)
+		}
+		follow, err := cmd.Flags().GetBool(flagLogFollow)
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(ctx)
+
+		log, err := idb.FileLog(ctx, args[0], maxCount, follow)
+		if err != nil {
+			return err
+		}
+
+		for i, e := range log {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("tx %d\n", e.Tx)
+			if e.Path != path.Clean("/"+args[0]) {
+				fmt.Printf("Path:    %s\n", e.Path)
+			}
+			fmt.Printf("Date:    %s\n", e.Time.Format(time.RFC3339))
+			fmt.Printf("Size:    %d (%+d)\n", e.Size, e.SizeDelta)
+			fmt.Printf("SHA256:  %s\n", hex.EncodeToString(e.SHA256[:]))
+			if e.Provenance != nil {
+				fmt.Printf("Writer:  %s\n", describeWriter(e.Provenance))
+			}
+			if sig := e.Signature; sig != nil {
+				fmt.Printf("Signer:  %s (valid: %t)\n", sig.Signer, sig.Valid)
+			}
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	logCmd.Flags().IntP(flagLogMaxCount, "n", 0, "list the last n revisions only (0 lists them all)")
+	logCmd.Flags().Bool(flagLogFollow, false, "list the revisions written before the file was renamed too")
+
+	rootCmd.AddCommand(logCmd)
+}
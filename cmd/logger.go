@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"strings"
+
+	"immufs/pkg/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Credentials shorter than that are not masked in the messages logged, not to mangle them: immudb requires
+// passwords of at least 8 characters, except its well-known default one, "immudb".
+const minSecretLen = 8
+
+// redactHook masks the credentials of the configuration in the messages and the fields logged, at every level,
+// e.g. when an error quotes them.
+type redactHook struct {
+	replacer *strings.Replacer
+}
+
+func (h *redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *redactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.replacer.Replace(entry.Message)
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = h.replacer.Replace(v)
+		case error:
+			entry.Data[key] = h.replacer.Replace(v.Error())
+		}
+	}
+
+	return nil
+}
+
+// newLogger returns a logger masking the credentials of the configuration, which must have been read, and of the
+// mount profiles given.
+func newLogger(profiles ...mountProfile) *logrus.Logger {
+	logger := logrus.New()
+	setLogLevel(logger, cfg.LogLevel)
+
+	secrets := cfg.Secrets()
+	for _, profile := range profiles {
+		secrets = append(secrets, profile.cfg.Secrets()...)
+	}
+	var pairs []string
+	for _, secret := range secrets {
+		if len(secret) >= minSecretLen {
+			pairs = append(pairs, secret, config.Mask)
+		}
+	}
+	if len(pairs) > 0 {
+		logger.AddHook(&redactHook{replacer: strings.NewReplacer(pairs...)})
+	}
+
+	return logger
+}
+
+// setLogLevel sets the least severe level logged, keeping the current one if the level is not valid.
+func setLogLevel(logger *logrus.Logger, level string) {
+	if level == "" {
+		return
+	}
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		logger.Warnf("invalid log level %q, logging at %s", level, logger.GetLevel())
+
+		return
+	}
+	logger.SetLevel(lvl)
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagMigrateWorkers = "workers"
+
+	// Prefix of the paths within the filesystem.
+	immufsPathPrefix = "immufs:"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate <dir> immufs:<path>",
+	Short: "import a local directory tree into the filesystem",
+	Long: `load the content of a local directory into an existing directory of the filesystem, with parallel workers
+and batched transactions. It is much faster than copying files onto a mount. Symbolic links and special files are skipped.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		workers, err := cmd.Flags().GetInt(flagMigrateWorkers)
+		if err != nil {
+			return err
+		}
+		if workers < 1 {
+			return fmt.Errorf("at least a worker is needed")
+		}
+		target, ok := strings.CutPrefix(args[1], immufsPathPrefix)
+		if !ok {
+			return fmt.Errorf("the target must be written as %s<path>", immufsPathPrefix)
+		}
+
+		ctx := context.Background()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(ctx)
+
+		stats, err := idb.Migrate(ctx, args[0], target, workers)
+		if err != nil {
+			return err
+		}
+		for _, path := range stats.Skipped {
+			fmt.Printf("skipped %s\n", path)
+		}
+		fmt.Printf("%d directories and %d files (%d bytes) imported\n", stats.Dirs, stats.Files, stats.Bytes)
+
+		return nil
+	},
+}
+
+func init() {
+	migrateCmd.Flags().Int(flagMigrateWorkers, runtime.NumCPU(), "number of files written in parallel")
+
+	rootCmd.AddCommand(migrateCmd)
+}
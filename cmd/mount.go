@@ -0,0 +1,21 @@
+package cmd
+
+import "github.com/spf13/cobra"
+
+// mountCmd mounts the filesystem, as immufs does without a subcommand, or the profiles of the configuration file.
+var mountCmd = &cobra.Command{
+	Use:   "mount",
+	Short: "mount the filesystem, e.g. as of a snapshot with --snapshot, or the profiles of the configuration file",
+	Long: `mount the filesystem configured by the flags and the configuration file or, with --profile, the one configured
+by a profile of the mounts list of the configuration file, or, with --all, every one of them from the same process.
+The settings of a profile take precedence over those at the top level of the file, and the flags over both.`,
+	Args: cobra.NoArgs,
+}
+
+func init() {
+	mountCmd.Run = rootCmd.Run
+	mountCmd.Flags().String(flagProfile, "", "mount the profile of the mounts list of the configuration file with this name")
+	mountCmd.Flags().Bool(flagAllProfiles, false, "mount every profile of the mounts list of the configuration file")
+	mountCmd.MarkFlagsMutuallyExclusive(flagProfile, flagAllProfiles)
+	rootCmd.AddCommand(mountCmd)
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"log"
+	"strings"
+
+	"immufs/pkg/config"
+
+	"github.com/jacobsa/fuse"
+	"github.com/sirupsen/logrus"
+)
+
+// Raw mount options handled by immufs itself (see Options in the configuration).
+const (
+	mountOptionReadOnly = "ro"
+	mountOptionDebug    = "debug"
+)
+
+// splitOptions returns raw mount options by name, with their values (empty if none). An entry may hold several
+// options separated by commas, as with mount -o.
+func splitOptions(raw []string) map[string]string {
+	opts := make(map[string]string)
+	for _, entry := range raw {
+		for _, opt := range strings.Split(entry, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(opt), "=")
+			if name != "" {
+				opts[name] = value
+			}
+		}
+	}
+
+	return opts
+}
+
+// mountConfig returns the FUSE configuration of the mount of cfg: the transfer sizes (see transferOptions), then
+// the raw options, which take precedence. The debug option is not passed to the kernel: the requests exchanged
+// with it are logged at the debug level instead.
+func mountConfig(cfg *config.Config, logger *logrus.Logger) *fuse.MountConfig {
+	mountCfg := &fuse.MountConfig{
+		FSName:   "immufs",
+		ReadOnly: cfg.ReadOnly || cfg.Snapshot != "",
+		Options:  transferOptions(cfg),
+	}
+	for name, value := range splitOptions(cfg.Options) {
+		switch name {
+		case mountOptionDebug:
+			mountCfg.DebugLogger = log.New(logger.WriterLevel(logrus.DebugLevel), "fuse: ", 0)
+		case mountOptionReadOnly:
+			// Set by ReadOnly, which readConfig sets as well.
+		default:
+			mountCfg.Options[name] = value
+		}
+	}
+
+	return mountCfg
+}
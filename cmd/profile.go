@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+
+	"immufs/pkg/config"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	flagProfile     = "profile"
+	flagAllProfiles = "all"
+
+	// Key of the configuration file listing the mount profiles, and key of the name of a profile.
+	keyMounts      = "mounts"
+	keyProfileName = "name"
+)
+
+// processSettings are the settings of the whole process, which only the top level of the configuration file sets.
+var processSettings = []string{
+	flagLogFile, flagLogLevel, flagMetricsListen, flagPprofListen, flagOTLPEndpoint, flagSlowOpThreshold,
+}
+
+// mountProfile is the configuration of one of the mounts of the process: that of the configuration file and of the
+// flags if name is empty, else that of the named profile of the mounts key.
+type mountProfile struct {
+	name string
+	cfg  *config.Config
+}
+
+// profiles returns the mount profiles of the configuration file, by name, in the order listed.
+func profiles() ([]string, map[string]map[string]any, error) {
+	var entries []map[string]any
+	if err := viper.UnmarshalKey(keyMounts, &entries); err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", keyMounts, err)
+	}
+
+	var names []string
+	byName := make(map[string]map[string]any)
+	for i, entry := range entries {
+		name, _ := entry[keyProfileName].(string)
+		if name == "" {
+			return nil, nil, fmt.Errorf("profile %d of %s has no %s", i+1, keyMounts, keyProfileName)
+		}
+		if _, ok := byName[name]; ok {
+			return nil, nil, fmt.Errorf("profile %s is listed twice in %s", name, keyMounts)
+		}
+		for _, key := range processSettings {
+			if _, ok := entry[key]; ok {
+				return nil, nil, fmt.Errorf("profile %s can't set %s, which applies to the whole process", name, key)
+			}
+		}
+		delete(entry, keyProfileName)
+		names = append(names, name)
+		byName[name] = entry
+	}
+
+	return names, byName, nil
+}
+
+// profileConfig returns the configuration of a profile: its settings take precedence over those at the top level of
+// the configuration file, and the flags given on the command line over both. The empty name stands for the top
+// level.
+func profileConfig(name string, flags *pflag.FlagSet) (*config.Config, error) {
+	if name == "" {
+		var c config.Config
+		if err := readConfig(viper.GetViper(), &c); err != nil {
+			return nil, err
+		}
+
+		return &c, nil
+	}
+
+	_, byName, err := profiles()
+	if err != nil {
+		return nil, err
+	}
+	settings, ok := byName[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %s", name)
+	}
+
+	// The defaults of the flags are not settings of the file: the settings left unset stay so.
+	v := viper.New()
+	v.SetConfigFile(viper.ConfigFileUsed())
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	if err := v.MergeConfigMap(settings); err != nil {
+		return nil, err
+	}
+	// Only the flags given on the command line take precedence over the file.
+	if err := v.BindPFlags(flags); err != nil {
+		return nil, err
+	}
+	var c config.Config
+	if err := readConfig(v, &c); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+
+	return &c, nil
+}
+
+// selectedProfiles returns the mounts selected by --profile or --all, or the one of the top level of the
+// configuration if neither is given, e.g. by immufs without a subcommand.
+func selectedProfiles(cmd *cobra.Command) ([]mountProfile, error) {
+	// Only the mount subcommand has the flags.
+	name, _ := cmd.Flags().GetString(flagProfile)
+	all, _ := cmd.Flags().GetBool(flagAllProfiles)
+
+	var names []string
+	switch {
+	case all:
+		var err error
+		if names, _, err = profiles(); err != nil {
+			return nil, err
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("no profile listed in %s", keyMounts)
+		}
+	case name != "":
+		names = []string{name}
+	default:
+		return []mountProfile{{cfg: &cfg}}, nil
+	}
+
+	var selected []mountProfile
+	mountpoints := make(map[string]string)
+	for _, name := range names {
+		c, err := profileConfig(name, cmd.Flags())
+		if err != nil {
+			return nil, err
+		}
+		if c.Mountpoint == "" {
+			return nil, fmt.Errorf("profile %s has no mountpoint", name)
+		}
+		if other, ok := mountpoints[c.Mountpoint]; ok {
+			return nil, fmt.Errorf("profiles %s and %s have the same mountpoint %s", other, name, c.Mountpoint)
+		}
+		mountpoints[c.Mountpoint] = name
+		selected = append(selected, mountProfile{name: name, cfg: c})
+	}
+
+	return selected, nil
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	quarantineCmd = &cobra.Command{
+		Use:   "quarantine",
+		Short: "inspect the files whose content did not match its checksum",
+		Long:  `list the files found corrupted when read: their content did not match the checksum written with it`,
+	}
+
+	quarantineListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "list the files quarantined",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			entries, err := idb.ListQuarantine(ctx)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "INODE\tPATH\tDETECTED\tEXPECTED\tACTUAL")
+			for _, e := range entries {
+				path := e.Path
+				if path == "" {
+					path = "<unlinked>"
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", e.Inumber, path, e.DetectedAt.Format(time.RFC3339), e.Expected, e.Actual)
+			}
+
+			return w.Flush()
+		},
+	}
+)
+
+func init() {
+	quarantineCmd.AddCommand(quarantineListCmd)
+	rootCmd.AddCommand(quarantineCmd)
+}
@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"immufs/pkg/fs"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagQuotaBytes  = "bytes"
+	flagQuotaInodes = "inodes"
+)
+
+var (
+	quotaCmd = &cobra.Command{
+		Use:   "quota",
+		Short: "manage per-owner and per-directory quotas",
+		Long: `limit the bytes and inodes owned by each uid or stored in a directory tree; writes exceeding a quota fail with EDQUOT.
+The files of a mount are all owned by its uid (--uid), whichever process creates them: the quota of a uid limits
+the mounts of that uid, not the local users of a mount`,
+	}
+
+	quotaSetCmd = &cobra.Command{
+		Use:   "set <uid>",
+		Short: "set the limits of a user (0 means unlimited)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			uid, err := strconv.ParseUint(args[0], 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid uid %q: %w", args[0], err)
+			}
+			maxBytes, err := cmd.Flags().GetInt64(flagQuotaBytes)
+			if err != nil {
+				return err
+			}
+			maxInodes, err := cmd.Flags().GetInt64(flagQuotaInodes)
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			return idb.SetQuota(ctx, fs.Quota{Uid: uint32(uid), MaxBytes: maxBytes, MaxInodes: maxInodes})
+		},
+	}
+
+	quotaRemoveCmd = &cobra.Command{
+		Use:   "remove <uid>",
+		Short: "remove the limits of a user",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			uid, err := strconv.ParseUint(args[0], 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid uid %q: %w", args[0], err)
+			}
+
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			return idb.DeleteQuota(ctx, uint32(uid))
+		},
+	}
+
+	quotaReportCmd = &cobra.Command{
+		Use:   "report",
+		Short: "report the usage and limits of every user",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			usage, err := idb.ListUsage(ctx)
+			if err != nil {
+				return err
+			}
+			quotas, err := idb.ListQuotas(ctx)
+			if err != nil {
+				return err
+			}
+			limits := make(map[uint32]fs.Quota, len(quotas))
+			for _, q := range quotas {
+				limits[q.Uid] = q
+			}
+
+			// Users with a quota but no inodes are reported as well.
+			for _, q := range quotas {
+				found := false
+				for _, u := range usage {
+					found = found || u.Uid == q.Uid
+				}
+				if !found {
+					usage = append(usage, fs.QuotaUsage{Uid: q.Uid})
+				}
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "UID\tBYTES\tBYTES LIMIT\tINODES\tINODES LIMIT")
+			for _, u := range usage {
+				q := limits[u.Uid]
+				fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%s\n", u.Uid, u.Bytes, formatLimit(q.MaxBytes), u.Inodes, formatLimit(q.MaxInodes))
+			}
+
+			return w.Flush()
+		},
+	}
+
+	quotaDirCmd = &cobra.Command{
+		Use:   "dir",
+		Short: "manage per-directory quotas",
+		Long:  `limit the bytes and inodes of directory trees; paths are relative to the root of the filesystem`,
+	}
+
+	quotaDirSetCmd = &cobra.Command{
+		Use:   "set <path>",
+		Short: "set the limits of a directory tree (0 means unlimited)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			maxBytes, err := cmd.Flags().GetInt64(flagQuotaBytes)
+			if err != nil {
+				return err
+			}
+			maxInodes, err := cmd.Flags().GetInt64(flagQuotaInodes)
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			dir, _, err := idb.LookUpPath(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			return idb.SetDirQuota(ctx, fs.DirQuota{Inumber: dir.Inumber, MaxBytes: maxBytes, MaxInodes: maxInodes})
+		},
+	}
+
+	quotaDirRemoveCmd = &cobra.Command{
+		Use:   "remove <path>",
+		Short: "remove the limits of a directory tree",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			dir, parent, err := idb.LookUpPath(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			var parentProject int64
+			if parent != nil {
+				parentProject = parent.Project
+			}
+
+			return idb.DeleteDirQuota(ctx, dir.Inumber, parentProject)
+		},
+	}
+
+	quotaDirReportCmd = &cobra.Command{
+		Use:   "report",
+		Short: "report the usage and limits of every directory tree with a quota",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			quotas, err := idb.ListDirQuotas(ctx)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "INODE\tBYTES\tBYTES LIMIT\tINODES\tINODES LIMIT")
+			for _, q := range quotas {
+				u, err := idb.GetDirUsage(ctx, q.Inumber)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%s\n", q.Inumber, u.Bytes, formatLimit(q.MaxBytes), u.Inodes, formatLimit(q.MaxInodes))
+			}
+
+			return w.Flush()
+		},
+	}
+)
+
+func formatLimit(limit int64) string {
+	if limit == 0 {
+		return "-"
+	}
+
+	return strconv.FormatInt(limit, 10)
+}
+
+func init() {
+	quotaSetCmd.Flags().Int64(flagQuotaBytes, 0, "maximum bytes owned by the user (0 means unlimited)")
+	quotaSetCmd.Flags().Int64(flagQuotaInodes, 0, "maximum inodes owned by the user (0 means unlimited)")
+	quotaDirSetCmd.Flags().Int64(flagQuotaBytes, 0, "maximum bytes in the directory tree (0 means unlimited)")
+	quotaDirSetCmd.Flags().Int64(flagQuotaInodes, 0, "maximum inodes in the directory tree (0 means unlimited)")
+
+	quotaDirCmd.AddCommand(quotaDirSetCmd, quotaDirRemoveCmd, quotaDirReportCmd)
+	quotaCmd.AddCommand(quotaSetCmd, quotaRemoveCmd, quotaReportCmd, quotaDirCmd)
+	rootCmd.AddCommand(quotaCmd)
+}
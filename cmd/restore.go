@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"immufs/pkg/fs"
+
+	"github.com/spf13/cobra"
+)
+
+const flagRestoreSource = "source-database"
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "load a backup into an empty database",
+	Long: `check the digest of a dump produced by the backup subcommand, then load it into the database,
+which must be empty and have the immufs tables. Every row restored is verified with immudb proofs.
+With --source-database, the backup is first looked up in the database it was taken from (on the same server),
+proving that it has not been altered.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		source, err := cmd.Flags().GetString(flagRestoreSource)
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(ctx)
+
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if source != "" {
+			info, err := fs.ReadBackupInfo(f)
+			if err != nil {
+				return err
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+
+			sourceCfg := cfg
+			sourceCfg.Database = source
+			sourceIdb, err := fs.NewImmuDbClient(ctx, &sourceCfg, newLogger())
+			if err != nil {
+				return err
+			}
+			defer sourceIdb.Destroy(ctx)
+
+			if _, err := sourceIdb.CheckBackup(ctx, info.Digest); err != nil {
+				return fmt.Errorf("backup %s in %s: %w", info.Digest, source, err)
+			}
+			fmt.Printf("backup %s found in %s\n", info.Digest, source)
+		}
+
+		info, err := idb.Restore(ctx, f)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d rows restored from TX=%d of %s, digest %s\n", info.Rows, info.Tx, info.Database, info.Digest)
+
+		return nil
+	},
+}
+
+func init() {
+	restoreCmd.Flags().String(flagRestoreSource, "", "database the backup was taken from, to prove it has not been altered")
+
+	rootCmd.AddCommand(restoreCmd)
+}
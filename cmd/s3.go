@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"immufs/pkg/fs"
+	"immufs/pkg/s3"
+
+	"github.com/spf13/cobra"
+)
+
+var s3Cmd = &cobra.Command{
+	Use:   "s3",
+	Short: "serve the filesystem over the S3 API",
+	Long: `expose the filesystem over a minimal S3 API, without mounting it: buckets are the directories at the root,
+objects the files within them. GET, HEAD, PUT and DELETE of objects and buckets are supported, as well as listings.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := readFlags(cmd.Flags()); err != nil {
+			return err
+		}
+		logger := newLogger()
+		if cfg.LogFile != "" {
+			fh, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			defer fh.Close()
+			logger.SetOutput(fh)
+		}
+		if cfg.S3AccessKey == "" {
+			logger.Warnf("no access key configured: S3 requests are not authenticated")
+		} else if cfg.S3SecretKey == "" {
+			return errors.New("a secret key is required together with the access key")
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		_, filesystem, err := newFileSystem(ctx, &cfg, logger)
+		if err != nil {
+			return err
+		}
+		defer filesystem.Destroy()
+
+		gateway := s3.NewGateway(fs.NewSession(filesystem), logger, cfg.S3AccessKey, cfg.S3SecretKey)
+
+		return gateway.Serve(ctx, cfg.S3Listen)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(s3Cmd)
+}
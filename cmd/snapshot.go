@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	snapshotCmd = &cobra.Command{
+		Use:   "snapshot",
+		Short: "manage named snapshots",
+		Long:  `give human readable names to immudb transactions, i.e. to the state of the filesystem at a given time`,
+	}
+
+	snapshotCreateCmd = &cobra.Command{
+		Use:   "create <name>",
+		Short: "snapshot the filesystem as of the last transaction",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			snap, err := idb.CreateSnapshot(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Printf("snapshot %s created at TX=%d\n", snap.Name, snap.Tx)
+
+			return nil
+		},
+	}
+
+	snapshotListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "list the snapshots",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			snaps, err := idb.ListSnapshots(ctx)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tTX\tCREATED")
+			for _, snap := range snaps {
+				fmt.Fprintf(w, "%s\t%d\t%s\n", snap.Name, snap.Tx, snap.CreatedAt.Format(time.RFC3339))
+			}
+
+			return w.Flush()
+		},
+	}
+
+	snapshotDeleteCmd = &cobra.Command{
+		Use:   "delete <name>",
+		Short: "delete a snapshot (the history of the filesystem is not affected)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			return idb.DeleteSnapshot(ctx, args[0])
+		},
+	}
+)
+
+func init() {
+	snapshotCmd.AddCommand(snapshotCreateCmd, snapshotListCmd, snapshotDeleteCmd)
+	rootCmd.AddCommand(snapshotCmd)
+}
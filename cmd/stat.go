@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"immufs/pkg/fs"
+
+	"github.com/spf13/cobra"
+)
+
+var statCmd = &cobra.Command{
+	Use:   "stat <path>",
+	Short: "summarize the provenance of a file",
+	Long: `print, for the file or directory at a path, its inumber, the current transaction, the number of revisions of its
+content kept in the history, the transactions which wrote the first and the last of them, the operation which wrote the
+last one, the checksum (SHA-256) of the content, and whether it is verified with immudb proofs. The command fails if
+the verification does.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := context.Background()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(ctx)
+
+		p, err := idb.ProvenanceOf(ctx, args[0])
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "path:\t%s\n", p.Path)
+		fmt.Fprintf(w, "inumber:\t%d\n", p.Inumber)
+		if !p.Dir {
+			fmt.Fprintf(w, "size:\t%d\n", p.Size)
+		}
+		fmt.Fprintf(w, "current TX:\t%d\n", p.CurrentTx)
+		if p.Revisions > 0 {
+			fmt.Fprintf(w, "revisions:\t%d\n", p.Revisions)
+			fmt.Fprintf(w, "first modified:\tTX=%d at %s\n", p.FirstTx, p.FirstTime.Format(time.RFC3339))
+			fmt.Fprintf(w, "last modified:\tTX=%d at %s\n", p.LastTx, p.LastTime.Format(time.RFC3339))
+		}
+		if p.LastWriter != nil {
+			fmt.Fprintf(w, "written by:\t%s\n", describeWriter(p.LastWriter))
+		}
+		if p.Signature != nil {
+			fmt.Fprintf(w, "signed by:\t%s (valid: %t)\n", p.Signature.Signer, p.Signature.Valid)
+		}
+		fmt.Fprintf(w, "sha256:\t%s\n", p.SHA256)
+		switch {
+		case p.Proof != nil:
+			fmt.Fprintf(w, "verification:\tverified at TX=%d, hash %s\n", p.Proof.Tx, hex.EncodeToString(p.Proof.TxHash[:]))
+		case errors.Is(p.VerifyErr, fs.ErrNotSupported):
+			fmt.Fprintf(w, "verification:\tnot supported (%s)\n", p.VerifyErr)
+		case p.VerifyErr != nil:
+			fmt.Fprintf(w, "verification:\tFAILED (%s)\n", p.VerifyErr)
+		default:
+			fmt.Fprintf(w, "verification:\tnone (inlined content)\n")
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if p.VerifyErr != nil && !errors.Is(p.VerifyErr, fs.ErrNotSupported) {
+			return fmt.Errorf("%s: verification failed: %w", p.Path, p.VerifyErr)
+		}
+
+		return nil
+	},
+}
+
+// describeWriter describes the operation which wrote a content, as recorded in the audit table.
+func describeWriter(rec *fs.AuditRecord) string {
+	s := fmt.Sprintf("%s by uid=%d gid=%d pid=%d", rec.Op, rec.Uid, rec.Gid, rec.Pid)
+	if rec.DBUser != "" {
+		s += " as immudb user " + rec.DBUser
+	}
+
+	return s
+}
+
+func init() {
+	rootCmd.AddCommand(statCmd)
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"immufs/pkg/fs"
+
+	"github.com/spf13/cobra"
+)
+
+const flagTierDryRun = "dry-run"
+
+var tierCmd = &cobra.Command{
+	Use:   "tier",
+	Short: "move the content of the cold files to the tier",
+	Long: `move the content of the files neither modified nor accessed for --tier-after, and of at least
+--tier-min-size bytes, to the object storage of --tier-url. Their checksum and metadata stay in immudb, and the
+files are read back from the tier transparently. With --dry-run, nothing is moved.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dryRun, err := cmd.Flags().GetBool(flagTierDryRun)
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(ctx)
+
+		report, err := idb.TierColdFiles(ctx, fs.TierPolicy{After: cfg.TierAfter, MinSize: cfg.TierMinSize, DryRun: dryRun})
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			fmt.Printf("%d files (%d bytes) would be moved to the tier\n", report.Files, report.Bytes)
+		} else {
+			fmt.Printf("%d files (%d bytes) moved to the tier\n", report.Files, report.Bytes)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	tierCmd.Flags().Bool(flagTierDryRun, false, "report the files which would be moved, without moving them")
+
+	rootCmd.AddCommand(tierCmd)
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"immufs/pkg/config"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// Biggest request exchanged with the kernel, reads and writes alike: the FUSE library negotiates it on mount, and
+// allocates its buffers accordingly.
+const maxTransfer = 1 << 20
+
+// checkTransfer validates the transfer sizes of the configuration (see MaxRead in the configuration).
+func checkTransfer(cfg *config.Config) error {
+	switch {
+	case cfg.MaxRead < 0 || cfg.MaxRead > maxTransfer:
+		return fmt.Errorf("%s must be between 0 and %d", flagMaxRead, maxTransfer)
+	case cfg.MaxReadahead < 0:
+		return fmt.Errorf("%s can't be negative", flagMaxReadahead)
+	case cfg.MaxBackground < 0 || cfg.MaxBackground > 1<<16-1:
+		return fmt.Errorf("%s must be between 0 and %d", flagMaxBackground, 1<<16-1)
+	case cfg.CongestionThreshold < 0 || cfg.CongestionThreshold > 1<<16-1:
+		return fmt.Errorf("%s must be between 0 and %d", flagCongestionThreshold, 1<<16-1)
+	}
+
+	return nil
+}
+
+// transferOptions returns the mount options setting the transfer sizes the kernel takes on mount.
+func transferOptions(cfg *config.Config) map[string]string {
+	opts := make(map[string]string)
+	if cfg.MaxRead > 0 {
+		opts["max_read"] = strconv.Itoa(cfg.MaxRead)
+	}
+
+	return opts
+}
+
+// tuneTransfer applies the transfer sizes which the FUSE library fixes on mount, through the settings the kernel
+// exposes for each mount in sysfs (Linux only, and writable by root only). Failures are only logged: the mount
+// keeps the defaults.
+func tuneTransfer(cfg *config.Config, mountpoint string, logger *logrus.Logger) {
+	if cfg.MaxReadahead == 0 && cfg.MaxBackground == 0 && cfg.CongestionThreshold == 0 {
+		return
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(mountpoint, &st); err != nil {
+		logger.Warnf("could not tune the transfer sizes of the mount: %s", err)
+
+		return
+	}
+	major, minor := unix.Major(uint64(st.Dev)), unix.Minor(uint64(st.Dev))
+	// Device number of the mount, as encoded by the kernel.
+	conn := filepath.Join("/sys/fs/fuse/connections", strconv.FormatUint(uint64(major)<<20|uint64(minor), 10))
+	bdi := filepath.Join("/sys/class/bdi", fmt.Sprintf("%d:%d", major, minor))
+
+	settings := []struct {
+		path  string
+		value int
+	}{
+		{filepath.Join(bdi, "read_ahead_kb"), cfg.MaxReadahead},
+		{filepath.Join(conn, "max_background"), cfg.MaxBackground},
+		{filepath.Join(conn, "congestion_threshold"), cfg.CongestionThreshold},
+	}
+	for _, s := range settings {
+		if s.value == 0 {
+			continue
+		}
+		if err := os.WriteFile(s.path, []byte(strconv.Itoa(s.value)), 0644); err != nil {
+			logger.Warnf("could not tune the transfer sizes of the mount: %s", err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	trashCmd = &cobra.Command{
+		Use:   "trash",
+		Short: "manage the files moved to the trash",
+		Long:  `list and restore the files unlinked from a filesystem mounted with --trash`,
+	}
+
+	trashListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "list the files in the trash",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			entries, err := idb.ListTrash(ctx)
+			if err != nil {
+				return err
+			}
+			dirs, err := idb.DirectoryPaths(ctx)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "INODE\tORIGINAL PATH\tDELETED")
+			for _, e := range entries {
+				dir, ok := dirs[e.Parent]
+				if !ok {
+					dir = fmt.Sprintf("<deleted directory %d>", e.Parent)
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\n", e.Inumber, path.Join(dir, e.Name), e.DeletedAt.Format(time.RFC3339))
+			}
+
+			return w.Flush()
+		},
+	}
+
+	trashRestoreCmd = &cobra.Command{
+		Use:   "restore <inode>",
+		Short: "move a file from the trash back to its original path",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			inumber, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid inode %q: %w", args[0], err)
+			}
+
+			ctx := context.Background()
+			idb, err := newClient(ctx, cmd.Flags())
+			if err != nil {
+				return err
+			}
+			defer idb.Destroy(ctx)
+
+			return idb.RestoreTrash(ctx, inumber)
+		},
+	}
+)
+
+func init() {
+	trashCmd.AddCommand(trashListCmd, trashRestoreCmd)
+	rootCmd.AddCommand(trashCmd)
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"immufs/pkg/fs"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagVerifyTreeRecord  = "record"
+	flagVerifyTreeAgainst = "against"
+)
+
+var verifyTreeCmd = &cobra.Command{
+	Use:   "verify-tree [<point>]",
+	Short: "compute the root of a hash tree over the namespace, and record or compare it",
+	Long: `compute a hash tree over the names, the attributes and the content checksums of the filesystem, as of a
+transaction number or a snapshot name, or currently, and print its root. With --record, the root is recorded under a
+name, e.g. when the tree is certified; with --against, it is compared with a recorded root, or a root in hexadecimal,
+and the command fails if they differ.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		record, err := cmd.Flags().GetString(flagVerifyTreeRecord)
+		if err != nil {
+			return err
+		}
+		against, err := cmd.Flags().GetString(flagVerifyTreeAgainst)
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(ctx)
+
+		var tx uint64
+		if len(args) > 0 {
+			if tx, err = resolveTx(ctx, idb, args[0]); err != nil {
+				return err
+			}
+		}
+		// Resolve the root compared with before hashing the whole tree.
+		expected, err := expectedTreeRoot(ctx, idb, against)
+		if err != nil {
+			return err
+		}
+
+		digest, err := idb.TreeHash(ctx, tx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("root %s as of TX=%d (%d files, %d directories)\n", digest.Root, digest.Tx, digest.Files, digest.Dirs)
+
+		if record != "" {
+			root, err := idb.RecordTreeRoot(ctx, record, digest)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("tree root %s recorded at TX=%d\n", root.Name, root.Tx)
+		}
+		if expected != nil {
+			if expected.Root != digest.Root {
+				return fmt.Errorf("tree differs from %s, rooted at %s", expected.Name, expected.Root)
+			}
+			fmt.Printf("tree matches %s\n", expected.Name)
+		}
+
+		return nil
+	},
+}
+
+// expectedTreeRoot returns the root named by --against: a recorded root, or a root in hexadecimal. It returns nil
+// without --against.
+func expectedTreeRoot(ctx context.Context, idb *fs.ImmuDbClient, against string) (*fs.TreeRoot, error) {
+	if against == "" {
+		return nil, nil
+	}
+	root, err := idb.GetTreeRoot(ctx, against)
+	if errors.Is(err, fs.ErrTreeRootNotFound) {
+		if digest, hexErr := hex.DecodeString(against); hexErr == nil && len(digest) == 32 {
+			return &fs.TreeRoot{Name: against, Root: against}, nil
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", against, err)
+	}
+	fmt.Printf("comparing with %s, recorded at TX=%d\n", root.Name, root.Tx)
+
+	return root, nil
+}
+
+func init() {
+	verifyTreeCmd.Flags().String(flagVerifyTreeRecord, "", "record the root under this name")
+	verifyTreeCmd.Flags().String(flagVerifyTreeAgainst, "", "compare the root with the one recorded under this name, or with a root in hexadecimal")
+
+	rootCmd.AddCommand(verifyTreeCmd)
+}
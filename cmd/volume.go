@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"immufs/pkg/volume"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/spf13/cobra"
+)
+
+var volumePluginCmd = &cobra.Command{
+	Use:   "volume-plugin",
+	Short: "serve the filesystem as Docker volumes",
+	Long: `mount the filesystem at the mountpoint and serve the Docker volume plugin API, so that containers get
+immufs volumes with "docker run -v <name>:<path> --volume-driver immufs". Every directory at the root of the
+filesystem is a volume.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := readFlags(cmd.Flags()); err != nil {
+			return err
+		}
+		logger := newLogger()
+		if cfg.LogFile != "" {
+			fh, err := os.OpenFile(cfg.LogFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			defer fh.Close()
+			logger.SetOutput(fh)
+		}
+		if cfg.Mountpoint == "" {
+			return errors.New("a mountpoint is required")
+		}
+		if err := checkTransfer(&cfg); err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		immufs, filesystem, err := newFileSystem(ctx, &cfg, logger)
+		if err != nil {
+			return err
+		}
+		// Docker and the containers access the volumes as users other than the one running the plugin.
+		mountCfg := mountConfig(&cfg, logger)
+		mountCfg.Options["allow_other"] = ""
+		mfs, err := fuse.Mount(cfg.Mountpoint, immufs.NotifyingServer(fuseutil.NewFileSystemServer(filesystem)), mountCfg)
+		if err != nil {
+			return err
+		}
+		tuneTransfer(&cfg, cfg.Mountpoint, logger)
+		logger.Infof("immufs mounted on %s", cfg.Mountpoint)
+
+		serveErr := volume.NewPlugin(cfg.Mountpoint, logger).Serve(ctx, cfg.VolumeSocket)
+		if err := fuse.Unmount(cfg.Mountpoint); err != nil {
+			logger.Errorf("could not unmount immufs: %s. Remember to run umount %s manually.", err, cfg.Mountpoint)
+
+			return err
+		}
+		if err := mfs.Join(context.Background()); err != nil {
+			return err
+		}
+		logger.Info("immufs unmounted")
+
+		return serveErr
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(volumePluginCmd)
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"immufs/pkg/fs"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagWatchPollInterval = "interval"
+	flagWatchSinceTx      = "since-tx"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [<path>]",
+	Short: "print the changes under a path as they are committed",
+	Long: `poll the history of the tables and print the files and directories created, modified or deleted under a path
+(the whole filesystem by default) as they are committed, until interrupted. The changes are listed from the current
+transaction on, or after --since-tx. As with changes, renames show up as modifications of the directories involved,
+and a change committed while polling may be printed twice.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		interval, err := cmd.Flags().GetDuration(flagWatchPollInterval)
+		if err != nil {
+			return err
+		}
+		if interval <= 0 {
+			return fmt.Errorf("invalid interval %s", interval)
+		}
+		sinceTx, err := cmd.Flags().GetUint64(flagWatchSinceTx)
+		if err != nil {
+			return err
+		}
+		root := "/"
+		if len(args) > 0 {
+			root = path.Clean("/" + args[0])
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		idb, err := newClient(ctx, cmd.Flags())
+		if err != nil {
+			return err
+		}
+		defer idb.Destroy(context.Background())
+
+		if !cmd.Flags().Changed(flagWatchSinceTx) {
+			if sinceTx, err = idb.CurrentTx(ctx); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(os.Stderr, "watching %s after TX=%d\n", root, sinceTx)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			changes, current, err := idb.Changes(ctx, sinceTx)
+			if err != nil && ctx.Err() == nil {
+				// Retried at the next poll, e.g. once immudb is back.
+				fmt.Fprintln(os.Stderr, err)
+			}
+			if err == nil {
+				printWatched(changes, root, sinceTx, current)
+				sinceTx = current
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+// printWatched prints the changes under root committed between two transactions, one per line.
+func printWatched(changes []fs.Change, root string, fromTx, toTx uint64) {
+	now := time.Now().Format(time.RFC3339)
+	for _, c := range changes {
+		if !underPath(c.Path, root) {
+			continue
+		}
+		kind := "file"
+		if c.Dir {
+			kind = "dir"
+		}
+		path := c.Path
+		if path == "" {
+			path = "<unreachable>"
+		}
+		fmt.Printf("%s TX=%d..%d %s %s %d %s\n", now, fromTx+1, toTx, c.Kind, kind, c.Inumber, path)
+	}
+}
+
+// underPath tells whether a path is root or lies beneath it. Unreachable files are only under the root of the
+// filesystem.
+func underPath(p, root string) bool {
+	if root == "/" {
+		return true
+	}
+
+	return p == root || strings.HasPrefix(p, root+"/")
+}
+
+func init() {
+	watchCmd.Flags().Duration(flagWatchPollInterval, time.Second, "interval between the polls of immudb")
+	watchCmd.Flags().Uint64(flagWatchSinceTx, 0, "print the changes committed after this transaction, rather than the current one")
+
+	rootCmd.AddCommand(watchCmd)
+}
@@ -1,3 +1,29 @@
-CREATE TABLE inode (inumber INTEGER, size INTEGER NOT NULL, nlink INTEGER NOT NULL, mode INTEGER NOT NULL, atime TIMESTAMP NULL, mtime TIMESTAMP NULL, ctime TIMESTAMP NULL, crtime TIMESTAMP NULL, uid INTEGER NOT NULL, gid INTEGER NOT NULL, to_be_deleted BOOLEAN, PRIMARY KEY(inumber));
+CREATE TABLE inode (inumber INTEGER, size INTEGER NOT NULL, nlink INTEGER NOT NULL, mode INTEGER NOT NULL, atime TIMESTAMP NULL, mtime TIMESTAMP NULL, ctime TIMESTAMP NULL, crtime TIMESTAMP NULL, uid INTEGER NOT NULL, gid INTEGER NOT NULL, to_be_deleted BOOLEAN, generation INTEGER, project INTEGER, sealed BOOLEAN, inline_content BLOB, PRIMARY KEY(inumber));
 
-CREATE TABLE content(inumber INTEGER, content BLOB, PRIMARY KEY(inumber));
+CREATE TABLE content(inumber INTEGER, content BLOB, checksum VARCHAR[64], signature BLOB[64], signer VARCHAR[64], tier VARCHAR[64], PRIMARY KEY(inumber));
+
+CREATE TABLE content_delta(id INTEGER AUTO_INCREMENT, inumber INTEGER NOT NULL, off INTEGER NOT NULL, data BLOB, PRIMARY KEY(id));
+
+CREATE INDEX ON content_delta(inumber);
+
+CREATE TABLE xattr(inumber INTEGER, name VARCHAR[256], value BLOB, PRIMARY KEY(inumber, name));
+
+CREATE TABLE inumber_allocator(id INTEGER, last_inumber INTEGER NOT NULL, generation INTEGER NOT NULL, PRIMARY KEY(id));
+
+CREATE TABLE snapshot(name VARCHAR[256], tx INTEGER NOT NULL, created_at TIMESTAMP, PRIMARY KEY(name));
+
+CREATE TABLE audit(id INTEGER AUTO_INCREMENT, ts TIMESTAMP, op VARCHAR[32], inumber INTEGER, name VARCHAR[600], uid INTEGER, gid INTEGER, pid INTEGER, result VARCHAR[128], db_user VARCHAR[128], PRIMARY KEY(id));
+
+CREATE TABLE user_quota(uid INTEGER, max_bytes INTEGER NOT NULL, max_inodes INTEGER NOT NULL, PRIMARY KEY(uid));
+
+CREATE TABLE dir_quota(inumber INTEGER, max_bytes INTEGER NOT NULL, max_inodes INTEGER NOT NULL, PRIMARY KEY(inumber));
+
+CREATE TABLE trash(inumber INTEGER, parent INTEGER NOT NULL, name VARCHAR[256], deleted_at TIMESTAMP, PRIMARY KEY(inumber));
+
+CREATE TABLE file_lock(inumber INTEGER, retain_until TIMESTAMP NULL, legal_hold BOOLEAN, PRIMARY KEY(inumber));
+
+CREATE TABLE backup(digest VARCHAR[64], tx INTEGER NOT NULL, row_count INTEGER NOT NULL, created_at TIMESTAMP, PRIMARY KEY(digest));
+
+CREATE TABLE quarantine(inumber INTEGER, expected VARCHAR[64], actual VARCHAR[64], detected_at TIMESTAMP, PRIMARY KEY(inumber));
+
+CREATE TABLE tree_root(name VARCHAR[256], tx INTEGER NOT NULL, root VARCHAR[64], created_at TIMESTAMP, PRIMARY KEY(name));
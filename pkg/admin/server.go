@@ -0,0 +1,303 @@
+// Package admin serves the gRPC admin API of a mount, defined in admin.proto, on a unix socket.
+package admin
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative admin.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"immufs/pkg/fs"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements the Admin service on top of a mounted filesystem.
+type Server struct {
+	UnimplementedAdminServer
+
+	fs  *fs.Immufs
+	log *logrus.Entry
+}
+
+// NewServer returns the admin server of a filesystem.
+func NewServer(immufs *fs.Immufs, logger *logrus.Logger) *Server {
+	return &Server{
+		fs:  immufs,
+		log: logger.WithField("component", "admin"),
+	}
+}
+
+// Serve runs the server on a unix socket until ctx is done. Only the user running the mount can
+// connect to the socket. A socket left over by a previous mount is replaced.
+func (s *Server) Serve(ctx context.Context, socket string) error {
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+
+		return fmt.Errorf("%s is in use by another process", socket)
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+
+		return err
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(s.logCall))
+	RegisterAdminServer(server, s)
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	s.log.Infof("serving the admin API on %s", socket)
+
+	return server.Serve(l)
+}
+
+func (s *Server) logCall(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	s.log.Infof("--> %s", info.FullMethod)
+	resp, err := handler(ctx, req)
+	if err != nil {
+		s.log.WithField("API", info.FullMethod).Warningf("%s", err)
+	}
+
+	return resp, err
+}
+
+// toStatus converts an error returned by the filesystem into a gRPC status.
+func toStatus(err error) error {
+	var errno syscall.Errno
+	switch {
+	case errors.Is(err, fs.ErrSnapshotExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, fs.ErrSnapshotNotFound), errors.Is(err, fs.ErrQuotaNotFound), errors.Is(err, fs.ErrInodeNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.As(err, &errno) && errno == syscall.EROFS:
+		return status.Error(codes.FailedPrecondition, "read-only filesystem")
+	case errors.As(err, &errno) && errno == syscall.ENOTDIR:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, fs.ErrNotSupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	}
+
+	return status.Error(codes.Internal, err.Error())
+}
+
+// client returns the immudb client of the mount, for the queries which don't change anything.
+func (s *Server) client() (*fs.ImmuDbClient, error) {
+	idb := s.fs.Client()
+	if idb == nil {
+		return nil, toStatus(fs.ErrNotSupported)
+	}
+
+	return idb, nil
+}
+
+func timestamp(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+
+	return timestamppb.New(*t)
+}
+
+func snapshotMessage(snap *fs.Snapshot) *Snapshot {
+	return &Snapshot{Name: snap.Name, Tx: snap.Tx, CreatedAt: timestamppb.New(snap.CreatedAt)}
+}
+
+func (s *Server) CreateSnapshot(ctx context.Context, req *CreateSnapshotRequest) (*Snapshot, error) {
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "a snapshot name is required")
+	}
+	snap, err := s.fs.CreateSnapshot(ctx, req.Name)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return snapshotMessage(snap), nil
+}
+
+func (s *Server) ListSnapshots(ctx context.Context, req *ListSnapshotsRequest) (*ListSnapshotsResponse, error) {
+	idb, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	snaps, err := idb.ListSnapshots(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &ListSnapshotsResponse{}
+	for i := range snaps {
+		resp.Snapshots = append(resp.Snapshots, snapshotMessage(&snaps[i]))
+	}
+
+	return resp, nil
+}
+
+func (s *Server) Verify(ctx context.Context, req *VerifyRequest) (*VerifyResponse, error) {
+	txID, problems, err := s.fs.Verify(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &VerifyResponse{VerifiedTx: txID}
+	for _, p := range problems {
+		resp.Problems = append(resp.Problems, &Problem{
+			Kind:    p.Kind,
+			Inumber: p.Inumber,
+			Parent:  p.Parent,
+			Name:    p.Name,
+			Detail:  p.Detail,
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *Server) GetStats(ctx context.Context, req *GetStatsRequest) (*Stats, error) {
+	stats, err := s.fs.Stats(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &Stats{
+		Inodes:              stats.Inodes,
+		SpaceUsed:           stats.SpaceUsed,
+		LastTx:              stats.LastTx,
+		OpenHandles:         int64(stats.OpenHandles),
+		MountTime:           timestamppb.New(stats.MountTime),
+		ReadOnly:            stats.ReadOnly,
+		SizeMismatches:      stats.SizeMismatches,
+		LastIndexFlush:      timestamp(stats.LastIndexFlush),
+		LastIndexCompaction: timestamp(stats.LastIndexCompaction),
+	}, nil
+}
+
+func (s *Server) DropCaches(ctx context.Context, req *DropCachesRequest) (*DropCachesResponse, error) {
+	s.fs.DropCaches()
+
+	return &DropCachesResponse{}, nil
+}
+
+func (s *Server) SetQuota(ctx context.Context, req *SetQuotaRequest) (*SetQuotaResponse, error) {
+	if req.MaxBytes < 0 || req.MaxInodes < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limits can't be negative")
+	}
+	if err := s.fs.SetQuota(ctx, fs.Quota{Uid: req.Uid, MaxBytes: req.MaxBytes, MaxInodes: req.MaxInodes}); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &SetQuotaResponse{}, nil
+}
+
+func (s *Server) DeleteQuota(ctx context.Context, req *DeleteQuotaRequest) (*DeleteQuotaResponse, error) {
+	if err := s.fs.DeleteQuota(ctx, req.Uid); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &DeleteQuotaResponse{}, nil
+}
+
+func (s *Server) ListQuotas(ctx context.Context, req *ListQuotasRequest) (*ListQuotasResponse, error) {
+	idb, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	usage, err := idb.ListUsage(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	quotas, err := idb.ListQuotas(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &ListQuotasResponse{}
+	byUid := make(map[uint32]*Quota)
+	for _, u := range usage {
+		q := &Quota{Uid: u.Uid, Bytes: u.Bytes, Inodes: u.Inodes}
+		byUid[u.Uid] = q
+		resp.Quotas = append(resp.Quotas, q)
+	}
+	// Users with a quota but no inodes are listed as well.
+	for _, l := range quotas {
+		q, ok := byUid[l.Uid]
+		if !ok {
+			q = &Quota{Uid: l.Uid}
+			resp.Quotas = append(resp.Quotas, q)
+		}
+		q.MaxBytes, q.MaxInodes = l.MaxBytes, l.MaxInodes
+	}
+
+	return resp, nil
+}
+
+func (s *Server) SetDirQuota(ctx context.Context, req *SetDirQuotaRequest) (*SetDirQuotaResponse, error) {
+	if req.MaxBytes < 0 || req.MaxInodes < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limits can't be negative")
+	}
+	if err := s.fs.SetDirQuota(ctx, req.Path, req.MaxBytes, req.MaxInodes); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &SetDirQuotaResponse{}, nil
+}
+
+func (s *Server) DeleteDirQuota(ctx context.Context, req *DeleteDirQuotaRequest) (*DeleteDirQuotaResponse, error) {
+	if err := s.fs.DeleteDirQuota(ctx, req.Path); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &DeleteDirQuotaResponse{}, nil
+}
+
+func (s *Server) ListDirQuotas(ctx context.Context, req *ListDirQuotasRequest) (*ListDirQuotasResponse, error) {
+	idb, err := s.client()
+	if err != nil {
+		return nil, err
+	}
+	quotas, err := idb.ListDirQuotas(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	paths, err := idb.DirectoryPaths(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &ListDirQuotasResponse{}
+	for _, q := range quotas {
+		u, err := idb.GetDirUsage(ctx, q.Inumber)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		resp.Quotas = append(resp.Quotas, &DirQuota{
+			Path:      paths[q.Inumber],
+			Inumber:   q.Inumber,
+			MaxBytes:  q.MaxBytes,
+			MaxInodes: q.MaxInodes,
+			Bytes:     u.Bytes,
+			Inodes:    u.Inodes,
+		})
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Mask replaces the credentials in the configurations and messages logged.
+const Mask = "***"
+
+// Secrets returns the credentials found in the configuration: the immudb password, the passwords of the user
+// map, the secret key of the S3 gateway, and the passwords of the URLs, the connection URL and the secret key of the
+// tier included.
+func (c Config) Secrets() []string {
+	var secrets []string
+	add := func(secret string) {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	add(c.Password)
+	add(c.S3SecretKey)
+	for _, entry := range c.UserMap {
+		if _, creds, ok := strings.Cut(entry, "="); ok {
+			if _, password, ok := strings.Cut(creds, ":"); ok {
+				add(password)
+			}
+		}
+	}
+	for _, rawURL := range []string{c.URL, c.EventsURL, c.AlertHook, c.OTLPEndpoint, c.TierURL} {
+		if u, err := url.Parse(rawURL); err == nil && u.User != nil {
+			password, _ := u.User.Password()
+			add(password)
+		}
+	}
+
+	return secrets
+}
+
+// Redacted returns a copy of the configuration whose credentials are masked, to be logged or displayed.
+func (c Config) Redacted() Config {
+	if c.Password != "" {
+		c.Password = Mask
+	}
+	if c.S3SecretKey != "" {
+		c.S3SecretKey = Mask
+	}
+	if c.UserMap != nil {
+		userMap := make([]string, len(c.UserMap))
+		for i, entry := range c.UserMap {
+			userMap[i] = entry
+			if uid, creds, ok := strings.Cut(entry, "="); ok {
+				if user, _, ok := strings.Cut(creds, ":"); ok {
+					userMap[i] = uid + "=" + user + ":" + Mask
+				}
+			}
+		}
+		c.UserMap = userMap
+	}
+	c.URL = redactURL(c.URL)
+	c.EventsURL = redactURL(c.EventsURL)
+	c.AlertHook = redactURL(c.AlertHook)
+	c.OTLPEndpoint = redactURL(c.OTLPEndpoint)
+	c.TierURL = redactURL(c.TierURL)
+
+	return c
+}
+
+// redactURL masks the password of a URL, if any.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	if _, ok := u.User.Password(); !ok {
+		return rawURL
+	}
+	// The mask would be escaped as a password.
+	userinfo := url.User(u.User.Username()).String() + ":" + Mask + "@"
+	u.User = nil
+
+	return strings.Replace(u.String(), "//", "//"+userinfo, 1)
+}
+
+// String formats the configuration with its credentials masked, so that printing it with %v or %+v, e.g. in
+// the log, never discloses them.
+func (c Config) String() string {
+	// Without its methods, the copy is formatted field by field.
+	type fields Config
+
+	return fmt.Sprintf("%+v", fields(c.Redacted()))
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStringRedactsSecrets(t *testing.T) {
+	const secret = "s3cr3t-Passw0rd"
+
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"Password", Config{Password: secret}},
+		{"S3SecretKey", Config{S3SecretKey: secret}},
+		{"UserMap", Config{UserMap: []string{"1000=alice:other", "1001=bob:" + secret}}},
+		{"URL", Config{URL: "immudb://immudb:" + secret + "@localhost:3322/defaultdb"}},
+		{"EventsURL", Config{EventsURL: "https://hook:" + secret + "@events.example.com/immufs"}},
+		{"AlertHook", Config{AlertHook: "https://alert:" + secret + "@alerts.example.com/hook"}},
+		{"OTLPEndpoint", Config{OTLPEndpoint: "https://otel:" + secret + "@collector.example.com:4318"}},
+		{"TierURL", Config{TierURL: "s3://minio:" + secret + "@10.0.0.3:9000/immufs"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, formatted := range []string{
+				fmt.Sprintf("%+v", tt.cfg),
+				fmt.Sprintf("%v", tt.cfg),
+				fmt.Sprintf("%+v", &tt.cfg),
+			} {
+				if strings.Contains(formatted, secret) {
+					t.Errorf("secret disclosed in %s", formatted)
+				}
+				if !strings.Contains(formatted, Mask) {
+					t.Errorf("secret not masked in %s", formatted)
+				}
+			}
+		})
+	}
+}
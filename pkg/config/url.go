@@ -0,0 +1,67 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// URLScheme is the scheme of the connection URLs of immudb.
+const URLScheme = "immudb"
+
+// ApplyURL sets the connection settings given by the connection URL of the configuration, if any: the server
+// address and port, the credentials, the database, and the tls query parameter. The settings the URL leaves out
+// keep their values.
+func (c *Config) ApplyURL() error {
+	if c.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		// The error would quote the URL, password included.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != URLScheme {
+		return fmt.Errorf("invalid url scheme %q, expecting %s", u.Scheme, URLScheme)
+	}
+
+	if host := u.Hostname(); host != "" {
+		c.Immudb = host
+	}
+	if port := u.Port(); port != "" {
+		if c.Port, err = strconv.Atoi(port); err != nil {
+			return fmt.Errorf("invalid url port %s", port)
+		}
+	}
+	if u.User != nil {
+		c.User = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			c.Password = password
+		}
+	}
+	if database := strings.TrimPrefix(u.Path, "/"); database != "" {
+		if strings.Contains(database, "/") {
+			return fmt.Errorf("invalid url database %q", database)
+		}
+		c.Database = database
+	}
+	for key, values := range u.Query() {
+		switch key {
+		case "tls":
+			if c.TLS, err = strconv.ParseBool(values[len(values)-1]); err != nil {
+				return fmt.Errorf("invalid url parameter tls=%s", values[len(values)-1])
+			}
+		default:
+			return fmt.Errorf("unknown url parameter %s", key)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,191 @@
+package fs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/codenotary/immudb/embedded/store"
+	"github.com/codenotary/immudb/pkg/client"
+)
+
+// The methods below serve the admin API of a mount (see pkg/admin). Unlike the subcommands, which
+// connect to immudb on their own, they keep the state cached by the mount consistent with their changes.
+
+// Client returns the immudb client of the filesystem, for the queries which don't change anything, or nil if
+// the filesystem is not stored in immudb.
+func (fs *Immufs) Client() *ImmuDbClient {
+	return fs.idb
+}
+
+// Stats returns the statistics of the filesystem, as found in .immufs/stats.
+func (fs *Immufs) Stats(ctx context.Context) (*Stats, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.stats(ctx)
+}
+
+// CreateSnapshot records a snapshot of the filesystem as of the last committed transaction.
+func (fs *Immufs) CreateSnapshot(ctx context.Context, name string) (*Snapshot, error) {
+	if err := fs.checkWritable("CreateSnapshot"); err != nil {
+		return nil, err
+	}
+	if fs.idb == nil {
+		return nil, ErrNotSupported
+	}
+
+	return fs.idb.CreateSnapshot(ctx, name)
+}
+
+// Verify verifies the last transaction with immudb proofs, then looks for inconsistencies between the
+// immufs tables as Fsck does, without repairing them. The filesystem is blocked meanwhile, so that the
+// problems found are not caused by the operations in progress.
+func (fs *Immufs) Verify(ctx context.Context) (uint64, []FsckProblem, error) {
+	if fs.idb == nil {
+		return 0, nil, ErrNotSupported
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	txID, err := fs.idb.VerifyLastTx(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	problems, err := fs.idb.Fsck(ctx, false)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return txID, problems, nil
+}
+
+// DropCaches drops the quota limits and usage cached by the mount, and the content of its cache: they are
+// reloaded from immudb when next needed.
+func (fs *Immufs) DropCaches() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.quotas = make(map[quotaKey]*quotaEntry)
+	if fs.cache != nil {
+		fs.cache.clear()
+	}
+}
+
+// SetQuota creates or replaces the limits of a user.
+func (fs *Immufs) SetQuota(ctx context.Context, q Quota) error {
+	if err := fs.checkWritable("SetQuota"); err != nil {
+		return err
+	}
+	if fs.idb == nil {
+		return ErrNotSupported
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	delete(fs.quotas, quotaKey{id: int64(q.Uid)})
+
+	return fs.idb.SetQuota(ctx, q)
+}
+
+// DeleteQuota removes the limits of a user.
+func (fs *Immufs) DeleteQuota(ctx context.Context, uid uint32) error {
+	if err := fs.checkWritable("DeleteQuota"); err != nil {
+		return err
+	}
+	if fs.idb == nil {
+		return ErrNotSupported
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	delete(fs.quotas, quotaKey{id: int64(uid)})
+
+	return fs.idb.DeleteQuota(ctx, uid)
+}
+
+// SetDirQuota creates or replaces the limits of the directory tree at the given path. The tree is
+// tagged while the filesystem is blocked, so that no inode is created meanwhile with the former project.
+func (fs *Immufs) SetDirQuota(ctx context.Context, path string, maxBytes, maxInodes int64) error {
+	if err := fs.checkWritable("SetDirQuota"); err != nil {
+		return err
+	}
+	if fs.idb == nil {
+		return ErrNotSupported
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	dir, _, err := fs.idb.LookUpPath(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	// Inodes move from a project to another: the usage of both must be computed again.
+	fs.quotas = make(map[quotaKey]*quotaEntry)
+
+	return fs.idb.SetDirQuota(ctx, DirQuota{Inumber: dir.Inumber, MaxBytes: maxBytes, MaxInodes: maxInodes})
+}
+
+// DeleteDirQuota removes the limits of the directory tree at the given path.
+func (fs *Immufs) DeleteDirQuota(ctx context.Context, path string) error {
+	if err := fs.checkWritable("DeleteDirQuota"); err != nil {
+		return err
+	}
+	if fs.idb == nil {
+		return ErrNotSupported
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	dir, parent, err := fs.idb.LookUpPath(ctx, path)
+	if err != nil {
+		return err
+	}
+	var parentProject int64
+	if parent != nil {
+		parentProject = parent.Project
+	}
+
+	fs.quotas = make(map[quotaKey]*quotaEntry)
+
+	return fs.idb.DeleteDirQuota(ctx, dir.Inumber, parentProject)
+}
+
+// VerifyLastTx verifies the last committed transaction with immudb proofs, against the last state
+// of the database the client has verified. It returns the identifier of the transaction.
+func (idb *ImmuDbClient) VerifyLastTx(ctx context.Context) (uint64, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	var txID uint64
+	err := idb.withImmuClient(ctx, func(cl client.ImmuClient) error {
+		state, err := cl.CurrentState(ctx)
+		if err != nil {
+			return err
+		}
+		txID = state.GetTxId()
+		_, err = cl.VerifiedTxByID(ctx, txID)
+
+		return err
+	})
+	if err != nil {
+		idb.log.Errorf("could not verify transaction %d: %s", txID, err)
+		if errors.Is(err, store.ErrCorruptedData) {
+			idb.verifyFailures.Add(1)
+		}
+
+		return 0, wrapErr(err)
+	}
+
+	return txID, nil
+}
+
+// VerifyFailures returns the number of transactions found corrupted by VerifyLastTx since the client was created.
+func (idb *ImmuDbClient) VerifyFailures() int64 {
+	return idb.verifyFailures.Load()
+}
@@ -0,0 +1,231 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Alert reports a condition of the mount needing attention, e.g. immudb failing most queries, when it starts
+// (firing) and when it ends (resolved).
+type Alert struct {
+	Name  string `json:"name"`  // db-errors, verify-failures or flush-backlog
+	State string `json:"state"` // firing or resolved
+	// Value observed by the check, e.g. the errors since the previous check, and the threshold it is compared to.
+	Value     int64     `json:"value"`
+	Threshold int64     `json:"threshold"`
+	Interval  string    `json:"interval"`
+	Host      string    `json:"host"`
+	Time      time.Time `json:"time"`
+}
+
+// AlertHook notifies the operators of the alerts.
+type AlertHook interface {
+	Alert(ctx context.Context, a *Alert) error
+}
+
+// NewAlertHook returns the hook for the given URL:
+//   - http:// and https:// URLs receive a POST request per alert, with a JSON body;
+//   - exec: URLs run the command following "exec:" with a shell, with the alert in JSON format on its
+//     standard input, and its name and state in IMMUFS_ALERT and IMMUFS_ALERT_STATE.
+func NewAlertHook(hookURL string) (AlertHook, error) {
+	switch {
+	case strings.HasPrefix(hookURL, "http://"), strings.HasPrefix(hookURL, "https://"):
+		return &webhookAlertHook{url: hookURL, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	case strings.HasPrefix(hookURL, "exec:") && len(hookURL) > len("exec:"):
+		return &execAlertHook{command: strings.TrimPrefix(hookURL, "exec:")}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported alert hook: %s", hookURL)
+}
+
+type webhookAlertHook struct {
+	url    string
+	client *http.Client
+}
+
+func (h *webhookAlertHook) Alert(ctx context.Context, a *Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook replied %s", res.Status)
+	}
+
+	return nil
+}
+
+type execAlertHook struct {
+	command string
+}
+
+func (h *execAlertHook) Alert(ctx context.Context, a *Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", h.command)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(), "IMMUFS_ALERT="+a.Name, "IMMUFS_ALERT_STATE="+a.State)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", h.command, err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// Time given to a hook to deliver an alert.
+const alertTimeout = 30 * time.Second
+
+// alertRule watches a value of the mount, measured at every interval.
+type alertRule struct {
+	name      string
+	threshold int64
+	// measure returns the value over the last interval.
+	measure func() int64
+	firing  bool
+}
+
+// deltaOf measures the growth of a counter between two intervals.
+func deltaOf(counter func() int64) func() int64 {
+	last := counter()
+
+	return func() int64 {
+		n := counter()
+		delta := n - last
+		last = n
+
+		return delta
+	}
+}
+
+// alerter checks the health of a mount at every interval, and notifies the hook when a value exceeds its
+// threshold, then once it is back under it. It doesn't take the lock of the filesystem, so that a wedged mount
+// is reported as well.
+type alerter struct {
+	hook     AlertHook
+	log      *logrus.Entry
+	interval time.Duration
+	rules    []*alertRule
+	host     string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newAlerter(fs *Immufs, hook AlertHook, interval time.Duration, dbErrors, verifyFailures, flushBacklog int64) *alerter {
+	host, _ := os.Hostname()
+
+	a := &alerter{
+		hook:     hook,
+		log:      fs.log.WithField("component", "alerter"),
+		interval: interval,
+		host:     host,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	a.rules = []*alertRule{
+		{name: "db-errors", threshold: dbErrors, measure: deltaOf(fs.counters.backendErrors.Load)},
+		{name: "verify-failures", threshold: verifyFailures, measure: deltaOf(func() int64 {
+			if fs.idb == nil {
+				return 0
+			}
+
+			return fs.idb.VerifyFailures() + fs.idb.SizeMismatches()
+		})},
+		// Writes are committed before returning: the only writes flushed later are the events.
+		{name: "flush-backlog", threshold: flushBacklog, measure: func() int64 {
+			if fs.events == nil {
+				return 0
+			}
+
+			return int64(fs.events.Backlog())
+		}},
+	}
+
+	return a
+}
+
+// Start checks the mount in background.
+func (a *alerter) Start() {
+	go a.run()
+}
+
+// Stop terminates the checks and waits for the alerts being delivered, if any.
+func (a *alerter) Stop() {
+	close(a.stop)
+	<-a.done
+}
+
+// check measures the values and notifies the alerts starting or ending.
+func (a *alerter) check() {
+	for _, r := range a.rules {
+		value := r.measure()
+		firing := value > r.threshold
+		if firing == r.firing {
+			continue
+		}
+		r.firing = firing
+
+		alert := &Alert{
+			Name:      r.name,
+			State:     "resolved",
+			Value:     value,
+			Threshold: r.threshold,
+			Interval:  a.interval.String(),
+			Host:      a.host,
+			Time:      time.Now(),
+		}
+		if firing {
+			alert.State = "firing"
+			a.log.Warnf("%s: %d, above %d", r.name, value, r.threshold)
+		} else {
+			a.log.Infof("%s resolved: %d", r.name, value)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+		if err := a.hook.Alert(ctx, alert); err != nil {
+			a.log.Errorf("could not deliver alert %s: %s", r.name, err)
+			// Delivered again at the next check, if still relevant.
+			r.firing = !firing
+		}
+		cancel()
+	}
+}
+
+func (a *alerter) run() {
+	defer close(a.done)
+
+	tick, stopTick := ticker(a.interval)
+	defer stopTick()
+
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-tick:
+			a.check()
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// asOfBackend serves the filesystem as of a past transaction of an ImmuDbClient, e.g. of a snapshot (see Snapshot
+// in the configuration). The state is frozen: writes fail with EROFS. The space used and the inumbers allocated are
+// the current ones.
+type asOfBackend struct {
+	Backend
+	idb *ImmuDbClient
+	tx  uint64
+	// Period clauses of the queries of the inodes and of the contents, which differ with a separate content
+	// database (see ReadContentAt).
+	period        string
+	contentPeriod string
+	contentArgs   []any
+}
+
+var (
+	_ Backend      = (*asOfBackend)(nil)
+	_ inodesGetter = (*asOfBackend)(nil)
+)
+
+func newAsOfBackend(ctx context.Context, idb *ImmuDbClient, tx uint64) (*asOfBackend, error) {
+	period, err := idb.periodAsOf(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	a := &asOfBackend{Backend: idb, idb: idb, tx: tx, period: period, contentPeriod: period}
+	if idb.contentApart {
+		ts, err := idb.TxTime(ctx, tx)
+		if err != nil {
+			return nil, err
+		}
+		a.contentPeriod, a.contentArgs = "UNTIL ?", []any{ts}
+	}
+
+	return a, nil
+}
+
+// asOfSnapshot returns the backend serving the filesystem as of a snapshot.
+func (idb *ImmuDbClient) asOfSnapshot(ctx context.Context, name string) (*asOfBackend, error) {
+	snap, err := idb.GetSnapshot(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", name, err)
+	}
+
+	return newAsOfBackend(ctx, idb, snap.Tx)
+}
+
+func (a *asOfBackend) GetInode(ctx context.Context, inumber int64) (*Inode, error) {
+	inode, err := a.idb.getInode(ctx, inumber, a.period)
+	if err != nil {
+		return nil, err
+	}
+	inode.cl = a
+
+	return inode, nil
+}
+
+func (a *asOfBackend) GetInodes(ctx context.Context, inumbers []int64) (map[int64]*Inode, error) {
+	inodes, err := a.idb.getInodes(ctx, inumbers, a.period)
+	if err != nil {
+		return nil, err
+	}
+	for _, inode := range inodes {
+		inode.cl = a
+	}
+
+	return inodes, nil
+}
+
+func (a *asOfBackend) GetChildren(ctx context.Context, parent int64) ([]fuseutil.Dirent, error) {
+	return a.idb.getChildren(ctx, parent, a.period)
+}
+
+func (a *asOfBackend) GetChildrenPage(ctx context.Context, parent int64, after fuseops.DirOffset, limit int) ([]fuseutil.Dirent, error) {
+	return a.idb.getChildrenPage(ctx, parent, a.period, after, limit)
+}
+
+func (a *asOfBackend) ReadContent(ctx context.Context, inumber int64) ([]byte, error) {
+	return a.idb.readContent(ctx, inumber, a.contentPeriod, a.contentArgs...)
+}
+
+func (a *asOfBackend) GetXattr(ctx context.Context, inumber int64, name string) ([]byte, error) {
+	return a.idb.getXattr(ctx, inumber, name, a.period)
+}
+
+func (a *asOfBackend) ListXattrs(ctx context.Context, inumber int64) ([]string, error) {
+	return a.idb.listXattrs(ctx, inumber, a.period)
+}
+
+// CurrentTx returns the transaction served: nothing changes after it.
+func (a *asOfBackend) CurrentTx(ctx context.Context) (uint64, error) {
+	return a.tx, nil
+}
+
+func (a *asOfBackend) WriteInode(ctx context.Context, inode *Inode) error {
+	return syscall.EROFS
+}
+
+func (a *asOfBackend) DeleteInode(ctx context.Context, inumber int64) error {
+	return syscall.EROFS
+}
+
+func (a *asOfBackend) AllocateInumber(ctx context.Context) (int64, int64, error) {
+	return 0, 0, syscall.EROFS
+}
+
+func (a *asOfBackend) WriteChildren(ctx context.Context, parent int64, children []fuseutil.Dirent) error {
+	return syscall.EROFS
+}
+
+func (a *asOfBackend) UpdateChildren(ctx context.Context, parent *Inode, update func([]fuseutil.Dirent) ([]fuseutil.Dirent, error)) error {
+	return syscall.EROFS
+}
+
+func (a *asOfBackend) WriteFile(ctx context.Context, inode *Inode, data []byte) error {
+	return syscall.EROFS
+}
+
+func (a *asOfBackend) SetXattr(ctx context.Context, inumber int64, name string, value []byte) error {
+	return syscall.EROFS
+}
+
+func (a *asOfBackend) RemoveXattr(ctx context.Context, inumber int64, name string) error {
+	return syscall.EROFS
+}
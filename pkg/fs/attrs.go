@@ -0,0 +1,209 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/sirupsen/logrus"
+)
+
+// inodeTimes are the timestamps of an inode which may change without any other attribute, e.g. when it is read.
+type inodeTimes struct {
+	atime time.Time
+	mtime time.Time
+	ctime time.Time
+}
+
+func timesOf(inode *Inode) inodeTimes {
+	return inodeTimes{atime: inode.Atime, mtime: inode.Mtime, ctime: inode.Ctime}
+}
+
+func (t inodeTimes) equal(other inodeTimes) bool {
+	return t.atime.Equal(other.atime) && t.mtime.Equal(other.mtime) && t.ctime.Equal(other.ctime)
+}
+
+func (t inodeTimes) apply(inode *Inode) {
+	inode.Atime, inode.Mtime, inode.Ctime = t.atime, t.mtime, t.ctime
+}
+
+// birthTime returns the creation time of an inode, or its change time if the creation time was not recorded, e.g.
+// by the writers of older versions: the earliest time known for the inode, and stored as its creation time once the
+// inode is written again.
+func birthTime(crtime, ctime time.Time) time.Time {
+	if crtime.IsZero() {
+		return ctime
+	}
+
+	return crtime
+}
+
+// crtimeXattrValue returns the creation time of an inode, in RFC 3339 format with nanoseconds: the kernel does not
+// ask filesystems for it on Linux, so that statx can't report it.
+func crtimeXattrValue(inode *Inode) []byte {
+	return []byte(birthTime(inode.Crtime, inode.Ctime).UTC().Format(time.RFC3339Nano))
+}
+
+// pendingTimes are the timestamps of an inode not written yet, together with those stored when they changed:
+// if the stored ones differ, the inode has been written since, pending timestamps included, or changed by
+// another mount, and the pending ones are obsolete.
+type pendingTimes struct {
+	stored inodeTimes
+	times  inodeTimes
+}
+
+// writeTimes persists the timestamps of an inode read by getInode, whose other attributes didn't change.
+// When attribute writes are coalesced they are only recorded, to be written by the next flush: until then
+// getInode reports them.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) writeTimes(inode *Inode) error {
+	if fs.attrFlusher == nil {
+		return inode.write()
+	}
+
+	id := fuseops.InodeID(inode.Inumber)
+	pending, ok := fs.pendingTimes[id]
+	if !ok {
+		pending.stored = inode.storedTimes
+	}
+	pending.times = timesOf(inode)
+	fs.pendingTimes[id] = pending
+
+	return nil
+}
+
+// overlayTimes replaces the timestamps of an inode read from the backend with the pending ones, if any, and
+// its access time with the one kept in memory, if later.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) overlayTimes(inode *Inode) {
+	inode.storedTimes = timesOf(inode)
+
+	id := fuseops.InodeID(inode.Inumber)
+	if pending, ok := fs.pendingTimes[id]; ok {
+		if pending.stored.equal(inode.storedTimes) {
+			pending.times.apply(inode)
+		} else {
+			delete(fs.pendingTimes, id)
+		}
+	}
+	if atime, ok := fs.atimes[id]; ok && atime.After(inode.Atime) {
+		inode.Atime = atime
+	}
+}
+
+// flushTimes writes the pending timestamps of an inode, if any.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) flushTimes(ctx context.Context, id fuseops.InodeID) error {
+	pending, ok := fs.pendingTimes[id]
+	if !ok {
+		return nil
+	}
+	delete(fs.pendingTimes, id)
+
+	inode, err := fs.backend.GetInode(ctx, int64(id))
+	if errors.Is(err, ErrInodeNotFound) {
+		// Removed in the meantime.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !pending.stored.equal(timesOf(inode)) {
+		return nil
+	}
+	pending.times.apply(inode)
+
+	return inode.write()
+}
+
+// flushAllTimes writes the pending timestamps of every inode. The failed writes are logged and dropped:
+// they are only timestamps, and the next ones would fail again if the backend is unavailable.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) flushAllTimes(ctx context.Context) {
+	for id := range fs.pendingTimes {
+		if err := fs.flushTimes(ctx, id); err != nil {
+			fs.log.Errorf("could not write the timestamps of inode %d: %s", id, err)
+		}
+	}
+}
+
+// attrFlusher periodically writes the timestamps coalesced by writeTimes.
+type attrFlusher struct {
+	fs  *Immufs
+	log *logrus.Entry
+
+	interval atomicDuration
+
+	stop  chan struct{}
+	done  chan struct{}
+	reset chan struct{}
+}
+
+func newAttrFlusher(fs *Immufs, log *logrus.Entry, interval time.Duration) *attrFlusher {
+	f := &attrFlusher{
+		fs:    fs,
+		log:   log.WithField("component", "attr flusher"),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+		reset: make(chan struct{}, 1),
+	}
+	f.interval.Store(interval)
+
+	return f
+}
+
+// Start runs the flushes in background.
+func (f *attrFlusher) Start() {
+	go f.run()
+}
+
+// Stop terminates the flushes and writes the timestamps still pending.
+func (f *attrFlusher) Stop() {
+	close(f.stop)
+	<-f.done
+}
+
+// setInterval changes the interval between the flushes, which starts over from the change.
+func (f *attrFlusher) setInterval(interval time.Duration) {
+	f.interval.Store(interval)
+	select {
+	case f.reset <- struct{}{}:
+	default:
+	}
+}
+
+func (f *attrFlusher) flush() {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+
+	if n := len(f.fs.pendingTimes); n > 0 {
+		f.log.Debugf("writing the timestamps of %d inodes", n)
+	}
+	f.fs.flushAllTimes(context.Background())
+}
+
+func (f *attrFlusher) run() {
+	defer close(f.done)
+
+	tickC, stopTick := ticker(f.interval.Load())
+	defer func() { stopTick() }()
+
+	for {
+		select {
+		case <-f.stop:
+			f.flush()
+
+			return
+		case <-f.reset:
+			stopTick()
+			tickC, stopTick = ticker(f.interval.Load())
+		case <-tickC:
+			f.flush()
+		}
+	}
+}
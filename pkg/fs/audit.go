@@ -0,0 +1,323 @@
+package fs
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	immusql "github.com/codenotary/immudb/embedded/sql"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// AuditRecord describes a mutating operation performed through the filesystem.
+type AuditRecord struct {
+	Time time.Time
+	Op   string
+
+	// Inode the operation applies to, or its parent directory when Name is set.
+	// Inodes do not know their path, which can be rebuilt from the history of the directories.
+	Inumber int64
+	Name    string
+
+	// Credentials of the calling process. Uid and Gid are -1 when the process could not be inspected.
+	Uid int64
+	Gid int64
+	Pid uint32
+
+	// "OK", or the error returned to the kernel.
+	Result string
+
+	// immudb user the record was stored by, i.e. the user the mount is connected as. Empty in the records
+	// stored before it was recorded.
+	DBUser string
+}
+
+// AppendAudit stores an audit record. Records are never updated nor deleted.
+func (idb *ImmuDbClient) AppendAudit(ctx context.Context, rec *AuditRecord) error {
+	ctx, cancel := withTimeout(ctx, idb.writeTimeout.Load())
+	defer cancel()
+
+	// Recorded as the immudb user the operation was served as.
+	pools := idb.pools(ctx)
+	rec.DBUser = pools.user
+	err := appendAudit(ctx, pools.cl, rec)
+	if err != nil {
+		idb.log.Errorf("could not append audit record %+v: %s", *rec, err)
+	}
+
+	return wrapErr(err)
+}
+
+func appendAudit(ctx context.Context, q querier, rec *AuditRecord) error {
+	_, err := q.ExecContext(ctx, "INSERT INTO audit(ts, op, inumber, name, uid, gid, pid, result, db_user) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		rec.Time, rec.Op, rec.Inumber, rec.Name, rec.Uid, rec.Gid, int64(rec.Pid), rec.Result, rec.DBUser)
+
+	return err
+}
+
+// Provenance returns the audit record of the operation which wrote the content of an inode, or the entries of a
+// directory, at the given transaction, nil if there is none: the operations are recorded after they succeed,
+// so it is the first record of the inode from the transaction on, and before the next write, if any (nextTx, 0
+// if none). There is none when the operation was not recorded (see --audit-log), or the audit table doesn't
+// exist.
+func (idb *ImmuDbClient) Provenance(ctx context.Context, inumber int64, tx, nextTx uint64) (*AuditRecord, error) {
+	ctx, cancel := withTimeout(ctx, idb.readTimeout.Load())
+	defer cancel()
+
+	period := fmt.Sprintf("SINCE TX %d", tx)
+	if nextTx > 0 {
+		period += fmt.Sprintf(" UNTIL TX %d", nextTx-1)
+	}
+	res, err := idb.cl.QueryContext(ctx, fmt.Sprintf("SELECT * FROM audit %s WHERE inumber=? ORDER BY id LIMIT 1", period), inumber)
+	if err != nil && strings.Contains(err.Error(), immusql.ErrTableDoesNotExist.Error()) {
+		return nil, nil
+	}
+	if err != nil {
+		idb.log.Errorf("could not get the audit record of inode %d at tx %d: %s", inumber, tx, err)
+
+		return nil, wrapErr(err)
+	}
+	defer res.Close()
+
+	if !res.Next() {
+		return nil, wrapErr(res.Err())
+	}
+	var rec AuditRecord
+	var name, result, dbUser sql.NullString
+	var pid int64
+	err = scanNamed(res, map[string]any{
+		"ts":      &rec.Time,
+		"op":      &rec.Op,
+		"inumber": &rec.Inumber,
+		"name":    &name,
+		"uid":     &rec.Uid,
+		"gid":     &rec.Gid,
+		"pid":     &pid,
+		"result":  &result,
+		"db_user": &dbUser,
+	})
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	rec.Name, rec.Result, rec.DBUser, rec.Pid = name.String, result.String, dbUser.String, uint32(pid)
+
+	return &rec, nil
+}
+
+// ContentProvenance returns the audit record of the operation which wrote the content of an inode as of a
+// transaction (see Provenance), together with the transaction which wrote it, 0 if none.
+//
+// The contents stored in a separate database are not supported: the transactions of the filesystem don't
+// identify their revisions.
+func (idb *ImmuDbClient) ContentProvenance(ctx context.Context, inumber int64, isDir bool, tx uint64) (*AuditRecord, uint64, error) {
+	if idb.contentApart && !isDir {
+		return nil, 0, fmt.Errorf("content of file %d, stored in a separate database: %w", inumber, ErrNotSupported)
+	}
+
+	writtenAt, err := idb.lastContentWrite(ctx, inumber, 1, tx)
+	if err != nil || writtenAt == 0 {
+		return nil, 0, err
+	}
+	current, err := idb.CurrentTx(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	next, err := idb.firstContentWrite(ctx, inumber, writtenAt+1, current)
+	if err != nil {
+		return nil, 0, err
+	}
+	rec, err := idb.Provenance(ctx, inumber, writtenAt, next)
+
+	return rec, writtenAt, err
+}
+
+// credsKey is the key of the credentials an operation is served for, in its context, when known otherwise than
+// from its process.
+type credsKey struct{ uid, gid uint32 }
+
+// WithCredentials returns a context telling the uid and gid the operations are served for, whatever the process
+// they come from, e.g. those of the peer of a unix socket.
+func WithCredentials(ctx context.Context, uid, gid uint32) context.Context {
+	return context.WithValue(ctx, credsKey{}, credsKey{uid: uid, gid: gid})
+}
+
+// callerCreds returns the uid and gid an operation is served for: those of ctx if any, or those of its process.
+func callerCreds(ctx context.Context, pid uint32) (uid, gid int64, err error) {
+	if creds, ok := ctx.Value(credsKey{}).(credsKey); ok {
+		return int64(creds.uid), int64(creds.gid), nil
+	}
+
+	return processCreds(pid)
+}
+
+// processCreds returns the filesystem uid and gid of a process, as found in /proc.
+func processCreds(pid uint32) (uid, gid int64, err error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return -1, -1, err
+	}
+	defer f.Close()
+
+	uid, gid = -1, -1
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// The lines read as "Uid:	<real>	<effective>	<saved>	<filesystem>".
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 5 {
+			continue
+		}
+		switch fields[0] {
+		case "Uid:":
+			uid, err = strconv.ParseInt(fields[4], 10, 64)
+		case "Gid:":
+			gid, err = strconv.ParseInt(fields[4], 10, 64)
+		}
+		if err != nil {
+			return -1, -1, err
+		}
+	}
+
+	return uid, gid, sc.Err()
+}
+
+// auditedFS records every mutating operation served by the wrapped filesystem in the audit table.
+type auditedFS struct {
+	*Immufs
+}
+
+// NewAuditedFileSystem wraps fs so that its mutating operations are recorded in the audit table.
+func NewAuditedFileSystem(fs *Immufs) fuseutil.FileSystem {
+	return &auditedFS{fs}
+}
+
+// record stores the outcome of an operation. Failures are logged only: the operation already took place.
+func (a *auditedFS) record(ctx context.Context, op string, opCtx fuseops.OpContext, id fuseops.InodeID, name string, err error) {
+	rec := &AuditRecord{
+		Time:    time.Now(),
+		Op:      op,
+		Inumber: int64(id),
+		Name:    name,
+		Pid:     opCtx.Pid,
+		Result:  "OK",
+	}
+	if isControlInode(id) {
+		// Control inodes are not stored in immudb.
+		rec.Inumber = 0
+		rec.Name = controlDirName + "/" + controlCtlName
+	}
+	if err != nil {
+		rec.Result = err.Error()
+	}
+
+	uid, gid, cerr := callerCreds(ctx, opCtx.Pid)
+	if cerr != nil {
+		a.log.WithField("API", op).Warningf("could not read the credentials of PID %d: %s", opCtx.Pid, cerr)
+	}
+	rec.Uid, rec.Gid = uid, gid
+
+	// The audit must not be lost because the caller gave up waiting.
+	ctx = context.TODO()
+	if uid >= 0 {
+		ctx = withCaller(ctx, uint32(uid))
+	}
+	if err := a.idb.AppendAudit(ctx, rec); err != nil {
+		a.log.WithField("API", op).Errorf("audit record lost: %s", err)
+	}
+}
+
+func (a *auditedFS) SetInodeAttributes(ctx context.Context, op *fuseops.SetInodeAttributesOp) error {
+	err := a.Immufs.SetInodeAttributes(ctx, op)
+	a.record(ctx, "SetInodeAttributes", op.OpContext, op.Inode, "", err)
+
+	return err
+}
+
+func (a *auditedFS) MkDir(ctx context.Context, op *fuseops.MkDirOp) error {
+	err := a.Immufs.MkDir(ctx, op)
+	a.record(ctx, "MkDir", op.OpContext, op.Parent, op.Name, err)
+
+	return err
+}
+
+func (a *auditedFS) MkNode(ctx context.Context, op *fuseops.MkNodeOp) error {
+	err := a.Immufs.MkNode(ctx, op)
+	a.record(ctx, "MkNode", op.OpContext, op.Parent, op.Name, err)
+
+	return err
+}
+
+func (a *auditedFS) CreateFile(ctx context.Context, op *fuseops.CreateFileOp) error {
+	err := a.Immufs.CreateFile(ctx, op)
+	a.record(ctx, "CreateFile", op.OpContext, op.Parent, op.Name, err)
+
+	return err
+}
+
+func (a *auditedFS) CreateSymlink(ctx context.Context, op *fuseops.CreateSymlinkOp) error {
+	err := a.Immufs.CreateSymlink(ctx, op)
+	a.record(ctx, "CreateSymlink", op.OpContext, op.Parent, op.Name, err)
+
+	return err
+}
+
+func (a *auditedFS) CreateLink(ctx context.Context, op *fuseops.CreateLinkOp) error {
+	err := a.Immufs.CreateLink(ctx, op)
+	a.record(ctx, "CreateLink", op.OpContext, op.Parent, op.Name, err)
+
+	return err
+}
+
+func (a *auditedFS) Rename(ctx context.Context, op *fuseops.RenameOp) error {
+	err := a.Immufs.Rename(ctx, op)
+	a.record(ctx, "Rename", op.OpContext, op.OldParent, fmt.Sprintf("%s -> %d/%s", op.OldName, op.NewParent, op.NewName), err)
+
+	return err
+}
+
+func (a *auditedFS) RmDir(ctx context.Context, op *fuseops.RmDirOp) error {
+	err := a.Immufs.RmDir(ctx, op)
+	a.record(ctx, "RmDir", op.OpContext, op.Parent, op.Name, err)
+
+	return err
+}
+
+func (a *auditedFS) Unlink(ctx context.Context, op *fuseops.UnlinkOp) error {
+	err := a.Immufs.Unlink(ctx, op)
+	a.record(ctx, "Unlink", op.OpContext, op.Parent, op.Name, err)
+
+	return err
+}
+
+func (a *auditedFS) WriteFile(ctx context.Context, op *fuseops.WriteFileOp) error {
+	err := a.Immufs.WriteFile(ctx, op)
+	a.record(ctx, "WriteFile", op.OpContext, op.Inode, "", err)
+
+	return err
+}
+
+func (a *auditedFS) SetXattr(ctx context.Context, op *fuseops.SetXattrOp) error {
+	err := a.Immufs.SetXattr(ctx, op)
+	a.record(ctx, "SetXattr", op.OpContext, op.Inode, op.Name, err)
+
+	return err
+}
+
+func (a *auditedFS) RemoveXattr(ctx context.Context, op *fuseops.RemoveXattrOp) error {
+	err := a.Immufs.RemoveXattr(ctx, op)
+	a.record(ctx, "RemoveXattr", op.OpContext, op.Inode, op.Name, err)
+
+	return err
+}
+
+func (a *auditedFS) Fallocate(ctx context.Context, op *fuseops.FallocateOp) error {
+	err := a.Immufs.Fallocate(ctx, op)
+	a.record(ctx, "Fallocate", op.OpContext, op.Inode, "", err)
+
+	return err
+}
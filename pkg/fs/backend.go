@@ -0,0 +1,203 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"immufs/pkg/config"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotSupported is returned by the features relying on the SQL tables of immudb when the filesystem is stored by
+// another backend.
+var ErrNotSupported = errors.New("not supported by the backend")
+
+// Backend stores the filesystem: the inodes, the entries of the directories, the content of the files and their
+// extended attributes. The FUSE layer, and the subcommands going through it, only store the filesystem through a
+// Backend; ImmuDbClient is the backend storing it in immudb.
+//
+// The inodes returned by a backend are bound to it: their methods read and write through it.
+type Backend interface {
+	// GetInode returns the inode with the given inumber, or ErrInodeNotFound.
+	GetInode(ctx context.Context, inumber int64) (*Inode, error)
+	// WriteInode creates or replaces an inode.
+	WriteInode(ctx context.Context, inode *Inode) error
+	// DeleteInode removes an inode, its content and its extended attributes.
+	DeleteInode(ctx context.Context, inumber int64) error
+
+	// AllocateInumber reserves an inumber never used before, returned with its generation.
+	AllocateInumber(ctx context.Context) (inumber int64, generation int64, err error)
+	// NextInumber returns the inumber the next allocation will return.
+	NextInumber(ctx context.Context) (int64, error)
+
+	// GetChildren returns the entries of a directory.
+	GetChildren(ctx context.Context, parent int64) ([]fuseutil.Dirent, error)
+	// WriteChildren replaces the entries of a directory.
+	WriteChildren(ctx context.Context, parent int64, children []fuseutil.Dirent) error
+	// UpdateChildren replaces the entries of a directory with those returned by update, and writes the inode of the
+	// directory, atomically.
+	UpdateChildren(ctx context.Context, parent *Inode, update func([]fuseutil.Dirent) ([]fuseutil.Dirent, error)) error
+
+	// ReadContent returns the content of a file, or the target of a symlink.
+	ReadContent(ctx context.Context, inumber int64) ([]byte, error)
+	// WriteFile replaces the content of a file and writes its inode, atomically.
+	WriteFile(ctx context.Context, inode *Inode, data []byte) error
+
+	// GetXattr returns the value of an extended attribute, or ErrXattrNotFound.
+	GetXattr(ctx context.Context, inumber int64, name string) ([]byte, error)
+	// ListXattrs returns the names of the extended attributes of an inode.
+	ListXattrs(ctx context.Context, inumber int64) ([]string, error)
+	SetXattr(ctx context.Context, inumber int64, name string, value []byte) error
+	RemoveXattr(ctx context.Context, inumber int64, name string) error
+
+	// SpaceUsed returns the total size of the files.
+	SpaceUsed(ctx context.Context) (int64, error)
+	// CurrentTx returns the identifier of the last change committed, increasing with every change.
+	CurrentTx(ctx context.Context) (uint64, error)
+
+	// Destroy releases the resources of the backend.
+	Destroy(ctx context.Context) error
+}
+
+var _ Backend = (*ImmuDbClient)(nil)
+
+// rangeAllocator is implemented by the backends reserving several inumbers at once (see InumberBatch in the
+// configuration).
+type rangeAllocator interface {
+	// AllocateInumbers reserves n consecutive inumbers never used before, returning the first one with their
+	// generation.
+	AllocateInumbers(ctx context.Context, n int64) (first int64, generation int64, err error)
+}
+
+var _ rangeAllocator = (*ImmuDbClient)(nil)
+
+// childrenPager is implemented by the backends reading a page of the entries of a directory without decoding all of
+// them (see getChildrenPage).
+type childrenPager interface {
+	// GetChildrenPage returns at most limit entries of a directory following the cursor after, in the order of
+	// their cookie (see direntCookie), offsets set.
+	GetChildrenPage(ctx context.Context, parent int64, after fuseops.DirOffset, limit int) ([]fuseutil.Dirent, error)
+}
+
+var _ childrenPager = (*ImmuDbClient)(nil)
+
+// inodesGetter is implemented by the backends reading several inodes at once more cheaply than one by one.
+type inodesGetter interface {
+	// GetInodes returns the inodes with the given inumbers. Inodes not found are missing from the result.
+	GetInodes(ctx context.Context, inumbers []int64) (map[int64]*Inode, error)
+}
+
+var _ inodesGetter = (*ImmuDbClient)(nil)
+
+// timeoutSetter is implemented by the backends bounding their queries with the timeouts of the configuration, so
+// that they can be changed while mounted.
+type timeoutSetter interface {
+	setTimeouts(cfg *config.Config)
+}
+
+var (
+	_ timeoutSetter = (*ImmuDbClient)(nil)
+	_ timeoutSetter = (*KVBackend)(nil)
+)
+
+// deltaWriter is implemented by the backends journaling the writes of the files rather than rewriting their content.
+type deltaWriter interface {
+	// writeDelta records data written at off in a file, and writes its inode, atomically. It returns false,
+	// without writing anything, if the whole content must be written instead.
+	writeDelta(ctx context.Context, inode *Inode, off int64, data []byte) (bool, error)
+}
+
+var _ deltaWriter = (*ImmuDbClient)(nil)
+
+// sizeChecker is implemented by the backends counting the files whose size does not match their content.
+type sizeChecker interface {
+	checkSize(inode *Inode, content []byte)
+}
+
+// Backends selectable by the configuration.
+const (
+	// The immudb SQL tables listed in database.sql (see ImmuDbClient), the default.
+	BackendSQL = "sql"
+	// The immudb key-value store (see KVBackend).
+	BackendKV = "kv"
+	// Memory only (see MemoryBackend).
+	BackendMemory = "memory"
+)
+
+// BackendFactory creates a backend for the given configuration.
+type BackendFactory func(ctx context.Context, cfg *config.Config, logger *logrus.Logger) (Backend, error)
+
+// Factories of the backends, by name. Only written by the init functions.
+var backends = make(map[string]BackendFactory)
+
+// RegisterBackend makes a backend selectable by name with the backend option. It is meant to be called by the
+// init function of the file implementing the backend, and panics if the name is already taken.
+func RegisterBackend(name string, factory BackendFactory) {
+	if _, ok := backends[name]; ok {
+		panic("backend registered twice: " + name)
+	}
+	backends[name] = factory
+}
+
+// Backends returns the names of the registered backends, sorted.
+func Backends() []string {
+	var names []string
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// NewBackend returns the backend selected by the configuration, the sql one by default.
+func NewBackend(ctx context.Context, cfg *config.Config, logger *logrus.Logger) (Backend, error) {
+	name := cfg.Backend
+	if name == "" {
+		name = BackendSQL
+	}
+	factory, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %s, expecting one of: %s", name, strings.Join(Backends(), ", "))
+	}
+
+	return factory(ctx, cfg, logger)
+}
+
+// sqlFeatures returns the options of the configuration enabling features which rely on the SQL tables of immudb,
+// only available with the sql backend.
+func sqlFeatures(cfg *config.Config) []string {
+	var options []string
+	for option, enabled := range map[string]bool{
+		"read-only":                cfg.ReadOnly,
+		"snapshot":                 cfg.Snapshot != "",
+		"watch-interval":           cfg.WatchInterval > 0,
+		"audit-log":                cfg.AuditLog,
+		"trash":                    cfg.Trash,
+		"index-flush-interval":     cfg.IndexFlushInterval > 0,
+		"index-compact-interval":   cfg.IndexCompactInterval > 0,
+		"storage-stats-interval":   cfg.StorageStatsInterval > 0,
+		"user-map":                 len(cfg.UserMap) > 0,
+		"signing-key":              cfg.SigningKey != "",
+		"inline-threshold":         cfg.InlineThreshold > 0,
+		"journal":                  cfg.Journal,
+		"journal-compact-interval": cfg.JournalCompactInterval > 0,
+		"disk-cache":               cfg.DiskCache != "",
+		"disconnected-journal":     cfg.DisconnectedJournal != "",
+		"tier-url":                 cfg.TierURL != "",
+		"blob-store":               cfg.BlobStore,
+	} {
+		if enabled {
+			options = append(options, option)
+		}
+	}
+	sort.Strings(options)
+
+	return options
+}
@@ -0,0 +1,194 @@
+package fs
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"time"
+
+	immusql "github.com/codenotary/immudb/embedded/sql"
+	"github.com/codenotary/immudb/pkg/api/schema"
+	"github.com/codenotary/immudb/pkg/client"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Format of the backup dumps: a JSON document per line, i.e. a header, the rows of all the tables
+// and a trailer. The trailer holds the SHA-256 digest of all the lines before it.
+const (
+	backupFormat  = "immufs-backup"
+	backupVersion = 1
+)
+
+// BackupInfo describes a backup, as recorded in the trailer of the dump and in the backup table.
+type BackupInfo struct {
+	Database  string    `json:"database"`
+	Tx        uint64    `json:"tx"`
+	Rows      int64     `json:"rows"`
+	Digest    string    `json:"digest"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type backupHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	Database  string    `json:"database"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type backupRow struct {
+	Table string          `json:"table"`
+	Row   json.RawMessage `json:"row"`
+}
+
+type backupTrailer struct {
+	BackupInfo
+	// immudb state the rows have been verified against, as returned by the server.
+	State json.RawMessage `json:"state"`
+}
+
+// backupWriter writes the lines of a dump, hashing them.
+type backupWriter struct {
+	w      *bufio.Writer
+	digest hash.Hash
+}
+
+func (bw *backupWriter) writeLine(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	bw.digest.Write(line)
+	_, err = bw.w.Write(line)
+
+	return err
+}
+
+// Backup dumps all the immufs tables to w. Every row is verified against the server with immudb proofs
+// before being written, and the immudb state they have been verified against is stored in the trailer,
+// together with a digest of the dump. The digest is also recorded in the backup table: as immudb is
+// tamper-evident, the dump can later be proven unaltered by looking its digest up.
+//
+// Rows are read table by table: for the dump to be consistent, no mount should be writing meanwhile.
+func (idb *ImmuDbClient) Backup(ctx context.Context, w io.Writer) (*BackupInfo, error) {
+	info := &BackupInfo{CreatedAt: time.Now()}
+	bw := &backupWriter{w: bufio.NewWriter(w), digest: sha256.New()}
+	var state *schema.ImmutableState
+
+	err := idb.withImmuClient(ctx, func(cl client.ImmuClient) error {
+		info.Database = cl.GetOptions().Database
+		err := bw.writeLine(backupHeader{
+			Format:    backupFormat,
+			Version:   backupVersion,
+			Database:  info.Database,
+			CreatedAt: info.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, table := range tables {
+			n, err := backupTable(ctx, cl, bw, table.name, table.keys)
+			if err != nil {
+				return fmt.Errorf("table %s: %w", table.name, err)
+			}
+			info.Rows += n
+		}
+
+		state, err = cl.CurrentState(ctx)
+
+		return err
+	})
+	if err != nil {
+		idb.log.Errorf("could not back up: %s", err)
+
+		return nil, wrapErr(err)
+	}
+
+	info.Tx = state.TxId
+	info.Digest = hex.EncodeToString(bw.digest.Sum(nil))
+	rawState, err := protojson.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	if err := bw.writeLine(backupTrailer{BackupInfo: *info, State: rawState}); err != nil {
+		return nil, err
+	}
+	if err := bw.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	_, err = idb.cl.ExecContext(ctx, "INSERT INTO backup(digest, tx, row_count, created_at) VALUES(?, ?, ?, ?)",
+		info.Digest, int64(info.Tx), info.Rows, info.CreatedAt)
+	if err != nil {
+		idb.log.Errorf("could not record backup %s: %s", info.Digest, err)
+
+		return nil, wrapErr(err)
+	}
+
+	return info, nil
+}
+
+// columnName strips the table from the column names returned by queries, i.e. "(table.column)".
+func columnName(col string) string {
+	return col[strings.LastIndex(col, ".")+1 : len(col)-1]
+}
+
+// verifyRow verifies a row returned by a query with immudb proofs, given the primary key of its table.
+func verifyRow(ctx context.Context, cl client.ImmuClient, row *schema.Row, table string, keys []string) error {
+	pk := make([]*schema.SQLValue, len(keys))
+	for i, key := range keys {
+		for j, col := range row.Columns {
+			if columnName(col) == key {
+				pk[i] = row.Values[j]
+			}
+		}
+		if pk[i] == nil {
+			return fmt.Errorf("row of %s without %s", table, key)
+		}
+	}
+
+	if err := cl.VerifyRow(ctx, row, table, pk); err != nil {
+		return fmt.Errorf("row %v of %s could not be verified: %w", schema.RawValue(pk[0]), table, err)
+	}
+
+	return nil
+}
+
+// backupTable writes the rows of a table, verifying each of them. Missing tables (i.e. older schemas) are skipped.
+func backupTable(ctx context.Context, cl client.ImmuClient, bw *backupWriter, table string, keys []string) (int64, error) {
+	res, err := cl.SQLQuery(ctx, "SELECT * FROM "+table, nil, true)
+	if err != nil && strings.Contains(err.Error(), immusql.ErrTableDoesNotExist.Error()) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for _, row := range res.Rows {
+		if err := verifyRow(ctx, cl, row, table, keys); err != nil {
+			return n, err
+		}
+
+		raw, err := protojson.Marshal(row)
+		if err != nil {
+			return n, err
+		}
+		if err := bw.writeLine(backupRow{Table: table, Row: raw}); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
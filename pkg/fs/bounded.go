@@ -0,0 +1,135 @@
+package fs
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// boundedFS limits the operations of the wrapped filesystem reaching immudb which run at the same time, so that a
+// burst of slow ones, e.g. scans of big directories, can't take all the immudb sessions and starve the others. The
+// operations only releasing memory (forgets, releases) are not limited.
+type boundedFS struct {
+	fuseutil.FileSystem
+	slots chan struct{}
+}
+
+// NewBoundedFileSystem wraps fs so that at most n of its operations reaching immudb run at the same time. The
+// others wait for a slot, until interrupted.
+func NewBoundedFileSystem(fs fuseutil.FileSystem, n int) fuseutil.FileSystem {
+	return &boundedFS{FileSystem: fs, slots: make(chan struct{}, n)}
+}
+
+// run serves an operation once a slot is free. Operations interrupted while waiting fail with EINTR.
+func (b *boundedFS) run(ctx context.Context, op func(ctx context.Context) error) error {
+	select {
+	case b.slots <- struct{}{}:
+	case <-ctx.Done():
+		return syscall.EINTR
+	}
+	defer func() { <-b.slots }()
+
+	return op(ctx)
+}
+
+func (b *boundedFS) StatFS(ctx context.Context, op *fuseops.StatFSOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.StatFS(ctx, op) })
+}
+
+func (b *boundedFS) LookUpInode(ctx context.Context, op *fuseops.LookUpInodeOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.LookUpInode(ctx, op) })
+}
+
+func (b *boundedFS) GetInodeAttributes(ctx context.Context, op *fuseops.GetInodeAttributesOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.GetInodeAttributes(ctx, op) })
+}
+
+func (b *boundedFS) SetInodeAttributes(ctx context.Context, op *fuseops.SetInodeAttributesOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.SetInodeAttributes(ctx, op) })
+}
+
+func (b *boundedFS) MkDir(ctx context.Context, op *fuseops.MkDirOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.MkDir(ctx, op) })
+}
+
+func (b *boundedFS) MkNode(ctx context.Context, op *fuseops.MkNodeOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.MkNode(ctx, op) })
+}
+
+func (b *boundedFS) CreateFile(ctx context.Context, op *fuseops.CreateFileOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.CreateFile(ctx, op) })
+}
+
+func (b *boundedFS) CreateLink(ctx context.Context, op *fuseops.CreateLinkOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.CreateLink(ctx, op) })
+}
+
+func (b *boundedFS) CreateSymlink(ctx context.Context, op *fuseops.CreateSymlinkOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.CreateSymlink(ctx, op) })
+}
+
+func (b *boundedFS) Rename(ctx context.Context, op *fuseops.RenameOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.Rename(ctx, op) })
+}
+
+func (b *boundedFS) RmDir(ctx context.Context, op *fuseops.RmDirOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.RmDir(ctx, op) })
+}
+
+func (b *boundedFS) Unlink(ctx context.Context, op *fuseops.UnlinkOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.Unlink(ctx, op) })
+}
+
+func (b *boundedFS) OpenDir(ctx context.Context, op *fuseops.OpenDirOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.OpenDir(ctx, op) })
+}
+
+func (b *boundedFS) ReadDir(ctx context.Context, op *fuseops.ReadDirOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.ReadDir(ctx, op) })
+}
+
+func (b *boundedFS) OpenFile(ctx context.Context, op *fuseops.OpenFileOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.OpenFile(ctx, op) })
+}
+
+func (b *boundedFS) ReadFile(ctx context.Context, op *fuseops.ReadFileOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.ReadFile(ctx, op) })
+}
+
+func (b *boundedFS) WriteFile(ctx context.Context, op *fuseops.WriteFileOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.WriteFile(ctx, op) })
+}
+
+func (b *boundedFS) SyncFile(ctx context.Context, op *fuseops.SyncFileOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.SyncFile(ctx, op) })
+}
+
+func (b *boundedFS) FlushFile(ctx context.Context, op *fuseops.FlushFileOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.FlushFile(ctx, op) })
+}
+
+func (b *boundedFS) ReadSymlink(ctx context.Context, op *fuseops.ReadSymlinkOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.ReadSymlink(ctx, op) })
+}
+
+func (b *boundedFS) RemoveXattr(ctx context.Context, op *fuseops.RemoveXattrOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.RemoveXattr(ctx, op) })
+}
+
+func (b *boundedFS) GetXattr(ctx context.Context, op *fuseops.GetXattrOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.GetXattr(ctx, op) })
+}
+
+func (b *boundedFS) ListXattr(ctx context.Context, op *fuseops.ListXattrOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.ListXattr(ctx, op) })
+}
+
+func (b *boundedFS) SetXattr(ctx context.Context, op *fuseops.SetXattrOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.SetXattr(ctx, op) })
+}
+
+func (b *boundedFS) Fallocate(ctx context.Context, op *fuseops.FallocateOp) error {
+	return b.run(ctx, func(ctx context.Context) error { return b.FileSystem.Fallocate(ctx, op) })
+}
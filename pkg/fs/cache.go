@@ -0,0 +1,429 @@
+package fs
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+
+	"immufs/pkg/metrics"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of entries of the cache, also the labels of its metrics.
+const (
+	cacheInodes   = "inode"
+	cacheDirents  = "dirent"
+	cacheContents = "content"
+	// Inumbers not found.
+	cacheNegative = "negative"
+)
+
+var cacheKinds = []string{cacheInodes, cacheDirents, cacheContents, cacheNegative}
+
+// Approximate memory taken by the bookkeeping of an entry, by an inode and by a directory entry, without its name.
+const (
+	cacheEntryBytes  = 128
+	cacheInodeBytes  = 256
+	cacheDirentBytes = 64
+)
+
+type cacheKey struct {
+	kind    string
+	inumber int64
+}
+
+type cacheEntry struct {
+	key cacheKey
+	// *Inode, []fuseutil.Dirent, []byte, or nil for the inumbers not found.
+	value any
+	size  int64
+}
+
+// CacheStats describes the cache of a mount (see CacheSize in the configuration).
+type CacheStats struct {
+	// Memory budget of the cache, and the memory taken by its entries, in bytes.
+	Budget int64 `json:"budget"`
+	Bytes  int64 `json:"bytes"`
+	// Entries, lookups served from the cache and those read from immudb, by kind of entry.
+	Entries map[string]int64 `json:"entries"`
+	Hits    map[string]int64 `json:"hits"`
+	Misses  map[string]int64 `json:"misses"`
+	// Entries evicted to stay within the budget.
+	Evictions int64 `json:"evictions"`
+	// Inodes read ahead of the lookups, after listing their directory (see prefetcher).
+	Prefetched int64 `json:"prefetched"`
+}
+
+var (
+	cacheBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "immufs_cache_bytes",
+		Help: "Approximate memory taken by the entries of the cache of the mount.",
+	})
+	cacheEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "immufs_cache_entries",
+		Help: "Entries of the cache of the mount, by kind.",
+	}, []string{"kind"})
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "immufs_cache_lookups_total",
+		Help: "Lookups of the cache of the mount, by kind of entry and result (hit or miss).",
+	}, []string{"kind", "result"})
+	cacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "immufs_cache_evictions_total",
+		Help: "Entries evicted from the cache of the mount to stay within its budget.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(cacheBytes, cacheEntries, cacheLookups, cacheEvictions)
+}
+
+// cachedBackend keeps the inodes, the entries of the directories, the contents of the files and the inumbers not
+// found, as read from the wrapped backend, within a memory budget shared by all of them: the least recently used
+// entries are evicted first. The entries changed through the cache are dropped, and those changed by other mounts
+// once the watcher reports them (see Immufs.invalidate). The values are copied in and out, as the callers modify
+// them.
+type cachedBackend struct {
+	Backend
+	budget int64
+
+	mu sync.Mutex
+	// Entries by key, in the order of their last use, most recent first.
+	//
+	// GUARDED_BY(mu)
+	entries map[cacheKey]*list.Element
+	// GUARDED_BY(mu)
+	lru *list.List
+	// GUARDED_BY(mu)
+	stats CacheStats
+	// Incremented whenever entries are dropped, so that values read before can't be cached after (see
+	// putInodes).
+	//
+	// GUARDED_BY(mu)
+	epoch uint64
+}
+
+func newCachedBackend(backend Backend, budget int64) *cachedBackend {
+	c := &cachedBackend{
+		Backend: backend,
+		budget:  budget,
+		entries: make(map[cacheKey]*list.Element),
+		lru:     list.New(),
+		stats: CacheStats{
+			Budget:  budget,
+			Entries: make(map[string]int64),
+			Hits:    make(map[string]int64),
+			Misses:  make(map[string]int64),
+		},
+	}
+	for _, kind := range cacheKinds {
+		cacheEntries.WithLabelValues(kind).Set(0)
+	}
+	cacheBytes.Set(0)
+
+	return c
+}
+
+// lookup returns the value of an entry, if cached, and counts the lookup. Otherwise, it returns the current epoch,
+// at which the value read instead is to be cached (see put).
+func (c *cachedBackend) lookup(kind string, inumber int64) (any, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[cacheKey{kind, inumber}]
+	if !ok {
+		c.stats.Misses[kind]++
+		cacheLookups.WithLabelValues(kind, "miss").Inc()
+
+		return nil, c.epoch, false
+	}
+	c.lru.MoveToFront(elem)
+	c.stats.Hits[kind]++
+	cacheLookups.WithLabelValues(kind, "hit").Inc()
+
+	return elem.Value.(*cacheEntry).value, c.epoch, true
+}
+
+// put caches an entry read while the cache was at the given epoch, evicting the least recently used ones beyond
+// the budget, unless entries were dropped since: the value may have been changed in the meantime, as the reads
+// don't all hold the lock of the mount. Entries bigger than the whole budget are not cached.
+func (c *cachedBackend) put(kind string, inumber int64, value any, size int64, epoch uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.epoch != epoch {
+		return
+	}
+	c.putLocked(kind, inumber, value, size)
+	cacheBytes.Set(float64(c.stats.Bytes))
+}
+
+// LOCKS_REQUIRED(c.mu)
+func (c *cachedBackend) putLocked(kind string, inumber int64, value any, size int64) {
+	size += cacheEntryBytes
+	if size > c.budget {
+		return
+	}
+
+	key := cacheKey{kind, inumber}
+	c.removeLocked(key)
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, size: size})
+	c.stats.Bytes += size
+	c.stats.Entries[kind]++
+	cacheEntries.WithLabelValues(kind).Inc()
+
+	for c.stats.Bytes > c.budget {
+		c.removeLocked(c.lru.Back().Value.(*cacheEntry).key)
+		c.stats.Evictions++
+		cacheEvictions.Inc()
+	}
+}
+
+// drop removes the entries of the given kinds of an inumber.
+func (c *cachedBackend) drop(inumber int64, kinds ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.epoch++
+	for _, kind := range kinds {
+		c.removeLocked(cacheKey{kind, inumber})
+	}
+	cacheBytes.Set(float64(c.stats.Bytes))
+}
+
+// LOCKS_REQUIRED(c.mu)
+func (c *cachedBackend) removeLocked(key cacheKey) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	e := c.lru.Remove(elem).(*cacheEntry)
+	delete(c.entries, key)
+	c.stats.Bytes -= e.size
+	c.stats.Entries[key.kind]--
+	cacheEntries.WithLabelValues(key.kind).Dec()
+}
+
+// invalidate drops every entry of the given inumbers, e.g. changed by other mounts.
+func (c *cachedBackend) invalidate(inumbers []int64) {
+	for _, inumber := range inumbers {
+		c.drop(inumber, cacheKinds...)
+	}
+}
+
+// clear drops every entry.
+func (c *cachedBackend) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.epoch++
+	c.entries = make(map[cacheKey]*list.Element)
+	c.lru.Init()
+	c.stats.Bytes = 0
+	for _, kind := range cacheKinds {
+		c.stats.Entries[kind] = 0
+		cacheEntries.WithLabelValues(kind).Set(0)
+	}
+	cacheBytes.Set(0)
+}
+
+// setBudget changes the memory budget of the cache, evicting the least recently used entries beyond it.
+func (c *cachedBackend) setBudget(budget int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.budget, c.stats.Budget = budget, budget
+	for c.stats.Bytes > c.budget {
+		c.removeLocked(c.lru.Back().Value.(*cacheEntry).key)
+		c.stats.Evictions++
+		cacheEvictions.Inc()
+	}
+	cacheBytes.Set(float64(c.stats.Bytes))
+}
+
+// uncachedInodes returns the inumbers whose inode is not cached, without counting lookups, and the current epoch.
+func (c *cachedBackend) uncachedInodes(inumbers []int64) ([]int64, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var uncached []int64
+	for _, inumber := range inumbers {
+		if _, ok := c.entries[cacheKey{cacheInodes, inumber}]; !ok {
+			uncached = append(uncached, inumber)
+		}
+	}
+
+	return uncached, c.epoch
+}
+
+// putInodes caches inodes read while the cache was at the given epoch, unless entries were dropped since: the
+// inodes may have been changed in the meantime. Inodes already cached are left untouched, so as not to evict
+// entries for nothing. It returns the number of inodes cached.
+func (c *cachedBackend) putInodes(inodes map[int64]*Inode, epoch uint64) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.epoch != epoch {
+		return 0
+	}
+	var n int
+	for inumber, inode := range inodes {
+		if _, ok := c.entries[cacheKey{cacheInodes, inumber}]; ok {
+			continue
+		}
+		c.putLocked(cacheInodes, inumber, c.copyInode(context.TODO(), inode), cacheInodeBytes)
+		n++
+	}
+	c.stats.Prefetched += int64(n)
+	cacheBytes.Set(float64(c.stats.Bytes))
+
+	return n
+}
+
+// status returns a copy of the statistics of the cache.
+func (c *cachedBackend) status() *CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries, stats.Hits, stats.Misses = make(map[string]int64), make(map[string]int64), make(map[string]int64)
+	for _, kind := range cacheKinds {
+		stats.Entries[kind], stats.Hits[kind], stats.Misses[kind] = c.stats.Entries[kind], c.stats.Hits[kind], c.stats.Misses[kind]
+	}
+
+	return &stats
+}
+
+// copyInode returns a copy of an inode bound to the cache, for the operation of ctx.
+func (c *cachedBackend) copyInode(ctx context.Context, inode *Inode) *Inode {
+	cp := *inode
+	cp.cl = c
+	cp.ctx = detach(ctx)
+
+	return &cp
+}
+
+func (c *cachedBackend) GetInode(ctx context.Context, inumber int64) (*Inode, error) {
+	value, epoch, ok := c.lookup(cacheInodes, inumber)
+	if ok {
+		return c.copyInode(ctx, value.(*Inode)), nil
+	}
+	if _, _, ok := c.lookup(cacheNegative, inumber); ok {
+		return nil, ErrInodeNotFound
+	}
+
+	inode, err := c.Backend.GetInode(ctx, inumber)
+	if errors.Is(err, ErrInodeNotFound) {
+		c.put(cacheNegative, inumber, nil, 0, epoch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.put(cacheInodes, inumber, c.copyInode(context.TODO(), inode), cacheInodeBytes, epoch)
+
+	return c.copyInode(ctx, inode), nil
+}
+
+func (c *cachedBackend) WriteInode(ctx context.Context, inode *Inode) error {
+	defer c.drop(inode.Inumber, cacheInodes, cacheNegative)
+
+	return c.Backend.WriteInode(ctx, inode)
+}
+
+func (c *cachedBackend) DeleteInode(ctx context.Context, inumber int64) error {
+	defer c.drop(inumber, cacheKinds...)
+
+	return c.Backend.DeleteInode(ctx, inumber)
+}
+
+func (c *cachedBackend) AllocateInumber(ctx context.Context) (int64, int64, error) {
+	inumber, generation, err := c.Backend.AllocateInumber(ctx)
+	if err == nil {
+		c.drop(inumber, cacheNegative)
+	}
+
+	return inumber, generation, err
+}
+
+func (c *cachedBackend) GetChildren(ctx context.Context, parent int64) ([]fuseutil.Dirent, error) {
+	value, epoch, ok := c.lookup(cacheDirents, parent)
+	if ok {
+		return append([]fuseutil.Dirent(nil), value.([]fuseutil.Dirent)...), nil
+	}
+
+	children, err := c.Backend.GetChildren(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(children)) * cacheDirentBytes
+	for _, child := range children {
+		size += int64(len(child.Name))
+	}
+	c.put(cacheDirents, parent, append([]fuseutil.Dirent(nil), children...), size, epoch)
+
+	return children, nil
+}
+
+// GetChildrenPage pages the entries cached, if any. Otherwise, the page is read from the backend, not cached: the
+// entries of huge directories would take the whole cache.
+func (c *cachedBackend) GetChildrenPage(ctx context.Context, parent int64, after fuseops.DirOffset, limit int) ([]fuseutil.Dirent, error) {
+	if value, _, ok := c.lookup(cacheDirents, parent); ok {
+		return pageOf(value.([]fuseutil.Dirent), after, limit), nil
+	}
+
+	return getChildrenPage(ctx, c.Backend, parent, after, limit)
+}
+
+func (c *cachedBackend) WriteChildren(ctx context.Context, parent int64, children []fuseutil.Dirent) error {
+	defer c.drop(parent, cacheDirents)
+
+	return c.Backend.WriteChildren(ctx, parent, children)
+}
+
+func (c *cachedBackend) UpdateChildren(ctx context.Context, parent *Inode, update func([]fuseutil.Dirent) ([]fuseutil.Dirent, error)) error {
+	defer c.drop(parent.Inumber, cacheDirents, cacheInodes)
+
+	return c.Backend.UpdateChildren(ctx, parent, update)
+}
+
+func (c *cachedBackend) ReadContent(ctx context.Context, inumber int64) ([]byte, error) {
+	value, epoch, ok := c.lookup(cacheContents, inumber)
+	if ok {
+		return append([]byte(nil), value.([]byte)...), nil
+	}
+
+	content, err := c.Backend.ReadContent(ctx, inumber)
+	if err != nil {
+		return nil, err
+	}
+	c.put(cacheContents, inumber, append([]byte(nil), content...), int64(len(content)), epoch)
+
+	return content, nil
+}
+
+func (c *cachedBackend) WriteFile(ctx context.Context, inode *Inode, data []byte) error {
+	defer c.drop(inode.Inumber, cacheContents, cacheInodes)
+
+	return c.Backend.WriteFile(ctx, inode, data)
+}
+
+// writeDelta forwards the journaled writes to the wrapped backend, if it journals them.
+func (c *cachedBackend) writeDelta(ctx context.Context, inode *Inode, off int64, data []byte) (bool, error) {
+	w, ok := c.Backend.(deltaWriter)
+	if !ok {
+		return false, nil
+	}
+	defer c.drop(inode.Inumber, cacheContents, cacheInodes)
+
+	return w.writeDelta(ctx, inode, off, data)
+}
+
+// checkSize forwards the checks of the sizes to the wrapped backend, if it makes them.
+func (c *cachedBackend) checkSize(inode *Inode, content []byte) {
+	if checker, ok := c.Backend.(sizeChecker); ok {
+		checker.checkSize(inode, content)
+	}
+}
@@ -0,0 +1,172 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// Kinds of change reported by Changes.
+const (
+	ChangeCreated  = "created"
+	ChangeModified = "modified"
+	ChangeDeleted  = "deleted"
+)
+
+// Change is a file or directory created, modified or deleted after a transaction.
+type Change struct {
+	Kind    string
+	Inumber int64
+	Dir     bool
+	// Path of the file, as of the last transaction or, when deleted, as of the transaction changes are
+	// listed since. Empty if the file is not reachable from the root, e.g. when unlinked while still open.
+	Path string
+}
+
+// Changes lists the files created, modified or deleted after the given transaction, sorted by inumber.
+// It compares the inode table as of sinceTx with the current one, and scans the history of the inode and
+// content tables for the files existing at both times. Renames show up as modifications of the directories
+// involved. History truncated by gc is lost: changes cannot be listed since a transaction before the cutoff.
+//
+// The returned transaction is the last one committed when the listing started: listing again since it does
+// not miss any change, though changes committed meanwhile may be listed twice.
+func (idb *ImmuDbClient) Changes(ctx context.Context, sinceTx uint64) ([]Change, uint64, error) {
+	current, err := idb.CurrentTx(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if sinceTx >= current {
+		return nil, current, nil
+	}
+
+	period := fmt.Sprintf("UNTIL TX %d", sinceTx)
+	before, err := idb.inodeModes(ctx, period)
+	if err != nil {
+		return nil, 0, err
+	}
+	now, err := idb.inodeModes(ctx, "")
+	if err != nil {
+		return nil, 0, err
+	}
+	changed, err := idb.ChangedInodes(ctx, sinceTx+1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var changes []Change
+	for _, inumber := range changed {
+		mode, ok := now[inumber]
+		if !ok {
+			// Deleted since: listed below, unless it did not exist at sinceTx either.
+			continue
+		}
+		kind := ChangeModified
+		if _, ok := before[inumber]; !ok {
+			kind = ChangeCreated
+		}
+		changes = append(changes, Change{Kind: kind, Inumber: inumber, Dir: os.FileMode(mode).IsDir()})
+	}
+	for inumber, mode := range before {
+		if _, ok := now[inumber]; !ok {
+			changes = append(changes, Change{Kind: ChangeDeleted, Inumber: inumber, Dir: os.FileMode(mode).IsDir()})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Inumber < changes[j].Inumber })
+
+	// The tree as of sinceTx is only walked for deleted files: it does not exist if the filesystem was created later.
+	var beforePaths map[int64]string
+	for _, c := range changes {
+		if c.Kind == ChangeDeleted {
+			beforePaths, err = idb.treePaths(ctx, period)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			break
+		}
+	}
+	nowPaths, err := idb.treePaths(ctx, "")
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range changes {
+		if changes[i].Kind == ChangeDeleted {
+			changes[i].Path = beforePaths[changes[i].Inumber]
+		} else {
+			changes[i].Path = nowPaths[changes[i].Inumber]
+		}
+	}
+
+	return changes, current, nil
+}
+
+// inodeModes returns the mode of every inode, by inumber, as of the given period clause (e.g. "UNTIL TX 10"),
+// or currently if period is empty.
+func (idb *ImmuDbClient) inodeModes(ctx context.Context, period string) (map[int64]int64, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	res, err := idb.cl.QueryContext(ctx, "SELECT inumber, mode FROM inode "+period)
+	if err != nil {
+		idb.log.Errorf("could not list inodes %s: %s", period, err)
+
+		return nil, wrapErr(err)
+	}
+	defer res.Close()
+
+	modes := make(map[int64]int64)
+	for res.Next() {
+		var inumber, mode int64
+		if err := res.Scan(&inumber, &mode); err != nil {
+			return nil, wrapErr(err)
+		}
+		modes[inumber] = mode
+	}
+
+	return modes, wrapErr(res.Err())
+}
+
+// treePaths returns the path of every file and directory reachable from the root, by inumber, as of the
+// given period clause, or currently if period is empty. Files with several links get one of their paths.
+func (idb *ImmuDbClient) treePaths(ctx context.Context, period string) (map[int64]string, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	paths := map[int64]string{1: "/"}
+	pending := []int64{1}
+	for len(pending) > 0 {
+		dir := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		var content []byte
+		err := idb.cl.QueryRowContext(ctx, fmt.Sprintf("SELECT content FROM content %s WHERE inumber=?", period), dir).Scan(&content)
+		if err != nil {
+			idb.log.Errorf("could not get directory %d content %s: %s", dir, period, err)
+
+			return nil, wrapErr(err)
+		}
+		dirents, err := unmarshalDirents(content)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, child := range dirents {
+			if child.Type == fuseutil.DT_Unknown {
+				continue
+			}
+			if _, ok := paths[int64(child.Inode)]; ok {
+				continue
+			}
+			paths[int64(child.Inode)] = strings.TrimSuffix(paths[dir], "/") + "/" + child.Name
+			if child.Type == fuseutil.DT_Directory {
+				pending = append(pending, int64(child.Inode))
+			}
+		}
+	}
+
+	return paths, nil
+}
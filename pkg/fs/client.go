@@ -1,28 +1,107 @@
 package fs
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"immufs/pkg/config"
+	"immufs/pkg/tracing"
 
+	"github.com/codenotary/immudb/embedded/store"
 	"github.com/codenotary/immudb/pkg/client"
 	"github.com/codenotary/immudb/pkg/stdlib"
+	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/fuseutil"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 var (
 	ErrInodeNotFound = errors.New("Inode not found")
+	ErrTimeout       = errors.New("immudb operation timed out")
+	ErrXattrNotFound = errors.New("Extended attribute not found")
+	ErrNoInumbers    = errors.New("inumbers exhausted")
 )
 
+// tables lists the immufs tables (see database.sql), together with their primary keys.
+var tables = []struct {
+	name string
+	keys []string
+}{
+	{"inode", []string{"inumber"}},
+	{"content", []string{"inumber"}},
+	{"content_delta", []string{"id"}},
+	{"xattr", []string{"inumber", "name"}},
+	{"inumber_allocator", []string{"id"}},
+	{"snapshot", []string{"name"}},
+	{"audit", []string{"id"}},
+	{"user_quota", []string{"uid"}},
+	{"dir_quota", []string{"inumber"}},
+	{"trash", []string{"inumber"}},
+	{"file_lock", []string{"inumber"}},
+	{"backup", []string{"digest"}},
+	{"quarantine", []string{"inumber"}},
+	{"tree_root", []string{"name"}},
+}
+
 // ImmuDbClient is a client for talking to Immudb and perform all the FS I/O.
 type ImmuDbClient struct {
 	cl  *sql.DB
 	log *logrus.Entry
+
+	// immudb user the client is connected as, recorded in the audit table.
+	user string
+
+	// Pools of the immudb users the uids are mapped to (see UserMap in the configuration), serving the operations
+	// of their processes.
+	userPools map[uint32]userPools
+
+	// Owners recorded for the files migrated.
+	squash rootSquash
+
+	// Key signing the contents written, if any.
+	signer *contentSigner
+
+	// Maximum size of the contents stored in the inode row rather than in the content table, 0 if none are.
+	inlineThreshold int
+
+	// Journal the writes of the files in the content_delta table, rather than rewriting their content.
+	journal bool
+
+	// Pool of the database storing the content of the files and symlinks: cl, unless contentApart. The entries of
+	// the directories are always stored with the inodes.
+	content      *sql.DB
+	contentApart bool
+
+	// Contents read kept on disk across mounts, if configured.
+	diskCache *diskCache
+
+	// Object storage the contents of the cold files are moved to, if configured (see TierColdFiles), and the contents
+	// of all the files are written to in the blob store mode.
+	tier      tierStore
+	blobStore bool
+
+	queryTimeouts
+
+	// Files read whose size did not match the length of their content.
+	sizeMismatches atomic.Int64
+
+	// Transactions whose immudb proofs did not verify.
+	verifyFailures atomic.Int64
+
+	// Sessions opened to immudb by the connection pool.
+	sessions atomic.Int64
 }
 
 // Helpers
@@ -37,23 +116,303 @@ func unmarshalDirents(data []byte) ([]fuseutil.Dirent, error) {
 	return ret, err
 }
 
+// visitDirents decodes the entries of a directory one at a time, so that they are never all in memory.
+func visitDirents(data []byte, visit func(fuseutil.Dirent)) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// The entries of an empty directory may be null.
+	tok, err := dec.Token()
+	if err != nil || tok == nil {
+		return err
+	}
+	if tok != json.Delim('[') {
+		return fmt.Errorf("unexpected %v in directory entries", tok)
+	}
+	for dec.More() {
+		var e fuseutil.Dirent
+		if err := dec.Decode(&e); err != nil {
+			return err
+		}
+		visit(e)
+	}
+	_, err = dec.Token()
+
+	return err
+}
+
+// atomicDuration is a duration read while it may be changed, e.g. on reload.
+type atomicDuration struct {
+	v atomic.Int64
+}
+
+func (d *atomicDuration) Load() time.Duration {
+	return time.Duration(d.v.Load())
+}
+
+func (d *atomicDuration) Store(v time.Duration) {
+	d.v.Store(int64(v))
+}
+
+// queryTimeouts are the deadlines applied to every query of a backend, by kind of operation. Zero means no deadline.
+type queryTimeouts struct {
+	readTimeout     atomicDuration
+	writeTimeout    atomicDuration
+	metadataTimeout atomicDuration
+}
+
+// setTimeouts sets the deadlines of the queries to come to those of the configuration.
+func (t *queryTimeouts) setTimeouts(cfg *config.Config) {
+	t.readTimeout.Store(cfg.ReadTimeout)
+	t.writeTimeout.Store(cfg.WriteTimeout)
+	t.metadataTimeout.Store(cfg.MetadataTimeout)
+}
+
+// withTimeout bounds ctx with the given timeout. A zero timeout leaves ctx untouched.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// wrapErr turns an expired deadline into ErrTimeout, so that callers can tell a stuck server apart.
+func wrapErr(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s", ErrTimeout, err)
+	}
+
+	return err
+}
+
+func init() {
+	RegisterBackend(BackendSQL, func(ctx context.Context, cfg *config.Config, logger *logrus.Logger) (Backend, error) {
+		cl, err := NewImmuDbClient(ctx, cfg, logger)
+		if err != nil {
+			return nil, errors.New("failed to create immudb client: " + err.Error())
+		}
+
+		return cl, nil
+	})
+}
+
 // Instantiate and connect the Immudb client
 func NewImmuDbClient(ctx context.Context, cfg *config.Config, log *logrus.Logger) (*ImmuDbClient, error) {
+	opts := clientOptions(cfg)
+	if cfg.CreateDatabase {
+		if err := createDatabase(ctx, opts, cfg.MetadataTimeout); err != nil {
+			return nil, err
+		}
+	}
+	connector, err := immudbConnector(opts)
+	if err != nil {
+		return nil, err
+	}
+	idb := &ImmuDbClient{
+		log:             log.WithFields(logrus.Fields{"component": "immudb client"}),
+		user:            cfg.User,
+		squash:          newRootSquash(cfg),
+		inlineThreshold: cfg.InlineThreshold,
+		journal:         cfg.Journal,
+	}
+	idb.setTimeouts(cfg)
+	// Queries are traced once an exporter is set (see the tracing package).
+	idb.cl = sql.OpenDB(tracing.WrapConnector(&countingConnector{Connector: connector, sessions: &idb.sessions}))
+	idb.content = idb.cl
+
+	contentOpts := *opts
+	if cfg.ContentImmudb != "" {
+		contentOpts.Address = cfg.ContentImmudb
+	}
+	if cfg.ContentDatabase != "" {
+		contentOpts.Database = cfg.ContentDatabase
+	}
+	if contentOpts.Address != opts.Address || contentOpts.Database != opts.Database {
+		if cfg.CreateDatabase {
+			if err := createDatabase(ctx, &contentOpts, cfg.MetadataTimeout); err != nil {
+				idb.cl.Close()
+
+				return nil, fmt.Errorf("content database: %w", err)
+			}
+		}
+		connector, err := immudbConnector(&contentOpts)
+		if err != nil {
+			idb.cl.Close()
+
+			return nil, fmt.Errorf("content database: %w", err)
+		}
+		idb.content = sql.OpenDB(tracing.WrapConnector(&countingConnector{Connector: connector, sessions: &idb.sessions}))
+		idb.contentApart = true
+	}
+
+	if cfg.SigningKey != "" {
+		if idb.signer, err = loadSigningKey(cfg.SigningKey); err != nil {
+			idb.Destroy(ctx)
+
+			return nil, fmt.Errorf("signing key: %w", err)
+		}
+	}
+	if cfg.TierURL != "" {
+		if idb.tier, err = newTierStore(cfg.TierURL); err != nil {
+			idb.Destroy(ctx)
+
+			return nil, err
+		}
+	}
+	if cfg.BlobStore {
+		if idb.tier == nil {
+			idb.Destroy(ctx)
+
+			return nil, errors.New("the blob store mode writes the contents to the tier: set tier-url")
+		}
+		idb.blobStore = true
+	}
+	if idb.journal {
+		if err := idb.checkJournal(); err != nil {
+			idb.Destroy(ctx)
+
+			return nil, err
+		}
+	}
+
+	if err := idb.openUserPools(cfg, opts, &contentOpts); err != nil {
+		idb.Destroy(ctx)
+
+		return nil, err
+	}
+
+	return idb, nil
+}
+
+// openUserPools opens the pools of the immudb users the uids are mapped to, on the same databases as the mount.
+// No session is opened until an operation of a mapped uid needs one.
+func (idb *ImmuDbClient) openUserPools(cfg *config.Config, opts, contentOpts *client.Options) error {
+	users, err := ParseUserMap(cfg.UserMap)
+	if err != nil {
+		return err
+	}
+
+	idb.userPools = make(map[uint32]userPools, len(users))
+	for uid, creds := range users {
+		userOpts := *opts
+		userOpts.Username, userOpts.Password = creds.User, creds.Password
+		connector, err := immudbConnector(&userOpts)
+		if err != nil {
+			return fmt.Errorf("immudb user %s of uid %d: %w", creds.User, uid, err)
+		}
+		p := userPools{user: creds.User}
+		p.cl = sql.OpenDB(tracing.WrapConnector(&countingConnector{Connector: connector, sessions: &idb.sessions}))
+		p.content = p.cl
+		// Registered right away, to be closed by Destroy.
+		idb.userPools[uid] = p
+
+		if idb.contentApart {
+			userContentOpts := *contentOpts
+			userContentOpts.Username, userContentOpts.Password = creds.User, creds.Password
+			connector, err := immudbConnector(&userContentOpts)
+			if err != nil {
+				return fmt.Errorf("immudb user %s of uid %d, content database: %w", creds.User, uid, err)
+			}
+			p.content = sql.OpenDB(tracing.WrapConnector(&countingConnector{Connector: connector, sessions: &idb.sessions}))
+			idb.userPools[uid] = p
+		}
+	}
+
+	return nil
+}
+
+// contentOf returns where the content of an inode is written within the transaction tx: tx itself, unless the
+// content of the files is stored apart. Such a content is then committed before tx.
+func (idb *ImmuDbClient) contentOf(ctx context.Context, tx querier, isDir bool) querier {
+	if idb.contentApart && !isDir {
+		return idb.pools(ctx).content
+	}
+
+	return tx
+}
+
+// Database of every immudb server, on which sessions are opened to create the others.
+const defaultDatabase = "defaultdb"
+
+// clientOptions returns the options of the immudb client connecting to the server and the database of the
+// configuration, over TLS if configured.
+func clientOptions(cfg *config.Config) *client.Options {
 	opts := client.DefaultOptions()
 	opts.Address = cfg.Immudb
+	if cfg.Port > 0 {
+		opts.Port = cfg.Port
+	}
 	opts.Username = cfg.User
 	opts.Password = cfg.Password
 	opts.Database = cfg.Database
+	// Keeps the transactions committed for the spans of the statements (see tracing.WrapConnector).
+	opts.DialOptions = []grpc.DialOption{grpc.WithChainUnaryInterceptor(tracing.RecordCommits)}
+	if cfg.TLS {
+		opts.DialOptions = append(opts.DialOptions,
+			grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})))
+	}
+
+	return opts
+}
+
+// createDatabase creates the database of the client options unless it exists, through a session on the default
+// database of the server. The user must be allowed to create databases.
+func createDatabase(ctx context.Context, opts *client.Options, timeout time.Duration) error {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	sessionOpts := *opts
+	sessionOpts.Database = defaultDatabase
+	cl := client.NewClient().WithOptions(&sessionOpts)
+	if err := cl.OpenSession(ctx, []byte(opts.Username), []byte(opts.Password), defaultDatabase); err != nil {
+		return fmt.Errorf("could not open a session to create database %s: %w", opts.Database, wrapErr(err))
+	}
+	defer cl.CloseSession(ctx)
+
+	if _, err := cl.SQLExec(ctx, "CREATE DATABASE IF NOT EXISTS "+opts.Database, nil); err != nil {
+		return fmt.Errorf("could not create database %s: %w", opts.Database, wrapErr(err))
+	}
+
+	return nil
+}
+
+// countingConnector counts the sessions opened by a connection pool.
+type countingConnector struct {
+	driver.Connector
+	sessions *atomic.Int64
+}
+
+func (c *countingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err == nil {
+		c.sessions.Add(1)
+	}
+
+	return conn, err
+}
+
+// immudbConnector returns the connector of the immudb driver for the given client options. The driver does not
+// export its connectors, but opens them for the names of registered options.
+func immudbConnector(opts *client.Options) (driver.Connector, error) {
 	db := stdlib.OpenDB(opts)
-	return &ImmuDbClient{
-		cl:  db,
-		log: log.WithFields(logrus.Fields{"component": "immudb client"}),
-	}, nil
+	defer db.Close()
+
+	return db.Driver().(driver.DriverContext).OpenConnector(stdlib.RegisterConnConfig(opts))
 }
 
 // Destroy must be called after all pending operations on Immufs are completed.
 func (idb *ImmuDbClient) Destroy(ctx context.Context) error {
 	err := idb.cl.Close()
+	if idb.contentApart {
+		if contentErr := idb.content.Close(); err == nil {
+			err = contentErr
+		}
+	}
+	for _, p := range idb.userPools {
+		p.cl.Close()
+		if p.content != p.cl {
+			p.content.Close()
+		}
+	}
 	if err != nil {
 		idb.log.Errorf("could not close session: %s", err)
 
@@ -65,15 +424,21 @@ func (idb *ImmuDbClient) Destroy(ctx context.Context) error {
 
 // GetInode retrieves an Inode from immudb, given its inumber.
 func (idb *ImmuDbClient) GetInode(ctx context.Context, inumber int64) (*Inode, error) {
-	res, err := idb.cl.QueryContext(ctx, "SELECT * FROM inode WHERE inumber=?", inumber)
+	return idb.getInode(ctx, inumber, "")
+}
+
+// getInode retrieves an Inode as of the given period clause (e.g. "UNTIL TX 10"), or currently if period is empty.
+func (idb *ImmuDbClient) getInode(ctx context.Context, inumber int64, period string) (*Inode, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	res, err := idb.pools(ctx).cl.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM inode %s WHERE inumber=?", inodeSelect, period), inumber)
 	if err != nil {
-		idb.log.Errorf("could not get inode %d: %s", inumber, err)
+		idb.log.Errorf("could not get inode %d %s: %s", inumber, period, err)
 
-		return nil, err
+		return nil, wrapErr(err)
 	}
 
-	var inode Inode
-
 	defer res.Close()
 	if found := res.Next(); !found {
 		idb.log.Warnf("Inode %d not found", inumber)
@@ -81,38 +446,136 @@ func (idb *ImmuDbClient) GetInode(ctx context.Context, inumber int64) (*Inode, e
 		return nil, ErrInodeNotFound
 	}
 
-	err = res.Scan(
-		&inode.Inumber,
-		&inode.Size,
-		&inode.Nlink,
-		&inode.Mode,
-		&inode.Atime,
-		&inode.Mtime,
-		&inode.Ctime,
-		&inode.Crtime,
-		&inode.Uid,
-		&inode.Gid,
-		&inode.ToBeDeleted,
-	)
+	var row inodeRow
+	if err := scanNamed(res, row.fields()); err != nil {
+		idb.log.Errorf("could not scan inode %d: %s", inumber, err)
+
+		return nil, wrapErr(err)
+	}
+	inode := row.toInode()
 	inode.cl = idb
+	inode.ctx = detach(ctx)
+
+	return inode, nil
+}
+
+// Maximum number of inumbers looked up by a single query of getInodes.
+const inodesPerQuery = 256
+
+// GetInodes retrieves several inodes at once, by inumber. Inodes not found are missing from the result.
+func (idb *ImmuDbClient) GetInodes(ctx context.Context, inumbers []int64) (map[int64]*Inode, error) {
+	return idb.getInodes(ctx, inumbers, "")
+}
+
+// getInodes retrieves several inodes as of the given period clause, or currently if period is empty, with a query
+// per inodesPerQuery inodes rather than one per inode.
+func (idb *ImmuDbClient) getInodes(ctx context.Context, inumbers []int64, period string) (map[int64]*Inode, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	inodes := make(map[int64]*Inode, len(inumbers))
+	for len(inumbers) > 0 {
+		batch := inumbers
+		if len(batch) > inodesPerQuery {
+			batch = batch[:inodesPerQuery]
+		}
+		inumbers = inumbers[len(batch):]
+
+		args := make([]any, len(batch))
+		for i, inumber := range batch {
+			args[i] = inumber
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		query := fmt.Sprintf("SELECT %s FROM inode %s WHERE inumber IN (%s)", inodeSelect, period, placeholders)
+		if err := idb.scanInodes(ctx, inodes, query, args...); err != nil {
+			idb.log.Errorf("could not get %d inodes %s: %s", len(batch), period, err)
+
+			return nil, wrapErr(err)
+		}
+	}
+
+	return inodes, nil
+}
+
+// scanInodes adds the inodes returned by a query to inodes.
+func (idb *ImmuDbClient) scanInodes(ctx context.Context, inodes map[int64]*Inode, query string, args ...any) error {
+	res, err := idb.pools(ctx).cl.QueryContext(ctx, query, args...)
 	if err != nil {
-		idb.log.Errorf("could not scan inode %d: %s", inumber, err)
+		return err
+	}
+	defer res.Close()
 
-		return nil, err
+	for res.Next() {
+		var row inodeRow
+		if err := scanNamed(res, row.fields()); err != nil {
+			return err
+		}
+		inode := row.toInode()
+		inode.cl = idb
+		inode.ctx = detach(ctx)
+		inodes[inode.Inumber] = inode
 	}
 
-	return &inode, nil
+	return res.Err()
 }
 
 // GetChildren retrieves a directory content. It must only be called on directories.
 func (idb *ImmuDbClient) GetChildren(ctx context.Context, parent int64) ([]fuseutil.Dirent, error) {
-	res, err := idb.cl.QueryContext(ctx, "SELECT content FROM content WHERE inumber=?", parent)
+	return idb.getChildren(ctx, parent, "")
+}
+
+// getChildren retrieves a directory content as of the given period clause, or currently if period is empty.
+func (idb *ImmuDbClient) getChildren(ctx context.Context, parent int64, period string) ([]fuseutil.Dirent, error) {
+	content, err := idb.readDirContent(ctx, parent, period)
+	if err != nil {
+		return nil, err
+	}
+
+	dirents, err := unmarshalDirents(content)
+	if err != nil {
+		idb.log.Errorf("could not unmarshal dirents of inode %d: %s", parent, err)
+
+		return nil, err
+	}
+
+	return dirents, err
+}
+
+// GetChildrenPage retrieves a page of a directory content, decoding only the entries of the page.
+func (idb *ImmuDbClient) GetChildrenPage(ctx context.Context, parent int64, after fuseops.DirOffset, limit int) ([]fuseutil.Dirent, error) {
+	return idb.getChildrenPage(ctx, parent, "", after, limit)
+}
+
+// getChildrenPage retrieves a page of a directory content as of the given period clause, or currently if period is
+// empty (see GetChildrenPage).
+func (idb *ImmuDbClient) getChildrenPage(ctx context.Context, parent int64, period string, after fuseops.DirOffset, limit int) ([]fuseutil.Dirent, error) {
+	content, err := idb.readDirContent(ctx, parent, period)
 	if err != nil {
-		idb.log.Errorf("could not get directory %d content: %s", parent, err)
+		return nil, err
+	}
+
+	page := newDirentPage(after, limit)
+	if err := visitDirents(content, page.add); err != nil {
+		idb.log.Errorf("could not unmarshal dirents of inode %d: %s", parent, err)
 
 		return nil, err
 	}
 
+	return page.entries(), nil
+}
+
+// readDirContent reads the entries of a directory, as stored, as of the given period clause.
+func (idb *ImmuDbClient) readDirContent(ctx context.Context, parent int64, period string) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	res, err := idb.pools(ctx).cl.QueryContext(ctx, fmt.Sprintf("SELECT content FROM content %s WHERE inumber=?", period), parent)
+	if err != nil {
+		idb.log.Errorf("could not get directory %d content %s: %s", parent, period, err)
+
+		return nil, wrapErr(err)
+	}
+
 	var content []byte
 
 	defer res.Close()
@@ -126,33 +589,71 @@ func (idb *ImmuDbClient) GetChildren(ctx context.Context, parent int64) ([]fuseu
 	if err != nil {
 		idb.log.Errorf("could not read directory %d content: %s", parent, err)
 
-		return nil, err
+		return nil, wrapErr(err)
 	}
 
-	dirents, err := unmarshalDirents(content)
-	if err != nil {
-		idb.log.Errorf("could not unmarshal dirents of inode %d: %s", parent, err)
+	return content, nil
+}
 
-		return nil, err
+// UpdateChildren atomically applies update to the content of a directory, and flushes the directory inode.
+// Both happen within a single transaction, which is retried if another mount changes the directory meanwhile.
+func (idb *ImmuDbClient) UpdateChildren(ctx context.Context, parent *Inode, update func([]fuseutil.Dirent) ([]fuseutil.Dirent, error)) error {
+	err := idb.inTx(ctx, idb.metadataTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+		var content []byte
+		err := tx.QueryRowContext(ctx, "SELECT content FROM content WHERE inumber=?", parent.Inumber).Scan(&content)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("Inode %d not found", parent.Inumber)
+		}
+		if err != nil {
+			return err
+		}
+
+		dirents, err := unmarshalDirents(content)
+		if err != nil {
+			return err
+		}
+
+		dirents, err = update(dirents)
+		if err != nil {
+			return err
+		}
+
+		content, err = marshalDirents(dirents)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPSERT INTO content(inumber, content) VALUES(?, ?)", parent.Inumber, content)
+		if err != nil {
+			return err
+		}
+
+		return writeInode(ctx, tx, parent)
+	})
+	if err != nil {
+		idb.log.Errorf("could not update directory %d content: %s", parent.Inumber, err)
 	}
 
-	return dirents, err
+	return err
 }
 
 // WriteChildren flushes the content of a directory to Immudb.
 func (idb *ImmuDbClient) WriteChildren(ctx context.Context, parentInumber int64, children []fuseutil.Dirent) error {
 	content, err := marshalDirents(children)
 	if err != nil {
-		idb.log.Errorf("could not marshal directory entries: %s", children)
+		idb.log.Errorf("could not marshal directory entries: %s", err)
 
 		return err
 	}
 
-	err = idb.WriteContent(ctx, parentInumber, content)
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	_, err = idb.pools(ctx).cl.ExecContext(ctx, "UPSERT INTO content(inumber, content) VALUES(?, ?)", parentInumber, content)
 	if err != nil {
 		idb.log.Errorf("could not write directory content: %s", err)
 
-		return err
+		return wrapErr(err)
 	}
 
 	return nil
@@ -160,99 +661,548 @@ func (idb *ImmuDbClient) WriteChildren(ctx context.Context, parentInumber int64,
 
 // ReadContent reads as a whole file from Immudb and loads it in memory.
 func (idb *ImmuDbClient) ReadContent(ctx context.Context, inumber int64) ([]byte, error) {
-	res, err := idb.cl.QueryContext(ctx, "SELECT content FROM content WHERE inumber=?", inumber)
-	if err != nil {
-		idb.log.Errorf("could not get file %d content: %s", inumber, err)
+	return idb.readContent(ctx, inumber, "")
+}
 
+// readContent reads a whole file as of the given period clause, or currently if period is empty. The arguments
+// of the period clause, if any, follow it.
+func (idb *ImmuDbClient) readContent(ctx context.Context, inumber int64, period string, periodArgs ...any) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, idb.readTimeout.Load())
+	defer cancel()
+
+	content, found, err := idb.readStoredContent(ctx, inumber, period, periodArgs...)
+	if err != nil {
 		return nil, err
 	}
+	if !found {
+		// The content may be inlined in the inode row instead.
+		content, err = idb.readInline(ctx, idb.pools(ctx).cl, inumber, period, periodArgs...)
+		if err != nil {
+			idb.log.Errorf("could not get file %d inline content %s: %s", inumber, period, err)
 
-	var content []byte
+			return nil, wrapErr(err)
+		}
+	}
 
-	defer res.Close()
-	if found := res.Next(); !found {
+	// The writes journaled since are applied on top of it.
+	deltas, err := idb.readDeltas(ctx, idb.pools(ctx).cl, inumber, period, periodArgs...)
+	if err != nil {
+		idb.log.Errorf("could not get file %d deltas %s: %s", inumber, period, err)
+
+		return nil, wrapErr(err)
+	}
+	if content == nil && len(deltas) == 0 {
 		idb.log.Warnf("Content not found for inode: %d", inumber)
 
 		return []byte{}, nil
 		//return nil, fmt.Errorf("Inode %d not found", inumber)
 	}
 
-	err = res.Scan(&content)
+	return applyDeltas(content, deltas), nil
+}
+
+// readStoredContent reads the content of a file from the content table, as of the given period clause: it is not
+// found if inlined in the inode row, and read from the tier if moved there. With a disk cache, only the checksum is
+// read first, and the content only if not cached.
+func (idb *ImmuDbClient) readStoredContent(ctx context.Context, inumber int64, period string, periodArgs ...any) ([]byte, bool, error) {
+	if idb.diskCache != nil {
+		var checksum sql.NullString
+		err := idb.pools(ctx).content.QueryRowContext(ctx, fmt.Sprintf("SELECT checksum FROM content %s WHERE inumber=?", period), append(periodArgs, inumber)...).Scan(&checksum)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, nil
+		}
+		if err != nil {
+			idb.log.Errorf("could not get file %d checksum %s: %s", inumber, period, err)
+
+			return nil, false, wrapErr(err)
+		}
+		if checksum.Valid {
+			if content, ok := idb.diskCache.get(checksum.String); ok {
+				return content, true, nil
+			}
+		}
+	}
+
+	res, err := idb.pools(ctx).content.QueryContext(ctx, fmt.Sprintf("SELECT content, checksum, tier FROM content %s WHERE inumber=?", period), append(periodArgs, inumber)...)
 	if err != nil {
+		idb.log.Errorf("could not get file %d content %s: %s", inumber, period, err)
+
+		return nil, false, wrapErr(err)
+	}
+	defer res.Close()
+	if !res.Next() {
+		return nil, false, wrapErr(res.Err())
+	}
+
+	var content []byte
+	var checksum, tier sql.NullString
+	if err := res.Scan(&content, &checksum, &tier); err != nil {
 		idb.log.Errorf("could not read file %d content: %s", inumber, err)
 
-		return nil, err
+		return nil, false, wrapErr(err)
+	}
+	if content, err = idb.resolveContent(ctx, inumber, content, checksum, tier); err != nil {
+		return nil, false, err
+	}
+	// Contents written before checksums existed can't be looked up.
+	if idb.diskCache != nil && checksum.Valid {
+		idb.diskCache.put(checksum.String, content)
 	}
 
-	return content, err
+	return content, true, nil
 }
 
 // WriteContent writes a whole file into Immudb.
 func (idb *ImmuDbClient) WriteContent(ctx context.Context, inumber int64, data []byte) error {
-	_, err := idb.cl.ExecContext(ctx, "UPSERT INTO content(inumber, content) VALUES(?, ?)", inumber, data)
+	ctx, cancel := withTimeout(ctx, idb.writeTimeout.Load())
+	defer cancel()
+
+	err := idb.upsertFileContent(ctx, idb.pools(ctx).content, inumber, data)
 	if err != nil {
 		idb.log.Errorf("could not write file %d content: %s", inumber, err)
 	}
 
+	return wrapErr(err)
+}
+
+// WriteFile writes the content of a file together with its inode, within a single transaction. The size
+// of the inode is set to the length of the content beforehand, so that the two can never diverge. Small
+// contents are stored in the inode row itself, so that a single row is written (see inlines).
+func (idb *ImmuDbClient) WriteFile(ctx context.Context, inode *Inode, data []byte) error {
+	inode.Size = int64(len(data))
+	inline, wasInline := idb.inlines(data), inode.inline != nil
+
+	err := idb.inTx(ctx, idb.writeTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+		// The content written supersedes the writes journaled before.
+		if err := idb.clearDeltas(ctx, tx, inode.Inumber); err != nil {
+			return err
+		}
+		if inline {
+			// The content row written before, if any, would be read instead of the inline content.
+			if !wasInline {
+				if _, err := tx.ExecContext(ctx, "DELETE FROM content WHERE inumber=?", inode.Inumber); err != nil {
+					return err
+				}
+			}
+			inode.inline = append([]byte{}, data...)
+		} else {
+			if err := idb.upsertFileContent(ctx, idb.contentOf(ctx, tx, false), inode.Inumber, data); err != nil {
+				return err
+			}
+			inode.inline = nil
+		}
+
+		return writeInode(ctx, tx, inode)
+	})
+	if err != nil {
+		idb.log.Errorf("could not write file %d: %s", inode.Inumber, err)
+	}
+
 	return err
 }
 
 // WriteInode flushed an inode to Immudb. It does not change the file content.
 func (idb *ImmuDbClient) WriteInode(ctx context.Context, inode *Inode) error {
-	_, err := idb.cl.ExecContext(ctx, "UPSERT INTO inode(inumber, size, nlink, mode, atime, mtime, ctime, crtime, uid, gid, to_be_deleted) VALUES(?,?,?,?,?,?,?,?,?,?,?)",
-		inode.Inumber, inode.Size, inode.Nlink, inode.Mode, inode.Atime, inode.Mtime, inode.Ctime, inode.Crtime, inode.Uid, inode.Gid, inode.ToBeDeleted)
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	err := writeInode(ctx, idb.pools(ctx).cl, inode)
 	if err != nil {
 		idb.log.Errorf("could not write inode: %s", err)
 	}
 
+	return wrapErr(err)
+}
+
+func writeInode(ctx context.Context, q querier, inode *Inode) error {
+	// NULL, rather than an empty BLOB, unless the content is inlined.
+	var inline any
+	if inode.inline != nil {
+		inline = inode.inline
+	}
+	_, err := q.ExecContext(ctx, "UPSERT INTO inode(inumber, size, nlink, mode, atime, mtime, ctime, crtime, uid, gid, to_be_deleted, generation, project, sealed, inline_content) VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)",
+		inode.Inumber, inode.Size, inode.Nlink, inode.Mode, inode.Atime, inode.Mtime, inode.Ctime, inode.Crtime, inode.Uid, inode.Gid, inode.ToBeDeleted, inode.Generation, inode.Project, inode.Sealed, inline)
+
 	return err
 }
 
+// inlines tells whether a content is stored in the inode row rather than in the content table. Signed contents,
+// those stored in a separate database, and those of the blob store mode never are.
+func (idb *ImmuDbClient) inlines(content []byte) bool {
+	return idb.inlineThreshold > 0 && len(content) <= idb.inlineThreshold && idb.signer == nil && !idb.contentApart && !idb.blobStore
+}
+
+// readInline reads the content of a file stored in its inode row as of the given period clause, or currently if
+// period is empty. It returns nil if the content is not inlined.
+func (idb *ImmuDbClient) readInline(ctx context.Context, q querier, inumber int64, period string, periodArgs ...any) ([]byte, error) {
+	var content []byte
+	err := q.QueryRowContext(ctx, fmt.Sprintf("SELECT inline_content FROM inode %s WHERE inumber=?", period), append(periodArgs, inumber)...).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return content, err
+}
+
 // DeleteInode removes an inode from Immudb. Id does not remove the actual file content
 func (idb *ImmuDbClient) DeleteInode(ctx context.Context, inumber int64) error {
-	_, err := idb.cl.ExecContext(ctx, "DELETE FROM inode WHERE inumber=?", inumber)
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	_, err := idb.pools(ctx).cl.ExecContext(ctx, "DELETE FROM inode WHERE inumber=?", inumber)
 	if err != nil {
 		idb.log.Errorf("could not delete inode %d: %s", inumber, err)
 
-		return err
+		return wrapErr(err)
 	}
 
-	_, err = idb.cl.ExecContext(ctx, "DELETE FROM content WHERE inumber=?", inumber)
+	_, err = idb.pools(ctx).cl.ExecContext(ctx, "DELETE FROM content WHERE inumber=?", inumber)
+	if err == nil && idb.contentApart {
+		_, err = idb.pools(ctx).content.ExecContext(ctx, "DELETE FROM content WHERE inumber=?", inumber)
+	}
+	if err == nil {
+		err = idb.clearDeltas(ctx, idb.pools(ctx).cl, inumber)
+	}
 	if err != nil {
 		idb.log.Errorf("could not delete inode %d content: %s", inumber, err)
 
-		return err
+		return wrapErr(err)
+	}
+
+	_, err = idb.pools(ctx).cl.ExecContext(ctx, "DELETE FROM xattr WHERE inumber=?", inumber)
+	if err != nil {
+		idb.log.Errorf("could not delete inode %d extended attributes: %s", inumber, err)
+
+		return wrapErr(err)
 	}
 
 	return nil
 }
 
-// NextInumber computer the next inumber available for Immufs
-func (idb *ImmuDbClient) NextInumber(ctx context.Context) (int64, error) {
-	res, err := idb.cl.QueryContext(ctx, "SELECT MAX(inumber) FROM inode")
+// GetXattr retrieves the value of an extended attribute of an inode.
+func (idb *ImmuDbClient) GetXattr(ctx context.Context, inumber int64, name string) ([]byte, error) {
+	return idb.getXattr(ctx, inumber, name, "")
+}
+
+// getXattr retrieves the value of an extended attribute as of the given period clause, or currently if period is
+// empty.
+func (idb *ImmuDbClient) getXattr(ctx context.Context, inumber int64, name string, period string) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	res, err := idb.pools(ctx).cl.QueryContext(ctx, fmt.Sprintf("SELECT value FROM xattr %s WHERE inumber=? AND name=?", period), inumber, name)
 	if err != nil {
-		return -1, err
+		idb.log.Errorf("could not get extended attribute %s of inode %d %s: %s", name, inumber, period, err)
+
+		return nil, wrapErr(err)
 	}
 
-	var inumber int64
+	var value []byte
 
 	defer res.Close()
 	if found := res.Next(); !found {
-		return 0, nil
+		return nil, ErrXattrNotFound
 	}
 
-	err = res.Scan(
-		&inumber,
-	)
+	err = res.Scan(&value)
+	if err != nil {
+		idb.log.Errorf("could not read extended attribute %s of inode %d: %s", name, inumber, err)
+
+		return nil, wrapErr(err)
+	}
+
+	return value, nil
+}
+
+// ListXattrs retrieves the names of all the extended attributes of an inode.
+func (idb *ImmuDbClient) ListXattrs(ctx context.Context, inumber int64) ([]string, error) {
+	return idb.listXattrs(ctx, inumber, "")
+}
+
+// listXattrs retrieves the names of the extended attributes of an inode as of the given period clause, or currently
+// if period is empty.
+func (idb *ImmuDbClient) listXattrs(ctx context.Context, inumber int64, period string) ([]string, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	res, err := idb.pools(ctx).cl.QueryContext(ctx, fmt.Sprintf("SELECT name FROM xattr %s WHERE inumber=?", period), inumber)
+	if err != nil {
+		idb.log.Errorf("could not list extended attributes of inode %d %s: %s", inumber, period, err)
+
+		return nil, wrapErr(err)
+	}
+
+	var names []string
+
+	defer res.Close()
+	for res.Next() {
+		var name string
+		if err := res.Scan(&name); err != nil {
+			idb.log.Errorf("could not read extended attributes of inode %d: %s", inumber, err)
 
-	return inumber + 1, nil
+			return nil, wrapErr(err)
+		}
+		names = append(names, name)
+	}
+
+	return names, wrapErr(res.Err())
+}
+
+// SetXattr writes the value of an extended attribute of an inode.
+func (idb *ImmuDbClient) SetXattr(ctx context.Context, inumber int64, name string, value []byte) error {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	_, err := idb.pools(ctx).cl.ExecContext(ctx, "UPSERT INTO xattr(inumber, name, value) VALUES(?, ?, ?)", inumber, name, value)
+	if err != nil {
+		idb.log.Errorf("could not write extended attribute %s of inode %d: %s", name, inumber, err)
+	}
+
+	return wrapErr(err)
+}
+
+// RemoveXattr removes an extended attribute of an inode.
+func (idb *ImmuDbClient) RemoveXattr(ctx context.Context, inumber int64, name string) error {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	_, err := idb.pools(ctx).cl.ExecContext(ctx, "DELETE FROM xattr WHERE inumber=? AND name=?", inumber, name)
+	if err != nil {
+		idb.log.Errorf("could not remove extended attribute %s of inode %d: %s", name, inumber, err)
+	}
+
+	return wrapErr(err)
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Maximum number of times a transaction is retried after a conflict with a concurrent one.
+const maxConflictRetries = 5
+
+// isConflict tells whether err is due to a concurrent transaction having modified the data read
+// within the failed one (optimistic concurrency control of immudb).
+func isConflict(err error) bool {
+	return err != nil && strings.Contains(err.Error(), store.ErrTxReadConflict.Error())
+}
+
+// inTx runs fn within a transaction. Should another transaction (e.g. from another mount) modify
+// the data read by fn before the commit, the transaction is retried from scratch, so that no update is lost.
+func (idb *ImmuDbClient) inTx(ctx context.Context, timeout time.Duration, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := idb.tryTx(ctx, timeout, fn)
+		if !isConflict(err) || attempt > maxConflictRetries {
+			return wrapErr(err)
+		}
+
+		idb.log.Warnf("transaction conflict, retrying (%d/%d)", attempt, maxConflictRetries)
+		time.Sleep(time.Duration(attempt) * 10 * time.Millisecond)
+	}
+}
+
+func (idb *ImmuDbClient) tryTx(ctx context.Context, timeout time.Duration, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	tx, err := idb.pools(ctx).cl.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(ctx, tx); err != nil {
+		tx.Rollback()
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// readAllocator returns the last allocated inumber and the current generation. When the allocator
+// row does not exist (new or upgraded database), they are rebuilt from the inode table, with a
+// generation greater than any existing one: should the allocator ever be lost, inodes created
+// afterwards can never be mistaken for older ones.
+func readAllocator(ctx context.Context, q querier) (last int64, generation int64, err error) {
+	err = q.QueryRowContext(ctx, "SELECT last_inumber, generation FROM inumber_allocator WHERE id=1").Scan(&last, &generation)
+	if err == nil {
+		return last, generation, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, err
+	}
+
+	var maxInumber, maxGeneration sql.NullInt64
+	err = q.QueryRowContext(ctx, "SELECT MAX(inumber), MAX(generation) FROM inode").Scan(&maxInumber, &maxGeneration)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, err
+	}
+
+	return maxInumber.Int64, maxGeneration.Int64 + 1, nil
+}
+
+// NextInumber computes the next inumber that will be allocated, without reserving it.
+func (idb *ImmuDbClient) NextInumber(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	last, _, err := readAllocator(ctx, idb.pools(ctx).cl)
+	if err != nil {
+		return -1, wrapErr(err)
+	}
+
+	return last + 1, nil
+}
+
+// AllocateInumber reserves the next inumber, together with the generation to assign to the new inode.
+// Inumbers are 64 bit values which only grow: they are never reused, even after the inode is deleted.
+// The allocation happens within a transaction, so that two mounts can't obtain the same inumber.
+func (idb *ImmuDbClient) AllocateInumber(ctx context.Context) (inumber int64, generation int64, err error) {
+	err = idb.inTx(ctx, idb.metadataTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+		last, gen, err := readAllocator(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if last == math.MaxInt64 {
+			return ErrNoInumbers
+		}
+
+		_, err = tx.ExecContext(ctx, "UPSERT INTO inumber_allocator(id, last_inumber, generation) VALUES(1, ?, ?)", last+1, gen)
+		if err != nil {
+			return err
+		}
+		inumber, generation = last+1, gen
+
+		return nil
+	})
+	if err != nil {
+		idb.log.Errorf("could not allocate inumber: %s", err)
+
+		return -1, 0, err
+	}
+
+	return inumber, generation, nil
+}
+
+// withImmuClient runs fn with the immudb client underlying a connection of the pool, for the
+// operations that are not available through SQL.
+func (idb *ImmuDbClient) withImmuClient(ctx context.Context, fn func(cl client.ImmuClient) error) error {
+	return withImmuClient(ctx, idb.cl, fn)
+}
+
+// withImmuClient runs fn with the immudb client underlying a connection of the given pool.
+func withImmuClient(ctx context.Context, db *sql.DB, fn func(cl client.ImmuClient) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		if traced, ok := driverConn.(interface{ Unwrap() driver.Conn }); ok {
+			driverConn = traced.Unwrap()
+		}
+
+		return fn(driverConn.(*stdlib.Conn).GetImmuClient())
+	})
+}
+
+// CurrentTx returns the identifier of the last transaction committed to the database.
+func (idb *ImmuDbClient) CurrentTx(ctx context.Context) (uint64, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	var txID uint64
+	err := idb.withImmuClient(ctx, func(cl client.ImmuClient) error {
+		state, err := cl.CurrentState(ctx)
+		if err != nil {
+			return err
+		}
+		txID = state.GetTxId()
+
+		return nil
+	})
+	if err != nil {
+		idb.log.Errorf("could not get current transaction: %s", err)
+
+		return 0, wrapErr(err)
+	}
+
+	return txID, nil
+}
+
+// ChangedInodes returns the inumbers whose inode or content has been written since the given transaction (included).
+func (idb *ImmuDbClient) ChangedInodes(ctx context.Context, sinceTx uint64) ([]int64, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	seen := make(map[int64]bool)
+	var inumbers []int64
+	for _, table := range []string{"inode", "content"} {
+		res, err := idb.cl.QueryContext(ctx, fmt.Sprintf("SELECT inumber FROM %s SINCE TX %d", table, sinceTx))
+		if err != nil {
+			idb.log.Errorf("could not get %s changes since tx %d: %s", table, sinceTx, err)
+
+			return nil, wrapErr(err)
+		}
+
+		for res.Next() {
+			var inumber int64
+			if err := res.Scan(&inumber); err != nil {
+				res.Close()
+
+				return nil, wrapErr(err)
+			}
+			if !seen[inumber] {
+				seen[inumber] = true
+				inumbers = append(inumbers, inumber)
+			}
+		}
+		err = res.Err()
+		res.Close()
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+	}
+
+	return inumbers, nil
+}
+
+// checkSize reports a file whose size does not match the length of its content, which can only
+// be repaired offline (see Fsck).
+func (idb *ImmuDbClient) checkSize(inode *Inode, content []byte) {
+	if inode.Size != int64(len(content)) {
+		idb.sizeMismatches.Add(1)
+		idb.log.Warnf("inode %d has size %d, but %d bytes of content: run fsck", inode.Inumber, inode.Size, len(content))
+	}
+}
+
+// SizeMismatches returns the number of files found with a size not matching their content since the client was created.
+func (idb *ImmuDbClient) SizeMismatches() int64 {
+	return idb.sizeMismatches.Load()
+}
+
+// Reconnects returns the number of sessions opened to immudb after the first one: the pool opens new sessions
+// to replace the connections lost, or when queries run concurrently.
+func (idb *ImmuDbClient) Reconnects() int64 {
+	if n := idb.sessions.Load(); n > 1 {
+		return n - 1
+	}
+
+	return 0
 }
 
 // SpaceUsed calculates the total amount of space consumed by all the files together.
 func (idb *ImmuDbClient) SpaceUsed(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
 	res, err := idb.cl.QueryContext(ctx, "SELECT SUM(size) FROM inode")
 	if err != nil {
-		return -1, err
+		return -1, wrapErr(err)
 	}
 
 	var totalSpace int64
@@ -265,6 +1215,9 @@ func (idb *ImmuDbClient) SpaceUsed(ctx context.Context) (int64, error) {
 	err = res.Scan(
 		&totalSpace,
 	)
+	if err != nil {
+		return -1, wrapErr(err)
+	}
 
 	return totalSpace, nil
 }
@@ -0,0 +1,249 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"immufs/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	journalCompactedFiles = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "immufs_journal_compacted_files_total",
+		Help: "Files whose journaled writes were folded into their content.",
+	})
+	journalFoldedDeltas = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "immufs_journal_folded_deltas_total",
+		Help: "Journaled writes folded into the content of their file.",
+	})
+	journalPendingDeltas = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "immufs_journal_pending_deltas",
+		Help: "Journaled writes left to fold after the last compaction.",
+	})
+	journalCompactionSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "immufs_journal_compaction_duration_seconds",
+		Help: "Duration of the compactions of the journal.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(journalCompactedFiles, journalFoldedDeltas, journalPendingDeltas, journalCompactionSeconds)
+}
+
+// CompactReport describes a compaction of the journal.
+type CompactReport struct {
+	// Files whose deltas were folded into their content, and the deltas folded.
+	Files  int
+	Deltas int
+	// Deltas left, of the files below the threshold.
+	Pending int
+}
+
+// CompactJournal folds the deltas of the files with at least minDeltas of them into their content row, so that
+// reading them no longer needs to apply the deltas. The content read by the mounts is left unchanged.
+func (idb *ImmuDbClient) CompactJournal(ctx context.Context, minDeltas int) (*CompactReport, error) {
+	if err := idb.checkJournal(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	defer func() { journalCompactionSeconds.Observe(time.Since(start).Seconds()) }()
+
+	counts, err := idb.countDeltas(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inumbers := make([]int64, 0, len(counts))
+	for inumber := range counts {
+		inumbers = append(inumbers, inumber)
+	}
+	sort.Slice(inumbers, func(i, j int) bool { return inumbers[i] < inumbers[j] })
+
+	report := &CompactReport{}
+	for _, inumber := range inumbers {
+		if counts[inumber] < minDeltas {
+			report.Pending += counts[inumber]
+
+			continue
+		}
+		n, err := idb.foldDeltas(ctx, inumber)
+		if err != nil {
+			idb.log.Errorf("could not fold the deltas of file %d: %s", inumber, err)
+
+			return report, err
+		}
+		report.Files++
+		report.Deltas += n
+		journalCompactedFiles.Inc()
+		journalFoldedDeltas.Add(float64(n))
+	}
+	journalPendingDeltas.Set(float64(report.Pending))
+
+	return report, nil
+}
+
+// countDeltas returns the number of deltas of the files having some.
+func (idb *ImmuDbClient) countDeltas(ctx context.Context) (map[int64]int, error) {
+	ctx, cancel := withTimeout(ctx, idb.readTimeout.Load())
+	defer cancel()
+
+	res, err := idb.cl.QueryContext(ctx, "SELECT inumber FROM content_delta")
+	if err != nil {
+		idb.log.Errorf("could not list the deltas: %s", err)
+
+		return nil, wrapErr(err)
+	}
+	defer res.Close()
+
+	counts := make(map[int64]int)
+	for res.Next() {
+		var inumber int64
+		if err := res.Scan(&inumber); err != nil {
+			return nil, wrapErr(err)
+		}
+		counts[inumber]++
+	}
+
+	return counts, wrapErr(res.Err())
+}
+
+// foldDeltas writes the content of a file with its deltas applied, and drops the deltas, within a single
+// transaction. The deltas journaled meanwhile are kept. It returns the number of deltas folded.
+func (idb *ImmuDbClient) foldDeltas(ctx context.Context, inumber int64) (int, error) {
+	var folded int
+	err := idb.inTx(ctx, idb.writeTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+		var content []byte
+		var checksum, tier sql.NullString
+		err := tx.QueryRowContext(ctx, "SELECT content, checksum, tier FROM content WHERE inumber=?", inumber).Scan(&content, &checksum, &tier)
+		if errors.Is(err, sql.ErrNoRows) {
+			content, err = idb.readInline(ctx, tx, inumber, "")
+		} else if err == nil {
+			content, err = idb.resolveContent(ctx, inumber, content, checksum, tier)
+		}
+		if err != nil {
+			return err
+		}
+
+		deltas, err := queryDeltas(ctx, tx, inumber, "")
+		if err != nil || len(deltas) == 0 {
+			return err
+		}
+		if err := idb.upsertFileContent(ctx, tx, inumber, applyDeltas(content, deltas)); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM content_delta WHERE inumber=? AND id<=?", inumber, deltas[len(deltas)-1].id); err != nil {
+			return err
+		}
+		folded = len(deltas)
+
+		return nil
+	})
+
+	return folded, err
+}
+
+// compactor periodically folds the deltas of the files written by a long-lived mount, so that reading them
+// doesn't get slower as they are written.
+type compactor struct {
+	idb       *ImmuDbClient
+	log       *logrus.Entry
+	minDeltas int
+
+	// GUARDED_BY(mu)
+	interval time.Duration
+
+	// Completion time of the last successful run.
+	//
+	// GUARDED_BY(mu)
+	lastCompaction time.Time
+	mu             sync.Mutex
+
+	stop  chan struct{}
+	done  chan struct{}
+	reset chan struct{}
+}
+
+func newCompactor(idb *ImmuDbClient, log *logrus.Entry, interval time.Duration, minDeltas int) *compactor {
+	return &compactor{
+		idb:       idb,
+		log:       log.WithField("component", "compactor"),
+		interval:  interval,
+		minDeltas: minDeltas,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		reset:     make(chan struct{}, 1),
+	}
+}
+
+// Start runs the compactions in background.
+func (c *compactor) Start() {
+	go c.run()
+}
+
+// Stop terminates the compactions and waits for the current run, if any, to return.
+func (c *compactor) Stop() {
+	close(c.stop)
+	<-c.done
+}
+
+// status returns the completion time of the last compaction (zero if none).
+func (c *compactor) status() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lastCompaction
+}
+
+// setInterval changes the interval between the compactions, which starts over from the change.
+func (c *compactor) setInterval(interval time.Duration) {
+	c.mu.Lock()
+	c.interval = interval
+	c.mu.Unlock()
+
+	select {
+	case c.reset <- struct{}{}:
+	default:
+	}
+}
+
+// currentInterval returns the interval between the compactions.
+func (c *compactor) currentInterval() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.interval
+}
+
+func (c *compactor) run() {
+	defer close(c.done)
+
+	t := time.NewTicker(c.currentInterval())
+	defer t.Stop()
+
+	// Errors are only logged: the compaction is tried again at the next tick.
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-c.reset:
+			t.Reset(c.currentInterval())
+		case <-t.C:
+			report, err := c.idb.CompactJournal(context.Background(), c.minDeltas)
+			if err != nil {
+				continue
+			}
+			c.mu.Lock()
+			c.lastCompaction = time.Now()
+			c.mu.Unlock()
+			if report.Files > 0 {
+				c.log.Infof("folded %d deltas of %d files", report.Deltas, report.Files)
+			}
+		}
+	}
+}
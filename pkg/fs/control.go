@@ -0,0 +1,319 @@
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// The .immufs directory is a synthetic directory at the root of the mount, which lets scripts
+// control the filesystem:
+//   - .immufs/ctl accepts commands, one per line (e.g. `echo "snapshot mybackup" > .immufs/ctl`);
+//   - .immufs/stats is a JSON document describing the filesystem.
+//
+// The control inodes never reach immudb: their IDs are above the range of the inumbers.
+const (
+	controlDirName   = ".immufs"
+	controlCtlName   = "ctl"
+	controlStatsName = "stats"
+
+	controlDirInode   fuseops.InodeID = 1 << 63
+	controlCtlInode   fuseops.InodeID = controlDirInode + 1
+	controlStatsInode fuseops.InodeID = controlDirInode + 2
+)
+
+var controlEntries = []fuseutil.Dirent{
+	{Offset: 1, Inode: controlCtlInode, Name: controlCtlName, Type: fuseutil.DT_File},
+	{Offset: 2, Inode: controlStatsInode, Name: controlStatsName, Type: fuseutil.DT_File},
+}
+
+func isControlInode(id fuseops.InodeID) bool {
+	return id >= controlDirInode
+}
+
+// isControlName tells whether name, created within parent, would hide the .immufs directory.
+func isControlName(parent fuseops.InodeID, name string) bool {
+	return parent == fuseops.RootInodeID && name == controlDirName
+}
+
+// controlAttributes returns the attributes of a control inode.
+func (fs *Immufs) controlAttributes(id fuseops.InodeID) fuseops.InodeAttributes {
+	attrs := fuseops.InodeAttributes{
+		Nlink:  1,
+		Atime:  fs.mountTime,
+		Mtime:  fs.mountTime,
+		Ctime:  fs.mountTime,
+		Crtime: fs.mountTime,
+		Uid:    fs.uid,
+		Gid:    fs.gid,
+	}
+
+	switch id {
+	case controlDirInode:
+		attrs.Nlink = 2
+		attrs.Mode = 0500 | os.ModeDir
+	case controlCtlInode:
+		attrs.Mode = 0200
+	case controlStatsInode:
+		attrs.Mode = 0400
+	}
+
+	return attrs
+}
+
+func (fs *Immufs) controlEntry(id fuseops.InodeID) fuseops.ChildInodeEntry {
+	return fuseops.ChildInodeEntry{
+		Child:                id,
+		Attributes:           fs.controlAttributes(id),
+		AttributesExpiration: fs.attrExpiration(),
+		EntryExpiration:      fs.entryExpiration(),
+	}
+}
+
+// lookUpControl serves the lookups of and within the .immufs directory. handled is false if the lookup
+// must be served by the regular filesystem.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) lookUpControl(parent fuseops.InodeID, name string) (entry fuseops.ChildInodeEntry, handled bool, err error) {
+	if isControlName(parent, name) {
+		return fs.controlEntry(controlDirInode), true, nil
+	}
+	if parent != controlDirInode {
+		return entry, false, nil
+	}
+
+	for _, e := range controlEntries {
+		if e.Name == name {
+			return fs.controlEntry(e.Inode), true, nil
+		}
+	}
+
+	return entry, true, fuse.ENOENT
+}
+
+// readControlDir serves a ReadDir request on the .immufs directory.
+func readControlDir(p []byte, offset int) int {
+	var n int
+	for i := offset; i < len(controlEntries); i++ {
+		tmp := fuseutil.WriteDirent(p[n:], controlEntries[i])
+		if tmp == 0 {
+			break
+		}
+
+		n += tmp
+	}
+
+	return n
+}
+
+// Stats describes a mounted filesystem. It is the content of .immufs/stats.
+type Stats struct {
+	Inodes      int64     `json:"inodes"`
+	SpaceUsed   int64     `json:"space_used"`
+	LastTx      uint64    `json:"last_tx"`
+	OpenHandles int       `json:"open_handles"`
+	MountTime   time.Time `json:"mount_time"`
+	ReadOnly    bool      `json:"read_only"`
+
+	// Snapshot served, if any: LastTx is then its transaction.
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Files read with a size not matching their content (see Fsck).
+	SizeMismatches int64 `json:"size_mismatches"`
+
+	// Operations served since the mount, by FUSE operation.
+	Ops map[string]int64 `json:"ops"`
+
+	// Lookups of the quotas served from the cache of the mount, and those read from immudb.
+	QuotaCacheHits   int64 `json:"quota_cache_hits"`
+	QuotaCacheMisses int64 `json:"quota_cache_misses"`
+
+	// Bytes written but not committed to immudb yet. Writes are committed before returning, so there are none.
+	DirtyBytes int64 `json:"dirty_bytes"`
+
+	// Operations failed because of immudb since the mount, and transactions found corrupted by a verification.
+	BackendErrors  int64 `json:"backend_errors"`
+	VerifyFailures int64 `json:"verify_failures"`
+
+	// Sessions opened to immudb after the first one (see ImmuDbClient.Reconnects).
+	Reconnects int64 `json:"reconnects"`
+
+	// Set when the mount maintains the index.
+	LastIndexFlush      *time.Time `json:"last_index_flush,omitempty"`
+	LastIndexCompaction *time.Time `json:"last_index_compaction,omitempty"`
+
+	// Set when the mount compacts the journal.
+	LastJournalCompaction *time.Time `json:"last_journal_compaction,omitempty"`
+
+	// Set when the mount moves the cold files to the tier.
+	LastTiering *time.Time `json:"last_tiering,omitempty"`
+
+	// Set when the mount gathers the storage statistics, once gathered.
+	Storage *StorageStats `json:"storage,omitempty"`
+
+	// Set when the mount has a cache.
+	Cache *CacheStats `json:"cache,omitempty"`
+
+	// Set when the mount has a disk cache.
+	DiskCache *DiskCacheStats `json:"disk_cache,omitempty"`
+
+	// Set when the mount has a disconnected journal.
+	Disconnected *DisconnectedStats `json:"disconnected,omitempty"`
+}
+
+// stats gathers the statistics of the filesystem.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) stats(ctx context.Context) (*Stats, error) {
+	next, err := fs.backend.NextInumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	space, err := fs.backend.SpaceUsed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	txID, err := fs.backend.CurrentTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{
+		Inodes:      next - 1,
+		SpaceUsed:   space,
+		LastTx:      txID,
+		OpenHandles: len(fs.handles),
+		MountTime:   fs.mountTime,
+		ReadOnly:    fs.readOnly,
+		Snapshot:    fs.snapshot,
+
+		Ops:              fs.opCounts(),
+		QuotaCacheHits:   fs.counters.quotaHits.Load(),
+		QuotaCacheMisses: fs.counters.quotaMisses.Load(),
+		BackendErrors:    fs.counters.backendErrors.Load(),
+	}
+	if fs.idb != nil {
+		stats.SizeMismatches = fs.idb.SizeMismatches()
+		stats.VerifyFailures = fs.idb.VerifyFailures()
+		stats.Reconnects = fs.idb.Reconnects()
+	}
+	if fs.maintainer != nil {
+		lastFlush, lastCompaction := fs.maintainer.status()
+		stats.LastIndexFlush, stats.LastIndexCompaction = &lastFlush, &lastCompaction
+	}
+	if fs.compactor != nil {
+		lastCompaction := fs.compactor.status()
+		stats.LastJournalCompaction = &lastCompaction
+	}
+	if fs.tierer != nil {
+		lastTiering := fs.tierer.status()
+		stats.LastTiering = &lastTiering
+	}
+	if fs.storage != nil {
+		stats.Storage = fs.storage.status()
+	}
+	if fs.cache != nil {
+		stats.Cache = fs.cache.status()
+	}
+	if fs.idb != nil && fs.idb.diskCache != nil {
+		stats.DiskCache = fs.idb.diskCache.status()
+	}
+	if fs.disconnected != nil {
+		stats.Disconnected = fs.disconnected.status()
+	}
+
+	return stats, nil
+}
+
+// readStats builds the content of .immufs/stats.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) readStats(ctx context.Context) ([]byte, error) {
+	stats, err := fs.stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	content, err := json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(content, '\n'), nil
+}
+
+// runControl executes the commands written to .immufs/ctl, one per line.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) runControl(ctx context.Context, data []byte) error {
+	for _, line := range strings.Split(string(data), "\n") {
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+
+		log := fs.log.WithField("API", "ctl")
+		log.Infof("running control command: %s", line)
+
+		switch args[0] {
+		case "snapshot":
+			if len(args) != 2 {
+				log.Warningf("usage: snapshot <name>")
+
+				return fuse.EINVAL
+			}
+			if err := fs.checkWritable("ctl"); err != nil {
+				return err
+			}
+			if fs.idb == nil {
+				return ErrNotSupported
+			}
+			snap, err := fs.idb.CreateSnapshot(ctx, args[1])
+			if err != nil {
+				if err == ErrSnapshotExists {
+					return fuse.EEXIST
+				}
+
+				return err
+			}
+			log.Infof("snapshot %s created at TX=%d", snap.Name, snap.Tx)
+		case "flush":
+			// Nothing to do: every write is committed to immudb before returning.
+		case "journal":
+			if len(args) != 2 {
+				log.Warningf("usage: journal replay|drop")
+
+				return fuse.EINVAL
+			}
+			if fs.disconnected == nil {
+				return ErrNotSupported
+			}
+			var err error
+			switch args[1] {
+			case "replay":
+				err = fs.disconnected.Replay(ctx)
+			case "drop":
+				err = fs.disconnected.Drop()
+			default:
+				log.Warningf("usage: journal replay|drop")
+
+				return fuse.EINVAL
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			log.Warningf("unknown control command: %s", args[0])
+
+			return syscall.EINVAL
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package fs
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// counters are the live statistics of a mount, reported by .immufs/stats.
+type counters struct {
+	mu sync.Mutex
+	// Operations served, by FUSE operation.
+	//
+	// GUARDED_BY(mu)
+	ops map[string]int64
+
+	// Lookups of the quotas served from the cache of the mount, and those read from immudb.
+	quotaHits   atomic.Int64
+	quotaMisses atomic.Int64
+
+	// Operations failed because of immudb, reported as EIO.
+	backendErrors atomic.Int64
+}
+
+// countOp counts an operation served by the filesystem.
+func (fs *Immufs) countOp(op string) {
+	fs.counters.mu.Lock()
+	defer fs.counters.mu.Unlock()
+
+	if fs.counters.ops == nil {
+		fs.counters.ops = make(map[string]int64)
+	}
+	fs.counters.ops[op]++
+}
+
+// opCounts returns a copy of the operation counters.
+func (fs *Immufs) opCounts() map[string]int64 {
+	fs.counters.mu.Lock()
+	defer fs.counters.mu.Unlock()
+
+	ops := make(map[string]int64, len(fs.counters.ops))
+	for op, n := range fs.counters.ops {
+		ops[op] = n
+	}
+
+	return ops
+}
@@ -0,0 +1,161 @@
+package fs
+
+import (
+	"context"
+	"os"
+	"sort"
+
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// Kinds of difference reported by Diff.
+const (
+	DiffAdded    = "added"
+	DiffRemoved  = "removed"
+	DiffModified = "modified"
+)
+
+// DiffEntry is a path which differs between two transactions. The sizes and the checksums (see contentChecksum)
+// are those of the file at each of them: the ones of a directory, and of a path missing at one of them, are left
+// empty.
+type DiffEntry struct {
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+	Dir  bool   `json:"dir"`
+
+	OldSize int64  `json:"old_size,omitempty"`
+	NewSize int64  `json:"new_size,omitempty"`
+	OldHash string `json:"old_sha256,omitempty"`
+	NewHash string `json:"new_sha256,omitempty"`
+}
+
+// Diff compares the namespace as of two transactions, 0 standing for the current state, and returns the paths
+// added, removed or modified from the first to the second, sorted by path. A path is modified when it names another
+// file, or a file whose size or change time differ: changes of the attributes alone are listed too, with the same
+// checksums. Directories are only listed when added or removed, and a renamed path as removed and added.
+func (idb *ImmuDbClient) Diff(ctx context.Context, fromTx, toTx uint64) ([]DiffEntry, error) {
+	from, err := idb.namespaceAt(ctx, fromTx)
+	if err != nil {
+		return nil, err
+	}
+	to, err := idb.namespaceAt(ctx, toTx)
+	if err != nil {
+		return nil, err
+	}
+
+	var diff []DiffEntry
+	for path, old := range from {
+		cur, ok := to[path]
+		switch {
+		case !ok:
+			e := DiffEntry{Kind: DiffRemoved, Path: path, Dir: isDir(old)}
+			if !e.Dir {
+				if e.OldSize, e.OldHash, err = idb.fileDigestAt(ctx, old, fromTx); err != nil {
+					return nil, err
+				}
+			}
+			diff = append(diff, e)
+		case isDir(old) != isDir(cur):
+			// A directory replaced by a file, or conversely.
+			diff = append(diff, DiffEntry{Kind: DiffRemoved, Path: path, Dir: isDir(old)}, DiffEntry{Kind: DiffAdded, Path: path, Dir: isDir(cur)})
+		case !isDir(old) && (old.Inumber != cur.Inumber || old.Size != cur.Size || !old.Ctime.Equal(cur.Ctime)):
+			e := DiffEntry{Kind: DiffModified, Path: path}
+			if e.OldSize, e.OldHash, err = idb.fileDigestAt(ctx, old, fromTx); err != nil {
+				return nil, err
+			}
+			if e.NewSize, e.NewHash, err = idb.fileDigestAt(ctx, cur, toTx); err != nil {
+				return nil, err
+			}
+			diff = append(diff, e)
+		}
+	}
+	for path, cur := range to {
+		if _, ok := from[path]; ok {
+			continue
+		}
+		e := DiffEntry{Kind: DiffAdded, Path: path, Dir: isDir(cur)}
+		if !e.Dir {
+			if e.NewSize, e.NewHash, err = idb.fileDigestAt(ctx, cur, toTx); err != nil {
+				return nil, err
+			}
+		}
+		diff = append(diff, e)
+	}
+	// A path whose kind changed stays removed, then added.
+	sort.SliceStable(diff, func(i, j int) bool { return diff[i].Path < diff[j].Path })
+
+	return diff, nil
+}
+
+func isDir(inode *Inode) bool {
+	return os.FileMode(inode.Mode).IsDir()
+}
+
+// namespaceAt returns every path of the filesystem as of a transaction, the root excepted: the files linked several
+// times are listed at each of their paths.
+func (idb *ImmuDbClient) namespaceAt(ctx context.Context, tx uint64) (map[string]*Inode, error) {
+	period, err := idb.periodAsOf(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]int64)
+	dirs := map[int64]string{1: ""}
+	pending := []int64{1}
+	for len(pending) > 0 {
+		dir := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		children, err := idb.getChildren(ctx, dir, period)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			if child.Type == fuseutil.DT_Unknown {
+				continue
+			}
+			path := dirs[dir] + "/" + child.Name
+			paths[path] = int64(child.Inode)
+			if _, ok := dirs[int64(child.Inode)]; child.Type == fuseutil.DT_Directory && !ok {
+				dirs[int64(child.Inode)] = path
+				pending = append(pending, int64(child.Inode))
+			}
+		}
+	}
+
+	var inumbers []int64
+	seen := make(map[int64]bool)
+	for _, inumber := range paths {
+		if !seen[inumber] {
+			seen[inumber] = true
+			inumbers = append(inumbers, inumber)
+		}
+	}
+	inodes, err := idb.getInodes(ctx, inumbers, period)
+	if err != nil {
+		return nil, err
+	}
+	namespace := make(map[string]*Inode, len(paths))
+	for path, inumber := range paths {
+		inode, ok := inodes[inumber]
+		if !ok {
+			idb.log.Warnf("Inode %d of %s not found", inumber, path)
+
+			return nil, ErrInodeNotFound
+		}
+		namespace[path] = inode
+	}
+
+	return namespace, nil
+}
+
+// fileDigestAt returns the size and the checksum of the content of a file, or the target of a symlink, as of a
+// transaction.
+func (idb *ImmuDbClient) fileDigestAt(ctx context.Context, inode *Inode, tx uint64) (int64, string, error) {
+	content, err := idb.ReadContentAt(ctx, inode.Inumber, tx)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return inode.Size, contentChecksum(content), nil
+}
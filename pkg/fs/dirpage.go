@@ -0,0 +1,146 @@
+package fs
+
+import (
+	"container/heap"
+	"context"
+	"hash/fnv"
+	"sort"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// The entries of a directory are listed in the order of their cookie, a hash of their name, which is the offset
+// returned to the kernel: the offset passed back is a cursor, and the following page holds the entries with a
+// greater cookie (keyset pagination). As the cookie of an entry only depends on its name, the listings stay
+// consistent while the directory changes, without keeping any state per handle: the entries removed meanwhile are
+// not returned, those added are returned if their cookie is after the cursor, and no entry is returned twice.
+
+// Smallest room taken by an entry written by fuseutil.WriteDirent: its header, and a name of one byte, aligned.
+const minDirentSize = 32
+
+// direntCookie returns the offset of the entry with the given name: a 63-bit FNV-1a hash, never 0, which is the
+// offset of the start of the directory.
+func direntCookie(name string) fuseops.DirOffset {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	cookie := h.Sum64() >> 1
+	if cookie == 0 {
+		cookie = 1
+	}
+
+	return fuseops.DirOffset(cookie)
+}
+
+// direntHeap is a max-heap of entries by cookie.
+type direntHeap []fuseutil.Dirent
+
+func (h direntHeap) Len() int           { return len(h) }
+func (h direntHeap) Less(i, j int) bool { return h[i].Offset > h[j].Offset }
+func (h direntHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *direntHeap) Push(x any)        { *h = append(*h, x.(fuseutil.Dirent)) }
+func (h *direntHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return e
+}
+
+// direntPage collects the entries of a page while the entries of a directory are visited, keeping no more than the
+// entries of the page: the limit entries with the smallest cookies after the cursor. The entries whose cookies
+// collide are kept or dropped together, so that a page never ends in the middle of them.
+type direntPage struct {
+	after fuseops.DirOffset
+	limit int
+	heap  direntHeap
+}
+
+func newDirentPage(after fuseops.DirOffset, limit int) *direntPage {
+	if limit < 1 {
+		limit = 1
+	}
+
+	return &direntPage{after: after, limit: limit}
+}
+
+// add visits an entry of the directory. The unused entries are skipped.
+func (p *direntPage) add(e fuseutil.Dirent) {
+	if e.Type == fuseutil.DT_Unknown {
+		return
+	}
+	e.Offset = direntCookie(e.Name)
+	if e.Offset <= p.after {
+		return
+	}
+	if len(p.heap) >= p.limit && e.Offset > p.heap[0].Offset {
+		return
+	}
+	heap.Push(&p.heap, e)
+
+	// Drop the entries with the greatest cookie, once the page is full without them.
+	last := p.heap[0].Offset
+	var group []fuseutil.Dirent
+	for len(p.heap) > 0 && p.heap[0].Offset == last {
+		group = append(group, heap.Pop(&p.heap).(fuseutil.Dirent))
+	}
+	if len(p.heap) < p.limit {
+		for _, e := range group {
+			heap.Push(&p.heap, e)
+		}
+	}
+}
+
+// entries returns the entries of the page, in the order of their cookie.
+func (p *direntPage) entries() []fuseutil.Dirent {
+	entries := []fuseutil.Dirent(p.heap)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Offset < entries[j].Offset })
+
+	return entries
+}
+
+// pageOf returns the page of the given entries after the cursor (see direntPage).
+func pageOf(children []fuseutil.Dirent, after fuseops.DirOffset, limit int) []fuseutil.Dirent {
+	p := newDirentPage(after, limit)
+	for _, e := range children {
+		p.add(e)
+	}
+
+	return p.entries()
+}
+
+// getChildrenPage returns at most limit entries of a directory following the cursor after, in the order of their
+// cookie, offsets set, paged by the backend if it can.
+func getChildrenPage(ctx context.Context, b Backend, parent int64, after fuseops.DirOffset, limit int) ([]fuseutil.Dirent, error) {
+	if pager, ok := b.(childrenPager); ok {
+		return pager.GetChildrenPage(ctx, parent, after, limit)
+	}
+	children, err := b.GetChildren(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+
+	return pageOf(children, after, limit), nil
+}
+
+// writeDirentPage writes the entries of a page to a ReadDir buffer, and returns the bytes and the entries written.
+// The entries whose cookies collide are written together, unless they can't fit in the buffer at all.
+func writeDirentPage(p []byte, entries []fuseutil.Dirent) (int, []fuseutil.Dirent) {
+	var n, groupStart, groupBytes int
+	for i, e := range entries {
+		if i == 0 || e.Offset != entries[i-1].Offset {
+			groupStart, groupBytes = i, n
+		}
+		tmp := fuseutil.WriteDirent(p[n:], e)
+		if tmp == 0 {
+			if groupStart > 0 {
+				return groupBytes, entries[:groupStart]
+			}
+
+			return n, entries[:i]
+		}
+		n += tmp
+	}
+
+	return n, entries
+}
@@ -0,0 +1,254 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// DirQuota limits the bytes and inodes of a directory tree. A zero limit means unlimited.
+//
+// Every inode records the directory tree (project) it is charged to, inherited from its parent
+// on creation: setting a quota tags the existing tree, except for the nested trees having a quota
+// on their own.
+type DirQuota struct {
+	Inumber   int64
+	MaxBytes  int64
+	MaxInodes int64
+}
+
+// DirUsage reports the bytes and inodes of a directory tree.
+type DirUsage struct {
+	Inumber int64
+	Bytes   int64
+	Inodes  int64
+}
+
+// GetDirQuota returns the limits of the tree rooted at the given directory, or ErrQuotaNotFound if it has none.
+func (idb *ImmuDbClient) GetDirQuota(ctx context.Context, inumber int64) (*DirQuota, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	q := DirQuota{Inumber: inumber}
+	err := idb.cl.QueryRowContext(ctx, "SELECT max_bytes, max_inodes FROM dir_quota WHERE inumber=?", inumber).Scan(&q.MaxBytes, &q.MaxInodes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrQuotaNotFound
+	}
+	if err != nil {
+		idb.log.Errorf("could not get quota of directory %d: %s", inumber, err)
+
+		return nil, wrapErr(err)
+	}
+
+	return &q, nil
+}
+
+// SetDirQuota creates or replaces the limits of a directory tree, tagging the tree if needed.
+func (idb *ImmuDbClient) SetDirQuota(ctx context.Context, q DirQuota) error {
+	root, err := idb.GetInode(ctx, q.Inumber)
+	if err != nil {
+		return err
+	}
+	if !root.isDir() {
+		return syscall.ENOTDIR
+	}
+
+	mctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	_, err = idb.cl.ExecContext(mctx, "UPSERT INTO dir_quota(inumber, max_bytes, max_inodes) VALUES(?, ?, ?)", q.Inumber, q.MaxBytes, q.MaxInodes)
+	if err != nil {
+		idb.log.Errorf("could not set quota of directory %d: %s", q.Inumber, err)
+
+		return wrapErr(err)
+	}
+
+	if root.Project == q.Inumber {
+		return nil
+	}
+
+	return idb.tagTree(ctx, root, root.Project, q.Inumber)
+}
+
+// DeleteDirQuota removes the limits of a directory tree, which is charged back to the tree of its parent.
+func (idb *ImmuDbClient) DeleteDirQuota(ctx context.Context, inumber int64, parentProject int64) error {
+	if _, err := idb.GetDirQuota(ctx, inumber); err != nil {
+		return err
+	}
+	root, err := idb.GetInode(ctx, inumber)
+	if err != nil {
+		return err
+	}
+
+	mctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	_, err = idb.cl.ExecContext(mctx, "DELETE FROM dir_quota WHERE inumber=?", inumber)
+	if err != nil {
+		idb.log.Errorf("could not delete quota of directory %d: %s", inumber, err)
+
+		return wrapErr(err)
+	}
+
+	return idb.tagTree(ctx, root, inumber, parentProject)
+}
+
+// ListDirQuotas returns the limits of all directory trees, ordered by inumber.
+func (idb *ImmuDbClient) ListDirQuotas(ctx context.Context) ([]DirQuota, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	res, err := idb.cl.QueryContext(ctx, "SELECT inumber, max_bytes, max_inodes FROM dir_quota ORDER BY inumber")
+	if err != nil {
+		idb.log.Errorf("could not list directory quotas: %s", err)
+
+		return nil, wrapErr(err)
+	}
+	defer res.Close()
+
+	var quotas []DirQuota
+	for res.Next() {
+		var q DirQuota
+		if err := res.Scan(&q.Inumber, &q.MaxBytes, &q.MaxInodes); err != nil {
+			return nil, wrapErr(err)
+		}
+		quotas = append(quotas, q)
+	}
+
+	return quotas, wrapErr(res.Err())
+}
+
+// GetDirUsage computes the bytes and inodes charged to a directory tree.
+func (idb *ImmuDbClient) GetDirUsage(ctx context.Context, inumber int64) (*DirUsage, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	var inodes, bytes sql.NullInt64
+	err := idb.cl.QueryRowContext(ctx, "SELECT COUNT(*), SUM(size) FROM inode WHERE project=?", inumber).Scan(&inodes, &bytes)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		idb.log.Errorf("could not get usage of directory %d: %s", inumber, err)
+
+		return nil, wrapErr(err)
+	}
+
+	return &DirUsage{Inumber: inumber, Bytes: bytes.Int64, Inodes: inodes.Int64}, nil
+}
+
+// tagTree charges to project `to` the inodes of the tree rooted at root which are charged to project `from`.
+// Nested trees charged to other projects are left untouched.
+func (idb *ImmuDbClient) tagTree(ctx context.Context, root *Inode, from, to int64) error {
+	pending := []*Inode{root}
+	for len(pending) > 0 {
+		in := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if in.Project != from {
+			continue
+		}
+
+		in.Project = to
+		if err := idb.WriteInode(ctx, in); err != nil {
+			return err
+		}
+		if !in.isDir() {
+			continue
+		}
+
+		children, err := idb.GetChildren(ctx, in.Inumber)
+		if err != nil {
+			return err
+		}
+		var inumbers []int64
+		for _, child := range children {
+			if child.Type != fuseutil.DT_Unknown {
+				inumbers = append(inumbers, int64(child.Inode))
+			}
+		}
+		inodes, err := idb.GetInodes(ctx, inumbers)
+		if err != nil {
+			return err
+		}
+		for _, inumber := range inumbers {
+			inode, ok := inodes[inumber]
+			if !ok {
+				return fmt.Errorf("Inode %d: %w", inumber, ErrInodeNotFound)
+			}
+			pending = append(pending, inode)
+		}
+	}
+
+	return nil
+}
+
+// LookUpPath resolves a path, relative to the root of the filesystem, returning the inode and its parent.
+// The parent of the root is nil.
+func (idb *ImmuDbClient) LookUpPath(ctx context.Context, path string) (inode *Inode, parent *Inode, err error) {
+	return idb.lookUpPath(ctx, path, "")
+}
+
+// lookUpPath resolves a path as of the given period clause, or currently if period is empty.
+func (idb *ImmuDbClient) lookUpPath(ctx context.Context, path string, period string) (inode *Inode, parent *Inode, err error) {
+	inode, err = idb.getInode(ctx, 1, period)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, name := range strings.Split(path, "/") {
+		if name == "" || name == "." {
+			continue
+		}
+		if !inode.isDir() {
+			return nil, nil, fmt.Errorf("%s: %w", path, syscall.ENOTDIR)
+		}
+
+		children, err := idb.getChildren(ctx, inode.Inumber, period)
+		if err != nil {
+			return nil, nil, err
+		}
+		found := false
+		for _, child := range children {
+			if child.Type != fuseutil.DT_Unknown && child.Name == name {
+				parent = inode
+				if inode, err = idb.getInode(ctx, int64(child.Inode), period); err != nil {
+					return nil, nil, err
+				}
+				found = true
+
+				break
+			}
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("%s: %w", path, ErrInodeNotFound)
+		}
+	}
+
+	return inode, parent, nil
+}
+
+// DirectoryPaths returns the path of every directory, by inumber, walking the whole tree.
+func (idb *ImmuDbClient) DirectoryPaths(ctx context.Context) (map[int64]string, error) {
+	paths := map[int64]string{1: "/"}
+	pending := []int64{1}
+	for len(pending) > 0 {
+		dir := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		children, err := idb.GetChildren(ctx, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			if child.Type != fuseutil.DT_Directory {
+				continue
+			}
+			paths[int64(child.Inode)] = strings.TrimSuffix(paths[dir], "/") + "/" + child.Name
+			pending = append(pending, int64(child.Inode))
+		}
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,1019 @@
+package fs
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"immufs/pkg/config"
+	"immufs/pkg/metrics"
+
+	"github.com/codenotary/immudb/pkg/client"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrJournalFull is returned by the writes made while immudb is unreachable once the disconnected journal is full.
+var ErrJournalFull = errors.New("disconnected journal full")
+
+// ErrReplayConflict is returned by the replays of the changes journaled while disconnected which conflict with the
+// changes made meanwhile by other mounts: the journal is kept.
+var ErrReplayConflict = errors.New("entries changed by another mount")
+
+// DisconnectedStats describes the disconnected mode of a mount (see DisconnectedJournal in the configuration).
+type DisconnectedStats struct {
+	// Set while immudb is unreachable, since the time it became so.
+	Disconnected bool       `json:"disconnected"`
+	Since        *time.Time `json:"since,omitempty"`
+	// Times immudb became unreachable.
+	Disconnections int64 `json:"disconnections"`
+	// Budget of the journal, and the space taken by the changes left to replay, in bytes, and their number.
+	Budget  int64 `json:"budget"`
+	Bytes   int64 `json:"bytes"`
+	Changes int64 `json:"changes"`
+	// Changes replayed to immudb, and writes refused because the journal was full.
+	Replayed int64 `json:"replayed"`
+	Refused  int64 `json:"refused"`
+	// Set while the changes left can't be replayed because of the changes of other mounts, with the inumbers of
+	// the files they change.
+	Conflict string  `json:"conflict,omitempty"`
+	Pending  []int64 `json:"pending,omitempty"`
+}
+
+var (
+	disconnectedState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "immufs_disconnected",
+		Help: "1 while immudb is unreachable by the mount and its changes are journaled, 0 otherwise.",
+	})
+	disconnectedJournalBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "immufs_disconnected_journal_bytes",
+		Help: "Space taken by the changes journaled while disconnected, left to replay.",
+	})
+	disconnectedReplayed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "immufs_disconnected_replayed_changes_total",
+		Help: "Changes journaled while disconnected, replayed to immudb.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(disconnectedState, disconnectedJournalBytes, disconnectedReplayed)
+}
+
+// Errors of the immudb client and of the gRPC transport telling that immudb does not answer, which the driver only
+// reports as text.
+var unreachableErrors = []string{
+	"connection refused", "connection reset", "connection error", "no such host", "transport is closing", "Unavailable",
+}
+
+// unreachable tells whether err is due to immudb not answering, rather than to the operation itself.
+func unreachable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrTimeout) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	if s, ok := status.FromError(err); ok && s.Code() == codes.Unavailable {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	for _, text := range unreachableErrors {
+		if strings.Contains(err.Error(), text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Kinds of the changes journaled while disconnected.
+const (
+	changeInode    = "inode"
+	changeDelete   = "delete"
+	changeChildren = "children"
+	changeFile     = "file"
+	changeXattr    = "xattr"
+	changeRmXattr  = "rmxattr"
+)
+
+// offlineChange is a write journaled while disconnected, stored as a line of JSON in the journal file.
+type offlineChange struct {
+	Kind    string `json:"kind"`
+	Inumber int64  `json:"inumber"`
+	// Inode written with the change, if any, and its inline content (see InlineThreshold in the configuration).
+	Inode   *Inode `json:"inode,omitempty"`
+	Inlined bool   `json:"inlined,omitempty"`
+	Inline  []byte `json:"inline,omitempty"`
+	// Entries of a directory, content of a file, or name and value of an extended attribute.
+	Children []fuseutil.Dirent `json:"children,omitempty"`
+	Data     []byte            `json:"data,omitempty"`
+	Name     string            `json:"name,omitempty"`
+	// Set for the entries changed by UpdateChildren, with the entries removed and added: they are replayed on top
+	// of the entries the directory has by then, rather than overwriting them (see replayEntries).
+	Merge   bool              `json:"merge,omitempty"`
+	Removed []fuseutil.Dirent `json:"removed,omitempty"`
+	Added   []fuseutil.Dirent `json:"added,omitempty"`
+}
+
+// inodeChange returns a change writing a copy of inode.
+func inodeChange(kind string, inode *Inode) *offlineChange {
+	cp := *inode
+	c := &offlineChange{Kind: kind, Inumber: inode.Inumber, Inode: &cp}
+	if inode.inline != nil {
+		c.Inlined, c.Inline = true, inode.inline
+	}
+
+	return c
+}
+
+// offlineState is the result of the changes journaled: the last value written of each row, replayed at once.
+type offlineState struct {
+	inodes   map[int64]*Inode
+	deleted  map[int64]bool
+	children map[int64][]fuseutil.Dirent
+	contents map[int64][]byte
+	// Values of the extended attributes by inumber and name, nil for those removed.
+	xattrs map[int64]map[string][]byte
+	// Changes of the entries of the directories to merge, in order, unless the entries were written as a whole.
+	merges    map[int64][]*offlineChange
+	rewritten map[int64]bool
+}
+
+func newOfflineState() offlineState {
+	return offlineState{
+		inodes:    make(map[int64]*Inode),
+		deleted:   make(map[int64]bool),
+		children:  make(map[int64][]fuseutil.Dirent),
+		contents:  make(map[int64][]byte),
+		xattrs:    make(map[int64]map[string][]byte),
+		merges:    make(map[int64][]*offlineChange),
+		rewritten: make(map[int64]bool),
+	}
+}
+
+// apply folds a change into the state. The rows of a deleted inode written again afterwards are deleted first, then
+// written when replayed.
+func (s *offlineState) apply(c *offlineChange) {
+	if c.Inode != nil {
+		inode := *c.Inode
+		inode.inline = nil
+		if c.Inlined {
+			inode.inline = append([]byte{}, c.Inline...)
+		}
+		s.inodes[c.Inumber] = &inode
+	}
+
+	switch c.Kind {
+	case changeDelete:
+		delete(s.inodes, c.Inumber)
+		delete(s.children, c.Inumber)
+		delete(s.contents, c.Inumber)
+		delete(s.xattrs, c.Inumber)
+		delete(s.merges, c.Inumber)
+		delete(s.rewritten, c.Inumber)
+		s.deleted[c.Inumber] = true
+	case changeChildren:
+		s.children[c.Inumber] = c.Children
+		switch {
+		case !c.Merge:
+			s.rewritten[c.Inumber] = true
+			delete(s.merges, c.Inumber)
+		case !s.rewritten[c.Inumber]:
+			s.merges[c.Inumber] = append(s.merges[c.Inumber], c)
+		}
+	case changeFile:
+		s.contents[c.Inumber] = c.Data
+	case changeXattr, changeRmXattr:
+		if s.xattrs[c.Inumber] == nil {
+			s.xattrs[c.Inumber] = make(map[string][]byte)
+		}
+		var value []byte
+		if c.Kind == changeXattr {
+			value = append([]byte{}, c.Data...)
+		}
+		s.xattrs[c.Inumber][c.Name] = value
+	}
+}
+
+// changes tells whether the changes change the rows of an inode.
+func (s *offlineState) changes(inumber int64) bool {
+	_, inode := s.inodes[inumber]
+	_, children := s.children[inumber]
+	_, content := s.contents[inumber]
+	_, xattrs := s.xattrs[inumber]
+
+	return s.deleted[inumber] || inode || children || content || xattrs
+}
+
+// inumbers returns the inumbers changed.
+func (s *offlineState) inumbers() []int64 {
+	changed := make(map[int64]bool)
+	for inumber := range s.deleted {
+		changed[inumber] = true
+	}
+	for inumber := range s.inodes {
+		changed[inumber] = true
+	}
+	for inumber := range s.children {
+		changed[inumber] = true
+	}
+	for inumber := range s.contents {
+		changed[inumber] = true
+	}
+	for inumber := range s.xattrs {
+		changed[inumber] = true
+	}
+
+	inumbers := make([]int64, 0, len(changed))
+	for inumber := range changed {
+		inumbers = append(inumbers, inumber)
+	}
+	sort.Slice(inumbers, func(i, j int) bool { return inumbers[i] < inumbers[j] })
+
+	return inumbers
+}
+
+// replayChanges writes the changes journaled while disconnected within a single transaction, so that immudb gets
+// either all of them or none. The entries of the directories are merged with those changed meanwhile by other
+// mounts (see replayEntries), and the replay fails with ErrReplayConflict if they can't be; the other rows are
+// overwritten whatever their current value, as the writes made while connected do.
+func (idb *ImmuDbClient) replayChanges(ctx context.Context, s *offlineState) error {
+	return idb.inTx(ctx, idb.writeTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+		for inumber := range s.deleted {
+			for _, query := range []string{
+				"DELETE FROM inode WHERE inumber=?", "DELETE FROM content WHERE inumber=?", "DELETE FROM xattr WHERE inumber=?",
+			} {
+				if _, err := tx.ExecContext(ctx, query, inumber); err != nil {
+					return err
+				}
+			}
+			if err := idb.clearDeltas(ctx, tx, inumber); err != nil {
+				return err
+			}
+		}
+
+		// As written by WriteFile: the inode of a file written comes with its content.
+		for inumber, content := range s.contents {
+			if err := idb.clearDeltas(ctx, tx, inumber); err != nil {
+				return err
+			}
+			var err error
+			if inode := s.inodes[inumber]; inode != nil && inode.inline != nil {
+				_, err = tx.ExecContext(ctx, "DELETE FROM content WHERE inumber=?", inumber)
+			} else {
+				err = idb.upsertFileContent(ctx, tx, inumber, content)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		for inumber, children := range s.children {
+			if !s.rewritten[inumber] {
+				var err error
+				if children, err = replayEntries(ctx, tx, inumber, s.merges[inumber]); err != nil {
+					return err
+				}
+			}
+			content, err := marshalDirents(children)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, "UPSERT INTO content(inumber, content) VALUES(?, ?)", inumber, content); err != nil {
+				return err
+			}
+		}
+		for _, inode := range s.inodes {
+			if err := writeInode(ctx, tx, inode); err != nil {
+				return err
+			}
+		}
+		for inumber, xattrs := range s.xattrs {
+			for name, value := range xattrs {
+				var err error
+				if value == nil {
+					_, err = tx.ExecContext(ctx, "DELETE FROM xattr WHERE inumber=? AND name=?", inumber, name)
+				} else {
+					_, err = tx.ExecContext(ctx, "UPSERT INTO xattr(inumber, name, value) VALUES(?, ?, ?)", inumber, name, value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// replayEntries applies the changes of the entries of a directory journaled while disconnected on top of its current
+// entries, as UpdateChildren would have, and returns the entries to write. The entries removed meanwhile, or added
+// meanwhile to the same inode, are left as they are; should a name removed or added point to another inode by then,
+// ErrReplayConflict is returned.
+func replayEntries(ctx context.Context, tx *sql.Tx, parent int64, changes []*offlineChange) ([]fuseutil.Dirent, error) {
+	var content []byte
+	err := tx.QueryRowContext(ctx, "SELECT content FROM content WHERE inumber=?", parent).Scan(&content)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: directory %d removed", ErrReplayConflict, parent)
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries, err := unmarshalDirents(content)
+	if err != nil {
+		return nil, err
+	}
+
+	index := func(name string) int {
+		for i, e := range entries {
+			if e.Type != fuseutil.DT_Unknown && e.Name == name {
+				return i
+			}
+		}
+
+		return -1
+	}
+	for _, c := range changes {
+		for _, e := range c.Removed {
+			i := index(e.Name)
+			if i < 0 {
+				continue
+			}
+			if entries[i].Inode != e.Inode {
+				return nil, fmt.Errorf("%w: %q in directory %d", ErrReplayConflict, e.Name, parent)
+			}
+			entries[i] = fuseutil.Dirent{Type: fuseutil.DT_Unknown, Offset: fuseops.DirOffset(i + 1)}
+		}
+		for _, e := range c.Added {
+			if i := index(e.Name); i >= 0 {
+				if entries[i].Inode == e.Inode {
+					continue
+				}
+
+				return nil, fmt.Errorf("%w: %q in directory %d", ErrReplayConflict, e.Name, parent)
+			}
+			// In the first unused entry, or at the end, as AddChild does.
+			i := 0
+			for i < len(entries) && entries[i].Type != fuseutil.DT_Unknown {
+				i++
+			}
+			e.Offset = fuseops.DirOffset(i + 1)
+			if i == len(entries) {
+				entries = append(entries, e)
+			} else {
+				entries[i] = e
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+// disconnectedBackend keeps the mount usable while immudb is unreachable: the reads are served from the changes
+// left to replay and from the cache it wraps, and the writes are journaled to a file, within a budget, and replayed
+// once immudb answers again. While changes are left to replay, the writes are journaled even if immudb answers, so
+// that they reach it in order. The journal left by a mount is replayed by the next one.
+type disconnectedBackend struct {
+	Backend
+	idb   *ImmuDbClient
+	cache *cachedBackend
+	log   *logrus.Entry
+
+	// Server probed while disconnected, and interval between the probes.
+	opts     *client.Options
+	timeout  time.Duration
+	interval time.Duration
+
+	// Held by the writes and the replays, so that the changes are journaled and replayed in order.
+	mu sync.Mutex
+	// GUARDED_BY(mu)
+	journal *os.File
+	// GUARDED_BY(mu)
+	state offlineState
+	// GUARDED_BY(mu)
+	stats DisconnectedStats
+	// Error of the last replay, if in conflict: the writes are no longer journaled, and those of the files changed
+	// by the changes left are refused.
+	//
+	// GUARDED_BY(mu)
+	conflict error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newDisconnectedBackend wraps backend, the cache of the mount, to journal the changes to the file of the
+// configuration while immudb is unreachable, loading those left by a former mount.
+func newDisconnectedBackend(backend *cachedBackend, idb *ImmuDbClient, cfg *config.Config, log *logrus.Entry) (*disconnectedBackend, error) {
+	if cfg.DisconnectedJournalSize <= 0 {
+		return nil, fmt.Errorf("invalid disconnected journal size %d", cfg.DisconnectedJournalSize)
+	}
+	if cfg.ReconnectInterval <= 0 {
+		return nil, errors.New("the interval between the attempts to reconnect must be positive")
+	}
+	// The replay would commit the contents apart from the rest of the changes.
+	if idb.contentApart {
+		return nil, errors.New("not supported with a separate content database")
+	}
+	journal, err := os.OpenFile(cfg.DisconnectedJournal, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	d := &disconnectedBackend{
+		Backend:  backend,
+		idb:      idb,
+		cache:    backend,
+		log:      log.WithField("component", "disconnected mode"),
+		opts:     clientOptions(cfg),
+		timeout:  cfg.MetadataTimeout,
+		interval: cfg.ReconnectInterval,
+		journal:  journal,
+		state:    newOfflineState(),
+		stats:    DisconnectedStats{Budget: cfg.DisconnectedJournalSize << 20},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	disconnectedState.Set(0)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.loadLocked(); err != nil {
+		journal.Close()
+
+		return nil, fmt.Errorf("%s: %w", cfg.DisconnectedJournal, err)
+	}
+	if d.stats.Changes > 0 {
+		d.log.Warnf("%d changes left to replay by a former mount", d.stats.Changes)
+	}
+
+	return d, nil
+}
+
+// loadLocked reads the changes journaled by a former mount, dropping the last one if not completely written.
+//
+// LOCKS_REQUIRED(d.mu)
+func (d *disconnectedBackend) loadLocked() error {
+	r := bufio.NewReader(d.journal)
+	var size int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				d.log.Warnf("dropping the last change, not completely written")
+			}
+
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var c offlineChange
+		if err := json.Unmarshal(line, &c); err != nil {
+			return fmt.Errorf("invalid change at offset %d: %w", size, err)
+		}
+		d.state.apply(&c)
+		size += int64(len(line))
+		d.stats.Changes++
+	}
+	d.stats.Bytes = size
+	disconnectedJournalBytes.Set(float64(size))
+
+	return d.journal.Truncate(size)
+}
+
+// Start runs the attempts to reconnect in background.
+func (d *disconnectedBackend) Start() {
+	go d.run()
+}
+
+func (d *disconnectedBackend) run() {
+	defer close(d.done)
+
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-t.C:
+			d.reconnect(context.Background())
+		}
+	}
+}
+
+// reconnect replays the changes left once immudb answers again. Errors are only logged: the changes are replayed
+// at the next attempt. A conflict ends the disconnection all the same, as the writes are no longer journaled.
+func (d *disconnectedBackend) reconnect(ctx context.Context) {
+	d.mu.Lock()
+	pending := d.stats.Changes > 0 || d.stats.Disconnected
+	d.mu.Unlock()
+	if !pending {
+		return
+	}
+	if err := pingImmudb(ctx, d.opts, d.timeout); err != nil {
+		d.log.Debugf("immudb still unreachable: %s", err)
+
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.replayLocked(ctx); err != nil && !errors.Is(err, ErrReplayConflict) {
+		d.log.Errorf("could not replay %d changes: %s", d.stats.Changes, err)
+
+		return
+	}
+	if d.stats.Disconnected {
+		d.log.Infof("immudb reachable again after %s", time.Since(*d.stats.Since).Round(time.Second))
+	}
+	d.stats.Disconnected, d.stats.Since = false, nil
+	disconnectedState.Set(0)
+}
+
+// replayLocked writes the changes left to immudb, and empties the journal.
+//
+// LOCKS_REQUIRED(d.mu)
+func (d *disconnectedBackend) replayLocked(ctx context.Context) error {
+	if d.stats.Changes == 0 {
+		return nil
+	}
+	err := d.idb.replayChanges(ctx, &d.state)
+	if errors.Is(err, ErrReplayConflict) {
+		if d.conflict == nil {
+			d.log.Errorf("could not replay %d changes, the writes of the files they change are refused until they are "+
+				"replayed or dropped (see the journal control commands): %s", d.stats.Changes, err)
+		}
+		d.conflict = err
+	}
+	if err != nil {
+		return err
+	}
+	d.log.Infof("replayed %d changes of %d inodes", d.stats.Changes, len(d.state.inumbers()))
+	d.stats.Replayed += d.stats.Changes
+	disconnectedReplayed.Add(float64(d.stats.Changes))
+
+	return d.clearLocked()
+}
+
+// clearLocked empties the journal, once replayed or dropped.
+//
+// LOCKS_REQUIRED(d.mu)
+func (d *disconnectedBackend) clearLocked() error {
+	// Replaying them again would only write the same rows again.
+	if err := d.journal.Truncate(0); err != nil {
+		return err
+	}
+
+	inumbers := d.state.inumbers()
+	d.stats.Changes, d.stats.Bytes = 0, 0
+	disconnectedJournalBytes.Set(0)
+	d.state = newOfflineState()
+	d.conflict = nil
+	// The cache may hold the rows as read before the changes, or as changed by them if dropped.
+	d.cache.invalidate(inumbers)
+
+	return nil
+}
+
+// Replay replays the changes left right away, rather than at the next attempt to reconnect.
+func (d *disconnectedBackend) Replay(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.replayLocked(ctx)
+}
+
+// Drop discards the changes left to replay, e.g. once in conflict with those of other mounts.
+func (d *disconnectedBackend) Drop() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dropped := d.stats.Changes
+	if err := d.clearLocked(); err != nil {
+		return err
+	}
+	d.log.Warnf("dropped %d changes left to replay", dropped)
+
+	return nil
+}
+
+// queuingLocked tells whether the writes are journaled: while immudb is unreachable, and until the changes left are
+// replayed, unless they conflict with those of other mounts.
+//
+// LOCKS_REQUIRED(d.mu)
+func (d *disconnectedBackend) queuingLocked() bool {
+	return d.conflict == nil && (d.stats.Disconnected || d.stats.Changes > 0)
+}
+
+// conflictLocked returns the conflict of the replay if the changes left change the given file.
+//
+// LOCKS_REQUIRED(d.mu)
+func (d *disconnectedBackend) conflictLocked(inumber int64) error {
+	if d.conflict == nil {
+		return nil
+	}
+	if d.state.changes(inumber) {
+		return fmt.Errorf("file %d: %w", inumber, d.conflict)
+	}
+
+	return nil
+}
+
+// check switches to the disconnected mode if err tells that immudb is unreachable.
+func (d *disconnectedBackend) check(err error) {
+	if !unreachable(err) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.disconnectLocked(err)
+}
+
+// LOCKS_REQUIRED(d.mu)
+func (d *disconnectedBackend) disconnectLocked(err error) {
+	// In conflict, the writes are no longer journaled: the operations fail as without the journal.
+	if d.stats.Disconnected || d.conflict != nil {
+		return
+	}
+	now := time.Now()
+	d.stats.Disconnected, d.stats.Since = true, &now
+	d.stats.Disconnections++
+	disconnectedState.Set(1)
+	d.log.Warnf("immudb unreachable, journaling the changes until it answers again: %s", err)
+}
+
+// write applies a change of a file to the wrapped backend with apply while immudb answers and no change is left to
+// replay. Otherwise, or should immudb turn out to be unreachable, the change returned by change is journaled instead.
+// In conflict, the changes of the files changed by the changes left are refused, the others applied as is.
+func (d *disconnectedBackend) write(inumber int64, apply func() error, change func() (*offlineChange, error)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.conflictLocked(inumber); err != nil {
+		return err
+	}
+	if !d.queuingLocked() {
+		err := apply()
+		if !unreachable(err) || d.conflict != nil {
+			return err
+		}
+		d.disconnectLocked(err)
+	}
+	c, err := change()
+	if err != nil {
+		return err
+	}
+
+	return d.journalLocked(c)
+}
+
+// journalLocked appends a change to the journal, synced before the change is applied to the state.
+//
+// LOCKS_REQUIRED(d.mu)
+func (d *disconnectedBackend) journalLocked(c *offlineChange) error {
+	line, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if d.stats.Bytes+int64(len(line)) > d.stats.Budget {
+		d.stats.Refused++
+
+		return ErrJournalFull
+	}
+
+	if _, err = d.journal.Write(line); err == nil {
+		err = d.journal.Sync()
+	}
+	if err != nil {
+		// A partial line would hide the changes journaled after it.
+		d.journal.Truncate(d.stats.Bytes)
+
+		return fmt.Errorf("disconnected journal: %w", err)
+	}
+	d.stats.Bytes += int64(len(line))
+	d.stats.Changes++
+	disconnectedJournalBytes.Set(float64(d.stats.Bytes))
+	d.state.apply(c)
+
+	return nil
+}
+
+// status returns a copy of the statistics of the disconnected mode.
+func (d *disconnectedBackend) status() *DisconnectedStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := d.stats
+	if d.conflict != nil {
+		stats.Conflict = d.conflict.Error()
+		stats.Pending = d.state.inumbers()
+	}
+
+	return &stats
+}
+
+// bind returns a copy of an inode bound to the backend, for the operation of ctx.
+func (d *disconnectedBackend) bind(ctx context.Context, inode *Inode) *Inode {
+	cp := *inode
+	cp.cl = d
+	cp.ctx = detach(ctx)
+	if inode.inline != nil {
+		cp.inline = append([]byte{}, inode.inline...)
+	}
+
+	return &cp
+}
+
+func (d *disconnectedBackend) GetInode(ctx context.Context, inumber int64) (*Inode, error) {
+	d.mu.Lock()
+	changed, deleted := d.state.inodes[inumber], d.state.deleted[inumber]
+	d.mu.Unlock()
+	switch {
+	case changed != nil:
+		return d.bind(ctx, changed), nil
+	case deleted:
+		return nil, ErrInodeNotFound
+	}
+
+	inode, err := d.Backend.GetInode(ctx, inumber)
+	if err != nil {
+		d.check(err)
+
+		return nil, err
+	}
+	inode.cl = d
+
+	return inode, nil
+}
+
+func (d *disconnectedBackend) WriteInode(ctx context.Context, inode *Inode) error {
+	return d.write(inode.Inumber, func() error {
+		return d.Backend.WriteInode(ctx, inode)
+	}, func() (*offlineChange, error) {
+		return inodeChange(changeInode, inode), nil
+	})
+}
+
+func (d *disconnectedBackend) DeleteInode(ctx context.Context, inumber int64) error {
+	return d.write(inumber, func() error {
+		return d.Backend.DeleteInode(ctx, inumber)
+	}, func() (*offlineChange, error) {
+		return &offlineChange{Kind: changeDelete, Inumber: inumber}, nil
+	})
+}
+
+// The inumbers can't be allocated while disconnected, unless reserved beforehand (see InumberBatch in the
+// configuration).
+func (d *disconnectedBackend) AllocateInumber(ctx context.Context) (int64, int64, error) {
+	inumber, generation, err := d.Backend.AllocateInumber(ctx)
+	d.check(err)
+
+	return inumber, generation, err
+}
+
+func (d *disconnectedBackend) NextInumber(ctx context.Context) (int64, error) {
+	next, err := d.Backend.NextInumber(ctx)
+	d.check(err)
+
+	return next, err
+}
+
+func (d *disconnectedBackend) GetChildren(ctx context.Context, parent int64) ([]fuseutil.Dirent, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.childrenLocked(ctx, parent)
+}
+
+// LOCKS_REQUIRED(d.mu)
+func (d *disconnectedBackend) childrenLocked(ctx context.Context, parent int64) ([]fuseutil.Dirent, error) {
+	if children, ok := d.state.children[parent]; ok {
+		return append([]fuseutil.Dirent(nil), children...), nil
+	}
+
+	children, err := d.Backend.GetChildren(ctx, parent)
+	if unreachable(err) {
+		d.disconnectLocked(err)
+	}
+
+	return children, err
+}
+
+func (d *disconnectedBackend) GetChildrenPage(ctx context.Context, parent int64, after fuseops.DirOffset, limit int) ([]fuseutil.Dirent, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if children, ok := d.state.children[parent]; ok {
+		return pageOf(children, after, limit), nil
+	}
+
+	page, err := getChildrenPage(ctx, d.Backend, parent, after, limit)
+	if unreachable(err) {
+		d.disconnectLocked(err)
+	}
+
+	return page, err
+}
+
+func (d *disconnectedBackend) WriteChildren(ctx context.Context, parent int64, children []fuseutil.Dirent) error {
+	return d.write(parent, func() error {
+		return d.Backend.WriteChildren(ctx, parent, children)
+	}, func() (*offlineChange, error) {
+		return &offlineChange{Kind: changeChildren, Inumber: parent, Children: append([]fuseutil.Dirent(nil), children...)}, nil
+	})
+}
+
+func (d *disconnectedBackend) UpdateChildren(ctx context.Context, parent *Inode, update func([]fuseutil.Dirent) ([]fuseutil.Dirent, error)) error {
+	return d.write(parent.Inumber, func() error {
+		return d.Backend.UpdateChildren(ctx, parent, update)
+	}, func() (*offlineChange, error) {
+		before, err := d.childrenLocked(ctx, parent.Inumber)
+		if err != nil {
+			return nil, err
+		}
+		// update may change the entries in place.
+		children, err := update(append([]fuseutil.Dirent(nil), before...))
+		if err != nil {
+			return nil, err
+		}
+		c := inodeChange(changeChildren, parent)
+		c.Children = children
+		c.Merge = true
+		c.Removed, c.Added = diffEntries(before, children)
+
+		return c, nil
+	})
+}
+
+func (d *disconnectedBackend) ReadContent(ctx context.Context, inumber int64) ([]byte, error) {
+	d.mu.Lock()
+	content, ok := d.state.contents[inumber]
+	d.mu.Unlock()
+	if ok {
+		return append([]byte(nil), content...), nil
+	}
+
+	content, err := d.Backend.ReadContent(ctx, inumber)
+	d.check(err)
+
+	return content, err
+}
+
+func (d *disconnectedBackend) WriteFile(ctx context.Context, inode *Inode, data []byte) error {
+	return d.write(inode.Inumber, func() error {
+		return d.Backend.WriteFile(ctx, inode, data)
+	}, func() (*offlineChange, error) {
+		// As written by ImmuDbClient.WriteFile once replayed.
+		inode.Size = int64(len(data))
+		inode.inline = nil
+		if d.idb.inlines(data) {
+			inode.inline = append([]byte{}, data...)
+		}
+		c := inodeChange(changeFile, inode)
+		c.Data = append([]byte{}, data...)
+
+		return c, nil
+	})
+}
+
+func (d *disconnectedBackend) GetXattr(ctx context.Context, inumber int64, name string) ([]byte, error) {
+	d.mu.Lock()
+	value, ok := d.state.xattrs[inumber][name]
+	d.mu.Unlock()
+	if ok {
+		if value == nil {
+			return nil, ErrXattrNotFound
+		}
+
+		return append([]byte(nil), value...), nil
+	}
+
+	value, err := d.Backend.GetXattr(ctx, inumber, name)
+	d.check(err)
+
+	return value, err
+}
+
+func (d *disconnectedBackend) ListXattrs(ctx context.Context, inumber int64) ([]string, error) {
+	names, err := d.Backend.ListXattrs(ctx, inumber)
+	if err != nil {
+		d.check(err)
+
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	changed := d.state.xattrs[inumber]
+	if len(changed) == 0 {
+		return names, nil
+	}
+	var listed []string
+	for _, name := range names {
+		if value, ok := changed[name]; !ok || value != nil {
+			listed = append(listed, name)
+		}
+	}
+	var added []string
+	for name, value := range changed {
+		if value != nil && !containsString(names, name) {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+
+	return append(listed, added...), nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *disconnectedBackend) SetXattr(ctx context.Context, inumber int64, name string, value []byte) error {
+	return d.write(inumber, func() error {
+		return d.Backend.SetXattr(ctx, inumber, name, value)
+	}, func() (*offlineChange, error) {
+		return &offlineChange{Kind: changeXattr, Inumber: inumber, Name: name, Data: append([]byte{}, value...)}, nil
+	})
+}
+
+func (d *disconnectedBackend) RemoveXattr(ctx context.Context, inumber int64, name string) error {
+	return d.write(inumber, func() error {
+		return d.Backend.RemoveXattr(ctx, inumber, name)
+	}, func() (*offlineChange, error) {
+		return &offlineChange{Kind: changeRmXattr, Inumber: inumber, Name: name}, nil
+	})
+}
+
+// writeDelta forwards the journaled writes to the wrapped backend while immudb answers: otherwise, the whole
+// content is written, and journaled, instead.
+func (d *disconnectedBackend) writeDelta(ctx context.Context, inode *Inode, off int64, data []byte) (bool, error) {
+	w, ok := d.Backend.(deltaWriter)
+	if !ok {
+		return false, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// In conflict, write refuses the changes of the files changed by the changes left.
+	if d.queuingLocked() || d.conflictLocked(inode.Inumber) != nil {
+		return false, nil
+	}
+	journaled, err := w.writeDelta(ctx, inode, off, data)
+	if unreachable(err) && d.conflict == nil {
+		d.disconnectLocked(err)
+
+		return false, nil
+	}
+
+	return journaled, err
+}
+
+// checkSize forwards the checks of the sizes to the wrapped backend, if it makes them.
+func (d *disconnectedBackend) checkSize(inode *Inode, content []byte) {
+	if checker, ok := d.Backend.(sizeChecker); ok {
+		checker.checkSize(inode, content)
+	}
+}
+
+// Destroy stops the attempts to reconnect, and replays the changes left a last time: those which can't be are
+// replayed by the next mount.
+func (d *disconnectedBackend) Destroy(ctx context.Context) error {
+	close(d.stop)
+	<-d.done
+
+	d.mu.Lock()
+	if err := d.replayLocked(ctx); err != nil {
+		d.log.Warnf("%d changes left to replay by the next mount: %s", d.stats.Changes, err)
+	}
+	d.journal.Close()
+	d.mu.Unlock()
+
+	return d.Backend.Destroy(ctx)
+}
@@ -0,0 +1,308 @@
+package fs
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"immufs/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// Prefix of the files being written to the disk cache, renamed once complete.
+const diskCacheTempPrefix = ".tmp-"
+
+// DiskCacheStats describes the disk cache of a mount (see DiskCache in the configuration).
+type DiskCacheStats struct {
+	// Disk budget of the cache, and the space taken by its files, in bytes.
+	Budget int64 `json:"budget"`
+	Bytes  int64 `json:"bytes"`
+	Files  int64 `json:"files"`
+	// Contents read from the cache, and those read from immudb.
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Files evicted to stay within the budget, and those dropped because they no longer matched their name.
+	Evictions int64 `json:"evictions"`
+	Corrupted int64 `json:"corrupted"`
+}
+
+var (
+	diskCacheBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "immufs_disk_cache_bytes",
+		Help: "Space taken by the files of the disk cache of the mount.",
+	})
+	diskCacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "immufs_disk_cache_lookups_total",
+		Help: "Lookups of the disk cache of the mount, by result (hit or miss).",
+	}, []string{"result"})
+	diskCacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "immufs_disk_cache_evictions_total",
+		Help: "Files evicted from the disk cache of the mount to stay within its budget.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(diskCacheBytes, diskCacheLookups, diskCacheEvictions)
+}
+
+type diskCacheEntry struct {
+	checksum string
+	size     int64
+}
+
+// diskCache keeps the contents read from immudb in files under a directory, surviving the mount, within a disk
+// budget: the least recently used files are evicted first, as told by their modification time, touched when read.
+// The files are named after the checksum (SHA-256) of their content, which immudb stores with every revision of a
+// content: a content is looked up by the checksum read from immudb, and checked against it when read, so that a
+// file never serves a stale or damaged content. The same content is kept once, whatever the files, revisions or
+// databases it belongs to.
+type diskCache struct {
+	dir    string
+	budget int64
+	log    *logrus.Entry
+
+	mu sync.Mutex
+	// Files by checksum, in the order of their last use, most recent first.
+	//
+	// GUARDED_BY(mu)
+	entries map[string]*list.Element
+	// GUARDED_BY(mu)
+	lru *list.List
+	// GUARDED_BY(mu)
+	stats DiskCacheStats
+}
+
+// newDiskCache opens the disk cache under dir, created if needed, and indexes the files left by the former mounts,
+// evicting those beyond the budget.
+func newDiskCache(dir string, budget int64, log *logrus.Entry) (*diskCache, error) {
+	if budget <= 0 {
+		return nil, fmt.Errorf("invalid disk cache size %d", budget)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	c := &diskCache{
+		dir:     dir,
+		budget:  budget,
+		log:     log.WithField("component", "disk cache"),
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		stats:   DiskCacheStats{Budget: budget},
+	}
+
+	type found struct {
+		diskCacheEntry
+		used time.Time
+	}
+	var files []found
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		// Left by a mount stopped while writing.
+		if strings.HasPrefix(d.Name(), diskCacheTempPrefix) {
+			return os.Remove(path)
+		}
+		if path != c.path(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, found{diskCacheEntry{d.Name(), info.Size()}, info.ModTime()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Oldest first, so that the most recently used end up at the front.
+	sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range files {
+		c.addLocked(f.diskCacheEntry)
+	}
+	c.log.Infof("%d contents (%d bytes) cached in %s", c.stats.Files, c.stats.Bytes, dir)
+
+	return c, nil
+}
+
+// path returns the path of the file of a checksum, spread over subdirectories so that none gets too big.
+func (c *diskCache) path(checksum string) string {
+	if len(checksum) < 2 {
+		return filepath.Join(c.dir, checksum)
+	}
+
+	return filepath.Join(c.dir, checksum[:2], checksum)
+}
+
+// get returns the content with the given checksum, if cached.
+func (c *diskCache) get(checksum string) ([]byte, bool) {
+	c.mu.Lock()
+	elem, ok := c.entries[checksum]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+
+	if ok {
+		path := c.path(checksum)
+		content, err := os.ReadFile(path)
+		if err == nil && contentChecksum(content) == checksum {
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			c.count(true)
+
+			return content, true
+		}
+		c.log.Warnf("dropping cached content %s: %v", checksum, errOrMismatch(err))
+		c.drop(checksum)
+	}
+	c.count(false)
+
+	return nil, false
+}
+
+// put caches a content with its checksum. Contents bigger than the whole budget are not cached, and failures are
+// only logged: the content is read from immudb again.
+func (c *diskCache) put(checksum string, content []byte) {
+	size := int64(len(content))
+	c.mu.Lock()
+	_, ok := c.entries[checksum]
+	c.mu.Unlock()
+	if ok || size > c.budget {
+		return
+	}
+
+	if err := c.write(checksum, content); err != nil {
+		c.log.Warnf("could not cache content %s: %s", checksum, err)
+
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[checksum]; !ok {
+		c.addLocked(diskCacheEntry{checksum, size})
+	}
+}
+
+// write writes the file of a content.
+func (c *diskCache) write(checksum string, content []byte) error {
+	return writeFileAtomic(c.path(checksum), content, false)
+}
+
+// writeFileAtomic writes a file, renamed into place once complete, and synced if asked, so that it is never read
+// partially.
+func writeFileAtomic(path string, content []byte, sync bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), diskCacheTempPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if err == nil && sync {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+
+	return err
+}
+
+// LOCKS_REQUIRED(c.mu)
+func (c *diskCache) addLocked(e diskCacheEntry) {
+	if e.size > c.budget {
+		os.Remove(c.path(e.checksum))
+
+		return
+	}
+	c.entries[e.checksum] = c.lru.PushFront(&e)
+	c.stats.Bytes += e.size
+	c.stats.Files++
+
+	for c.stats.Bytes > c.budget {
+		victim := c.lru.Back().Value.(*diskCacheEntry)
+		c.removeLocked(victim.checksum)
+		if err := os.Remove(c.path(victim.checksum)); err != nil && !os.IsNotExist(err) {
+			c.log.Warnf("could not evict cached content %s: %s", victim.checksum, err)
+		}
+		c.stats.Evictions++
+		diskCacheEvictions.Inc()
+	}
+	diskCacheBytes.Set(float64(c.stats.Bytes))
+}
+
+// drop removes the file of a checksum which could not be read back, or no longer matched its name.
+func (c *diskCache) drop(checksum string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeLocked(checksum)
+	c.stats.Corrupted++
+	os.Remove(c.path(checksum))
+	diskCacheBytes.Set(float64(c.stats.Bytes))
+}
+
+// LOCKS_REQUIRED(c.mu)
+func (c *diskCache) removeLocked(checksum string) {
+	elem, ok := c.entries[checksum]
+	if !ok {
+		return
+	}
+	e := c.lru.Remove(elem).(*diskCacheEntry)
+	delete(c.entries, checksum)
+	c.stats.Bytes -= e.size
+	c.stats.Files--
+}
+
+func (c *diskCache) count(hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if hit {
+		c.stats.Hits++
+		diskCacheLookups.WithLabelValues("hit").Inc()
+	} else {
+		c.stats.Misses++
+		diskCacheLookups.WithLabelValues("miss").Inc()
+	}
+}
+
+// status returns a copy of the statistics of the cache.
+func (c *diskCache) status() *DiskCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+
+	return &stats
+}
+
+// errOrMismatch describes why a cached file could not be used: the error reading it, or else a checksum mismatch.
+func errOrMismatch(err error) error {
+	if err != nil {
+		return err
+	}
+
+	return ErrChecksumMismatch
+}
@@ -0,0 +1,388 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Event describes a change to the filesystem, as published to the configured sink.
+// Inodes do not know their path: names are given together with the inumber of their directory.
+type Event struct {
+	Type      string    `json:"type"` // create, mkdir, write, truncate, rename, unlink or rmdir
+	Inumber   int64     `json:"inumber"`
+	Parent    int64     `json:"parent,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	NewParent int64     `json:"new_parent,omitempty"`
+	NewName   string    `json:"new_name,omitempty"`
+	Tx        uint64    `json:"tx"` // Last transaction committed once the change completed
+	Time      time.Time `json:"time"`
+}
+
+// EventSink delivers events to a downstream system.
+type EventSink interface {
+	Publish(ctx context.Context, ev *Event) error
+	Close() error
+}
+
+// NewEventSink returns the sink for the given URL:
+//   - http:// and https:// URLs receive a POST request per event, with a JSON body;
+//   - file:// URLs get an event per line, in JSON format;
+//   - nats:// URLs publish each event to a NATS subject, given by the path (immufs.events by default).
+func NewEventSink(sinkURL string) (EventSink, error) {
+	u, err := url.Parse(sinkURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &webhookSink{url: sinkURL, client: &http.Client{Timeout: 10 * time.Second}}, nil
+	case "file":
+		f, err := os.OpenFile(u.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		return &fileSink{f: f, enc: json.NewEncoder(f)}, nil
+	case "nats":
+		subject := strings.TrimPrefix(u.Path, "/")
+		if subject == "" {
+			subject = defaultNatsSubject
+		}
+		if strings.ContainsAny(subject, " \t\r\n") {
+			return nil, fmt.Errorf("invalid NATS subject %q", subject)
+		}
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "4222")
+		}
+
+		return &natsSink{addr: addr, user: u.User, subject: subject}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported event sink: %s", sinkURL)
+}
+
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) Publish(ctx context.Context, ev *Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("webhook replied %s", res.Status)
+	}
+
+	return nil
+}
+
+func (s *webhookSink) Close() error {
+	return nil
+}
+
+type fileSink struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+func (s *fileSink) Publish(ctx context.Context, ev *Event) error {
+	return s.enc.Encode(ev)
+}
+
+func (s *fileSink) Close() error {
+	return s.f.Close()
+}
+
+// Subject the events are published to, unless the URL of a NATS sink has a path.
+const defaultNatsSubject = "immufs.events"
+
+// Time allowed to connect to a NATS server and to publish an event, unless the context has a deadline.
+const natsTimeout = 10 * time.Second
+
+// natsSink publishes the events to a NATS subject, speaking the client protocol of NATS: each publication is followed
+// by a PING, whose PONG tells that the server processed it. The connection is made on the first event, and made
+// again once broken.
+type natsSink struct {
+	addr    string
+	user    *url.Userinfo
+	subject string
+
+	// Connection to the server, nil until connected. Only used by the publisher.
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// natsConnect is the CONNECT message of the protocol.
+type natsConnect struct {
+	Verbose   bool   `json:"verbose"`
+	Pedantic  bool   `json:"pedantic"`
+	Name      string `json:"name"`
+	Lang      string `json:"lang"`
+	Version   string `json:"version"`
+	User      string `json:"user,omitempty"`
+	Pass      string `json:"pass,omitempty"`
+	AuthToken string `json:"auth_token,omitempty"`
+}
+
+func (s *natsSink) Publish(ctx context.Context, ev *Event) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	// A connection broken while idle is only noticed once used: try again on a new one.
+	connected := s.conn != nil
+	err = s.publish(ctx, body)
+	if err != nil && connected {
+		err = s.publish(ctx, body)
+	}
+
+	return err
+}
+
+// publish sends a PUB message, followed by a PING, and waits for the PONG. The connection is closed on error.
+func (s *natsSink) publish(ctx context.Context, body []byte) error {
+	if s.conn == nil {
+		if err := s.connect(ctx); err != nil {
+			return err
+		}
+	}
+	s.conn.SetDeadline(natsDeadline(ctx))
+
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\nPING\r\n", s.subject, len(body), body)
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		s.close()
+
+		return err
+	}
+	if err := s.waitPong(); err != nil {
+		s.close()
+
+		return err
+	}
+
+	return nil
+}
+
+// connect reads the INFO message of the server, and answers with a CONNECT message and a PING, whose PONG tells
+// that the server accepted the connection.
+func (s *natsSink) connect(ctx context.Context) error {
+	var d net.Dialer
+	ctx, cancel := context.WithDeadline(ctx, natsDeadline(ctx))
+	defer cancel()
+
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	s.conn, s.r = conn, bufio.NewReader(conn)
+
+	line, err := s.readLine()
+	if err != nil {
+		s.close()
+
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		s.close()
+
+		return fmt.Errorf("unexpected NATS greeting %q", line)
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+		s.close()
+
+		return fmt.Errorf("invalid NATS INFO message: %w", err)
+	}
+	if info.TLSRequired {
+		s.close()
+
+		return errors.New("the NATS server requires TLS, which is not supported")
+	}
+
+	connect := natsConnect{Name: "immufs", Lang: "go", Version: "1.0.0"}
+	// A user without password is a token.
+	if s.user != nil {
+		if pass, ok := s.user.Password(); ok {
+			connect.User, connect.Pass = s.user.Username(), pass
+		} else {
+			connect.AuthToken = s.user.Username()
+		}
+	}
+	body, err := json.Marshal(connect)
+	if err != nil {
+		s.close()
+
+		return err
+	}
+	if _, err := s.conn.Write([]byte(fmt.Sprintf("CONNECT %s\r\nPING\r\n", body))); err != nil {
+		s.close()
+
+		return err
+	}
+	if err := s.waitPong(); err != nil {
+		s.close()
+
+		return err
+	}
+
+	return nil
+}
+
+// waitPong reads the messages of the server until a PONG, answering its PINGs. -ERR messages are returned as
+// errors.
+func (s *natsSink) waitPong() error {
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS server error: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+		// +OK and INFO updates are ignored.
+	}
+}
+
+func (s *natsSink) readLine() (string, error) {
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (s *natsSink) close() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn, s.r = nil, nil
+	}
+}
+
+func (s *natsSink) Close() error {
+	s.close()
+
+	return nil
+}
+
+// natsDeadline returns the deadline of ctx, or natsTimeout from now if it has none.
+func natsDeadline(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+
+	return time.Now().Add(natsTimeout)
+}
+
+// Events waiting to be delivered. Further events are dropped, so that a slow sink never blocks the filesystem.
+const eventQueueSize = 1024
+
+// eventPublisher delivers events to a sink in the background.
+type eventPublisher struct {
+	sink  EventSink
+	log   *logrus.Entry
+	queue chan *Event
+	done  chan struct{}
+}
+
+func newEventPublisher(sink EventSink, log *logrus.Entry) *eventPublisher {
+	p := &eventPublisher{
+		sink:  sink,
+		log:   log.WithField("component", "events"),
+		queue: make(chan *Event, eventQueueSize),
+		done:  make(chan struct{}),
+	}
+	go p.run()
+
+	return p
+}
+
+func (p *eventPublisher) run() {
+	defer close(p.done)
+
+	for ev := range p.queue {
+		if err := p.sink.Publish(context.Background(), ev); err != nil {
+			p.log.Errorf("could not publish event %+v: %s", *ev, err)
+		}
+	}
+}
+
+// Publish queues an event, without blocking.
+func (p *eventPublisher) Publish(ev *Event) {
+	select {
+	case p.queue <- ev:
+	default:
+		p.log.Warningf("event queue full, dropping event %+v", *ev)
+	}
+}
+
+// Backlog returns the number of events waiting to be delivered.
+func (p *eventPublisher) Backlog() int {
+	return len(p.queue)
+}
+
+// Stop delivers the queued events, then closes the sink.
+func (p *eventPublisher) Stop() {
+	close(p.queue)
+	<-p.done
+
+	if err := p.sink.Close(); err != nil {
+		p.log.Errorf("could not close event sink: %s", err)
+	}
+}
+
+// publish completes an event and queues it, if events are enabled.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) publish(ev Event) {
+	if fs.events == nil {
+		return
+	}
+
+	ev.Time = time.Now()
+	txID, err := fs.backend.CurrentTx(context.TODO())
+	if err != nil {
+		fs.log.Warningf("could not get the transaction of event %+v: %s", ev, err)
+	}
+	ev.Tx = txID
+	fs.events.Publish(&ev)
+}
@@ -0,0 +1,422 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+var errWriteAtInAppendMode = errors.New("WriteAt in append mode")
+
+// File is a file or a directory opened through a session. It implements io.Reader, io.ReaderAt,
+// io.Writer, io.WriterAt and io.Seeker. Its methods are not bound to a context: the operations
+// they serve are only bounded by the immudb timeouts.
+//
+// As the kernel would, the session keeps the inode of an open file looked up until it is closed.
+type File struct {
+	s      *Session
+	name   string
+	inode  fuseops.InodeID
+	dir    bool
+	handle fuseops.HandleID
+	flag   int
+	// The inode has been looked up for the file, i.e. it is not the root.
+	lookedUp bool
+
+	mu     sync.Mutex
+	offset int64
+	closed bool
+	// Entries of a directory not returned yet by ReadDir, listed by its first call.
+	entries []*FileInfo
+	listed  bool
+}
+
+// OpenFile opens a file with the flags of os.OpenFile: O_CREATE creates it with the given mode if needed,
+// O_EXCL fails if it exists, O_TRUNC truncates it and O_APPEND makes writes append to it. Directories
+// can only be opened read-only, and symbolic links are not followed.
+func (s *Session) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (*File, error) {
+	var looked []fuseops.InodeID
+	defer func() { s.release(ctx, looked) }()
+
+	names := splitPath(name)
+	writing := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	fi, err := s.resolve(ctx, names, &looked)
+	switch {
+	case err == nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		err = syscall.EEXIST
+	case err == nil && fi.IsDir() && (writing || flag&os.O_TRUNC != 0):
+		err = syscall.EISDIR
+	case err == nil && !fi.IsDir() && !fi.Mode().IsRegular():
+		err = syscall.EINVAL
+	case errors.Is(err, syscall.ENOENT) && flag&os.O_CREATE != 0 && len(looked) == len(names)-1:
+		return s.createFile(ctx, name, names, flag, perm, &looked)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	f := &File{s: s, name: name, inode: fi.Inode, dir: fi.IsDir(), flag: flag}
+	if !f.dir {
+		open := &fuseops.OpenFileOp{Inode: fi.Inode, OpenFlags: syscall.O_RDONLY, OpContext: s.opCtx}
+		switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+		case os.O_WRONLY:
+			open.OpenFlags = syscall.O_WRONLY
+		case os.O_RDWR:
+			open.OpenFlags = syscall.O_RDWR
+		}
+		if flag&os.O_APPEND != 0 {
+			open.OpenFlags |= syscall.O_APPEND
+		}
+		if err := s.fs.OpenFile(ctx, open); err != nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		f.handle = open.Handle
+	}
+
+	// The file keeps the lookup of its inode until closed. The root is never looked up.
+	if len(names) > 0 {
+		looked = looked[:len(looked)-1]
+		f.lookedUp = true
+	}
+	if flag&os.O_TRUNC != 0 && fi.Size() > 0 {
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// createFile creates the file opened by OpenFile, whose parent directory has been looked up.
+func (s *Session) createFile(ctx context.Context, name string, names []string, flag int, perm os.FileMode, looked *[]fuseops.InodeID) (*File, error) {
+	var parent fuseops.InodeID = fuseops.RootInodeID
+	if len(*looked) > 0 {
+		parent = (*looked)[len(*looked)-1]
+	}
+	create := &fuseops.CreateFileOp{Parent: parent, Name: names[len(names)-1], Mode: perm.Perm(), OpContext: s.opCtx}
+	if err := s.fs.CreateFile(ctx, create); err != nil {
+		return nil, &os.PathError{Op: "create", Path: name, Err: err}
+	}
+
+	return &File{s: s, name: name, inode: create.Entry.Child, handle: create.Handle, flag: flag, lookedUp: true}, nil
+}
+
+// Rename moves a file or a directory, replacing the target if any as rename(2) does.
+func (s *Session) Rename(ctx context.Context, oldname, newname string) error {
+	var looked []fuseops.InodeID
+	defer func() { s.release(ctx, looked) }()
+
+	oldNames, newNames := splitPath(oldname), splitPath(newname)
+	if len(oldNames) == 0 || len(newNames) == 0 {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EBUSY}
+	}
+	oldParent, err := s.resolve(ctx, oldNames[:len(oldNames)-1], &looked)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	newParent, err := s.resolve(ctx, newNames[:len(newNames)-1], &looked)
+	if err == nil && (!oldParent.IsDir() || !newParent.IsDir()) {
+		err = syscall.ENOTDIR
+	}
+	if err == nil {
+		err = s.fs.Rename(ctx, &fuseops.RenameOp{
+			OldParent: oldParent.Inode,
+			OldName:   oldNames[len(oldNames)-1],
+			NewParent: newParent.Inode,
+			NewName:   newNames[len(newNames)-1],
+			OpContext: s.opCtx,
+		})
+	}
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+
+	return nil
+}
+
+// Name returns the name the file was opened with.
+func (f *File) Name() string {
+	return f.name
+}
+
+func (f *File) pathErr(op string, err error) error {
+	return &os.PathError{Op: op, Path: f.name, Err: err}
+}
+
+// check returns the error of an operation on a closed file, or not allowed by the flags the file was opened with.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *File) check(op string, write bool) error {
+	switch {
+	case f.closed:
+		return f.pathErr(op, os.ErrClosed)
+	case f.dir && op != "stat" && op != "readdir":
+		return f.pathErr(op, syscall.EISDIR)
+	case !f.dir && op == "readdir":
+		return f.pathErr(op, syscall.ENOTDIR)
+	case write && f.flag&(os.O_WRONLY|os.O_RDWR) == 0, !write && f.flag&os.O_WRONLY != 0:
+		return f.pathErr(op, syscall.EBADF)
+	}
+
+	return nil
+}
+
+// Stat returns the description of the file.
+func (f *File) Stat() (*FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check("stat", false); err != nil {
+		return nil, err
+	}
+
+	return f.stat()
+}
+
+// LOCKS_REQUIRED(f.mu)
+func (f *File) stat() (*FileInfo, error) {
+	op := &fuseops.GetInodeAttributesOp{Inode: f.inode, OpContext: f.s.opCtx}
+	if err := f.s.fs.GetInodeAttributes(context.Background(), op); err != nil {
+		return nil, f.pathErr("stat", err)
+	}
+
+	return &FileInfo{name: path.Base(f.name), Inode: f.inode, Attributes: op.Attributes}, nil
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, f.pathErr("read", syscall.EINVAL)
+	}
+
+	return f.readAt(p, off)
+}
+
+// readAt fills p from the given offset, failing with io.EOF if the file ends before.
+//
+// LOCKS_REQUIRED(f.mu)
+func (f *File) readAt(p []byte, off int64) (int, error) {
+	var n int
+	for n < len(p) {
+		op := &fuseops.ReadFileOp{Inode: f.inode, Handle: f.handle, Offset: off + int64(n), Dst: p[n:], OpContext: f.s.opCtx}
+		if err := f.s.fs.ReadFile(context.Background(), op); err != nil {
+			return n, f.pathErr("read", err)
+		}
+		if op.BytesRead == 0 {
+			return n, io.EOF
+		}
+		n += op.BytesRead
+	}
+
+	return n, nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		fi, err := f.stat()
+		if err != nil {
+			return 0, err
+		}
+		f.offset = fi.Size()
+	}
+	n, err := f.writeAt(p, f.offset)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		return 0, errWriteAtInAppendMode
+	}
+	if off < 0 {
+		return 0, f.pathErr("write", syscall.EINVAL)
+	}
+
+	return f.writeAt(p, off)
+}
+
+// LOCKS_REQUIRED(f.mu)
+func (f *File) writeAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	op := &fuseops.WriteFileOp{Inode: f.inode, Handle: f.handle, Offset: off, Data: p, OpContext: f.s.opCtx}
+	if err := f.s.fs.WriteFile(context.Background(), op); err != nil {
+		return 0, f.pathErr("write", err)
+	}
+
+	return len(p), nil
+}
+
+// Seek sets the offset of the next Read or Write, as io.Seeker. The offset of a directory can only be reset.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, f.pathErr("seek", os.ErrClosed)
+	}
+	if f.dir {
+		if offset != 0 || whence != io.SeekStart {
+			return 0, f.pathErr("seek", syscall.EINVAL)
+		}
+		f.entries, f.listed = nil, false
+
+		return 0, nil
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		fi, err := f.stat()
+		if err != nil {
+			return 0, err
+		}
+		offset += fi.Size()
+	case io.SeekStart:
+	default:
+		return 0, f.pathErr("seek", syscall.EINVAL)
+	}
+	if offset < 0 {
+		return 0, f.pathErr("seek", syscall.EINVAL)
+	}
+	f.offset = offset
+
+	return offset, nil
+}
+
+// Truncate changes the size of the file.
+func (f *File) Truncate(size int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	if size < 0 {
+		return f.pathErr("truncate", syscall.EINVAL)
+	}
+	usize := uint64(size)
+	op := &fuseops.SetInodeAttributesOp{Inode: f.inode, Handle: &f.handle, Size: &usize, OpContext: f.s.opCtx}
+	if err := f.s.fs.SetInodeAttributes(context.Background(), op); err != nil {
+		return f.pathErr("truncate", err)
+	}
+
+	return nil
+}
+
+// Sync does nothing but check the file is open: writes are committed to immudb as they are served.
+func (f *File) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return f.pathErr("sync", os.ErrClosed)
+	}
+
+	return nil
+}
+
+// ReadDir returns the next n entries of a directory, in the order they are stored, as fs.ReadDirFile does:
+// all the remaining ones if n <= 0, in which case the end of the directory is not an error.
+func (f *File) ReadDir(n int) ([]*FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.check("readdir", false); err != nil {
+		return nil, err
+	}
+	if !f.listed {
+		entries, err := f.s.readDir(context.Background(), f.inode, f.name)
+		if err != nil {
+			return nil, err
+		}
+		f.entries, f.listed = entries, true
+	}
+
+	if n > 0 && len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(f.entries) {
+		n = len(f.entries)
+	}
+	entries := f.entries[:n:n]
+	f.entries = f.entries[n:]
+
+	return entries, nil
+}
+
+// Close releases the file handle and the lookup of the inode.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return f.pathErr("close", os.ErrClosed)
+	}
+	f.closed = true
+
+	ctx := context.Background()
+	if f.lookedUp {
+		defer f.s.release(ctx, []fuseops.InodeID{f.inode})
+	}
+	if f.dir {
+		return nil
+	}
+	if err := f.s.fs.FlushFile(ctx, &fuseops.FlushFileOp{Inode: f.inode, Handle: f.handle, OpContext: f.s.opCtx}); err != nil {
+		f.s.fs.ReleaseFileHandle(ctx, &fuseops.ReleaseFileHandleOp{Handle: f.handle, OpContext: f.s.opCtx})
+
+		return f.pathErr("close", err)
+	}
+	if err := f.s.fs.ReleaseFileHandle(ctx, &fuseops.ReleaseFileHandleOp{Handle: f.handle, OpContext: f.s.opCtx}); err != nil {
+		return f.pathErr("close", err)
+	}
+
+	return nil
+}
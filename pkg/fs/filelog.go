@@ -0,0 +1,115 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"syscall"
+)
+
+// LogEntry is a revision of a file listed by FileLog.
+type LogEntry struct {
+	Revision
+	// Path of the file as of the revision, and the change of size from the previous revision (the whole size for
+	// the first one).
+	Path      string
+	SizeDelta int64
+}
+
+// FileLog returns the revisions of the content of the file, or of the entries of the directory, at a path, newest
+// first, up to limit of them (0 for all). Only the revisions written while the file was at that path are listed,
+// unless follow is set: the revisions written before it was renamed are listed too, with the path it had then,
+// as found in the history of the directories. Files are identified by their inumber: a file replaced by another
+// one under the same name is not followed.
+func (idb *ImmuDbClient) FileLog(ctx context.Context, name string, limit int, follow bool) ([]LogEntry, error) {
+	name = path.Clean("/" + name)
+	inode, _, err := idb.LookUpPath(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if idb.contentApart && !inode.isDir() {
+		return nil, fmt.Errorf("content of file %d, stored in a separate database: %w", inode.Inumber, ErrNotSupported)
+	}
+
+	current, err := idb.CurrentTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var log []LogEntry
+	// From the newest revision backwards, each of them being the last write before the next one.
+	tx, err := idb.lastContentWrite(ctx, inode.Inumber, 1, current)
+	if err != nil {
+		return nil, err
+	}
+	for tx > 0 && (limit == 0 || len(log) < limit) {
+		prev, err := idb.lastContentWrite(ctx, inode.Inumber, 1, tx-1)
+		if err != nil {
+			return nil, err
+		}
+		at, err := idb.pathAt(ctx, inode.Inumber, name, tx, follow)
+		if err != nil {
+			return nil, err
+		}
+		if at != "" {
+			e, err := idb.logEntry(ctx, inode, tx, prev)
+			if err != nil {
+				return nil, err
+			}
+			e.Path = at
+			log = append(log, *e)
+		}
+		tx = prev
+	}
+
+	return log, nil
+}
+
+// logEntry returns the revision of a file written by tx, and its size delta from the one written by prev, if any.
+func (idb *ImmuDbClient) logEntry(ctx context.Context, inode *Inode, tx, prev uint64) (*LogEntry, error) {
+	revs, err := idb.RevisionsBetween(ctx, inode.Inumber, inode.isDir(), tx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if len(revs) != 1 {
+		return nil, fmt.Errorf("revision of inode %d at tx %d: %w", inode.Inumber, tx, ErrInodeNotFound)
+	}
+	e := &LogEntry{Revision: revs[0], SizeDelta: revs[0].Size}
+	if prev > 0 {
+		var rev Revision
+		content, err := idb.contentWrittenBy(ctx, inode.Inumber, prev, &rev)
+		if err != nil {
+			return nil, err
+		}
+		e.SizeDelta -= int64(len(content))
+	}
+
+	return e, nil
+}
+
+// pathAt returns the path of a file as of a transaction: name if it was there then, or, if follow is set, the
+// path it had, found by walking the tree. It is empty if the file was not at name, or not reachable, then.
+func (idb *ImmuDbClient) pathAt(ctx context.Context, inumber int64, name string, tx uint64, follow bool) (string, error) {
+	inode, err := idb.LookUpPathAsOf(ctx, name, tx)
+	if err == nil && inode.Inumber == inumber {
+		return name, nil
+	}
+	if err != nil && !errors.Is(err, ErrInodeNotFound) && !errors.Is(err, syscall.ENOTDIR) {
+		return "", err
+	}
+	if !follow {
+		return "", nil
+	}
+
+	period, err := idb.periodAsOf(ctx, tx)
+	if err != nil {
+		return "", err
+	}
+	paths, err := idb.treePaths(ctx, period)
+	if err != nil {
+		return "", err
+	}
+
+	return paths[inumber], nil
+}
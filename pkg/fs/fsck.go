@@ -0,0 +1,421 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// Kinds of problems found by Fsck.
+const (
+	FsckDangling  = "dangling entry"
+	FsckOrphan    = "orphan inode"
+	FsckSize      = "size mismatch"
+	FsckDuplicate = "duplicate inumber"
+	FsckNoContent = "missing directory"
+	FsckChecksum  = "checksum mismatch"
+)
+
+// Directory where the orphan inodes are reattached, as "#<inumber>".
+const lostAndFoundName = "lost+found"
+
+// errFsckStale aborts a repair whose problem has been fixed meanwhile, e.g. by a mount.
+var errFsckStale = errors.New("problem no longer found")
+
+// FsckProblem is an inconsistency between the immufs tables.
+type FsckProblem struct {
+	Kind    string
+	Inumber int64
+	// Directory and name of the entry involved, if any.
+	Parent int64
+	Name   string
+	Detail string
+
+	Repaired bool
+}
+
+// fsckInode holds the attributes of an inode the checks need.
+type fsckInode struct {
+	size        int64
+	mode        os.FileMode
+	toBeDeleted bool
+}
+
+// fsckRef is a directory entry referring to an inode.
+type fsckRef struct {
+	parent int64
+	index  int
+	name   string
+}
+
+// Fsck looks for inconsistencies between the immufs tables: entries referring to missing inodes, inodes
+// no entry refers to, files whose size does not match their content, inumbers referenced more than once
+// or beyond the allocator. Files whose content does not match its checksum are quarantined and reported,
+// but can't be repaired. With repair set, the other problems are fixed, each in a transaction that also
+// stores an audit record (operation "fsck"). It is meant to run while no mount is writing the database.
+func (idb *ImmuDbClient) Fsck(ctx context.Context, repair bool) ([]FsckProblem, error) {
+	inodes, err := idb.fsckInodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inumbers := make([]int64, 0, len(inodes))
+	for inumber := range inodes {
+		inumbers = append(inumbers, inumber)
+	}
+	sort.Slice(inumbers, func(i, j int) bool { return inumbers[i] < inumbers[j] })
+
+	var problems []FsckProblem
+	report := func(p FsckProblem, fix func() error) error {
+		if repair {
+			err := fix()
+			if err != nil && !errors.Is(err, errFsckStale) {
+				return err
+			}
+			p.Repaired = err == nil
+		}
+		problems = append(problems, p)
+
+		return nil
+	}
+
+	// Walk the directories, recording which entries refer to each inode.
+	refs := make(map[int64][]fsckRef)
+	for _, inumber := range inumbers {
+		in := inodes[inumber]
+		if in.mode&os.ModeDir == 0 {
+			continue
+		}
+
+		children, err := idb.GetChildren(ctx, inumber)
+		if err != nil {
+			p := FsckProblem{Kind: FsckNoContent, Inumber: inumber, Detail: err.Error()}
+			err = report(p, func() error { return idb.fsckWriteEmptyDir(ctx, p) })
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+		for i, e := range children {
+			if e.Type == fuseutil.DT_Unknown {
+				continue
+			}
+			child := int64(e.Inode)
+			if _, ok := inodes[child]; ok {
+				refs[child] = append(refs[child], fsckRef{parent: inumber, index: i, name: e.Name})
+
+				continue
+			}
+
+			p := FsckProblem{Kind: FsckDangling, Inumber: child, Parent: inumber, Name: e.Name, Detail: "inode not found"}
+			err := report(p, func() error { return idb.fsckRemoveEntry(ctx, p, i, true) })
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, inumber := range inumbers {
+		in := inodes[inumber]
+
+		switch r := refs[inumber]; {
+		case len(r) == 0 && inumber != int64(fuseops.RootInodeID):
+			detail := "reattached to /" + lostAndFoundName
+			if in.toBeDeleted {
+				detail = "unlinked, deleted"
+			}
+			p := FsckProblem{Kind: FsckOrphan, Inumber: inumber, Detail: detail}
+			err := report(p, func() error { return idb.fsckReattach(ctx, p, in) })
+			if err != nil {
+				return nil, err
+			}
+		case len(r) > 1:
+			// Hard links are not supported: the first entry is kept.
+			for _, ref := range r[1:] {
+				p := FsckProblem{Kind: FsckDuplicate, Inumber: inumber, Parent: ref.parent, Name: ref.name,
+					Detail: fmt.Sprintf("also linked in directory %d, entry removed", r[0].parent)}
+				err := report(p, func() error { return idb.fsckRemoveEntry(ctx, p, ref.index, false) })
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if in.mode&(os.ModeDir|os.ModeSymlink) != 0 {
+			continue
+		}
+		content, err := idb.ReadContent(ctx, inumber)
+		if errors.Is(err, ErrChecksumMismatch) {
+			problems = append(problems, FsckProblem{Kind: FsckChecksum, Inumber: inumber, Detail: "content quarantined"})
+
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(content)) != in.size {
+			p := FsckProblem{Kind: FsckSize, Inumber: inumber,
+				Detail: fmt.Sprintf("size %d, content %d bytes", in.size, len(content))}
+			err := report(p, func() error { return idb.fsckFixSize(ctx, p) })
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Inumbers beyond the allocator would be allocated again.
+	if len(inumbers) > 0 {
+		last, _, err := readAllocator(ctx, idb.cl)
+		if err != nil {
+			return nil, wrapErr(err)
+		}
+		if highest := inumbers[len(inumbers)-1]; highest > last {
+			p := FsckProblem{Kind: FsckDuplicate, Inumber: highest,
+				Detail: fmt.Sprintf("beyond the last allocated inumber %d", last)}
+			err := report(p, func() error { return idb.fsckFixAllocator(ctx, p) })
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return problems, nil
+}
+
+// fsckInodes loads the attributes of all the inodes.
+func (idb *ImmuDbClient) fsckInodes(ctx context.Context) (map[int64]fsckInode, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	res, err := idb.cl.QueryContext(ctx, "SELECT inumber, size, mode, to_be_deleted FROM inode")
+	if err != nil {
+		idb.log.Errorf("could not list inodes: %s", err)
+
+		return nil, wrapErr(err)
+	}
+	defer res.Close()
+
+	inodes := make(map[int64]fsckInode)
+	for res.Next() {
+		var inumber, size, mode int64
+		var toBeDeleted sql.NullBool
+		if err := res.Scan(&inumber, &size, &mode, &toBeDeleted); err != nil {
+			return nil, wrapErr(err)
+		}
+		inodes[inumber] = fsckInode{size: size, mode: os.FileMode(mode), toBeDeleted: toBeDeleted.Bool}
+	}
+
+	return inodes, wrapErr(res.Err())
+}
+
+// fsckRepair runs fix in a transaction, which also records the repair in the audit table.
+func (idb *ImmuDbClient) fsckRepair(ctx context.Context, p FsckProblem, fix func(ctx context.Context, tx *sql.Tx) error) error {
+	rec := &AuditRecord{
+		Time:    time.Now(),
+		Op:      "fsck",
+		Inumber: p.Inumber,
+		Name:    p.Name,
+		Uid:     int64(os.Getuid()),
+		Gid:     int64(os.Getgid()),
+		Pid:     uint32(os.Getpid()),
+		Result:  p.Kind + ": " + p.Detail,
+		DBUser:  idb.user,
+	}
+	err := idb.inTx(ctx, idb.metadataTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+		if err := fix(ctx, tx); err != nil {
+			return err
+		}
+
+		return appendAudit(ctx, tx, rec)
+	})
+	if err != nil && !errors.Is(err, errFsckStale) {
+		idb.log.Errorf("could not repair %s of inode %d: %s", p.Kind, p.Inumber, err)
+	}
+
+	return err
+}
+
+// readDirents reads the entries of a directory within a transaction.
+func readDirents(ctx context.Context, tx *sql.Tx, inumber int64) ([]fuseutil.Dirent, error) {
+	var content []byte
+	err := tx.QueryRowContext(ctx, "SELECT content FROM content WHERE inumber=?", inumber).Scan(&content)
+	if err != nil {
+		return nil, err
+	}
+
+	return unmarshalDirents(content)
+}
+
+func writeDirents(ctx context.Context, tx *sql.Tx, inumber int64, dirents []fuseutil.Dirent) error {
+	content, err := marshalDirents(dirents)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "UPSERT INTO content(inumber, content) VALUES(?, ?)", inumber, content)
+
+	return err
+}
+
+// fsckRemoveEntry marks the index-th entry of the parent directory as unused. With missing set,
+// the entry is only removed if its inode still does not exist.
+func (idb *ImmuDbClient) fsckRemoveEntry(ctx context.Context, p FsckProblem, index int, missing bool) error {
+	return idb.fsckRepair(ctx, p, func(ctx context.Context, tx *sql.Tx) error {
+		if missing {
+			var inumber int64
+			err := tx.QueryRowContext(ctx, "SELECT inumber FROM inode WHERE inumber=?", p.Inumber).Scan(&inumber)
+			if err == nil {
+				return errFsckStale
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+
+		dirents, err := readDirents(ctx, tx, p.Parent)
+		if err != nil {
+			return err
+		}
+		if index >= len(dirents) || int64(dirents[index].Inode) != p.Inumber || dirents[index].Name != p.Name {
+			return errFsckStale
+		}
+		dirents[index] = fuseutil.Dirent{
+			Type:   fuseutil.DT_Unknown,
+			Offset: fuseops.DirOffset(index + 1),
+		}
+
+		return writeDirents(ctx, tx, p.Parent, dirents)
+	})
+}
+
+// fsckWriteEmptyDir gives a directory without content an empty list of entries.
+func (idb *ImmuDbClient) fsckWriteEmptyDir(ctx context.Context, p FsckProblem) error {
+	return idb.fsckRepair(ctx, p, func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := readDirents(ctx, tx, p.Inumber); !errors.Is(err, sql.ErrNoRows) {
+			return errFsckStale
+		}
+
+		return writeDirents(ctx, tx, p.Inumber, []fuseutil.Dirent{})
+	})
+}
+
+// fsckReattach deletes an orphan inode which had been unlinked, or links it into lost+found.
+func (idb *ImmuDbClient) fsckReattach(ctx context.Context, p FsckProblem, in fsckInode) error {
+	if in.toBeDeleted {
+		return idb.fsckRepair(ctx, p, func(ctx context.Context, tx *sql.Tx) error {
+			for _, table := range []string{"inode", "content", "xattr"} {
+				if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE inumber=?", table), p.Inumber); err != nil {
+					return err
+				}
+			}
+
+			return idb.clearDeltas(ctx, tx, p.Inumber)
+		})
+	}
+
+	lostAndFound, err := idb.lostAndFound(ctx)
+	if err != nil {
+		return err
+	}
+
+	return idb.fsckRepair(ctx, p, func(ctx context.Context, tx *sql.Tx) error {
+		dirents, err := readDirents(ctx, tx, lostAndFound.Inumber)
+		if err != nil {
+			return err
+		}
+		dirents = append(dirents, fuseutil.Dirent{
+			Offset: fuseops.DirOffset(len(dirents) + 1),
+			Inode:  fuseops.InodeID(p.Inumber),
+			Name:   fmt.Sprintf("#%d", p.Inumber),
+			Type:   direntType(in.mode),
+		})
+
+		return writeDirents(ctx, tx, lostAndFound.Inumber, dirents)
+	})
+}
+
+// lostAndFound returns the lost+found directory, creating it if needed.
+func (idb *ImmuDbClient) lostAndFound(ctx context.Context) (*Inode, error) {
+	dir, _, err := idb.LookUpPath(ctx, lostAndFoundName)
+	if err == nil {
+		return dir, nil
+	}
+	if !errors.Is(err, ErrInodeNotFound) {
+		return nil, err
+	}
+
+	root, err := idb.GetInode(ctx, int64(fuseops.RootInodeID))
+	if err != nil {
+		return nil, err
+	}
+	inumber, generation, err := idb.AllocateInumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	attrs := fuseops.InodeAttributes{
+		Nlink: 1,
+		Atime: now,
+		Ctime: now,
+		Mode:  0700 | os.ModeDir,
+		Uid:   uint32(root.Uid),
+		Gid:   uint32(root.Gid),
+	}
+	dir, err = NewInode(ctx, inumber, generation, 0, attrs, idb)
+	if err != nil {
+		return nil, err
+	}
+	if err := root.AddChild(fuseops.InodeID(dir.Inumber), lostAndFoundName, fuseutil.DT_Directory); err != nil {
+		return nil, err
+	}
+
+	return dir, nil
+}
+
+// fsckFixSize sets the size of a file to the length of its content.
+func (idb *ImmuDbClient) fsckFixSize(ctx context.Context, p FsckProblem) error {
+	return idb.fsckRepair(ctx, p, func(ctx context.Context, tx *sql.Tx) error {
+		var content []byte
+		var checksum, tier sql.NullString
+		err := idb.contentOf(ctx, tx, false).QueryRowContext(ctx, "SELECT content, checksum, tier FROM content WHERE inumber=?", p.Inumber).
+			Scan(&content, &checksum, &tier)
+		if errors.Is(err, sql.ErrNoRows) {
+			content, err = idb.readInline(ctx, tx, p.Inumber, "")
+		} else if err == nil {
+			content, err = idb.resolveContent(ctx, p.Inumber, content, checksum, tier)
+		}
+		if err != nil {
+			return err
+		}
+		deltas, err := idb.readDeltas(ctx, tx, p.Inumber, "")
+		if err != nil {
+			return err
+		}
+		content = applyDeltas(content, deltas)
+		_, err = tx.ExecContext(ctx, "UPDATE inode SET size=? WHERE inumber=?", int64(len(content)), p.Inumber)
+
+		return err
+	})
+}
+
+// fsckFixAllocator moves the inumber allocator past the given inumber.
+func (idb *ImmuDbClient) fsckFixAllocator(ctx context.Context, p FsckProblem) error {
+	return idb.fsckRepair(ctx, p, func(ctx context.Context, tx *sql.Tx) error {
+		last, generation, err := readAllocator(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if last >= p.Inumber {
+			return errFsckStale
+		}
+		_, err = tx.ExecContext(ctx, "UPSERT INTO inumber_allocator(id, last_inumber, generation) VALUES(1, ?, ?)", p.Inumber, generation)
+
+		return err
+	})
+}
@@ -0,0 +1,227 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	immusql "github.com/codenotary/immudb/embedded/sql"
+	"github.com/codenotary/immudb/embedded/store"
+	"github.com/codenotary/immudb/pkg/client"
+)
+
+var ErrRetentionTooShort = fmt.Errorf("retention must be at least %s", store.MinimumRetentionPeriod)
+
+// The server clock may differ from the local one: history is truncated up to GCClockMargin
+// before the cutoff computed locally.
+const GCClockMargin = 5 * time.Minute
+
+// Number of rows rewritten per transaction, to stay within the immudb transaction size.
+const gcBatchSize = 64
+
+// GCReport describes a garbage collection run.
+type GCReport struct {
+	// History older than Cutoff has been discarded.
+	Cutoff time.Time
+	// Rows rewritten by table, since they had not changed since Cutoff.
+	Rewritten map[string]int
+	// Snapshots preventing the truncation, if any.
+	HeldBy []string
+
+	// Set by dry runs (see PlanGarbageCollection): the last transaction whose values are discarded, the
+	// revisions of the contents written up to it, and the space taken by those superseded.
+	CutoffTx       uint64
+	Revisions      []GCRevision
+	ReclaimedBytes int64
+}
+
+// CollectGarbage discards the history older than retention, so that storage growth is bounded.
+//
+// immudb truncation drops every value written before the truncation point, including those still current.
+// Hence the rows which have not changed since the cutoff are rewritten first, so that the filesystem
+// itself is left untouched: only the revisions superseded before the cutoff are lost. As the view of the
+// filesystem as of a snapshot needs all the values written before it, snapshots prevent any truncation.
+func (idb *ImmuDbClient) CollectGarbage(ctx context.Context, retention time.Duration) (*GCReport, error) {
+	report, err := idb.newGCReport(ctx, retention)
+	if err != nil || len(report.HeldBy) > 0 {
+		return report, err
+	}
+
+	for _, table := range tables {
+		n, err := idb.rewriteUnchanged(ctx, table.name, table.keys, report.Cutoff)
+		if err != nil {
+			idb.log.Errorf("could not rewrite table %s: %s", table.name, err)
+
+			return nil, err
+		}
+		report.Rewritten[table.name] = n
+	}
+
+	err = idb.withImmuClient(ctx, func(cl client.ImmuClient) error {
+		return cl.TruncateDatabase(ctx, cl.GetOptions().Database, time.Since(report.Cutoff)+GCClockMargin)
+	})
+	if err != nil {
+		idb.log.Errorf("could not truncate history: %s", err)
+
+		return nil, wrapErr(err)
+	}
+
+	return report, nil
+}
+
+// newGCReport starts the report of a garbage collection, listing the snapshots preventing it.
+func (idb *ImmuDbClient) newGCReport(ctx context.Context, retention time.Duration) (*GCReport, error) {
+	if retention < store.MinimumRetentionPeriod {
+		return nil, ErrRetentionTooShort
+	}
+
+	report := &GCReport{
+		Cutoff:    time.Now().Add(-retention),
+		Rewritten: make(map[string]int),
+	}
+
+	snaps, err := idb.ListSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, snap := range snaps {
+		report.HeldBy = append(report.HeldBy, snap.Name)
+	}
+	if len(report.HeldBy) > 0 {
+		idb.log.Warnf("history not truncated: held by snapshots %s", strings.Join(report.HeldBy, ", "))
+	}
+
+	return report, nil
+}
+
+// rewriteUnchanged writes again, as they are, the rows of a table which have not changed since the
+// given time, and returns how many they were. Missing tables (i.e. older schemas) are skipped.
+func (idb *ImmuDbClient) rewriteUnchanged(ctx context.Context, table string, keys []string, since time.Time) (int, error) {
+	stale, err := idb.unchangedKeys(ctx, table, keys, since)
+	if err != nil {
+		return 0, err
+	}
+
+	conds := make([]string, len(keys))
+	for i, key := range keys {
+		conds[i] = key + "=?"
+	}
+	query := fmt.Sprintf("SELECT * FROM %s WHERE %s", table, strings.Join(conds, " AND "))
+
+	for start := 0; start < len(stale); start += gcBatchSize {
+		end := start + gcBatchSize
+		if end > len(stale) {
+			end = len(stale)
+		}
+		batch := stale[start:end]
+		// The rows are read again within the transaction: should a mount change them meanwhile,
+		// their newer value is written, or the transaction is retried.
+		err := idb.inTx(ctx, idb.writeTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+			for _, key := range batch {
+				if err := rewriteRow(ctx, tx, table, query, key); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(stale), nil
+}
+
+// unchangedKeys returns the primary keys of the rows of a table which have not changed since the given time.
+// Missing tables (i.e. older schemas) have none.
+func (idb *ImmuDbClient) unchangedKeys(ctx context.Context, table string, keys []string, since time.Time) ([][]any, error) {
+	all, err := idb.tableKeys(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(keys, ", "), table))
+	if err != nil && strings.Contains(err.Error(), immusql.ErrTableDoesNotExist.Error()) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	changed, err := idb.tableKeys(ctx, fmt.Sprintf("SELECT %s FROM %s SINCE ?", strings.Join(keys, ", "), table), since)
+	if err != nil {
+		return nil, err
+	}
+
+	var stale [][]any
+	for key, values := range all {
+		if _, ok := changed[key]; !ok {
+			stale = append(stale, values)
+		}
+	}
+
+	return stale, nil
+}
+
+// rewriteRow upserts the row selected by query with its current values. Deleted rows are skipped.
+func rewriteRow(ctx context.Context, tx *sql.Tx, table string, query string, key []any) error {
+	res, err := tx.QueryContext(ctx, query, key...)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	if !res.Next() {
+		return res.Err()
+	}
+	cols, err := res.Columns()
+	if err != nil {
+		return err
+	}
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := res.Scan(ptrs...); err != nil {
+		return err
+	}
+	res.Close()
+
+	marks := strings.TrimSuffix(strings.Repeat("?,", len(cols)), ",")
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("UPSERT INTO %s(%s) VALUES(%s)", table, strings.Join(cols, ", "), marks), values...)
+
+	return err
+}
+
+// tableKeys runs a query returning primary keys, and indexes them by their string representation.
+func (idb *ImmuDbClient) tableKeys(ctx context.Context, query string, args ...any) (map[string][]any, error) {
+	ctx, cancel := withTimeout(ctx, idb.readTimeout.Load())
+	defer cancel()
+
+	res, err := idb.cl.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, wrapErr(err)
+	}
+	defer res.Close()
+
+	cols, err := res.Columns()
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string][]any)
+	for res.Next() {
+		values := make([]any, len(cols))
+		ptrs := make([]any, len(cols))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := res.Scan(ptrs...); err != nil {
+			return nil, wrapErr(err)
+		}
+		keys[fmt.Sprintf("%#v", values)] = values
+	}
+	if err := res.Err(); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, wrapErr(err)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,113 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// GCRevision is a revision of the content of a file, or of the entries of a directory, written before the
+// cutoff of a garbage collection.
+type GCRevision struct {
+	Revision
+	Inumber int64
+	// Current path of the file, empty if it has been deleted.
+	Path string
+	// Superseded revisions are discarded; the others are the current content, rewritten and kept.
+	Superseded bool
+	// Whether the file is locked (see FileLock): a lock protects its current content, not its history.
+	Locked bool
+}
+
+// PlanGarbageCollection reports what CollectGarbage would do with the given retention, without writing
+// anything: the rows which would be rewritten, and the revisions of the contents written up to the last
+// transaction discarded, which are lost when superseded. The contents stored in a separate database are
+// left out, as the garbage collection only truncates the database of the inodes.
+func (idb *ImmuDbClient) PlanGarbageCollection(ctx context.Context, retention time.Duration) (*GCReport, error) {
+	report, err := idb.newGCReport(ctx, retention)
+	if err != nil || len(report.HeldBy) > 0 {
+		return report, err
+	}
+
+	kept := make(map[int64]bool)
+	for _, table := range tables {
+		stale, err := idb.unchangedKeys(ctx, table.name, table.keys, report.Cutoff)
+		if err != nil {
+			return nil, err
+		}
+		report.Rewritten[table.name] = len(stale)
+		if table.name == "content" {
+			for _, key := range stale {
+				if inumber, ok := key[0].(int64); ok {
+					kept[inumber] = true
+				}
+			}
+		}
+	}
+
+	report.CutoffTx, err = idb.TxAt(ctx, report.Cutoff.Add(-GCClockMargin))
+	if err != nil || report.CutoffTx == 0 {
+		return report, err
+	}
+
+	written, err := idb.tableKeys(ctx, "SELECT inumber FROM (HISTORY OF content)")
+	if err != nil {
+		return nil, err
+	}
+	var inumbers []int64
+	for _, key := range written {
+		if inumber, ok := key[0].(int64); ok {
+			inumbers = append(inumbers, inumber)
+		}
+	}
+	sort.Slice(inumbers, func(i, j int) bool { return inumbers[i] < inumbers[j] })
+	paths, err := idb.treePaths(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	current, err := idb.CurrentTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, inumber := range inumbers {
+		// With a separate content database, the table holds the entries of the directories only.
+		revs, err := idb.RevisionsBetween(ctx, inumber, idb.contentApart, 1, report.CutoffTx)
+		if err != nil {
+			return nil, fmt.Errorf("revisions of inode %d: %w", inumber, err)
+		}
+		if len(revs) == 0 {
+			continue
+		}
+		// A row unchanged since the cutoff may still have been written within the clock margin.
+		rewritten := kept[inumber]
+		if rewritten && report.CutoffTx < current {
+			later, err := idb.contentWritten(ctx, inumber, report.CutoffTx+1, current)
+			if err != nil {
+				return nil, err
+			}
+			rewritten = !later
+		}
+		lock, err := idb.GetLock(ctx, inumber)
+		if err != nil && !errors.Is(err, ErrLockNotFound) {
+			return nil, err
+		}
+		for i, rev := range revs {
+			r := GCRevision{
+				Revision:   rev,
+				Inumber:    inumber,
+				Path:       paths[inumber],
+				Superseded: i < len(revs)-1 || !rewritten,
+				Locked:     lock != nil && lock.Active(),
+			}
+			if r.Superseded {
+				report.ReclaimedBytes += r.Size
+			}
+			report.Revisions = append(report.Revisions, r)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,49 @@
+package fs
+
+import (
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// fileHandle keeps the state of a file opened by the kernel, until the handle is released.
+type fileHandle struct {
+	inode fuseops.InodeID
+
+	// The file was opened with O_APPEND: every write goes to the end of the file,
+	// regardless of the offset supplied by the kernel.
+	append bool
+
+	// The file was opened for writing.
+	write bool
+
+	// Content generated on open, for the files of the .immufs directory.
+	content []byte
+}
+
+// newHandle registers a handle for the given inode and returns its ID.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) newHandle(inode fuseops.InodeID, append bool) fuseops.HandleID {
+	fs.nextHandle++
+	fs.handles[fs.nextHandle] = &fileHandle{
+		inode:  inode,
+		append: append,
+	}
+
+	return fs.nextHandle
+}
+
+// getHandle returns the handle with the given ID, if still open.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) getHandle(id fuseops.HandleID) (*fileHandle, bool) {
+	h, ok := fs.handles[id]
+
+	return h, ok
+}
+
+// releaseHandle forgets the handle with the given ID.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) releaseHandle(id fuseops.HandleID) {
+	delete(fs.handles, id)
+}
@@ -0,0 +1,352 @@
+package fs
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/codenotary/immudb/pkg/client"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// The methods below read the filesystem as of a past transaction, for the history API (see pkg/historyapi).
+// The state as of a transaction includes its changes. Transaction 0 stands for the current state.
+
+var ErrTxNotCommitted = errors.New("transaction not committed yet")
+
+// DirEntry is a child of a directory, together with its inode.
+type DirEntry struct {
+	Name  string
+	Inode *Inode
+}
+
+// periodAsOf returns the period clause of the queries as of a transaction.
+func (idb *ImmuDbClient) periodAsOf(ctx context.Context, tx uint64) (string, error) {
+	if tx == 0 {
+		return "", nil
+	}
+	current, err := idb.CurrentTx(ctx)
+	if err != nil {
+		return "", err
+	}
+	if tx > current {
+		return "", fmt.Errorf("transaction %d: %w", tx, ErrTxNotCommitted)
+	}
+
+	return fmt.Sprintf("UNTIL TX %d", tx), nil
+}
+
+// GetInodeAt returns an inode as of a transaction, or ErrInodeNotFound if it did not exist then.
+func (idb *ImmuDbClient) GetInodeAt(ctx context.Context, inumber int64, tx uint64) (*Inode, error) {
+	period, err := idb.periodAsOf(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return idb.getInode(ctx, inumber, period)
+}
+
+// GetChildrenAt returns the entries of a directory as of a transaction, unused ones included.
+func (idb *ImmuDbClient) GetChildrenAt(ctx context.Context, parent int64, tx uint64) ([]fuseutil.Dirent, error) {
+	period, err := idb.periodAsOf(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return idb.getChildren(ctx, parent, period)
+}
+
+// LookUpPathAsOf resolves a path, relative to the root of the filesystem, as of a transaction.
+func (idb *ImmuDbClient) LookUpPathAsOf(ctx context.Context, path string, tx uint64) (*Inode, error) {
+	period, err := idb.periodAsOf(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	inode, _, err := idb.lookUpPath(ctx, path, period)
+
+	return inode, err
+}
+
+// ReadDirAsOf returns the children of a directory as of a transaction, sorted by name.
+func (idb *ImmuDbClient) ReadDirAsOf(ctx context.Context, dir *Inode, tx uint64) ([]DirEntry, error) {
+	period, err := idb.periodAsOf(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	children, err := idb.getChildren(ctx, dir.Inumber, period)
+	if err != nil {
+		return nil, err
+	}
+
+	var inumbers []int64
+	for _, child := range children {
+		if child.Type != fuseutil.DT_Unknown {
+			inumbers = append(inumbers, int64(child.Inode))
+		}
+	}
+	inodes, err := idb.getInodes(ctx, inumbers, period)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []DirEntry
+	for _, child := range children {
+		if child.Type == fuseutil.DT_Unknown {
+			continue
+		}
+		inode, ok := inodes[int64(child.Inode)]
+		if !ok {
+			idb.log.Warnf("Inode %d not found", child.Inode)
+
+			return nil, ErrInodeNotFound
+		}
+		entries = append(entries, DirEntry{Name: child.Name, Inode: inode})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	return entries, nil
+}
+
+// ReadContentAt reads a whole file as of a transaction.
+func (idb *ImmuDbClient) ReadContentAt(ctx context.Context, inumber int64, tx uint64) ([]byte, error) {
+	period, err := idb.periodAsOf(ctx, tx)
+	if err != nil || period == "" || !idb.contentApart {
+		return idb.readContent(ctx, inumber, period)
+	}
+
+	// The transactions of the content database are unrelated to those of the inodes: the content is read as of
+	// the time of the transaction instead, to the second.
+	ts, err := idb.TxTime(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return idb.readContent(ctx, inumber, "UNTIL ?", ts)
+}
+
+// TxTime returns the time a transaction was committed at, to the second.
+func (idb *ImmuDbClient) TxTime(ctx context.Context, tx uint64) (time.Time, error) {
+	var ts int64
+	err := idb.withImmuClient(ctx, func(cl client.ImmuClient) error {
+		t, err := cl.TxByID(ctx, tx)
+		if err != nil {
+			return err
+		}
+		ts = t.GetHeader().GetTs()
+
+		return nil
+	})
+	if err != nil {
+		idb.log.Errorf("could not get transaction %d: %s", tx, err)
+
+		return time.Time{}, wrapErr(err)
+	}
+
+	return time.Unix(ts, 0), nil
+}
+
+// TxAt returns the last transaction committed at or before the given time, to the second, 0 if none.
+func (idb *ImmuDbClient) TxAt(ctx context.Context, t time.Time) (uint64, error) {
+	current, err := idb.CurrentTx(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// Transactions are committed in order: bisect on their time.
+	lo, hi := uint64(0), current
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		ts, err := idb.TxTime(ctx, mid)
+		if err != nil {
+			return 0, err
+		}
+		if ts.After(t) {
+			hi = mid - 1
+		} else {
+			lo = mid
+		}
+	}
+
+	return lo, nil
+}
+
+// Revision is a revision of the content of a file, or of the entries of a directory.
+type Revision struct {
+	// Transaction which wrote the revision, and its time.
+	Tx   uint64
+	Time time.Time
+	// Size and SHA-256 digest of the content.
+	Size   int64
+	SHA256 [sha256.Size]byte
+	// Operation which wrote the revision, if recorded (see Provenance).
+	Provenance *AuditRecord
+	// Signature of the revision, if written by a mount signing its writes.
+	Signature *ContentSignature
+}
+
+// RevisionsBetween returns the revisions of the content of a file, or of the entries of a directory, written
+// between two transactions (included), oldest first.
+//
+// The contents stored in a separate database are not supported: the transactions of the filesystem don't
+// identify their revisions.
+func (idb *ImmuDbClient) RevisionsBetween(ctx context.Context, inumber int64, isDir bool, fromTx, toTx uint64) ([]Revision, error) {
+	if idb.contentApart && !isDir {
+		return nil, fmt.Errorf("content of file %d, stored in a separate database: %w", inumber, ErrNotSupported)
+	}
+	if fromTx == 0 {
+		fromTx = 1
+	}
+
+	// The write following the newest revision bounds its provenance.
+	current, err := idb.CurrentTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	next, err := idb.firstContentWrite(ctx, inumber, toTx+1, current)
+	if err != nil {
+		return nil, err
+	}
+
+	// From the newest revision backwards, each of them being the last write before the previous one.
+	var revs []Revision
+	for to := toTx; to >= fromTx; {
+		tx, err := idb.lastContentWrite(ctx, inumber, fromTx, to)
+		if err != nil {
+			return nil, err
+		}
+		if tx == 0 {
+			break
+		}
+
+		rev := Revision{Tx: tx}
+		content, err := idb.contentWrittenBy(ctx, inumber, tx, &rev)
+		if err != nil {
+			return nil, err
+		}
+		rev.Size, rev.SHA256 = int64(len(content)), sha256.Sum256(content)
+		if rev.Time, err = idb.TxTime(ctx, tx); err != nil {
+			return nil, err
+		}
+		if rev.Provenance, err = idb.Provenance(ctx, inumber, tx, next); err != nil {
+			return nil, err
+		}
+		revs = append(revs, rev)
+		to, next = tx-1, tx
+	}
+
+	for i, j := 0, len(revs)-1; i < j; i, j = i+1, j-1 {
+		revs[i], revs[j] = revs[j], revs[i]
+	}
+
+	return revs, nil
+}
+
+// contentWrittenBy returns the content row of an inode written by a transaction of the database of the inodes,
+// and sets the signature of the revision, if signed.
+func (idb *ImmuDbClient) contentWrittenBy(ctx context.Context, inumber int64, tx uint64, rev *Revision) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, idb.readTimeout.Load())
+	defer cancel()
+
+	var content, signature []byte
+	var signer, checksum, tier sql.NullString
+	err := idb.cl.QueryRowContext(ctx, fmt.Sprintf("SELECT content, signature, signer, checksum, tier FROM content SINCE TX %d UNTIL TX %d WHERE inumber=?", tx, tx), inumber).
+		Scan(&content, &signature, &signer, &checksum, &tier)
+	if err != nil {
+		idb.log.Errorf("could not read inode %d content written by tx %d: %s", inumber, tx, err)
+
+		return nil, wrapErr(err)
+	}
+	// The signature covers the content, wherever stored.
+	if tier.Valid {
+		if content, err = idb.readTier(ctx, inumber, tier.String, checksum); err != nil {
+			return nil, err
+		}
+	}
+	if signer.Valid {
+		rev.Signature = &ContentSignature{Signer: signer.String, Valid: verifySignature(inumber, content, signer.String, signature)}
+	}
+
+	return content, nil
+}
+
+// contentWritten tells whether the content row of an inode was written between two transactions (included).
+func (idb *ImmuDbClient) contentWritten(ctx context.Context, inumber int64, since, until uint64) (bool, error) {
+	var found int64
+	err := idb.cl.QueryRowContext(ctx, fmt.Sprintf("SELECT inumber FROM content SINCE TX %d UNTIL TX %d WHERE inumber=?", since, until), inumber).Scan(&found)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		idb.log.Errorf("could not look for the writes of inode %d content between tx %d and %d: %s", inumber, since, until, err)
+
+		return false, wrapErr(err)
+	}
+
+	return true, nil
+}
+
+// lastContentWrite returns the last transaction between from and to (included) which wrote the content row of
+// an inode, 0 if none. SQL queries don't return the transactions of the rows, so it is looked for by bisection.
+func (idb *ImmuDbClient) lastContentWrite(ctx context.Context, inumber int64, from, to uint64) (uint64, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	if from > to {
+		return 0, nil
+	}
+	written, err := idb.contentWritten(ctx, inumber, from, to)
+	if err != nil || !written {
+		return 0, err
+	}
+
+	lo, hi := from, to
+	for lo < hi {
+		mid := lo + (hi-lo+1)/2
+		written, err := idb.contentWritten(ctx, inumber, mid, to)
+		if err != nil {
+			return 0, err
+		}
+		if written {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	return lo, nil
+}
+
+// firstContentWrite returns the first transaction between from and to (included) which wrote the content row of
+// an inode, 0 if none, looked for by bisection like lastContentWrite.
+func (idb *ImmuDbClient) firstContentWrite(ctx context.Context, inumber int64, from, to uint64) (uint64, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	if from > to {
+		return 0, nil
+	}
+	written, err := idb.contentWritten(ctx, inumber, from, to)
+	if err != nil || !written {
+		return 0, err
+	}
+
+	lo, hi := from, to
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		written, err := idb.contentWritten(ctx, inumber, from, mid)
+		if err != nil {
+			return 0, err
+		}
+		if written {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	return lo, nil
+}
@@ -3,18 +3,22 @@ package fs
 import (
 	"context"
 	"errors"
+	"fmt"
 	"immufs/pkg/config"
 	"io"
 	"math"
 	"os"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jacobsa/fuse"
 	"github.com/jacobsa/fuse/fuseops"
 	"github.com/jacobsa/fuse/fuseutil"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 )
 
 // Immufs is a filesystem backed by Immudb. All inodes are kept in the `inode` table.
@@ -22,36 +26,252 @@ import (
 type Immufs struct {
 	fuseutil.NotImplementedFileSystem
 
+	// Storage of the filesystem.
+	backend Backend
+	// The immudb client storing the filesystem, for the features beyond the Backend interface: snapshots,
+	// versions, quotas, locks... Nil if the filesystem is stored by another backend: these features are
+	// then not available.
 	idb *ImmuDbClient
 	log *logrus.Entry
 
 	uid uint32
 	gid uint32
 
+	// Look up names regardless of their case, while preserving the stored names.
+	caseInsensitive bool
+
+	// Never write to immudb, e.g. when mounting a replica. Mutating operations fail with EROFS.
+	readOnly bool
+	// Name of the snapshot served, if any: the mount is then read-only, and frozen.
+	snapshot string
+
+	// Move unlinked files to the trash directory instead of deleting them.
+	trash bool
+
+	// Write-once-read-many: files are sealed once closed after being written.
+	worm bool
+
+	// Number of past versions of a file that can be accessed as "<name>@v<revision>", 0 for all.
+	versionRetention int
+
+	// Past versions known to the kernel, by inode ID and by version.
+	//
+	// GUARDED_BY(mu)
+	versions         map[fuseops.InodeID]versionInode
+	versionIDs       map[versionInode]fuseops.InodeID
+	nextVersionInode fuseops.InodeID
+
+	// Open file handles, by ID.
+	//
+	// GUARDED_BY(mu)
+	handles    map[fuseops.HandleID]*fileHandle
+	nextHandle fuseops.HandleID
+
+	// Watches the transactions committed by other mounts of the same database, if enabled.
+	watcher       *txWatcher
+	watchInterval time.Duration
+
+	// How long the kernel may cache the attributes and the entries (see AttrTimeout in the configuration).
+	attrTimeout  time.Duration
+	entryTimeout time.Duration
+
+	// Operations served without a calling process, i.e. issued by the kernel itself (see KernelOps in the
+	// configuration).
+	kernelOps map[string]bool
+
+	// Flushes and compacts the immudb index, if enabled.
+	maintainer *maintainer
+
+	// Folds the journaled writes into the content of the files, if enabled.
+	compactor *compactor
+
+	// Moves the contents of the cold files to the tier, if enabled.
+	tierer *tierer
+
+	// Gathers the storage statistics of the database, if enabled.
+	storage *storageMonitor
+
+	// Publishes the changes to the configured sink, if any.
+	events *eventPublisher
+
+	// Notifies the configured hook when the mount is unhealthy, if any.
+	alerter *alerter
+
+	// Writes the timestamps coalesced by writeTimes, if enabled.
+	attrFlusher *attrFlusher
+
+	// Timestamps not written yet, by inode.
+	//
+	// GUARDED_BY(mu)
+	pendingTimes map[fuseops.InodeID]pendingTimes
+
+	// Keep the access times in memory, by inode, rather than writing them.
+	volatileAtime bool
+	// GUARDED_BY(mu)
+	atimes map[fuseops.InodeID]time.Time
+
+	// Inodes changed since they were last opened, as reported by the watcher.
+	//
+	// GUARDED_BY(mu)
+	remoteChanges map[fuseops.InodeID]bool
+
+	// Inodes known to have no capabilities, as looked for by clearCapabilities.
+	//
+	// GUARDED_BY(mu)
+	noCapabilities map[fuseops.InodeID]bool
+
+	// Connection of the mount, to push the remote changes to the kernel: nil until served.
+	//
+	// GUARDED_BY(mu)
+	notifier kernelNotifier
+
+	// Live statistics, reported by .immufs/stats.
+	counters counters
+
+	// Quotas of the users owning inodes and of the directory trees they belong to.
+	//
+	// GUARDED_BY(mu)
+	quotas map[quotaKey]*quotaEntry
+
+	// Times reported for the inodes of the .immufs directory.
+	mountTime time.Time
+
+	// Inodes, directory entries and contents kept in memory, if any.
+	cache *cachedBackend
+
+	// Journals the changes while immudb is unreachable, if enabled.
+	disconnected *disconnectedBackend
+
+	// Deadlines of the queries to the backend, if it supports them, changed on reload.
+	timeouts timeoutSetter
+
+	// Reads ahead the inodes of the directories listed into the cache, if any and the backend supports it.
+	prefetcher *prefetcher
+
+	// Inumbers reserved at once by the mount, if more than one and the backend supports it, and those left to
+	// hand out.
+	inumberBatch int64
+	allocator    rangeAllocator
+	// GUARDED_BY(mu)
+	inumbers inumberRange
+
 	mu sync.Mutex
 }
 
+// Poll interval for changes, when the mount is read-only and no interval was configured.
+const defaultFollowerInterval = 5 * time.Second
+
 // Immufs constructor
 func NewImmufs(ctx context.Context, cfg *config.Config, logger *logrus.Logger) (*Immufs, error) {
 	log := logger.WithField("component", "immufs")
-	cl, err := NewImmuDbClient(ctx, cfg, logger)
+	kernelOps, err := newKernelOps(cfg.KernelOps)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Snapshot != "" && cfg.WatchInterval > 0 {
+		return nil, fmt.Errorf("snapshot %s can't be watched: it never changes", cfg.Snapshot)
+	}
+	if cfg.TierInterval > 0 && (cfg.TierURL == "" || cfg.TierAfter <= 0) {
+		return nil, errors.New("moving the cold files to the tier requires tier-url and tier-after")
+	}
+	backend, err := NewBackend(ctx, cfg, logger)
 	if err != nil {
-		return nil, errors.New("failed to create immudb client: " + err.Error())
+		return nil, err
+	}
+	idb, _ := backend.(*ImmuDbClient)
+	timeouts, _ := backend.(timeoutSetter)
+	if options := sqlFeatures(cfg); idb == nil && len(options) > 0 {
+		backend.Destroy(ctx)
+
+		return nil, fmt.Errorf("the %s backend does not support %s", cfg.Backend, strings.Join(options, ", "))
+	}
+	if cfg.Snapshot != "" {
+		asOf, err := idb.asOfSnapshot(ctx, cfg.Snapshot)
+		if err != nil {
+			backend.Destroy(ctx)
+
+			return nil, err
+		}
+		log.Infof("serving snapshot %s, as of TX=%d", cfg.Snapshot, asOf.tx)
+		backend = asOf
+	}
+
+	// Only the mount reads through the disk cache, not the subcommands, which would share its directory.
+	if cfg.DiskCache != "" {
+		if idb.diskCache, err = newDiskCache(cfg.DiskCache, cfg.DiskCacheSize<<20, log); err != nil {
+			backend.Destroy(ctx)
+
+			return nil, fmt.Errorf("disk cache: %w", err)
+		}
+	}
+
+	allocator, _ := backend.(rangeAllocator)
+	getter, _ := backend.(inodesGetter)
+	var cache *cachedBackend
+	if cfg.CacheSize > 0 {
+		cache = newCachedBackend(backend, cfg.CacheSize<<20)
+		backend = cache
 	}
+	var disconnected *disconnectedBackend
+	if cfg.DisconnectedJournal != "" {
+		if cache == nil {
+			backend.Destroy(ctx)
 
+			return nil, errors.New("the disconnected mode serves the reads from the cache: set its size")
+		}
+		if disconnected, err = newDisconnectedBackend(cache, idb, cfg, log); err != nil {
+			backend.Destroy(ctx)
+
+			return nil, fmt.Errorf("disconnected journal: %w", err)
+		}
+		disconnected.Start()
+		backend = disconnected
+	}
+
+	// The files created are owned by the configured uid and gid, squashed if root.
+	squash := newRootSquash(cfg)
 	fs := &Immufs{
-		idb: cl,
-		log: log,
-		uid: cfg.Uid,
-		gid: cfg.Gid,
+		backend: backend,
+		idb:     idb,
+		log:     log,
+		uid:     squash.uid(cfg.Uid),
+		gid:     squash.gid(cfg.Gid),
+		handles: make(map[fuseops.HandleID]*fileHandle),
+
+		caseInsensitive: cfg.CaseInsensitive,
+		readOnly:        cfg.ReadOnly || cfg.Snapshot != "",
+		snapshot:        cfg.Snapshot,
+		trash:           cfg.Trash,
+		worm:            cfg.Worm,
+
+		versionRetention: cfg.VersionRetention,
+		versions:         make(map[fuseops.InodeID]versionInode),
+		versionIDs:       make(map[versionInode]fuseops.InodeID),
+		watchInterval:    cfg.WatchInterval,
+		remoteChanges:    make(map[fuseops.InodeID]bool),
+		noCapabilities:   make(map[fuseops.InodeID]bool),
+		pendingTimes:     make(map[fuseops.InodeID]pendingTimes),
+		volatileAtime:    cfg.VolatileAtime,
+		atimes:           make(map[fuseops.InodeID]time.Time),
+		quotas:           make(map[quotaKey]*quotaEntry),
+		mountTime:        time.Now(),
+		inumberBatch:     cfg.InumberBatch,
+		allocator:        allocator,
+		cache:            cache,
+		disconnected:     disconnected,
+		timeouts:         timeouts,
+		kernelOps:        kernelOps,
 	}
 
 	// Lookup root
-	_, err = fs.idb.GetInode(ctx, 1)
+	_, err = fs.backend.GetInode(ctx, 1)
 	if err != nil {
 		if !errors.Is(err, ErrInodeNotFound) {
 			return nil, err
 		}
+		if fs.readOnly {
+			return nil, errors.New("root inode not found: the database has not been initialized yet")
+		}
 
 		// Set up the root inode.
 		rootAttrs := fuseops.InodeAttributes{
@@ -61,53 +281,409 @@ func NewImmufs(ctx context.Context, cfg *config.Config, logger *logrus.Logger) (
 			Nlink: 1,
 		}
 		// Adding root if not exists
-		root := NewInode(fuseops.RootInodeID, rootAttrs, fs.idb)
-		rootEnts := make([]fuseutil.Dirent, 0)
-		root.writeChildrenOrDie(rootEnts)
+		if _, err := NewInode(ctx, fuseops.RootInodeID, 1, 0, rootAttrs, fs.backend); err != nil {
+			return nil, err
+		}
 		fs.log.Info("root inode created")
 	}
 
+	// A read-only mount usually follows a replica: refresh the view as replication advances.
+	if fs.readOnly && fs.watchInterval == 0 && fs.snapshot == "" {
+		fs.watchInterval = defaultFollowerInterval
+	}
+
+	if fs.watchInterval > 0 {
+		fs.watcher = newTxWatcher(fs.idb, fs.log, fs.watchInterval, fs.invalidate)
+		if err := fs.watcher.Start(ctx); err != nil {
+			return nil, err
+		}
+		fs.log.Infof("watching remote changes every %s", fs.watchInterval)
+	}
+
+	fs.attrTimeout, fs.entryTimeout = fs.cacheTimeout(cfg.AttrTimeout), fs.cacheTimeout(cfg.EntryTimeout)
+
+	if cfg.IndexFlushInterval > 0 || cfg.IndexCompactInterval > 0 {
+		if fs.readOnly {
+			fs.log.Warnf("index maintenance disabled on read-only mounts")
+		} else {
+			fs.maintainer = newMaintainer(fs.idb, fs.log, cfg.IndexFlushInterval, cfg.IndexCompactInterval)
+			fs.maintainer.Start()
+		}
+	}
+
+	if fs.cache != nil && getter != nil {
+		fs.prefetcher = newPrefetcher(fs.cache, getter, fs.log)
+		fs.prefetcher.Start()
+	}
+
+	if cfg.JournalCompactInterval > 0 {
+		if fs.readOnly {
+			fs.log.Warnf("journal compaction disabled on read-only mounts")
+		} else {
+			fs.compactor = newCompactor(fs.idb, fs.log, cfg.JournalCompactInterval, cfg.JournalCompactDeltas)
+			fs.compactor.Start()
+		}
+	}
+
+	if cfg.TierInterval > 0 {
+		if fs.readOnly {
+			fs.log.Warnf("tiering disabled on read-only mounts")
+		} else {
+			fs.tierer = newTierer(fs.idb, fs.log, cfg.TierInterval, TierPolicy{After: cfg.TierAfter, MinSize: cfg.TierMinSize})
+			fs.tierer.Start()
+		}
+	}
+
+	if cfg.AttrFlushInterval > 0 && !fs.readOnly {
+		fs.attrFlusher = newAttrFlusher(fs, fs.log, cfg.AttrFlushInterval)
+		fs.attrFlusher.Start()
+	}
+
+	if cfg.StorageStatsInterval > 0 {
+		fs.storage = newStorageMonitor(fs.idb, fs.log, cfg.StorageStatsInterval)
+		fs.storage.Start()
+	}
+
+	if cfg.EventsURL != "" {
+		sink, err := NewEventSink(cfg.EventsURL)
+		if err != nil {
+			return nil, err
+		}
+		fs.events = newEventPublisher(sink, fs.log)
+	}
+
+	if cfg.AlertHook != "" {
+		if cfg.AlertInterval <= 0 {
+			return nil, errors.New("the interval between health checks must be positive")
+		}
+		hook, err := NewAlertHook(cfg.AlertHook)
+		if err != nil {
+			return nil, err
+		}
+		fs.alerter = newAlerter(fs, hook, cfg.AlertInterval, cfg.AlertDBErrors, cfg.AlertVerifyFailures, cfg.AlertFlushBacklog)
+		fs.alerter.Start()
+	}
+
 	return fs, nil
 }
 
+// WaitIdle returns once the filesystem can serve an operation: it waits for the lock the operations take, so that
+// it blocks as long as an operation hangs holding it.
+func (fs *Immufs) WaitIdle() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+}
+
+// Destroy stops the background activities and closes the connection to immudb.
+// It is called once the filesystem is unmounted.
+func (fs *Immufs) Destroy() {
+	if fs.attrFlusher != nil {
+		fs.attrFlusher.Stop()
+	}
+	if fs.alerter != nil {
+		fs.alerter.Stop()
+	}
+	if fs.watcher != nil {
+		fs.watcher.Stop()
+	}
+	if fs.events != nil {
+		fs.events.Stop()
+	}
+	if fs.maintainer != nil {
+		fs.maintainer.Stop()
+	}
+	if fs.compactor != nil {
+		fs.compactor.Stop()
+	}
+	if fs.tierer != nil {
+		fs.tierer.Stop()
+	}
+	if fs.storage != nil {
+		fs.storage.Stop()
+	}
+	if fs.prefetcher != nil {
+		fs.prefetcher.Stop()
+	}
+
+	if err := fs.backend.Destroy(context.TODO()); err != nil {
+		fs.log.Errorf("could not close immudb client: %s", err)
+	}
+}
+
 ////////////////////////////////////////////////////////////////////////
 // Utilities
 ////////////////////////////////////////////////////////////////////////
 
-// Find the given inode. Panic if it doesn't exist.
+// errno converts an error coming from the storage layer into the error returned to the kernel.
+// Any backend failure, timeouts included, is reported as EIO.
+func (fs *Immufs) errno(api string, err error) error {
+	var e syscall.Errno
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, ErrInodeNotFound):
+		fs.log.WithField("API", api).Warningf("%s", err)
+
+		return fuse.ENOENT
+	case errors.Is(err, ErrXattrNotFound):
+		return fuse.ENOATTR
+	case errors.Is(err, ErrNotSupported):
+		fs.log.WithField("API", api).Warningf("%s", err)
+
+		return syscall.ENOTSUP
+	case errors.Is(err, ErrChecksumMismatch):
+		fs.log.WithField("API", api).Errorf("%s", err)
+
+		return fuse.EIO
+	case errors.Is(err, ErrNoInumbers):
+		fs.log.WithField("API", api).Errorf("%s", err)
+
+		return syscall.ENOSPC
+	case errors.Is(err, ErrJournalFull):
+		fs.log.WithField("API", api).Warningf("%s", err)
+
+		return syscall.ENOSPC
+	case errors.Is(err, ErrReplayConflict):
+		fs.log.WithField("API", api).Warningf("%s", err)
+
+		return syscall.EBUSY
+	default:
+		fs.log.WithField("API", api).Errorf("backend failure: %s", err)
+		fs.counters.backendErrors.Add(1)
+
+		return fuse.EIO
+	}
+}
+
+// cacheTimeout returns how long the kernel may cache attributes or entries, as configured. By default, it may
+// cache them as long as it wants: we don't spontaneously mutate, unless other mounts write the same database. In
+// that case the cache must expire in time to see their changes.
+func (fs *Immufs) cacheTimeout(configured *time.Duration) time.Duration {
+	switch {
+	case configured != nil:
+		return *configured
+	case fs.watcher != nil:
+		return fs.watchInterval
+	default:
+		return 365 * 24 * time.Hour
+	}
+}
+
+// attrExpiration returns the time until which the kernel may cache attributes.
+func (fs *Immufs) attrExpiration() time.Time {
+	return time.Now().Add(fs.attrTimeout)
+}
+
+// entryExpiration returns the time until which the kernel may cache entries.
+func (fs *Immufs) entryExpiration() time.Time {
+	return time.Now().Add(fs.entryTimeout)
+}
+
+// invalidate records the inodes changed by other mounts since sinceTx, so that their cached content is
+// dropped when they are opened again, drops them from the cache of the mount, and pushes the changes to
+// the kernel if the connection is known (see NotifyingServer).
+func (fs *Immufs) invalidate(inumbers []int64, sinceTx uint64) {
+	fs.mu.Lock()
+	for _, inumber := range inumbers {
+		fs.remoteChanges[fuseops.InodeID(inumber)] = true
+		delete(fs.noCapabilities, fuseops.InodeID(inumber))
+	}
+	if fs.cache != nil {
+		fs.cache.invalidate(inumbers)
+	}
+	notifier := fs.notifier
+	fs.mu.Unlock()
+
+	if notifier != nil {
+		fs.notifyKernel(notifier, inumbers, sinceTx)
+	}
+}
+
+// updateAtime sets the access time of the inode to now and persists it, possibly coalesced with the next
+// timestamp changes (see writeTimes). Read-only mounts leave it untouched; with volatileAtime it is only
+// kept in memory.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) updateAtime(inode *Inode) error {
+	if fs.readOnly {
+		return nil
+	}
+
+	inode.Atime = time.Now()
+	if fs.volatileAtime {
+		fs.atimes[fuseops.InodeID(inode.Inumber)] = inode.Atime
+
+		return nil
+	}
+
+	return fs.writeTimes(inode)
+}
+
+// checkWritable fails with EROFS on read-only mounts.
+func (fs *Immufs) checkWritable(api string) error {
+	if fs.readOnly {
+		fs.log.WithField("API", api).Warningf("Read-only filesystem")
+
+		return syscall.EROFS
+	}
+
+	return nil
+}
+
+// checkMutable returns EPERM if the inode can be neither modified nor deleted, i.e. if it
+// is sealed or locked.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) checkMutable(api string, inode *Inode) error {
+	if inode.Sealed {
+		fs.log.WithField("API", api).Warningf("Inode %d is sealed", inode.Inumber)
+
+		return syscall.EPERM
+	}
+
+	return fs.checkLock(api, inode)
+}
+
+// Extended attributes with this prefix are computed by Immufs, and cannot be set.
+const virtualXattrPrefix = "user.immufs."
+
+// Virtual extended attribute reporting the creation time of an inode. It is not listed.
+const crtimeXattr = virtualXattrPrefix + "crtime"
+
+// maxNameLen is the maximum length in bytes of a directory entry name.
+const maxNameLen = 255
+
+// validateName checks that a name can be safely stored in a directory. Dirents are
+// JSON-encoded, hence names that are not valid UTF-8 would be silently altered.
+func validateName(name string) error {
+	switch {
+	case len(name) > maxNameLen:
+		return syscall.ENAMETOOLONG
+	case name == "" || name == "." || name == "..":
+		return fuse.EINVAL
+	case strings.ContainsAny(name, "/\x00"):
+		return fuse.EINVAL
+	case !utf8.ValidString(name):
+		return fuse.EINVAL
+	}
+
+	return nil
+}
+
+// Find the given inode.
 //
 // LOCKS_REQUIRED(fs.mu)
-func (fs *Immufs) getInodeOrDie(id fuseops.InodeID) *Inode {
-	inode, err := fs.idb.GetInode(context.TODO(), int64(id))
+func (fs *Immufs) getInode(ctx context.Context, id fuseops.InodeID) (*Inode, error) {
+	inode, err := fs.loadInode(ctx, id)
 	if err != nil {
-		fs.log.Panicf("could not get inode %d: %s", id, err)
+		return nil, err
 	}
+	fs.overlayTimes(inode)
 
-	return inode
+	return inode, nil
 }
 
-// nextInumber calculates the next available inumber. The function takes the maximum inumber from the db and increments it by 1.
-// In this implementation, inodes are never re-used.
+// readInode finds the given inode as getInode does, without holding fs.mu while the backend is read, for the
+// operations only reading it.
+//
+// LOCKS_EXCLUDED(fs.mu)
+func (fs *Immufs) readInode(ctx context.Context, id fuseops.InodeID) (*Inode, error) {
+	inode, err := fs.loadInode(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	fs.overlayTimes(inode)
+	fs.mu.Unlock()
+
+	return inode, nil
+}
+
+// loadInode reads an inode from the backend, without the timestamps kept by the mount.
+func (fs *Immufs) loadInode(ctx context.Context, id fuseops.InodeID) (*Inode, error) {
+	// The control inodes only support the operations handled by control.go.
+	if isControlInode(id) {
+		return nil, syscall.EPERM
+	}
+	// Past versions are read-only.
+	if isVersionInode(id) {
+		return nil, syscall.EROFS
+	}
+
+	inode, err := fs.backend.GetInode(ctx, int64(id))
+	if err != nil {
+		fs.log.Errorf("could not get inode %d: %s", id, err)
+
+		return nil, err
+	}
+	inode.foldCase = fs.caseInsensitive
+
+	return inode, nil
+}
+
+// nextInumber returns the next inumber that will be allocated. Inumbers are never re-used.
 //
 // LOCKS_REQUIRED(fs.mu)
-func (fs *Immufs) nextInumber() int64 {
-	next, err := fs.idb.NextInumber(context.TODO())
+func (fs *Immufs) nextInumber(ctx context.Context) (int64, error) {
+	next, err := fs.backend.NextInumber(ctx)
 	if err != nil {
-		fs.log.Panic("could not get an available inumber: %s", err)
+		fs.log.Errorf("could not get an available inumber: %s", err)
+
+		return -1, err
+	}
+
+	return next, nil
+}
+
+// inumberRange is a range of inumbers reserved by the mount, handed out to the inodes it creates.
+type inumberRange struct {
+	// Next inumber to hand out, and the one following the range.
+	next, end  int64
+	generation int64
+}
+
+// allocateInumber returns an inumber never used before, with its generation. With inumberBatch, the inumbers
+// are reserved by ranges, saving the round trip to the backend of most creations, when the backend supports it.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) allocateInumber(ctx context.Context) (inumber int64, generation int64, err error) {
+	if fs.inumberBatch <= 1 || fs.allocator == nil {
+		return fs.backend.AllocateInumber(ctx)
+	}
+
+	if fs.inumbers.next == fs.inumbers.end {
+		first, generation, err := fs.allocator.AllocateInumbers(ctx, fs.inumberBatch)
+		if err != nil {
+			return -1, 0, err
+		}
+		fs.inumbers = inumberRange{next: first, end: first + fs.inumberBatch, generation: generation}
 	}
+	inumber = fs.inumbers.next
+	fs.inumbers.next++
 
-	return next
+	return inumber, fs.inumbers.generation, nil
 }
 
-// Allocate a new inode, assigning it an ID that is not in use.
+// Allocate a new inode, assigning it an ID that is not in use. The inode is charged to the
+// given project, i.e. to the quota of the directory tree it is created in.
 //
 // LOCKS_REQUIRED(fs.mu)
-func (fs *Immufs) allocateInode(
-	attrs fuseops.InodeAttributes) (id fuseops.InodeID, inode *Inode) {
+func (fs *Immufs) allocateInode(ctx context.Context,
+	attrs fuseops.InodeAttributes, project int64) (id fuseops.InodeID, inode *Inode, err error) {
+	inumber, generation, err := fs.allocateInumber(ctx)
+	if err != nil {
+		fs.log.Errorf("could not allocate an inumber: %s", err)
+
+		return 0, nil, err
+	}
+
 	// Create the inode.
-	inode = NewInode(fs.nextInumber(), attrs, fs.idb)
+	inode, err = NewInode(ctx, inumber, generation, project, attrs, fs.backend)
+	if err != nil {
+		return 0, nil, err
+	}
 
-	return fuseops.InodeID(inode.Inumber), inode
+	return fuseops.InodeID(inode.Inumber), inode, nil
 }
 
 ////////////////////////////////////////////////////////////////////////
@@ -118,6 +694,7 @@ func (fs *Immufs) StatFS(
 	ctx context.Context,
 	op *fuseops.StatFSOp) error {
 	fs.log.Infof("--> StatFS")
+	fs.countOp("StatFS")
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -125,7 +702,7 @@ func (fs *Immufs) StatFS(
 	op.BlockSize = 1
 	op.Blocks = uint64(math.Pow(2, 31)) // Max FS size is 2GB
 
-	space, err := fs.idb.SpaceUsed(context.TODO())
+	space, err := fs.backend.SpaceUsed(ctx)
 	if err != nil {
 		space = 0 // We decide that in case of error the FS appears empty
 	}
@@ -134,7 +711,11 @@ func (fs *Immufs) StatFS(
 
 	op.IoSize = 1
 
-	op.Inodes = uint64(fs.nextInumber() - 1)
+	next, err := fs.nextInumber(ctx)
+	if err != nil {
+		return fs.errno("StatFS", err)
+	}
+	op.Inodes = uint64(next - 1)
 	op.InodesFree = math.MaxInt64 - op.Inodes
 
 	fs.log.WithField("API", "StatFS").Debugf("Stat: %+v", op)
@@ -146,44 +727,72 @@ func (fs *Immufs) LookUpInode(
 	ctx context.Context,
 	op *fuseops.LookUpInodeOp) error {
 	fs.log.Infof("--> LookupInode: %s in parent inode: %d", op.Name, op.Parent)
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "LookupInode").Warningf("Invalid PID 0")
-
-		return fuse.EINVAL
+	fs.countOp("LookUpInode")
+	if err := fs.checkPid("LookUpInode", op.OpContext); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	if entry, handled, err := fs.lookUpControl(op.Parent, op.Name); handled {
+		op.Entry = entry
+
+		return err
+	}
+
 	// Grab the parent directory.
-	inode := fs.getInodeOrDie(op.Parent)
+	inode, err := fs.getInode(ctx, op.Parent)
+	if err != nil {
+		return fs.errno("LookupInode", err)
+	}
 
 	// Does the directory have an entry with the given name?
-	childID, _, ok := inode.LookUpChild(op.Name)
+	childID, _, ok, err := inode.LookUpChild(op.Name)
+	if err != nil {
+		return fs.errno("LookupInode", err)
+	}
 	if !ok {
+		// Is it a past version of a file?
+		if entry, handled, err := fs.lookUpVersion(inode, op.Name); handled {
+			if err != nil {
+				return fs.errno("LookupInode", err)
+			}
+			op.Entry = entry
+
+			return nil
+		}
+
 		fs.log.WithField("API", "LookupInode").Warningf("Entry %s not found", op.Name)
 
 		return fuse.ENOENT
 	}
 
 	// Grab the child.
-	child := fs.getInodeOrDie(childID)
-
-	// Increment ref cnt
-	child.Nlink++
+	child, err := fs.getInode(ctx, childID)
+	if err != nil {
+		return fs.errno("LookupInode", err)
+	}
 
-	// Update access time
-	child.Atime = time.Now()
-	child.writeOrDie()
+	// Increment ref cnt and update access time. Read-only mounts don't track references.
+	// The reference count must be persisted: the inode is written right away.
+	if !fs.readOnly {
+		child.Nlink++
+		child.Atime = time.Now()
+		if err := child.write(); err != nil {
+			return fs.errno("LookupInode", err)
+		}
+	}
 
 	// Fill in the response.
 	op.Entry.Child = childID
+	op.Entry.Generation = fuseops.GenerationNumber(child.Generation)
 	op.Entry.Attributes = child.Attributes()
 
-	// We don't spontaneously mutate, so the kernel can cache as long as it wants
-	// (since it also handles invalidation).
-	op.Entry.AttributesExpiration = time.Now().Add(365 * 24 * time.Hour)
-	op.Entry.EntryExpiration = op.Entry.AttributesExpiration
+	// The kernel can cache as long as the configured timeouts allow (since it also
+	// handles invalidation of local changes).
+	op.Entry.AttributesExpiration = fs.attrExpiration()
+	op.Entry.EntryExpiration = fs.entryExpiration()
 
 	fs.log.WithField("API", "LookupInode").Infof("Inode found: %+v", *op)
 
@@ -194,28 +803,48 @@ func (fs *Immufs) GetInodeAttributes(
 	ctx context.Context,
 	op *fuseops.GetInodeAttributesOp) error {
 	fs.log.Infof("--> GetInodeAttributes: %d", op.Inode)
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "GetInodeAttributes").Warningf("Invalid PID 0")
-
-		return fuse.EINVAL
+	fs.countOp("GetInodeAttributes")
+	if err := fs.checkPid("GetInodeAttributes", op.OpContext); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	if isControlInode(op.Inode) {
+		op.Attributes = fs.controlAttributes(op.Inode)
+		op.AttributesExpiration = fs.attrExpiration()
+
+		return nil
+	}
+	if isVersionInode(op.Inode) {
+		file, v, err := fs.getVersion(op.Inode)
+		if err != nil {
+			return fs.errno("GetInodeAttributes", err)
+		}
+		op.Attributes = versionAttributes(file, v)
+		op.AttributesExpiration = fs.attrExpiration()
+
+		return nil
+	}
+
 	// Grab the inode.
-	inode := fs.getInodeOrDie(op.Inode)
+	inode, err := fs.getInode(ctx, op.Inode)
+	if err != nil {
+		return fs.errno("GetInodeAttributes", err)
+	}
 
 	// Fill in the response.
 	op.Attributes = inode.Attributes()
 
-	// We don't spontaneously mutate, so the kernel can cache as long as it wants
-	// (since it also handles invalidation).
-	op.AttributesExpiration = time.Now().Add(365 * 24 * time.Hour)
+	// The kernel can cache as long as the configured timeouts allow (since it also
+	// handles invalidation of local changes).
+	op.AttributesExpiration = fs.attrExpiration()
 
 	// Update atime
-	inode.Atime = time.Now()
-	inode.writeOrDie()
+	if err := fs.updateAtime(inode); err != nil {
+		return fs.errno("GetInodeAttributes", err)
+	}
 
 	fs.log.WithField("API", "GetInodeAttributes").Infof("Attributes got: %+v", *op)
 	return nil
@@ -225,10 +854,24 @@ func (fs *Immufs) SetInodeAttributes(
 	ctx context.Context,
 	op *fuseops.SetInodeAttributesOp) error {
 	fs.log.Infof("--> SetInodeAttributes")
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "SetInodeAttributes").Warningf("Invalid PID 0")
+	fs.countOp("SetInodeAttributes")
+	if err := fs.checkPid("SetInodeAttributes", op.OpContext); err != nil {
+		return err
+	}
 
-		return fuse.EINVAL
+	// Control files have no content to truncate: ignore the request, which comes from open(O_TRUNC).
+	if isControlInode(op.Inode) {
+		op.Attributes = fs.controlAttributes(op.Inode)
+		op.AttributesExpiration = fs.attrExpiration()
+
+		return nil
+	}
+	if isVersionInode(op.Inode) {
+		return syscall.EROFS
+	}
+
+	if err := fs.checkWritable("SetInodeAttributes"); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
@@ -243,19 +886,60 @@ func (fs *Immufs) SetInodeAttributes(
 	}
 
 	// Grab the inode.
-	inode := fs.getInodeOrDie(op.Inode)
+	inode, ierr := fs.getInode(ctx, op.Inode)
+	if ierr != nil {
+		return fs.errno("SetInodeAttributes", ierr)
+	}
+	if ierr := fs.checkMutable("SetInodeAttributes", inode); ierr != nil {
+		return fs.errno("SetInodeAttributes", ierr)
+	}
+
+	if op.Uid != nil || op.Gid != nil {
+		if ierr := fs.clearCapabilities(inode); ierr != nil {
+			return fs.errno("SetInodeAttributes", ierr)
+		}
+	}
 
-	// Handle the request.
-	inode.SetAttributes(op.Size, op.Mode, op.Mtime)
+	// Growing a file is subject to the quota of its owner.
+	oldSize := inode.Size
+	if op.Size != nil && int64(*op.Size) > oldSize {
+		if qerr := fs.checkQuota("SetInodeAttributes", uint32(inode.Uid), inode.Project, 0, int64(*op.Size)-oldSize); qerr != nil {
+			return fs.errno("SetInodeAttributes", qerr)
+		}
+	}
+
+	// Handle the request. Changing only the timestamps, e.g. touch, may be coalesced with the next changes.
+	if op.Atime != nil {
+		// An explicit access time replaces the one kept in memory.
+		delete(fs.atimes, op.Inode)
+	}
+	if op.Size == nil && op.Mode == nil {
+		inode.Ctime = time.Now()
+		if op.Atime != nil {
+			inode.Atime = *op.Atime
+		}
+		if op.Mtime != nil {
+			inode.Mtime = *op.Mtime
+		}
+		if ierr := fs.writeTimes(inode); ierr != nil {
+			return fs.errno("SetInodeAttributes", ierr)
+		}
+	} else if ierr := inode.SetAttributes(op.Size, op.Mode, op.Atime, op.Mtime); ierr != nil {
+		return fs.errno("SetInodeAttributes", ierr)
+	}
+	fs.chargeQuota(uint32(inode.Uid), inode.Project, 0, inode.Size-oldSize)
+	if op.Size != nil {
+		fs.publish(Event{Type: "truncate", Inumber: inode.Inumber})
+	}
 
-	// atime is managed by the SetAttributes func
+	// atime and mtime are managed by the SetAttributes func
 
 	// Fill in the response.
 	op.Attributes = inode.Attributes()
 
-	// We don't spontaneously mutate, so the kernel can cache as long as it wants
-	// (since it also handles invalidation).
-	op.AttributesExpiration = time.Now().Add(365 * 24 * time.Hour)
+	// The kernel can cache as long as the configured timeouts allow (since it also
+	// handles invalidation of local changes).
+	op.AttributesExpiration = fs.attrExpiration()
 
 	return err
 }
@@ -264,27 +948,53 @@ func (fs *Immufs) MkDir(
 	ctx context.Context,
 	op *fuseops.MkDirOp) error {
 	fs.log.Infof("--> MkDir: %s", op.Name)
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "MkDir").Warningf("Invalid PID 0")
+	fs.countOp("MkDir")
+	if err := fs.checkPid("MkDir", op.OpContext); err != nil {
+		return err
+	}
 
-		return fuse.EINVAL
+	if err := fs.checkWritable("MkDir"); err != nil {
+		return err
+	}
+
+	if err := validateName(op.Name); err != nil {
+		fs.log.WithField("API", "MkDir").Warningf("Invalid name %q: %s", op.Name, err)
+
+		return err
+	}
+
+	if isControlName(op.Parent, op.Name) {
+		fs.log.WithField("API", "MkDir").Warningf("Entry %s is reserved", op.Name)
+
+		return fuse.EEXIST
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
 	// Grab the parent, which we will update shortly.
-	parent := fs.getInodeOrDie(op.Parent)
+	parent, err := fs.getInode(ctx, op.Parent)
+	if err != nil {
+		return fs.errno("MkDir", err)
+	}
 
 	// Ensure that the name doesn't already exist, so we don't wind up with a
 	// duplicate.
-	_, _, exists := parent.LookUpChild(op.Name)
+	_, _, exists, err := parent.LookUpChild(op.Name)
+	if err != nil {
+		return fs.errno("MkDir", err)
+	}
 	if exists {
 		fs.log.WithField("API", "MkDir").Warningf("Entry %s already exists", op.Name)
 
 		return fuse.EEXIST
 	}
 
+	// New inodes are owned by the uid of the mount, whoever creates them, and so charged.
+	if err := fs.checkQuota("MkDir", fs.uid, parent.Project, 1, 0); err != nil {
+		return fs.errno("MkDir", err)
+	}
+
 	// Set up attributes from the child.
 	now := time.Now()
 	childAttrs := fuseops.InodeAttributes{
@@ -299,19 +1009,27 @@ func (fs *Immufs) MkDir(
 	}
 
 	// Allocate a child.
-	childID, child := fs.allocateInode(childAttrs)
+	childID, child, err := fs.allocateInode(ctx, childAttrs, parent.Project)
+	if err != nil {
+		return fs.errno("MkDir", err)
+	}
 
 	// Add an entry in the parent.
-	parent.AddChild(childID, op.Name, fuseutil.DT_Directory)
+	if err := parent.AddChild(childID, op.Name, fuseutil.DT_Directory); err != nil {
+		return fs.errno("MkDir", err)
+	}
+	fs.chargeQuota(fs.uid, parent.Project, 1, 0)
+	fs.publish(Event{Type: "mkdir", Inumber: child.Inumber, Parent: parent.Inumber, Name: op.Name})
 
 	// Fill in the response.
 	op.Entry.Child = childID
+	op.Entry.Generation = fuseops.GenerationNumber(child.Generation)
 	op.Entry.Attributes = child.Attributes()
 
-	// We don't spontaneously mutate, so the kernel can cache as long as it wants
-	// (since it also handles invalidation).
-	op.Entry.AttributesExpiration = time.Now().Add(365 * 24 * time.Hour)
-	op.Entry.EntryExpiration = op.Entry.AttributesExpiration
+	// The kernel can cache as long as the configured timeouts allow (since it also
+	// handles invalidation of local changes).
+	op.Entry.AttributesExpiration = fs.attrExpiration()
+	op.Entry.EntryExpiration = fs.entryExpiration()
 
 	fs.log.WithField("API", "MkDir").Infof("Directory created: %+v", *op)
 
@@ -322,36 +1040,62 @@ func (fs *Immufs) MkNode(
 	ctx context.Context,
 	op *fuseops.MkNodeOp) error {
 	fs.log.Infof("--> MkNode")
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "MkDir").Warningf("Invalid PID 0")
+	fs.countOp("MkNode")
+	if err := fs.checkPid("MkNode", op.OpContext); err != nil {
+		return err
+	}
 
-		return fuse.EINVAL
+	if err := fs.checkWritable("MkNode"); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
 	var err error
-	op.Entry, err = fs.createFile(op.Parent, op.Name, op.Mode)
+	op.Entry, err = fs.createFile(ctx, op.Parent, op.Name, op.Mode)
 	return err
 }
 
 // LOCKS_REQUIRED(fs.mu)
 func (fs *Immufs) createFile(
+	ctx context.Context,
 	parentID fuseops.InodeID,
 	name string,
 	mode os.FileMode) (fuseops.ChildInodeEntry, error) {
+	if err := validateName(name); err != nil {
+		fs.log.WithField("API", "createFile").Warningf("Invalid name %q: %s", name, err)
+
+		return fuseops.ChildInodeEntry{}, err
+	}
+	if isControlName(parentID, name) {
+		fs.log.WithField("API", "createFile").Warningf("Entry %s is reserved", name)
+
+		return fuseops.ChildInodeEntry{}, fuse.EEXIST
+	}
+
 	// Grab the parent, which we will update shortly.
-	parent := fs.getInodeOrDie(parentID)
+	parent, err := fs.getInode(ctx, parentID)
+	if err != nil {
+		return fuseops.ChildInodeEntry{}, fs.errno("createFile", err)
+	}
 
 	// Ensure that the name doesn't already exist, so we don't wind up with a
 	// duplicate.
-	_, _, exists := parent.LookUpChild(name)
+	_, _, exists, err := parent.LookUpChild(name)
+	if err != nil {
+		return fuseops.ChildInodeEntry{}, fs.errno("createFile", err)
+	}
 	if exists {
 		fs.log.WithField("API", "createFile").Warningf("Entry %s already exists", name)
 		return fuseops.ChildInodeEntry{}, fuse.EEXIST
 	}
 
+	// New inodes are owned by the uid of the mount, whoever creates them, and so charged.
+	if err := fs.checkQuota("createFile", fs.uid, parent.Project, 1, 0); err != nil {
+		return fuseops.ChildInodeEntry{}, fs.errno("createFile", err)
+	}
+
 	// Set up attributes for the child.
 	now := time.Now()
 	childAttrs := fuseops.InodeAttributes{
@@ -366,20 +1110,29 @@ func (fs *Immufs) createFile(
 	}
 
 	// Allocate a child.
-	childID, child := fs.allocateInode(childAttrs)
+	childID, child, err := fs.allocateInode(ctx, childAttrs, parent.Project)
+	if err != nil {
+		return fuseops.ChildInodeEntry{}, fs.errno("createFile", err)
+	}
 
-	// Add an entry in the parent.
-	parent.AddChild(childID, name, fuseutil.DT_File)
+	// Add an entry in the parent. Besides regular files, MkNode may create special files,
+	// such as the character devices used by overlayfs as whiteouts.
+	if err := parent.AddChild(childID, name, direntType(mode)); err != nil {
+		return fuseops.ChildInodeEntry{}, fs.errno("createFile", err)
+	}
+	fs.chargeQuota(fs.uid, parent.Project, 1, 0)
+	fs.publish(Event{Type: "create", Inumber: child.Inumber, Parent: parent.Inumber, Name: name})
 
 	// Fill in the response entry.
 	var entry fuseops.ChildInodeEntry
 	entry.Child = childID
+	entry.Generation = fuseops.GenerationNumber(child.Generation)
 	entry.Attributes = child.Attributes()
 
-	// We don't spontaneously mutate, so the kernel can cache as long as it wants
-	// (since it also handles invalidation).
-	entry.AttributesExpiration = time.Now().Add(365 * 24 * time.Hour)
-	entry.EntryExpiration = entry.AttributesExpiration
+	// The kernel can cache as long as the configured timeouts allow (since it also
+	// handles invalidation of local changes).
+	entry.AttributesExpiration = fs.attrExpiration()
+	entry.EntryExpiration = fs.entryExpiration()
 
 	return entry, nil
 }
@@ -388,17 +1141,30 @@ func (fs *Immufs) CreateFile(
 	ctx context.Context,
 	op *fuseops.CreateFileOp) (err error) {
 	fs.log.Infof("--> CreateFile")
-	if op.OpContext.Pid == 0 {
-		// CreateFileOp should have a valid pid in context.
-		fs.log.WithField("API", "MkDir").Warningf("Invalid PID 0")
-		return fuse.EINVAL
+	fs.countOp("CreateFile")
+	if err := fs.checkPid("CreateFile", op.OpContext); err != nil {
+		return err
+	}
+
+	if err := fs.checkWritable("CreateFile"); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	op.Entry, err = fs.createFile(op.Parent, op.Name, op.Mode)
-	return err
+	op.Entry, err = fs.createFile(ctx, op.Parent, op.Name, op.Mode)
+	if err != nil {
+		return err
+	}
+
+	// The kernel does not tell us the open flags on create: O_APPEND is honoured
+	// by the kernel itself, since the file is empty and only known to this mount.
+	op.Handle = fs.newHandle(op.Entry.Child, false)
+	h, _ := fs.getHandle(op.Handle)
+	h.write = true
+
+	return nil
 }
 
 //NOTE These methods are currently not implemented as we must have a rock solid
@@ -508,50 +1274,124 @@ func (fs *Immufs) Rename(
 	ctx context.Context,
 	op *fuseops.RenameOp) error {
 	fs.log.Infof("--> Rename: %+v", *op)
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "Rename").Warningf("Invalid PID 0")
+	fs.countOp("Rename")
+	if err := fs.checkPid("Rename", op.OpContext); err != nil {
+		return err
+	}
 
-		return fuse.EINVAL
+	if err := fs.checkWritable("Rename"); err != nil {
+		return err
+	}
+
+	if err := validateName(op.NewName); err != nil {
+		fs.log.WithField("API", "Rename").Warningf("Invalid name %q: %s", op.NewName, err)
+
+		return err
+	}
+	if isControlName(op.NewParent, op.NewName) {
+		fs.log.WithField("API", "Rename").Warningf("Entry %s is reserved", op.NewName)
+
+		return fuse.EEXIST
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
 	// Ask the old parent for the child's inode ID and type.
-	oldParent := fs.getInodeOrDie(op.OldParent)
-	childID, childType, ok := oldParent.LookUpChild(op.OldName)
+	oldParent, err := fs.getInode(ctx, op.OldParent)
+	if err != nil {
+		return fs.errno("Rename", err)
+	}
+	childID, childType, ok, err := oldParent.LookUpChild(op.OldName)
+	if err != nil {
+		return fs.errno("Rename", err)
+	}
 
 	if !ok {
 		fs.log.WithField("API", "Rename").Warningf("Entry '%s' not found in parent: %d", op.OldName, op.OldParent)
 
 		return fuse.ENOENT
 	}
+	child, err := fs.getInode(ctx, childID)
+	if err != nil {
+		return fs.errno("Rename", err)
+	}
+	if err := fs.checkMutable("Rename", child); err != nil {
+		return fs.errno("Rename", err)
+	}
 
 	// If the new name exists already in the new parent, make sure it's not a
 	// non-empty directory, then delete it.
-	newParent := fs.getInodeOrDie(op.NewParent)
-	existingID, _, ok := newParent.LookUpChild(op.NewName)
-	if ok {
-		existing := fs.getInodeOrDie(existingID)
+	newParent, err := fs.getInode(ctx, op.NewParent)
+	if err != nil {
+		return fs.errno("Rename", err)
+	}
+
+	// Moving an entry to another directory tree with its own quota would require recharging the
+	// whole subtree: as XFS does for project quotas, let the caller fall back to copy and delete.
+	if oldParent.Project != newParent.Project {
+		fs.log.WithField("API", "Rename").Warningf("Cannot move '%s' across directory quotas", op.OldName)
+
+		return syscall.EXDEV
+	}
 
-		var buf [4096]byte
-		if existing.isDir() && existing.ReadDir(buf[:], 0) > 0 {
-			fs.log.WithField("API", "Rename").Warningf("Entry %s not empty", op.NewName)
+	existingID, _, ok, err := newParent.LookUpChild(op.NewName)
+	if err != nil {
+		return fs.errno("Rename", err)
+	}
+	if ok && existingID == childID && op.OldParent == op.NewParent {
+		// On case-insensitive mounts the new name may just change the case of the old one:
+		// the entry is replaced in place.
+		if err := oldParent.RemoveChild(op.OldName); err != nil {
+			return fs.errno("Rename", err)
+		}
+		if err := oldParent.AddChild(childID, op.NewName, childType); err != nil {
+			return fs.errno("Rename", err)
+		}
+		fs.publish(Event{Type: "rename", Inumber: child.Inumber, Parent: oldParent.Inumber, Name: op.OldName, NewParent: newParent.Inumber, NewName: op.NewName})
+
+		return nil
+	}
+	if ok {
+		existing, err := fs.getInode(ctx, existingID)
+		if err != nil {
+			return fs.errno("Rename", err)
+		}
+		if err := fs.checkMutable("Rename", existing); err != nil {
+			return fs.errno("Rename", err)
+		}
 
-			return fuse.ENOTEMPTY
+		if existing.isDir() {
+			var buf [4096]byte
+			n, _, err := existing.ReadDir(buf[:], 0)
+			if err != nil {
+				return fs.errno("Rename", err)
+			}
+			if n > 0 {
+				fs.log.WithField("API", "Rename").Warningf("Entry %s not empty", op.NewName)
+
+				return fuse.ENOTEMPTY
+			}
 		}
 
-		newParent.RemoveChild(op.NewName)
+		if err := newParent.RemoveChild(op.NewName); err != nil {
+			return fs.errno("Rename", err)
+		}
 	}
 
 	// Link the new name.
-	newParent.AddChild(
+	if err := newParent.AddChild(
 		childID,
 		op.NewName,
-		childType)
+		childType); err != nil {
+		return fs.errno("Rename", err)
+	}
 
 	// Finally, remove the old name from the old parent.
-	oldParent.RemoveChild(op.OldName)
+	if err := oldParent.RemoveChild(op.OldName); err != nil {
+		return fs.errno("Rename", err)
+	}
+	fs.publish(Event{Type: "rename", Inumber: child.Inumber, Parent: oldParent.Inumber, Name: op.OldName, NewParent: newParent.Inumber, NewName: op.NewName})
 
 	return nil
 }
@@ -560,20 +1400,29 @@ func (fs *Immufs) RmDir(
 	ctx context.Context,
 	op *fuseops.RmDirOp) error {
 	fs.log.Infof("--> RmDir")
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "RmDir").Warningf("Invalid PID 0")
+	fs.countOp("RmDir")
+	if err := fs.checkPid("RmDir", op.OpContext); err != nil {
+		return err
+	}
 
-		return fuse.EINVAL
+	if err := fs.checkWritable("RmDir"); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
 	// Grab the parent, which we will update shortly.
-	parent := fs.getInodeOrDie(op.Parent)
+	parent, err := fs.getInode(ctx, op.Parent)
+	if err != nil {
+		return fs.errno("RmDir", err)
+	}
 
 	// Find the child within the parent.
-	childID, _, ok := parent.LookUpChild(op.Name)
+	childID, _, ok, err := parent.LookUpChild(op.Name)
+	if err != nil {
+		return fs.errno("RmDir", err)
+	}
 	if !ok {
 		fs.log.WithField("API", "RmDir").Warningf("Entry %s not found", op.Name)
 
@@ -581,23 +1430,35 @@ func (fs *Immufs) RmDir(
 	}
 
 	// Grab the child.
-	child := fs.getInodeOrDie(childID)
+	child, err := fs.getInode(ctx, childID)
+	if err != nil {
+		return fs.errno("RmDir", err)
+	}
 
 	// Make sure the child is empty.
-	if child.Len() != 0 {
+	n, err := child.Len()
+	if err != nil {
+		return fs.errno("RmDir", err)
+	}
+	if n != 0 {
 		fs.log.WithField("API", "RmDir").Warningf("Entry %s not empty", op.Name)
 
 		return fuse.ENOTEMPTY
 	}
 
 	// Remove the entry within the parent.
-	parent.RemoveChild(op.Name)
+	if err := parent.RemoveChild(op.Name); err != nil {
+		return fs.errno("RmDir", err)
+	}
 
 	// Mark the child as unlinked.
 	child.Nlink--
 	child.ToBeDeleted = true
 	child.Atime = time.Now()
-	child.writeOrDie()
+	if err := child.write(); err != nil {
+		return fs.errno("RmDir", err)
+	}
+	fs.publish(Event{Type: "rmdir", Inumber: child.Inumber, Parent: parent.Inumber, Name: op.Name})
 
 	return nil
 }
@@ -606,20 +1467,29 @@ func (fs *Immufs) Unlink(
 	ctx context.Context,
 	op *fuseops.UnlinkOp) error {
 	fs.log.Infof("--> Unlink")
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "Unlink").Warningf("Invalid PID 0")
+	fs.countOp("Unlink")
+	if err := fs.checkPid("Unlink", op.OpContext); err != nil {
+		return err
+	}
 
-		return fuse.EINVAL
+	if err := fs.checkWritable("Unlink"); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
 	// Grab the parent, which we will update shortly.
-	parent := fs.getInodeOrDie(op.Parent)
+	parent, err := fs.getInode(ctx, op.Parent)
+	if err != nil {
+		return fs.errno("Unlink", err)
+	}
 
 	// Find the child within the parent.
-	childID, _, ok := parent.LookUpChild(op.Name)
+	childID, _, ok, err := parent.LookUpChild(op.Name)
+	if err != nil {
+		return fs.errno("Unlink", err)
+	}
 	if !ok {
 		fs.log.WithField("API", "Unlink").Warningf("Entry %s not found", op.Name)
 
@@ -627,46 +1497,77 @@ func (fs *Immufs) Unlink(
 	}
 
 	// Grab the child.
-	child := fs.getInodeOrDie(childID)
+	child, err := fs.getInode(ctx, childID)
+	if err != nil {
+		return fs.errno("Unlink", err)
+	}
+	if err := fs.checkMutable("Unlink", child); err != nil {
+		return fs.errno("Unlink", err)
+	}
+
+	// Keep the file in the trash, if enabled.
+	if fs.trash {
+		trashed, err := fs.moveToTrash(parent, op.Name, child)
+		if err != nil {
+			return fs.errno("Unlink", err)
+		}
+		if trashed {
+			fs.publish(Event{Type: "unlink", Inumber: child.Inumber, Parent: parent.Inumber, Name: op.Name})
+
+			return nil
+		}
+	}
 
 	// Remove the entry within the parent.
-	parent.RemoveChild(op.Name)
+	if err := parent.RemoveChild(op.Name); err != nil {
+		return fs.errno("Unlink", err)
+	}
 
 	// Mark the child as unlinked.
 	child.Nlink--
 	child.ToBeDeleted = true
 	child.Atime = time.Now()
-	child.writeOrDie()
+	if err := child.write(); err != nil {
+		return fs.errno("Unlink", err)
+	}
+	fs.publish(Event{Type: "unlink", Inumber: child.Inumber, Parent: parent.Inumber, Name: op.Name})
 
 	return nil
 }
 
-// TODO should I implement a dir handler?
 func (fs *Immufs) OpenDir(
 	ctx context.Context,
 	op *fuseops.OpenDirOp) error {
 	fs.log.Infof("--> OpenDir")
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "OpenDir").Warningf("Invalid PID 0")
-
-		return fuse.EINVAL
+	fs.countOp("OpenDir")
+	if err := fs.checkPid("OpenDir", op.OpContext); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	if op.Inode == controlDirInode {
+		return nil
+	}
+
 	// We don't mutate spontaneosuly, so if the VFS layer has asked for an
 	// inode that doesn't exist, something screwed up earlier (a lookup, a
 	// cache invalidation, etc.).
-	inode := fs.getInodeOrDie(op.Inode)
+	inode, err := fs.getInode(ctx, op.Inode)
+	if err != nil {
+		return fs.errno("OpenDir", err)
+	}
 
 	if !inode.isDir() {
 		panic("Found non-dir.")
 	}
 
 	// Update atime
-	inode.Atime = time.Now()
-	inode.writeOrDie()
+	if err := fs.updateAtime(inode); err != nil {
+		return fs.errno("OpenDir", err)
+	}
+	op.Handle = fs.newHandle(op.Inode, false)
 
 	return nil
 }
@@ -675,24 +1576,46 @@ func (fs *Immufs) ReadDir(
 	ctx context.Context,
 	op *fuseops.ReadDirOp) error {
 	fs.log.Infof("--> ReadDir")
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "ReadDir").Warningf("Invalid PID 0")
+	fs.countOp("ReadDir")
+	if err := fs.checkPid("ReadDir", op.OpContext); err != nil {
+		return err
+	}
 
-		return fuse.EINVAL
+	if op.Inode == controlDirInode {
+		op.BytesRead = readControlDir(op.Dst, int(op.Offset))
+
+		return nil
 	}
 
-	fs.mu.Lock()
-	defer fs.mu.Unlock()
+	// Listings may scan big directories: the entries are read without holding fs.mu, so that the other operations
+	// don't wait for them. The pages are read at once, hence consistent, whatever the changes made meanwhile.
+	inode, err := fs.readInode(ctx, op.Inode)
+	if err != nil {
+		return fs.errno("ReadDir", err)
+	}
 
-	// Grab the directory.
-	inode := fs.getInodeOrDie(op.Inode)
+	// Serve the request, a page at a time: the offset is a cursor, which doesn't depend on the index of the entries
+	// in the directory, changing with the directory.
+	var written []fuseutil.Dirent
+	if op.BytesRead, written, err = inode.ReadDir(op.Dst, op.Offset); err != nil {
+		return fs.errno("ReadDir", err)
+	}
 
-	// Serve the request.
-	op.BytesRead = inode.ReadDir(op.Dst, int(op.Offset))
+	// The attributes of the entries are usually looked up next: read them ahead, page after page.
+	if fs.prefetcher != nil {
+		fs.prefetcher.enqueue(ctx, written)
+	}
 
-	// Update atime
-	inode.Atime = time.Now()
-	inode.writeOrDie()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// Update atime, on the inode as of now: it may have been changed while the entries were read.
+	if inode, err = fs.getInode(ctx, op.Inode); err != nil {
+		return fs.errno("ReadDir", err)
+	}
+	if err := fs.updateAtime(inode); err != nil {
+		return fs.errno("ReadDir", err)
+	}
 
 	return nil
 }
@@ -702,28 +1625,81 @@ func (fs *Immufs) OpenFile(
 	ctx context.Context,
 	op *fuseops.OpenFileOp) error {
 	fs.log.Infof("--> OpenFile")
-	if op.OpContext.Pid == 0 {
-		// OpenFileOp should have a valid pid in context.
-		fs.log.WithField("API", "OpenFile").Warningf("Invalid PID 0")
-
-		return fuse.EINVAL
+	fs.countOp("OpenFile")
+	if err := fs.checkPid("OpenFile", op.OpContext); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	// The content of the control files is generated on open: bypass the page cache,
+	// as their size is unknown to the kernel.
+	if isControlInode(op.Inode) {
+		op.Handle = fs.newHandle(op.Inode, false)
+		op.UseDirectIO = true
+		if op.Inode == controlStatsInode {
+			content, err := fs.readStats(ctx)
+			if err != nil {
+				return fs.errno("OpenFile", err)
+			}
+			h, _ := fs.getHandle(op.Handle)
+			h.content = content
+		}
+
+		return nil
+	}
+
+	// Past versions never change: their content is read once, and may be cached by the kernel.
+	if isVersionInode(op.Inode) {
+		if op.OpenFlags&syscall.O_ACCMODE != syscall.O_RDONLY {
+			return syscall.EROFS
+		}
+		content, err := fs.readVersion(op.Inode)
+		if err != nil {
+			return fs.errno("OpenFile", err)
+		}
+		op.Handle = fs.newHandle(op.Inode, false)
+		op.KeepPageCache = true
+		h, _ := fs.getHandle(op.Handle)
+		h.content = content
+
+		return nil
+	}
+
 	// We don't mutate spontaneosuly, so if the VFS layer has asked for an
 	// inode that doesn't exist, something screwed up earlier (a lookup, a
 	// cache invalidation, etc.).
-	inode := fs.getInodeOrDie(op.Inode)
+	inode, err := fs.getInode(ctx, op.Inode)
+	if err != nil {
+		return fs.errno("OpenFile", err)
+	}
 
 	if !inode.isFile() {
 		panic("Found non-file.")
 	}
 
+	writing := op.OpenFlags&syscall.O_ACCMODE != syscall.O_RDONLY
+	if writing {
+		if err := fs.checkMutable("OpenFile", inode); err != nil {
+			return fs.errno("OpenFile", err)
+		}
+	}
+
 	// Update atime
-	inode.Atime = time.Now()
-	inode.writeOrDie()
+	if err := fs.updateAtime(inode); err != nil {
+		return fs.errno("OpenFile", err)
+	}
+
+	op.Handle = fs.newHandle(op.Inode, op.OpenFlags&syscall.O_APPEND != 0)
+	h, _ := fs.getHandle(op.Handle)
+	h.write = writing
+
+	// When watching remote changes, the page cache is kept unless another mount changed the file.
+	if fs.watcher != nil {
+		op.KeepPageCache = !fs.remoteChanges[op.Inode]
+		delete(fs.remoteChanges, op.Inode)
+	}
 
 	return nil
 }
@@ -732,56 +1708,132 @@ func (fs *Immufs) ReadFile(
 	ctx context.Context,
 	op *fuseops.ReadFileOp) error {
 	fs.log.Infof("--> ReadFile")
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "ReadFile").Warningf("Invalid PID 0")
-
-		return fuse.EINVAL
+	fs.countOp("ReadFile")
+	if err := fs.checkPid("ReadFile", op.OpContext); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
+	if isControlInode(op.Inode) || isVersionInode(op.Inode) {
+		if h, ok := fs.getHandle(op.Handle); ok && op.Offset < int64(len(h.content)) {
+			op.BytesRead = copy(op.Dst, h.content[op.Offset:])
+		}
+
+		return nil
+	}
+
 	// Find the inode in question.
-	inode := fs.getInodeOrDie(op.Inode)
+	inode, err := fs.getInode(ctx, op.Inode)
+	if err != nil {
+		return fs.errno("ReadFile", err)
+	}
 
 	// Serve the request.
-	var err error
 	op.BytesRead, err = inode.ReadAt(op.Dst, op.Offset)
 
 	// Don't return EOF errors; we just indicate EOF to fuse using a short read.
 	if err == io.EOF {
 		return nil
 	}
+	if err != nil {
+		return fs.errno("ReadFile", err)
+	}
 
 	// Update atime
-	inode.Atime = time.Now()
-	inode.writeOrDie()
+	if err := fs.updateAtime(inode); err != nil {
+		return fs.errno("ReadFile", err)
+	}
 
-	return err
+	return nil
 }
 
 func (fs *Immufs) WriteFile(
 	ctx context.Context,
 	op *fuseops.WriteFileOp) error {
 	fs.log.Infof("--> WriteFile")
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "WriteFile").Warningf("Invalid PID 0")
+	fs.countOp("WriteFile")
+	if err := fs.checkPid("WriteFile", op.OpContext); err != nil {
+		return err
+	}
 
-		return fuse.EINVAL
+	if op.Inode == controlCtlInode {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+
+		if err := fs.runControl(ctx, op.Data); err != nil {
+			return fs.errno("WriteFile", err)
+		}
+
+		return nil
+	}
+
+	if err := fs.checkWritable("WriteFile"); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
 	// Find the inode in question.
-	inode := fs.getInodeOrDie(op.Inode)
+	inode, err := fs.getInode(ctx, op.Inode)
+	if err != nil {
+		return fs.errno("WriteFile", err)
+	}
+	if err := fs.checkMutable("WriteFile", inode); err != nil {
+		return fs.errno("WriteFile", err)
+	}
 
-	// Serve the request.
-	_, err := inode.WriteAt(op.Data, op.Offset)
+	// Growing a file is subject to the quota of its owner.
+	h, ok := fs.getHandle(op.Handle)
+	appending := ok && h.append
+	oldSize := inode.Size
+	newSize := op.Offset + int64(len(op.Data))
+	if appending {
+		newSize = oldSize + int64(len(op.Data))
+	}
+	if newSize > oldSize {
+		if err := fs.checkQuota("WriteFile", uint32(inode.Uid), inode.Project, 0, newSize-oldSize); err != nil {
+			return fs.errno("WriteFile", err)
+		}
+	}
+	if err := fs.clearCapabilities(inode); err != nil {
+		return fs.errno("WriteFile", err)
+	}
 
-	inode.writeOrDie()
+	// Serve the request. Appends ignore the offset chosen by the kernel, which may be stale.
+	// Both WriteAt and Append flush the inode as well.
+	if appending {
+		_, err = inode.Append(op.Data)
+	} else {
+		_, err = inode.WriteAt(op.Data, op.Offset)
+	}
+	if err != nil {
+		return fs.errno("WriteFile", err)
+	}
+	fs.chargeQuota(uint32(inode.Uid), inode.Project, 0, inode.Size-oldSize)
+	fs.publish(Event{Type: "write", Inumber: inode.Inumber})
 
-	return err
+	return nil
+}
+
+// SyncFile writes the timestamps of the file not written yet, if attribute writes are coalesced. The content
+// is always written right away.
+func (fs *Immufs) SyncFile(
+	ctx context.Context,
+	op *fuseops.SyncFileOp) error {
+	fs.log.Infof("--> SyncFile")
+	fs.countOp("SyncFile")
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := fs.flushTimes(ctx, op.Inode); err != nil {
+		return fs.errno("SyncFile", err)
+	}
+
+	return nil
 }
 
 // FlushFile is not required as we immediately write the bytes into the database.
@@ -790,16 +1842,57 @@ func (fs *Immufs) FlushFile(
 	ctx context.Context,
 	op *fuseops.FlushFileOp) (err error) {
 	fs.log.Infof("--> FlushFile")
-	if op.OpContext.Pid == 0 {
-		// FlushFileOp should have a valid pid in context.
-		fs.log.WithField("API", "FlushFile").Warningf("Invalid PID 0")
-
-		return fuse.EINVAL
+	fs.countOp("FlushFile")
+	if err := fs.checkPid("FlushFile", op.OpContext); err != nil {
+		return err
 	}
 
 	return
 }
 
+func (fs *Immufs) ReleaseDirHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseDirHandleOp) error {
+	fs.log.Infof("--> ReleaseDirHandle")
+	fs.countOp("ReleaseDirHandle")
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.releaseHandle(op.Handle)
+
+	return nil
+}
+
+func (fs *Immufs) ReleaseFileHandle(
+	ctx context.Context,
+	op *fuseops.ReleaseFileHandleOp) error {
+	fs.log.Infof("--> ReleaseFileHandle")
+	fs.countOp("ReleaseFileHandle")
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	h, ok := fs.getHandle(op.Handle)
+	fs.releaseHandle(op.Handle)
+
+	// WORM mounts seal files as soon as they are closed after being written.
+	if ok && h.write && fs.worm {
+		inode, err := fs.getInode(ctx, h.inode)
+		if err != nil {
+			return fs.errno("ReleaseFileHandle", err)
+		}
+		if !inode.Sealed {
+			inode.Sealed = true
+			if err := inode.write(); err != nil {
+				return fs.errno("ReleaseFileHandle", err)
+			}
+		}
+	}
+
+	return nil
+}
+
 /*
 func (fs *Immufs) ReadSymlink(
 	ctx context.Context,
@@ -820,47 +1913,101 @@ func (fs *Immufs) ReadSymlink(
 	return nil
 }
 
+*/
+
 func (fs *Immufs) GetXattr(ctx context.Context,
 	op *fuseops.GetXattrOp) error {
-	if op.OpContext.Pid == 0 {
-		return fuse.EINVAL
+	fs.log.Infof("--> GetXattr: %s", op.Name)
+	fs.countOp("GetXattr")
+	if err := fs.checkPid("GetXattr", op.OpContext); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	inode := fs.getInodeOrDie(op.Inode)
-	if value, ok := inode.xattrs[op.Name]; ok {
-		op.BytesRead = len(value)
-		if len(op.Dst) >= len(value) {
-			copy(op.Dst, value)
-		} else if len(op.Dst) != 0 {
-			return syscall.ERANGE
-		}
-	} else {
+	if isControlInode(op.Inode) || isVersionInode(op.Inode) {
 		return fuse.ENOATTR
 	}
 
+	inode, err := fs.getInode(ctx, op.Inode)
+	if err != nil {
+		return fs.errno("GetXattr", err)
+	}
+
+	var value []byte
+	switch {
+	case op.Name == versionsXattr:
+		value, err = fs.versionsXattrValue(inode)
+	case op.Name == crtimeXattr:
+		value = crtimeXattrValue(inode)
+	case strings.HasPrefix(op.Name, quotaXattrPrefix):
+		value, err = fs.quotaXattr(uint32(inode.Uid), op.Name)
+	case op.Name == lockXattrRetainUntil || op.Name == lockXattrLegalHold:
+		value, err = fs.lockXattr(inode, op.Name)
+	default:
+		value, err = inode.GetXattr(op.Name)
+	}
+	if err != nil {
+		return fs.errno("GetXattr", err)
+	}
+
+	op.BytesRead = len(value)
+	if len(op.Dst) >= len(value) {
+		copy(op.Dst, value)
+	} else if len(op.Dst) != 0 {
+		return syscall.ERANGE
+	}
+
 	return nil
 }
 
 func (fs *Immufs) ListXattr(ctx context.Context,
 	op *fuseops.ListXattrOp) error {
-	if op.OpContext.Pid == 0 {
-		return fuse.EINVAL
+	fs.log.Infof("--> ListXattr")
+	fs.countOp("ListXattr")
+	if err := fs.checkPid("ListXattr", op.OpContext); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	inode := fs.getInodeOrDie(op.Inode)
+	if isControlInode(op.Inode) || isVersionInode(op.Inode) {
+		return nil
+	}
+
+	inode, err := fs.getInode(ctx, op.Inode)
+	if err != nil {
+		return fs.errno("ListXattr", err)
+	}
+
+	names, err := inode.ListXattrs()
+	if err != nil {
+		return fs.errno("ListXattr", err)
+	}
+
+	// The kernel checks the privileges of the processes reading or writing the trusted attributes, with the
+	// credentials overlayfs acts with, but lets the filesystem hide them from the listings of the others.
+	listTrusted := true
+	for _, key := range names {
+		if strings.HasPrefix(key, trustedXattrPrefix) {
+			listTrusted = fs.capable("ListXattr", op.OpContext, capSysAdmin)
+
+			break
+		}
+	}
 
 	dst := op.Dst[:]
-	for key := range inode.xattrs {
+	for _, key := range names {
+		if !listTrusted && strings.HasPrefix(key, trustedXattrPrefix) {
+			continue
+		}
 		keyLen := len(key) + 1
 
 		if len(dst) >= keyLen {
 			copy(dst, key)
+			dst[keyLen-1] = 0
 			dst = dst[keyLen:]
 		} else if len(op.Dst) != 0 {
 			return syscall.ERANGE
@@ -873,33 +2020,87 @@ func (fs *Immufs) ListXattr(ctx context.Context,
 
 func (fs *Immufs) RemoveXattr(ctx context.Context,
 	op *fuseops.RemoveXattrOp) error {
-	if op.OpContext.Pid == 0 {
-		return fuse.EINVAL
+	fs.log.Infof("--> RemoveXattr: %s", op.Name)
+	fs.countOp("RemoveXattr")
+	if err := fs.checkPid("RemoveXattr", op.OpContext); err != nil {
+		return err
+	}
+
+	if err := fs.checkWritable("RemoveXattr"); err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(op.Name, virtualXattrPrefix) {
+		fs.log.WithField("API", "RemoveXattr").Warningf("Attribute %s is read-only", op.Name)
+
+		return syscall.EPERM
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
-	inode := fs.getInodeOrDie(op.Inode)
 
-	if _, ok := inode.xattrs[op.Name]; ok {
-		delete(inode.xattrs, op.Name)
-	} else {
-		return fuse.ENOATTR
+	inode, err := fs.getInode(ctx, op.Inode)
+	if err != nil {
+		return fs.errno("RemoveXattr", err)
 	}
+	if err := fs.checkMutable("RemoveXattr", inode); err != nil {
+		return fs.errno("RemoveXattr", err)
+	}
+
+	if _, err := inode.GetXattr(op.Name); err != nil {
+		return fs.errno("RemoveXattr", err)
+	}
+	if err := inode.RemoveXattr(op.Name); err != nil {
+		return fs.errno("RemoveXattr", err)
+	}
+
 	return nil
 }
 
 func (fs *Immufs) SetXattr(ctx context.Context,
 	op *fuseops.SetXattrOp) error {
-	if op.OpContext.Pid == 0 {
-		return fuse.EINVAL
+	fs.log.Infof("--> SetXattr: %s", op.Name)
+	fs.countOp("SetXattr")
+	if err := fs.checkPid("SetXattr", op.OpContext); err != nil {
+		return err
+	}
+
+	if err := fs.checkWritable("SetXattr"); err != nil {
+		return err
+	}
+
+	isLock := op.Name == lockXattrRetainUntil || op.Name == lockXattrLegalHold
+	if strings.HasPrefix(op.Name, virtualXattrPrefix) && !isLock {
+		fs.log.WithField("API", "SetXattr").Warningf("Attribute %s is read-only", op.Name)
+
+		return syscall.EPERM
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
-	inode := fs.getInodeOrDie(op.Inode)
 
-	_, ok := inode.xattrs[op.Name]
+	inode, err := fs.getInode(ctx, op.Inode)
+	if err != nil {
+		return fs.errno("SetXattr", err)
+	}
+
+	// Locks can be placed on immutable files as well.
+	if isLock {
+		if err := fs.setLockXattr(inode, op.Name, op.Value); err != nil {
+			return fs.errno("SetXattr", err)
+		}
+
+		return nil
+	}
+	if err := fs.checkMutable("SetXattr", inode); err != nil {
+		return fs.errno("SetXattr", err)
+	}
+
+	_, err = inode.GetXattr(op.Name)
+	if err != nil && !errors.Is(err, ErrXattrNotFound) {
+		return fs.errno("SetXattr", err)
+	}
+	ok := err == nil
 
 	switch op.Flags {
 	case unix.XATTR_CREATE:
@@ -914,24 +2115,47 @@ func (fs *Immufs) SetXattr(ctx context.Context,
 
 	value := make([]byte, len(op.Value))
 	copy(value, op.Value)
-	inode.xattrs[op.Name] = value
+	if err := inode.SetXattr(op.Name, value); err != nil {
+		return fs.errno("SetXattr", err)
+	}
+	if op.Name == capabilityXattr {
+		delete(fs.noCapabilities, op.Inode)
+	}
+
 	return nil
 }
-*/
 
 func (fs *Immufs) Fallocate(ctx context.Context,
 	op *fuseops.FallocateOp) error {
 	fs.log.Infof("--> Fallocate")
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "Fallocate").Warningf("Invalid PID 0")
+	fs.countOp("Fallocate")
+	if err := fs.checkPid("Fallocate", op.OpContext); err != nil {
+		return err
+	}
 
-		return fuse.EINVAL
+	if err := fs.checkWritable("Fallocate"); err != nil {
+		return err
 	}
 
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
-	inode := fs.getInodeOrDie(op.Inode)
-	inode.Fallocate(op.Mode, op.Offset, op.Length)
+	inode, err := fs.getInode(ctx, op.Inode)
+	if err != nil {
+		return fs.errno("Fallocate", err)
+	}
+	if err := fs.checkMutable("Fallocate", inode); err != nil {
+		return fs.errno("Fallocate", err)
+	}
+	oldSize := inode.Size
+	if newSize := int64(op.Offset + op.Length); op.Mode == 0 && newSize > oldSize {
+		if err := fs.checkQuota("Fallocate", uint32(inode.Uid), inode.Project, 0, newSize-oldSize); err != nil {
+			return fs.errno("Fallocate", err)
+		}
+	}
+	if err := inode.Fallocate(op.Mode, op.Offset, op.Length); err != nil {
+		return fs.errno("Fallocate", err)
+	}
+	fs.chargeQuota(uint32(inode.Uid), inode.Project, 0, inode.Size-oldSize)
 
 	return nil
 }
@@ -939,16 +2163,44 @@ func (fs *Immufs) Fallocate(ctx context.Context,
 func (fs *Immufs) ForgetInode(ctx context.Context,
 	op *fuseops.ForgetInodeOp) error {
 	fs.log.Infof("--> ForgetInode")
-	if op.OpContext.Pid == 0 {
-		fs.log.WithField("API", "ForgetInode").Warningf("Invalid PID 0")
+	fs.countOp("ForgetInode")
+	if err := fs.checkPid("ForgetInode", op.OpContext); err != nil {
+		return err
+	}
 
-		return fuse.EINVAL
+	if isVersionInode(op.Inode) {
+		fs.mu.Lock()
+		defer fs.mu.Unlock()
+
+		fs.forgetVersion(op.Inode)
+
+		return nil
 	}
 
-	inode := fs.getInodeOrDie(op.Inode)
-	cnt := inode.DecrRef(op.N)
+	// References are not tracked on read-only mounts, nor for the control inodes.
+	if fs.readOnly || isControlInode(op.Inode) {
+		return nil
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	inode, err := fs.getInode(ctx, op.Inode)
+	if err != nil {
+		return fs.errno("ForgetInode", err)
+	}
+	cnt, err := inode.DecrRef(op.N)
+	if err != nil {
+		return fs.errno("ForgetInode", err)
+	}
+	if cnt == 0 {
+		delete(fs.noCapabilities, op.Inode)
+	}
 	if cnt == 0 && inode.ToBeDeleted {
-		inode.Del()
+		if err := inode.Del(); err != nil {
+			return fs.errno("ForgetInode", err)
+		}
+		fs.chargeQuota(uint32(inode.Uid), inode.Project, -1, -inode.Size)
 	}
 
 	return nil
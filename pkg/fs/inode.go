@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/jacobsa/fuse"
@@ -26,7 +27,38 @@ type Inode struct {
 	Gid     int64
 
 	ToBeDeleted bool
-	cl          *ImmuDbClient
+	// Generation of the inumber, reported to the kernel together with it. Inumbers are
+	// never reused, so it only changes if the inumber allocator had to be rebuilt.
+	Generation int64
+	// Inumber of the directory whose quota the inode is charged to, 0 if none. It is inherited
+	// from the parent directory on creation.
+	Project int64
+	// The file can no longer be modified nor deleted (WORM mounts seal files once written).
+	Sealed bool
+	cl     Backend
+
+	// Content of the file, if stored in the inode row rather than in the content table (see InlineThreshold in
+	// the configuration), nil otherwise.
+	inline []byte
+
+	// Match child names regardless of their case (case-preserving lookups).
+	foldCase bool
+
+	// Timestamps stored by the backend, before those not written yet are applied (see Immufs.writeTimes).
+	storedTimes inodeTimes
+
+	// Context of the operation the inode was read for, carrying its span but not its cancellation:
+	// the queries of the inode methods are traced as part of the operation.
+	ctx context.Context
+}
+
+// opContext returns the context of the queries run on behalf of the inode.
+func (in *Inode) opContext() context.Context {
+	if in.ctx == nil {
+		return context.TODO()
+	}
+
+	return in.ctx
 }
 
 ////////////////////////////////////////////////////////////////////////
@@ -45,81 +77,92 @@ func (in *Inode) isFile() bool {
 	return !(in.isDir() || in.isSymlink())
 }
 
-// getChildrenOrDie returns the list of children of a directory
-//
-// REQUIRES in.isDir()
-func (in *Inode) getChildrenOrDie() []fuseutil.Dirent {
-	entries, err := in.cl.GetChildren(context.TODO(), in.Inumber)
-	if err != nil {
-		panic(err)
+// direntType returns the directory entry type matching the given file mode.
+func direntType(mode os.FileMode) fuseutil.DirentType {
+	switch {
+	case mode&os.ModeDir != 0:
+		return fuseutil.DT_Directory
+	case mode&os.ModeSymlink != 0:
+		return fuseutil.DT_Link
+	case mode&os.ModeCharDevice != 0:
+		return fuseutil.DT_Char
+	case mode&os.ModeDevice != 0:
+		return fuseutil.DT_Block
+	case mode&os.ModeNamedPipe != 0:
+		return fuseutil.DT_FIFO
+	case mode&os.ModeSocket != 0:
+		return fuseutil.DT_Socket
+	default:
+		return fuseutil.DT_File
 	}
-
-	return entries
 }
 
-func (in *Inode) writeChildrenOrDie(children []fuseutil.Dirent) {
-	err := in.cl.WriteChildren(context.TODO(), in.Inumber, children)
-	if err != nil {
-		panic(err)
-	}
+// getChildren returns the list of children of a directory
+//
+// REQUIRES in.isDir()
+func (in *Inode) getChildren() ([]fuseutil.Dirent, error) {
+	return in.cl.GetChildren(in.opContext(), in.Inumber)
 }
 
-// Return the index of the child within in.entries, if it exists.
-//
-// REQUIRES: in.isDir()
-func (in *Inode) findChild(name string) (i int, ok bool) {
-	if !in.isDir() {
-		panic("findChild called on non-directory.")
-	}
+func (in *Inode) writeChildren(children []fuseutil.Dirent) error {
+	return in.cl.WriteChildren(in.opContext(), in.Inumber, children)
+}
 
-	var e fuseutil.Dirent
-	entries := in.getChildrenOrDie()
-	for i, e = range entries {
+// indexOf returns the index of the entry with the given name. On case-insensitive
+// mounts an exact match is preferred, then the first entry matching regardless of case.
+func (in *Inode) indexOf(entries []fuseutil.Dirent, name string) (int, bool) {
+	folded := -1
+	for i, e := range entries {
+		if e.Type == fuseutil.DT_Unknown {
+			continue
+		}
 		if e.Name == name {
 			return i, true
 		}
+		if in.foldCase && folded < 0 && strings.EqualFold(e.Name, name) {
+			folded = i
+		}
 	}
 
-	return 0, false
+	return folded, folded >= 0
 }
 
-// Like findChild, but returns the Dirent
-func (in *Inode) findChild2(name string) (d fuseutil.Dirent, ok bool) {
+// Return the Dirent of the child with the given name, if it exists.
+//
+// REQUIRES: in.isDir()
+func (in *Inode) findChild(name string) (d fuseutil.Dirent, ok bool, err error) {
 	if !in.isDir() {
 		panic("findChild called on non-directory.")
 	}
 
-	var e fuseutil.Dirent
-	entries := in.getChildrenOrDie()
-	for _, e = range entries {
-		if e.Name == name {
-			return e, true
-		}
+	entries, err := in.getChildren()
+	if err != nil {
+		return d, false, err
+	}
+	if i, ok := in.indexOf(entries, name); ok {
+		return entries[i], true, nil
 	}
 
-	return e, false
+	return d, false, nil
 }
 
-func (in *Inode) readContentOrDie() []byte {
-	content, err := in.cl.ReadContent(context.TODO(), in.Inumber)
-	if err != nil {
-		panic(err)
+// readContent returns the content of a file, without querying the backend if it is stored in the inode.
+func (in *Inode) readContent() ([]byte, error) {
+	if in.inline != nil {
+		return append([]byte{}, in.inline...), nil
 	}
 
-	return content
+	return in.cl.ReadContent(in.opContext(), in.Inumber)
 }
 
-func (in *Inode) writeContentOrDie(content []byte) {
-	if err := in.cl.WriteContent(context.TODO(), in.Inumber, content); err != nil {
-		panic(err)
-	}
+// writeFile flushes the content of a file together with the inode, updating its size.
+func (in *Inode) writeFile(content []byte) error {
+	return in.cl.WriteFile(in.opContext(), in, content)
 }
 
 // Flush inode to immudb. It must be called to make every change to the inode permanent.
-func (in *Inode) writeOrDie() {
-	if err := in.cl.WriteInode(context.TODO(), in); err != nil {
-		panic(err)
-	}
+func (in *Inode) write() error {
+	return in.cl.WriteInode(in.opContext(), in)
 }
 
 ////////////////////////////////////////////////////////////////////////
@@ -129,7 +172,7 @@ func (in *Inode) writeOrDie() {
 // Constructor
 // Create a new inode with the supplied attributes, which need not contain
 // time-related information (the inode object will take care of that).
-func NewInode(inumber int64, attrs fuseops.InodeAttributes, db *ImmuDbClient) *Inode {
+func NewInode(ctx context.Context, inumber int64, generation int64, project int64, attrs fuseops.InodeAttributes, db Backend) (*Inode, error) {
 	// Update time info.
 	now := time.Now()
 	attrs.Mtime = now
@@ -148,24 +191,31 @@ func NewInode(inumber int64, attrs fuseops.InodeAttributes, db *ImmuDbClient) *I
 		Uid:         int64(attrs.Uid),
 		Gid:         int64(attrs.Gid),
 		ToBeDeleted: false,
+		Generation:  generation,
+		Project:     project,
 		cl:          db,
-
-		// TODO manage extended attr?
-		//xattrs: make(map[string][]byte),
+		ctx:         detach(ctx),
+	}
+	if err := inode.write(); err != nil {
+		return nil, err
 	}
-	inode.writeOrDie()
 	if inode.isDir() {
-		inode.writeChildrenOrDie([]fuseutil.Dirent{})
+		if err := inode.writeChildren([]fuseutil.Dirent{}); err != nil {
+			return nil, err
+		}
 	}
 
-	return &inode
+	return &inode, nil
 }
 
 // Return the number of children of the directory.
 //
 // REQUIRES: in.isDir()
-func (in *Inode) Len() int {
-	entries := in.getChildrenOrDie()
+func (in *Inode) Len() (int, error) {
+	entries, err := in.getChildren()
+	if err != nil {
+		return 0, err
+	}
 	var n int
 	for _, e := range entries {
 		if e.Type != fuseutil.DT_Unknown {
@@ -173,7 +223,7 @@ func (in *Inode) Len() int {
 		}
 	}
 
-	return n
+	return n, nil
 }
 
 // Find an entry for the given child name and return its inode ID.
@@ -182,14 +232,15 @@ func (in *Inode) Len() int {
 func (in *Inode) LookUpChild(name string) (
 	id fuseops.InodeID,
 	typ fuseutil.DirentType,
-	ok bool) {
-	dirent, ok := in.findChild2(name)
+	ok bool,
+	err error) {
+	dirent, ok, err := in.findChild(name)
 	if ok {
 		id = dirent.Inode
 		typ = dirent.Type
 	}
 
-	return id, typ, ok
+	return id, typ, ok, err
 }
 
 func (in *Inode) Attributes() fuseops.InodeAttributes {
@@ -200,7 +251,7 @@ func (in *Inode) Attributes() fuseops.InodeAttributes {
 		Atime:  in.Atime,
 		Mtime:  in.Mtime,
 		Ctime:  in.Ctime,
-		Crtime: in.Crtime,
+		Crtime: birthTime(in.Crtime, in.Ctime),
 		Uid:    uint32(in.Uid),
 		Gid:    uint32(in.Gid),
 	}
@@ -208,15 +259,15 @@ func (in *Inode) Attributes() fuseops.InodeAttributes {
 
 // Add an entry for a child.
 // It updated the Atime and Mtime of the parent
+// The entries are read and written within a single transaction: should another
+// mount have created an entry with the same name in the meantime, EEXIST is returned.
 //
 // REQUIRES: in.isDir()
 // REQUIRES: dt != fuseutil.DT_Unknown
 func (in *Inode) AddChild(
 	id fuseops.InodeID,
 	name string,
-	dt fuseutil.DirentType) {
-	var index int
-
+	dt fuseutil.DirentType) error {
 	// Update the modification time.
 	in.Mtime = time.Now()
 
@@ -230,91 +281,78 @@ func (in *Inode) AddChild(
 		Type:  dt,
 	}
 
-	// Look for a gap in which we can insert it.
-	entries := in.getChildrenOrDie()
-	for index = range entries {
-		if entries[index].Type == fuseutil.DT_Unknown {
-			entries[index] = e
-			// No matter where we place the entry, make sure it has the correct Offset
-			// field.
-			entries[index].Offset = fuseops.DirOffset(index + 1)
+	return in.cl.UpdateChildren(in.opContext(), in, func(entries []fuseutil.Dirent) ([]fuseutil.Dirent, error) {
+		if _, exists := in.indexOf(entries, name); exists {
+			return nil, fuse.EEXIST
+		}
+
+		// Look for a gap in which we can insert it.
+		for index := range entries {
+			if entries[index].Type == fuseutil.DT_Unknown {
+				entries[index] = e
+				// No matter where we place the entry, make sure it has the correct Offset
+				// field.
+				entries[index].Offset = fuseops.DirOffset(index + 1)
 
-			in.writeChildrenOrDie(entries)
-			in.writeOrDie()
-			return
+				return entries, nil
+			}
 		}
-	}
 
-	// Append it to the end.
-	index = len(entries)
-	// No matter where we place the entry, make sure it has the correct Offset
-	// field.
-	e.Offset = fuseops.DirOffset(index + 1)
-	entries = append(entries, e)
-	in.writeChildrenOrDie(entries)
-	in.writeOrDie()
+		// Append it to the end.
+		// No matter where we place the entry, make sure it has the correct Offset
+		// field.
+		e.Offset = fuseops.DirOffset(len(entries) + 1)
+
+		return append(entries, e), nil
+	})
 }
 
 // Remove an entry for a child.
 // It also updates the Atime and Mtime of the parent.
+// Should another mount have removed the entry in the meantime, ENOENT is returned.
 //
 // REQUIRES: in.isDir()
 // REQUIRES: An entry for the given name exists.
-func (in *Inode) RemoveChild(name string) {
+func (in *Inode) RemoveChild(name string) error {
 	// Update the modification time.
 	in.Mtime = time.Now()
 
 	// Update the acccess time
 	in.Atime = time.Now()
 
-	// Find the entry.
-	i, ok := in.findChild(name)
-	if !ok {
-		panic(fmt.Sprintf("Unknown child: %s", name))
-	}
+	return in.cl.UpdateChildren(in.opContext(), in, func(entries []fuseutil.Dirent) ([]fuseutil.Dirent, error) {
+		// Find the entry.
+		i, ok := in.indexOf(entries, name)
+		if !ok {
+			return nil, fuse.ENOENT
+		}
 
-	// Mark it as unused.
-	entries := in.getChildrenOrDie()
-	entries[i] = fuseutil.Dirent{
-		Type:   fuseutil.DT_Unknown,
-		Offset: fuseops.DirOffset(i + 1),
-	}
-	in.writeChildrenOrDie(entries)
-	in.writeOrDie()
+		// Mark it as unused.
+		entries[i] = fuseutil.Dirent{
+			Type:   fuseutil.DT_Unknown,
+			Offset: fuseops.DirOffset(i + 1),
+		}
+
+		return entries, nil
+	})
 }
 
-// Serve a ReadDir request.
+// Serve a ReadDir request from the cursor offset (see direntCookie), decoding only the entries which may fit in p.
+// It returns the bytes and the entries written.
 //
 // REQUIRES: in.isDir()
-func (in *Inode) ReadDir(p []byte, offset int) int {
+func (in *Inode) ReadDir(p []byte, offset fuseops.DirOffset) (int, []fuseutil.Dirent, error) {
 	if !in.isDir() {
 		panic("ReadDir called on non-directory.")
 	}
 
-	var n int
-	entries := in.getChildrenOrDie()
-
-	// Update the acccess time
-	in.Atime = time.Now()
-	in.writeOrDie()
-
-	for i := offset; i < len(entries); i++ {
-		e := entries[i]
-
-		// Skip unused entries.
-		if e.Type == fuseutil.DT_Unknown {
-			continue
-		}
-
-		tmp := fuseutil.WriteDirent(p[n:], entries[i])
-		if tmp == 0 {
-			break
-		}
-
-		n += tmp
+	entries, err := getChildrenPage(in.opContext(), in.cl, in.Inumber, offset, len(p)/minDirentSize+1)
+	if err != nil {
+		return 0, nil, err
 	}
+	n, written := writeDirentPage(p, entries)
 
-	return n
+	return n, written, nil
 }
 
 // Read from the file's contents. See documentation for ioutil.ReaderAt.
@@ -325,7 +363,14 @@ func (in *Inode) ReadAt(p []byte, off int64) (int, error) {
 		panic("ReadAt called on non-file.")
 	}
 
-	content := in.readContentOrDie()
+	content, err := in.readContent()
+	if err != nil {
+		return 0, err
+	}
+	if c, ok := in.cl.(sizeChecker); ok {
+		c.checkSize(in, content)
+	}
+
 	// Ensure the offset is in range.
 	if off > int64(len(content)) {
 		return 0, io.EOF
@@ -348,17 +393,72 @@ func (in *Inode) WriteAt(p []byte, off int64) (int, error) {
 		panic("WriteAt called on non-file.")
 	}
 
+	journaled, err := in.writeDelta(p, off)
+	if err != nil {
+		return 0, err
+	}
+	if journaled {
+		return len(p), nil
+	}
+
+	content, err := in.readContent()
+	if err != nil {
+		return 0, err
+	}
+
+	return in.writeAt(content, p, off)
+}
+
+// Append writes p at the end of the file. The offset is taken from the stored content
+// at the time of the write, so that concurrent appenders never overwrite each other.
+//
+// REQUIRES: in.isFile()
+func (in *Inode) Append(p []byte) (int, error) {
+	if !in.isFile() {
+		panic("Append called on non-file.")
+	}
+
+	// Journaled appends go to the end of the file as of its inode, read by the same operation.
+	journaled, err := in.writeDelta(p, in.Size)
+	if err != nil {
+		return 0, err
+	}
+	if journaled {
+		return len(p), nil
+	}
+
+	content, err := in.readContent()
+	if err != nil {
+		return 0, err
+	}
+
+	return in.writeAt(content, p, int64(len(content)))
+}
+
+// writeDelta journals p written at off, without reading nor rewriting the content, if the backend journals the
+// writes (see deltaWriter). It returns false if the whole content must be written instead.
+func (in *Inode) writeDelta(p []byte, off int64) (bool, error) {
+	w, ok := in.cl.(deltaWriter)
+	if !ok {
+		return false, nil
+	}
+	in.Atime = time.Now()
+	in.Mtime = time.Now()
+
+	return w.writeDelta(in.opContext(), in, off, p)
+}
+
+// writeAt copies p into content at the given offset and flushes both content and inode.
+func (in *Inode) writeAt(content []byte, p []byte, off int64) (int, error) {
 	// Update the modification time.
 	in.Atime = time.Now()
 	in.Mtime = time.Now()
-	content := in.readContentOrDie()
 
 	// Ensure that the contents slice is long enough.
 	newLen := int(off) + len(p)
 	if len(content) < newLen {
 		padding := make([]byte, newLen-len(content))
 		content = append(content, padding...)
-		in.Size = int64(newLen)
 	}
 
 	// Copy in the data.
@@ -369,39 +469,44 @@ func (in *Inode) WriteAt(p []byte, off int64) (int, error) {
 		panic(fmt.Sprintf("Unexpected short copy: %v", n))
 	}
 
-	in.writeContentOrDie(content)
-	in.writeOrDie()
+	if err := in.writeFile(content); err != nil {
+		return 0, err
+	}
 
 	return n, nil
 }
 
 // Update attributes from non-nil parameters.
+// Timestamps supplied by the caller (utimens) are persisted as they are: the kernel
+// resolves UTIME_NOW into the current time and leaves UTIME_OMIT fields nil.
 func (in *Inode) SetAttributes(
 	size *uint64,
 	mode *os.FileMode,
-	mtime *time.Time) {
-	// Update the modification time.
-	in.Atime = time.Now()
-	in.Mtime = time.Now()
+	atime *time.Time,
+	mtime *time.Time) error {
+	// Any attribute change updates the change time.
 	in.Ctime = time.Now()
 
 	// Truncate?
+	var content []byte
 	if size != nil {
 		intSize := int(*size)
 
-		// Update contents.
-		content := in.readContentOrDie()
+		// Update contents. They are written together with the inode.
+		var err error
+		content, err = in.readContent()
+		if err != nil {
+			return err
+		}
 		if intSize <= len(content) {
 			content = content[:intSize]
-			in.writeContentOrDie(content)
 		} else {
 			padding := make([]byte, intSize-len(content))
 			content = append(content, padding...)
-			in.writeContentOrDie(content)
 		}
 
-		// Update attributes.
-		in.Size = int64(*size)
+		// Truncation modifies the content.
+		in.Mtime = in.Ctime
 	}
 
 	// Change mode?
@@ -409,13 +514,22 @@ func (in *Inode) SetAttributes(
 		in.Mode = int64(*mode)
 	}
 
+	// Change atime?
+	if atime != nil {
+		in.Atime = *atime
+	}
+
 	// Change mtime?
 	if mtime != nil {
 		in.Mtime = *mtime
 	}
 
 	// Write Inode data
-	in.writeOrDie()
+	if size != nil {
+		return in.writeFile(content)
+	}
+
+	return in.write()
 }
 
 // Allocate space for the file. Updates the Atime
@@ -424,39 +538,68 @@ func (in *Inode) Fallocate(mode uint32, offset uint64, length uint64) error {
 		return fuse.ENOSYS
 	}
 	newSize := int(offset + length)
-	content := in.readContentOrDie()
+	content, err := in.readContent()
+	if err != nil {
+		return err
+	}
 	if newSize > len(content) {
 		padding := make([]byte, newSize-len(content))
 		content = append(content, padding...)
-		in.Size = int64(offset + length)
 
 		in.Atime = time.Now()
 		in.Mtime = time.Now()
 		in.Ctime = time.Now()
 
-		in.writeOrDie()
-		in.writeContentOrDie(content)
+		return in.writeFile(content)
 	}
 	return nil
 }
 
+// GetXattr returns the value of the extended attribute with the given name.
+// It returns ErrXattrNotFound if the attribute is not set.
+func (in *Inode) GetXattr(name string) ([]byte, error) {
+	return in.cl.GetXattr(in.opContext(), in.Inumber, name)
+}
+
+// ListXattrs returns the names of all the extended attributes of the inode.
+func (in *Inode) ListXattrs() ([]string, error) {
+	return in.cl.ListXattrs(in.opContext(), in.Inumber)
+}
+
+// SetXattr sets the value of an extended attribute, and updates the Ctime.
+func (in *Inode) SetXattr(name string, value []byte) error {
+	if err := in.cl.SetXattr(in.opContext(), in.Inumber, name, value); err != nil {
+		return err
+	}
+
+	in.Ctime = time.Now()
+
+	return in.write()
+}
+
+// RemoveXattr removes an extended attribute, and updates the Ctime.
+func (in *Inode) RemoveXattr(name string) error {
+	if err := in.cl.RemoveXattr(in.opContext(), in.Inumber, name); err != nil {
+		return err
+	}
+
+	in.Ctime = time.Now()
+
+	return in.write()
+}
+
 // DecrRef decrements the reference counter and returns its current value.
 // The reference count can't become negative.
-func (in *Inode) DecrRef(N uint64) int64 {
+func (in *Inode) DecrRef(N uint64) (int64, error) {
 	in.Nlink -= int64(N)
 	if in.Nlink < 0 {
 		in.Nlink = 0
 	}
 
-	in.writeOrDie()
-
-	return in.Nlink
+	return in.Nlink, in.write()
 }
 
 // Delete an Inode from Immudb
-func (in *Inode) Del() {
-	err := in.cl.DeleteInode(context.TODO(), in.Inumber)
-	if err != nil {
-		panic(err)
-	}
+func (in *Inode) Del() error {
+	return in.cl.DeleteInode(in.opContext(), in.Inumber)
 }
@@ -0,0 +1,115 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// contentDelta is a write journaled in the content_delta table: data written at an offset of a file, on top of its
+// content row and of the deltas written before (see Journal in the configuration).
+type contentDelta struct {
+	id     int64
+	offset int64
+	data   []byte
+}
+
+// errJournalUnsupported is returned when the journal is enabled together with an option it can't work with.
+var errJournalUnsupported = errors.New("the journal is not supported")
+
+// checkJournal tells whether the configuration of the client allows the journal: the deltas are neither signed nor
+// stored in the content database, and they hold the bytes written, which the blob store keeps out of immudb.
+func (idb *ImmuDbClient) checkJournal() error {
+	switch {
+	case idb.signer != nil:
+		return fmt.Errorf("%w with signed contents", errJournalUnsupported)
+	case idb.contentApart:
+		return fmt.Errorf("%w with a separate content database", errJournalUnsupported)
+	case idb.blobStore:
+		return fmt.Errorf("%w with the blob store", errJournalUnsupported)
+	}
+
+	return nil
+}
+
+// writeDelta journals data written at off in a file, together with its inode, within a single transaction: the
+// content row is left untouched. Writes making or leaving the file small enough to be inlined rewrite the whole
+// content instead, as do the writes of mounts without the journal: false is then returned, and nothing written.
+func (idb *ImmuDbClient) writeDelta(ctx context.Context, inode *Inode, off int64, data []byte) (bool, error) {
+	size := off + int64(len(data))
+	if size < inode.Size {
+		size = inode.Size
+	}
+	if !idb.journal || inode.inline != nil || (idb.inlineThreshold > 0 && size <= int64(idb.inlineThreshold)) {
+		return false, nil
+	}
+	inode.Size = size
+
+	err := idb.inTx(ctx, idb.writeTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO content_delta(inumber, off, data) VALUES(?, ?, ?)", inode.Inumber, off, data); err != nil {
+			return err
+		}
+
+		return writeInode(ctx, tx, inode)
+	})
+	if err != nil {
+		idb.log.Errorf("could not journal a write of file %d: %s", inode.Inumber, err)
+	}
+
+	return true, err
+}
+
+// readDeltas returns the deltas of a file as of the given period clause, or currently if period is empty, in the
+// order they were written. None are read if the journal is disabled.
+func (idb *ImmuDbClient) readDeltas(ctx context.Context, q querier, inumber int64, period string, periodArgs ...any) ([]contentDelta, error) {
+	if !idb.journal {
+		return nil, nil
+	}
+
+	return queryDeltas(ctx, q, inumber, period, periodArgs...)
+}
+
+// queryDeltas reads the deltas of a file, whether the journal is enabled or not.
+func queryDeltas(ctx context.Context, q querier, inumber int64, period string, periodArgs ...any) ([]contentDelta, error) {
+	res, err := q.QueryContext(ctx, fmt.Sprintf("SELECT id, off, data FROM content_delta %s WHERE inumber=?", period), append(periodArgs, inumber)...)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Close()
+
+	var deltas []contentDelta
+	for res.Next() {
+		var d contentDelta
+		if err := res.Scan(&d.id, &d.offset, &d.data); err != nil {
+			return nil, err
+		}
+		deltas = append(deltas, d)
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i].id < deltas[j].id })
+
+	return deltas, res.Err()
+}
+
+// applyDeltas returns content with the deltas written on top of it, in order.
+func applyDeltas(content []byte, deltas []contentDelta) []byte {
+	for _, d := range deltas {
+		if end := d.offset + int64(len(d.data)); end > int64(len(content)) {
+			content = append(content, make([]byte, end-int64(len(content)))...)
+		}
+		copy(content[d.offset:], d.data)
+	}
+
+	return content
+}
+
+// clearDeltas removes the deltas of a file, once its whole content is written or the file deleted.
+func (idb *ImmuDbClient) clearDeltas(ctx context.Context, q querier, inumber int64) error {
+	if !idb.journal {
+		return nil
+	}
+	_, err := q.ExecContext(ctx, "DELETE FROM content_delta WHERE inumber=?", inumber)
+
+	return err
+}
@@ -0,0 +1,563 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"immufs/pkg/config"
+	"immufs/pkg/tracing"
+
+	"github.com/codenotary/immudb/embedded/store"
+	"github.com/codenotary/immudb/pkg/api/schema"
+	"github.com/codenotary/immudb/pkg/client"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/sirupsen/logrus"
+)
+
+// KVBackend stores the filesystem in the key-value store of immudb instead of SQL tables, with a key per inode,
+// per content and per extended attribute:
+//
+//	immufs.inode.<inumber>         the inode, in JSON format
+//	immufs.content.<inumber>       the content of a file or symlink, or the entries of a directory
+//	immufs.xattr.<inumber>.<name>  the value of an extended attribute
+//	immufs.inumber                 the last inumber allocated
+//
+// Every change is an immudb transaction, verifiable like those of the sql backend. The features relying on the
+// SQL tables (snapshots, versions, quotas, locks...) are not available.
+type KVBackend struct {
+	log *logrus.Entry
+	cl  *sql.DB
+
+	queryTimeouts
+}
+
+var _ Backend = (*KVBackend)(nil)
+
+func init() {
+	RegisterBackend(BackendKV, func(ctx context.Context, cfg *config.Config, logger *logrus.Logger) (Backend, error) {
+		return NewKVBackend(ctx, cfg, logger)
+	})
+}
+
+// NewKVBackend returns a backend storing the filesystem in the key-value store of the configured database.
+func NewKVBackend(ctx context.Context, cfg *config.Config, logger *logrus.Logger) (*KVBackend, error) {
+	opts := clientOptions(cfg)
+	if cfg.CreateDatabase {
+		if err := createDatabase(ctx, opts, cfg.MetadataTimeout); err != nil {
+			return nil, err
+		}
+	}
+	connector, err := immudbConnector(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create immudb client: %w", err)
+	}
+
+	kv := &KVBackend{
+		log: logger.WithField("component", "kv backend"),
+		cl:  sql.OpenDB(tracing.WrapConnector(connector)),
+	}
+	kv.setTimeouts(cfg)
+
+	return kv, nil
+}
+
+// Keys of the kv backend.
+const kvPrefix = "immufs."
+
+var kvInumberKey = []byte(kvPrefix + "inumber")
+
+func kvInodeKey(inumber int64) []byte {
+	return []byte(kvPrefix + "inode." + strconv.FormatInt(inumber, 10))
+}
+
+func kvContentKey(inumber int64) []byte {
+	return []byte(kvPrefix + "content." + strconv.FormatInt(inumber, 10))
+}
+
+func kvXattrPrefix(inumber int64) []byte {
+	return []byte(kvPrefix + "xattr." + strconv.FormatInt(inumber, 10) + ".")
+}
+
+func kvXattrKey(inumber int64, name string) []byte {
+	return append(kvXattrPrefix(inumber), name...)
+}
+
+// Maximum number of entries read by a scan request.
+const kvScanLimit = 1000
+
+// isKeyNotFound tells whether err is due to a missing key. Errors of immudb lose their type through gRPC.
+func isKeyNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), store.ErrKeyNotFound.Error())
+}
+
+// isPreconditionFailed tells whether err is due to a precondition of a write not being met.
+func isPreconditionFailed(err error) bool {
+	return err != nil && strings.Contains(err.Error(), store.ErrPreconditionFailed.Error())
+}
+
+// kvScan returns the entries whose key starts with prefix.
+func kvScan(ctx context.Context, cl client.ImmuClient, prefix []byte) ([]*schema.Entry, error) {
+	var entries []*schema.Entry
+	var seek []byte
+	for {
+		res, err := cl.Scan(ctx, &schema.ScanRequest{Prefix: prefix, SeekKey: seek, Limit: kvScanLimit})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, res.Entries...)
+		if len(res.Entries) < kvScanLimit {
+			return entries, nil
+		}
+		seek = res.Entries[len(res.Entries)-1].Key
+	}
+}
+
+// get returns the entry of a key, or nil if there is none.
+func (kv *KVBackend) get(ctx context.Context, key []byte) (*schema.Entry, error) {
+	var entry *schema.Entry
+	err := withImmuClient(ctx, kv.cl, func(cl client.ImmuClient) error {
+		var err error
+		entry, err = cl.Get(ctx, key)
+
+		return err
+	})
+	if isKeyNotFound(err) {
+		return nil, nil
+	}
+
+	return entry, wrapErr(err)
+}
+
+// set writes the given entries in a single transaction.
+func (kv *KVBackend) set(ctx context.Context, kvs ...*schema.KeyValue) error {
+	err := withImmuClient(ctx, kv.cl, func(cl client.ImmuClient) error {
+		_, err := cl.SetAll(ctx, &schema.SetRequest{KVs: kvs})
+
+		return err
+	})
+
+	return wrapErr(err)
+}
+
+func inodeKV(inode *Inode) (*schema.KeyValue, error) {
+	value, err := json.Marshal(inode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &schema.KeyValue{Key: kvInodeKey(inode.Inumber), Value: value}, nil
+}
+
+func (kv *KVBackend) GetInode(ctx context.Context, inumber int64) (*Inode, error) {
+	opCtx := ctx
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	entry, err := kv.get(ctx, kvInodeKey(inumber))
+	if err != nil {
+		kv.log.Errorf("could not get inode %d: %s", inumber, err)
+
+		return nil, err
+	}
+	if entry == nil {
+		return nil, ErrInodeNotFound
+	}
+
+	inode := &Inode{}
+	if err := json.Unmarshal(entry.Value, inode); err != nil {
+		return nil, fmt.Errorf("inode %d: %w", inumber, err)
+	}
+	inode.cl = kv
+	inode.ctx = detach(opCtx)
+
+	return inode, nil
+}
+
+func (kv *KVBackend) WriteInode(ctx context.Context, inode *Inode) error {
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	entry, err := inodeKV(inode)
+	if err != nil {
+		return err
+	}
+	if err := kv.set(ctx, entry); err != nil {
+		kv.log.Errorf("could not write inode: %s", err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (kv *KVBackend) DeleteInode(ctx context.Context, inumber int64) error {
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	err := withImmuClient(ctx, kv.cl, func(cl client.ImmuClient) error {
+		// Deleting a missing key fails: only delete those found.
+		found, err := cl.GetAll(ctx, [][]byte{kvInodeKey(inumber), kvContentKey(inumber)})
+		if err != nil {
+			return err
+		}
+		xattrs, err := kvScan(ctx, cl, kvXattrPrefix(inumber))
+		if err != nil {
+			return err
+		}
+
+		var keys [][]byte
+		for _, e := range append(found.Entries, xattrs...) {
+			keys = append(keys, e.Key)
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+		_, err = cl.Delete(ctx, &schema.DeleteKeysRequest{Keys: keys})
+
+		return err
+	})
+	if err != nil {
+		kv.log.Errorf("could not delete inode %d: %s", inumber, err)
+	}
+
+	return wrapErr(err)
+}
+
+// lastInumber returns the last inumber allocated, with the precondition for allocating the next one.
+func lastInumber(ctx context.Context, cl client.ImmuClient) (int64, *schema.Precondition, error) {
+	entry, err := cl.Get(ctx, kvInumberKey)
+	if isKeyNotFound(err) {
+		// The root inode is created without allocating its inumber.
+		return fuseops.RootInodeID, schema.PreconditionKeyMustNotExist(kvInumberKey), nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	last, err := strconv.ParseInt(string(entry.Value), 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid last inumber: %w", err)
+	}
+
+	return last, schema.PreconditionKeyNotModifiedAfterTX(kvInumberKey, entry.Tx), nil
+}
+
+func (kv *KVBackend) AllocateInumber(ctx context.Context) (inumber int64, generation int64, err error) {
+	return kv.AllocateInumbers(ctx, 1)
+}
+
+// AllocateInumbers reserves n consecutive inumbers, returning the first one.
+func (kv *KVBackend) AllocateInumbers(ctx context.Context, n int64) (first int64, generation int64, err error) {
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	err = withImmuClient(ctx, kv.cl, func(cl client.ImmuClient) error {
+		// Another mount allocating an inumber meanwhile makes the precondition fail: try again.
+		for attempt := 0; ; attempt++ {
+			last, precondition, err := lastInumber(ctx, cl)
+			if err != nil {
+				return err
+			}
+			if last > math.MaxInt64-n {
+				return ErrNoInumbers
+			}
+
+			_, err = cl.SetAll(ctx, &schema.SetRequest{
+				KVs:           []*schema.KeyValue{{Key: kvInumberKey, Value: []byte(strconv.FormatInt(last+n, 10))}},
+				Preconditions: []*schema.Precondition{precondition},
+			})
+			if isPreconditionFailed(err) && attempt < maxConflictRetries {
+				continue
+			}
+			first = last + 1
+
+			return err
+		}
+	})
+	if err != nil {
+		kv.log.Errorf("could not allocate %d inumbers: %s", n, err)
+
+		return -1, 0, wrapErr(err)
+	}
+
+	// Inumbers are never reused.
+	return first, 1, nil
+}
+
+func (kv *KVBackend) NextInumber(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	var last int64
+	err := withImmuClient(ctx, kv.cl, func(cl client.ImmuClient) error {
+		var err error
+		last, _, err = lastInumber(ctx, cl)
+
+		return err
+	})
+	if err != nil {
+		kv.log.Errorf("could not get next inumber: %s", err)
+
+		return 0, wrapErr(err)
+	}
+
+	return last + 1, nil
+}
+
+func (kv *KVBackend) GetChildren(ctx context.Context, parent int64) ([]fuseutil.Dirent, error) {
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	entry, err := kv.get(ctx, kvContentKey(parent))
+	if err != nil {
+		kv.log.Errorf("could not get directory %d content: %s", parent, err)
+
+		return nil, err
+	}
+	if entry == nil {
+		kv.log.Errorf("Directory %d content not found", parent)
+
+		return nil, fmt.Errorf("Inode %d not found", parent)
+	}
+
+	return unmarshalDirents(entry.Value)
+}
+
+func (kv *KVBackend) WriteChildren(ctx context.Context, parent int64, children []fuseutil.Dirent) error {
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	content, err := marshalDirents(children)
+	if err != nil {
+		return err
+	}
+	if err := kv.set(ctx, &schema.KeyValue{Key: kvContentKey(parent), Value: content}); err != nil {
+		kv.log.Errorf("could not write directory %d content: %s", parent, err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (kv *KVBackend) UpdateChildren(ctx context.Context, parent *Inode, update func([]fuseutil.Dirent) ([]fuseutil.Dirent, error)) error {
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	key := kvContentKey(parent.Inumber)
+	err := withImmuClient(ctx, kv.cl, func(cl client.ImmuClient) error {
+		for attempt := 0; ; attempt++ {
+			entry, err := cl.Get(ctx, key)
+			if err != nil {
+				return err
+			}
+			dirents, err := unmarshalDirents(entry.Value)
+			if err != nil {
+				return err
+			}
+			if dirents, err = update(dirents); err != nil {
+				return err
+			}
+			content, err := marshalDirents(dirents)
+			if err != nil {
+				return err
+			}
+			inode, err := inodeKV(parent)
+			if err != nil {
+				return err
+			}
+
+			// Fails if another mount changed the directory meanwhile: its entries are read again.
+			_, err = cl.SetAll(ctx, &schema.SetRequest{
+				KVs:           []*schema.KeyValue{{Key: key, Value: content}, inode},
+				Preconditions: []*schema.Precondition{schema.PreconditionKeyNotModifiedAfterTX(key, entry.Tx)},
+			})
+			if isPreconditionFailed(err) && attempt < maxConflictRetries {
+				continue
+			}
+
+			return err
+		}
+	})
+	if err != nil {
+		kv.log.Errorf("could not update directory %d: %s", parent.Inumber, err)
+	}
+
+	return wrapErr(err)
+}
+
+func (kv *KVBackend) ReadContent(ctx context.Context, inumber int64) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, kv.readTimeout.Load())
+	defer cancel()
+
+	entry, err := kv.get(ctx, kvContentKey(inumber))
+	if err != nil {
+		kv.log.Errorf("could not get file %d content: %s", inumber, err)
+
+		return nil, err
+	}
+	if entry == nil {
+		kv.log.Warnf("Content not found for inode: %d", inumber)
+
+		return []byte{}, nil
+	}
+
+	return entry.Value, nil
+}
+
+func (kv *KVBackend) WriteFile(ctx context.Context, inode *Inode, data []byte) error {
+	ctx, cancel := withTimeout(ctx, kv.writeTimeout.Load())
+	defer cancel()
+
+	inode.Size = int64(len(data))
+	entry, err := inodeKV(inode)
+	if err != nil {
+		return err
+	}
+	if err := kv.set(ctx, &schema.KeyValue{Key: kvContentKey(inode.Inumber), Value: data}, entry); err != nil {
+		kv.log.Errorf("could not write file %d: %s", inode.Inumber, err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (kv *KVBackend) GetXattr(ctx context.Context, inumber int64, name string) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	entry, err := kv.get(ctx, kvXattrKey(inumber, name))
+	if err != nil {
+		kv.log.Errorf("could not get extended attribute %s of inode %d: %s", name, inumber, err)
+
+		return nil, err
+	}
+	if entry == nil {
+		return nil, ErrXattrNotFound
+	}
+
+	return entry.Value, nil
+}
+
+func (kv *KVBackend) ListXattrs(ctx context.Context, inumber int64) ([]string, error) {
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	prefix := kvXattrPrefix(inumber)
+	var names []string
+	err := withImmuClient(ctx, kv.cl, func(cl client.ImmuClient) error {
+		entries, err := kvScan(ctx, cl, prefix)
+		for _, e := range entries {
+			names = append(names, string(e.Key[len(prefix):]))
+		}
+
+		return err
+	})
+	if err != nil {
+		kv.log.Errorf("could not list extended attributes of inode %d: %s", inumber, err)
+
+		return nil, wrapErr(err)
+	}
+
+	return names, nil
+}
+
+func (kv *KVBackend) SetXattr(ctx context.Context, inumber int64, name string, value []byte) error {
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	if err := kv.set(ctx, &schema.KeyValue{Key: kvXattrKey(inumber, name), Value: value}); err != nil {
+		kv.log.Errorf("could not set extended attribute %s of inode %d: %s", name, inumber, err)
+
+		return err
+	}
+
+	return nil
+}
+
+func (kv *KVBackend) RemoveXattr(ctx context.Context, inumber int64, name string) error {
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	err := withImmuClient(ctx, kv.cl, func(cl client.ImmuClient) error {
+		_, err := cl.Delete(ctx, &schema.DeleteKeysRequest{Keys: [][]byte{kvXattrKey(inumber, name)}})
+
+		return err
+	})
+	// Like the sql backend, removing a missing attribute is not an error.
+	if err != nil && !isKeyNotFound(err) {
+		kv.log.Errorf("could not remove extended attribute %s of inode %d: %s", name, inumber, err)
+
+		return wrapErr(err)
+	}
+
+	return nil
+}
+
+func (kv *KVBackend) SpaceUsed(ctx context.Context) (int64, error) {
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	var total int64
+	err := withImmuClient(ctx, kv.cl, func(cl client.ImmuClient) error {
+		entries, err := kvScan(ctx, cl, []byte(kvPrefix+"inode."))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			var inode Inode
+			if err := json.Unmarshal(e.Value, &inode); err != nil {
+				return fmt.Errorf("%s: %w", e.Key, err)
+			}
+			total += inode.Size
+		}
+
+		return nil
+	})
+	if err != nil {
+		kv.log.Errorf("could not compute space used: %s", err)
+
+		return 0, wrapErr(err)
+	}
+
+	return total, nil
+}
+
+func (kv *KVBackend) CurrentTx(ctx context.Context) (uint64, error) {
+	ctx, cancel := withTimeout(ctx, kv.metadataTimeout.Load())
+	defer cancel()
+
+	var txID uint64
+	err := withImmuClient(ctx, kv.cl, func(cl client.ImmuClient) error {
+		state, err := cl.CurrentState(ctx)
+		if err != nil {
+			return err
+		}
+		txID = state.GetTxId()
+
+		return nil
+	})
+	if err != nil {
+		kv.log.Errorf("could not get current transaction: %s", err)
+
+		return 0, wrapErr(err)
+	}
+
+	return txID, nil
+}
+
+func (kv *KVBackend) Destroy(ctx context.Context) error {
+	if err := kv.cl.Close(); err != nil {
+		kv.log.Errorf("could not close session: %s", err)
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse"
+)
+
+var (
+	ErrLockNotFound       = errors.New("file lock not found")
+	ErrRetentionShortened = errors.New("retention period can only be extended")
+)
+
+// FileLock prevents a file from being modified or deleted, until a given time or until
+// a legal hold is released. Locks are kept in the `file_lock` table, whose history records them immutably.
+type FileLock struct {
+	Inumber     int64
+	RetainUntil time.Time // Zero if there is no retention period
+	LegalHold   bool
+}
+
+// Active tells whether the lock currently prevents changes.
+func (l *FileLock) Active() bool {
+	return l.LegalHold || time.Now().Before(l.RetainUntil)
+}
+
+// GetLock returns the lock of a file, or ErrLockNotFound.
+func (idb *ImmuDbClient) GetLock(ctx context.Context, inumber int64) (*FileLock, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	l := FileLock{Inumber: inumber}
+	var retainUntil sql.NullTime
+	var legalHold sql.NullBool
+	err := idb.cl.QueryRowContext(ctx, "SELECT retain_until, legal_hold FROM file_lock WHERE inumber=?", inumber).Scan(&retainUntil, &legalHold)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrLockNotFound
+	}
+	if err != nil {
+		idb.log.Errorf("could not get lock of inode %d: %s", inumber, err)
+
+		return nil, wrapErr(err)
+	}
+	l.RetainUntil = retainUntil.Time
+	l.LegalHold = legalHold.Bool
+
+	return &l, nil
+}
+
+// updateLock reads, changes and writes the lock of a file in a single transaction.
+func (idb *ImmuDbClient) updateLock(ctx context.Context, inumber int64, update func(l *FileLock) error) error {
+	err := idb.inTx(ctx, idb.metadataTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+		l := FileLock{Inumber: inumber}
+		var retainUntil sql.NullTime
+		var legalHold sql.NullBool
+		err := tx.QueryRowContext(ctx, "SELECT retain_until, legal_hold FROM file_lock WHERE inumber=?", inumber).Scan(&retainUntil, &legalHold)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		l.RetainUntil = retainUntil.Time
+		l.LegalHold = legalHold.Bool
+
+		if err := update(&l); err != nil {
+			return err
+		}
+
+		var until interface{}
+		if !l.RetainUntil.IsZero() {
+			until = l.RetainUntil
+		}
+		_, err = tx.ExecContext(ctx, "UPSERT INTO file_lock(inumber, retain_until, legal_hold) VALUES(?, ?, ?)", inumber, until, l.LegalHold)
+
+		return err
+	})
+	if err != nil {
+		idb.log.Errorf("could not update lock of inode %d: %s", inumber, err)
+	}
+
+	return err
+}
+
+// Retain prevents changes to a file until the given time. The retention period can only be extended.
+func (idb *ImmuDbClient) Retain(ctx context.Context, inumber int64, until time.Time) error {
+	return idb.updateLock(ctx, inumber, func(l *FileLock) error {
+		if until.Before(l.RetainUntil) {
+			return ErrRetentionShortened
+		}
+		l.RetainUntil = until
+
+		return nil
+	})
+}
+
+// SetLegalHold places or releases a legal hold on a file.
+func (idb *ImmuDbClient) SetLegalHold(ctx context.Context, inumber int64, hold bool) error {
+	return idb.updateLock(ctx, inumber, func(l *FileLock) error {
+		l.LegalHold = hold
+
+		return nil
+	})
+}
+
+// Virtual extended attributes to read and place file locks. Legal holds can only be released with the lock command.
+const (
+	lockXattrRetainUntil = virtualXattrPrefix + "retain_until"
+	lockXattrLegalHold   = virtualXattrPrefix + "legal_hold"
+)
+
+// checkLock returns EPERM if the inode is locked.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) checkLock(api string, inode *Inode) error {
+	if fs.idb == nil {
+		return nil
+	}
+	l, err := fs.idb.GetLock(inode.opContext(), inode.Inumber)
+	if errors.Is(err, ErrLockNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if l.Active() {
+		fs.log.WithField("API", api).Warningf("Inode %d is locked: %+v", inode.Inumber, *l)
+
+		return syscall.EPERM
+	}
+
+	return nil
+}
+
+// lockXattr returns the value of a virtual lock attribute.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) lockXattr(inode *Inode, name string) ([]byte, error) {
+	if fs.idb == nil {
+		return nil, fuse.ENOATTR
+	}
+	l, err := fs.idb.GetLock(inode.opContext(), inode.Inumber)
+	if errors.Is(err, ErrLockNotFound) {
+		return nil, fuse.ENOATTR
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case name == lockXattrRetainUntil && !l.RetainUntil.IsZero():
+		return []byte(l.RetainUntil.Format(time.RFC3339)), nil
+	case name == lockXattrLegalHold && l.LegalHold:
+		return []byte("1"), nil
+	}
+
+	return nil, fuse.ENOATTR
+}
+
+// setLockXattr places a lock through a virtual attribute: a retention period (RFC 3339) or a legal hold ("1").
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) setLockXattr(inode *Inode, name string, value []byte) error {
+	if fs.idb == nil {
+		return ErrNotSupported
+	}
+
+	switch name {
+	case lockXattrRetainUntil:
+		until, err := time.Parse(time.RFC3339, string(value))
+		if err != nil {
+			return fuse.EINVAL
+		}
+		err = fs.idb.Retain(inode.opContext(), inode.Inumber, until)
+		if errors.Is(err, ErrRetentionShortened) {
+			return syscall.EPERM
+		}
+
+		return err
+	case lockXattrLegalHold:
+		if string(value) != "1" {
+			return syscall.EPERM
+		}
+
+		return fs.idb.SetLegalHold(inode.opContext(), inode.Inumber, true)
+	}
+
+	return syscall.EPERM
+}
@@ -0,0 +1,164 @@
+package fs
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/codenotary/immudb/pkg/client"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/sirupsen/logrus"
+)
+
+// Percentage of the index cleaned up by every flush. immudb suggests small values, run frequently,
+// as an alternative to full compactions.
+const indexCleanupPercentage = 1
+
+// FlushIndex persists the immudb index, cleaning up part of it.
+func (idb *ImmuDbClient) FlushIndex(ctx context.Context) error {
+	err := idb.withImmuClient(ctx, func(cl client.ImmuClient) error {
+		_, err := cl.FlushIndex(ctx, indexCleanupPercentage, false)
+
+		return err
+	})
+	if err != nil {
+		idb.log.Errorf("could not flush index: %s", err)
+	}
+
+	return wrapErr(err)
+}
+
+// CompactIndex fully compacts the immudb index. It may take a long time on big databases.
+func (idb *ImmuDbClient) CompactIndex(ctx context.Context) error {
+	err := idb.withImmuClient(ctx, func(cl client.ImmuClient) error {
+		return cl.CompactIndex(ctx, &empty.Empty{})
+	})
+	if err != nil {
+		idb.log.Errorf("could not compact index: %s", err)
+	}
+
+	return wrapErr(err)
+}
+
+// maintainer periodically flushes and compacts the immudb index on behalf of a long-lived mount.
+type maintainer struct {
+	idb *ImmuDbClient
+	log *logrus.Entry
+
+	// GUARDED_BY(mu)
+	flushInterval   time.Duration
+	compactInterval time.Duration
+
+	// Completion time of the last successful runs.
+	//
+	// GUARDED_BY(mu)
+	lastFlush      time.Time
+	lastCompaction time.Time
+	mu             sync.Mutex
+
+	stop  chan struct{}
+	done  chan struct{}
+	reset chan struct{}
+}
+
+func newMaintainer(idb *ImmuDbClient, log *logrus.Entry, flushInterval, compactInterval time.Duration) *maintainer {
+	return &maintainer{
+		idb:             idb,
+		log:             log.WithField("component", "maintainer"),
+		flushInterval:   flushInterval,
+		compactInterval: compactInterval,
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+		reset:           make(chan struct{}, 1),
+	}
+}
+
+// Start runs the maintenance in background.
+func (m *maintainer) Start() {
+	go m.run()
+}
+
+// Stop terminates the maintenance and waits for the current run, if any, to return.
+func (m *maintainer) Stop() {
+	close(m.stop)
+	<-m.done
+}
+
+// status returns the completion time of the last flush and of the last compaction (zero if none).
+func (m *maintainer) status() (lastFlush time.Time, lastCompaction time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lastFlush, m.lastCompaction
+}
+
+// setIntervals changes the intervals between the flushes and between the compactions, zero disabling them. The
+// intervals start over from the change.
+func (m *maintainer) setIntervals(flushInterval, compactInterval time.Duration) {
+	m.mu.Lock()
+	m.flushInterval, m.compactInterval = flushInterval, compactInterval
+	m.mu.Unlock()
+
+	select {
+	case m.reset <- struct{}{}:
+	default:
+	}
+}
+
+// intervals returns the intervals between the flushes and between the compactions.
+func (m *maintainer) intervals() (flushInterval, compactInterval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.flushInterval, m.compactInterval
+}
+
+// ticker returns a channel ticking at every interval, or never if the interval is zero.
+func ticker(interval time.Duration) (<-chan time.Time, func()) {
+	if interval <= 0 {
+		return nil, func() {}
+	}
+	t := time.NewTicker(interval)
+
+	return t.C, t.Stop
+}
+
+func (m *maintainer) run() {
+	defer close(m.done)
+
+	flushInterval, compactInterval := m.intervals()
+	flushC, stopFlush := ticker(flushInterval)
+	compactC, stopCompact := ticker(compactInterval)
+	defer func() {
+		stopFlush()
+		stopCompact()
+	}()
+
+	// Errors are only logged: maintenance is tried again at the next tick.
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-m.reset:
+			stopFlush()
+			stopCompact()
+			flushInterval, compactInterval := m.intervals()
+			flushC, stopFlush = ticker(flushInterval)
+			compactC, stopCompact = ticker(compactInterval)
+		case <-flushC:
+			if err := m.idb.FlushIndex(context.Background()); err == nil {
+				m.mu.Lock()
+				m.lastFlush = time.Now()
+				m.mu.Unlock()
+			}
+		case <-compactC:
+			m.log.Infof("compacting index")
+			if err := m.idb.CompactIndex(context.Background()); err == nil {
+				m.mu.Lock()
+				m.lastCompaction = time.Now()
+				m.mu.Unlock()
+				m.log.Infof("index compacted")
+			}
+		}
+	}
+}
@@ -0,0 +1,264 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"immufs/pkg/config"
+
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryBackend keeps the filesystem in memory, e.g. to develop and test the FUSE layer, or to run a demo mount,
+// without an immudb server. Nothing survives the backend, and nothing is versioned: the features relying on
+// immudb (snapshots, versions, quotas, locks...) are not available.
+type MemoryBackend struct {
+	log *logrus.Entry
+
+	mu sync.Mutex
+	// GUARDED_BY(mu)
+	inodes   map[int64]Inode
+	contents map[int64][]byte
+	xattrs   map[int64]map[string][]byte
+	// Last inumber allocated or written, and number of changes made.
+	lastInumber int64
+	tx          uint64
+}
+
+// NewMemoryBackend returns an empty backend.
+func NewMemoryBackend(logger *logrus.Logger) *MemoryBackend {
+	return &MemoryBackend{
+		log:      logger.WithField("component", "memory backend"),
+		inodes:   make(map[int64]Inode),
+		contents: make(map[int64][]byte),
+		xattrs:   make(map[int64]map[string][]byte),
+	}
+}
+
+var _ Backend = (*MemoryBackend)(nil)
+
+func init() {
+	RegisterBackend(BackendMemory, func(ctx context.Context, cfg *config.Config, logger *logrus.Logger) (Backend, error) {
+		return NewMemoryBackend(logger), nil
+	})
+}
+
+// copyBytes returns a copy of b, so that the callers never share the buffers of the backend.
+func copyBytes(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+// LOCKS_REQUIRED(m.mu)
+func (m *MemoryBackend) writeInode(inode *Inode) {
+	stored := *inode
+	stored.cl, stored.ctx = nil, nil
+	m.inodes[inode.Inumber] = stored
+	if inode.Inumber > m.lastInumber {
+		m.lastInumber = inode.Inumber
+	}
+	m.tx++
+}
+
+func (m *MemoryBackend) GetInode(ctx context.Context, inumber int64) (*Inode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.inodes[inumber]
+	if !ok {
+		return nil, ErrInodeNotFound
+	}
+	inode := stored
+	inode.cl = m
+	inode.ctx = detach(ctx)
+
+	return &inode, nil
+}
+
+func (m *MemoryBackend) WriteInode(ctx context.Context, inode *Inode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.writeInode(inode)
+
+	return nil
+}
+
+func (m *MemoryBackend) DeleteInode(ctx context.Context, inumber int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.inodes, inumber)
+	delete(m.contents, inumber)
+	delete(m.xattrs, inumber)
+	m.tx++
+
+	return nil
+}
+
+func (m *MemoryBackend) AllocateInumber(ctx context.Context) (inumber int64, generation int64, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastInumber++
+
+	return m.lastInumber, 1, nil
+}
+
+func (m *MemoryBackend) NextInumber(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lastInumber + 1, nil
+}
+
+// LOCKS_REQUIRED(m.mu)
+func (m *MemoryBackend) getChildren(parent int64) ([]fuseutil.Dirent, error) {
+	content, ok := m.contents[parent]
+	if !ok {
+		return nil, fmt.Errorf("Inode %d not found", parent)
+	}
+
+	return unmarshalDirents(content)
+}
+
+func (m *MemoryBackend) GetChildren(ctx context.Context, parent int64) ([]fuseutil.Dirent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.getChildren(parent)
+}
+
+func (m *MemoryBackend) WriteChildren(ctx context.Context, parent int64, children []fuseutil.Dirent) error {
+	content, err := marshalDirents(children)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.contents[parent] = content
+	m.tx++
+
+	return nil
+}
+
+func (m *MemoryBackend) UpdateChildren(ctx context.Context, parent *Inode, update func([]fuseutil.Dirent) ([]fuseutil.Dirent, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dirents, err := m.getChildren(parent.Inumber)
+	if err != nil {
+		return err
+	}
+	if dirents, err = update(dirents); err != nil {
+		return err
+	}
+	content, err := marshalDirents(dirents)
+	if err != nil {
+		return err
+	}
+
+	m.contents[parent.Inumber] = content
+	m.writeInode(parent)
+
+	return nil
+}
+
+func (m *MemoryBackend) ReadContent(ctx context.Context, inumber int64) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return copyBytes(m.contents[inumber]), nil
+}
+
+func (m *MemoryBackend) WriteFile(ctx context.Context, inode *Inode, data []byte) error {
+	inode.Size = int64(len(data))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.contents[inode.Inumber] = copyBytes(data)
+	m.writeInode(inode)
+
+	return nil
+}
+
+func (m *MemoryBackend) GetXattr(ctx context.Context, inumber int64, name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.xattrs[inumber][name]
+	if !ok {
+		return nil, ErrXattrNotFound
+	}
+
+	return copyBytes(value), nil
+}
+
+func (m *MemoryBackend) ListXattrs(ctx context.Context, inumber int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var names []string
+	for name := range m.xattrs[inumber] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (m *MemoryBackend) SetXattr(ctx context.Context, inumber int64, name string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.xattrs[inumber] == nil {
+		m.xattrs[inumber] = make(map[string][]byte)
+	}
+	m.xattrs[inumber][name] = copyBytes(value)
+	m.tx++
+
+	return nil
+}
+
+func (m *MemoryBackend) RemoveXattr(ctx context.Context, inumber int64, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.xattrs[inumber], name)
+	m.tx++
+
+	return nil
+}
+
+func (m *MemoryBackend) SpaceUsed(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total int64
+	for _, inode := range m.inodes {
+		total += inode.Size
+	}
+
+	return total, nil
+}
+
+func (m *MemoryBackend) CurrentTx(ctx context.Context) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.tx, nil
+}
+
+func (m *MemoryBackend) Destroy(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.log.Infof("dropping %d inodes", len(m.inodes))
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package fs
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+var (
+	ErrTreeRootNotFound = errors.New("tree root not found")
+	ErrTreeRootExists   = errors.New("tree root already exists")
+)
+
+// Prefix of every node hashed by TreeHash, changed whenever the hashing changes.
+const treeHashVersion = "immufs tree v1"
+
+// TreeDigest is the root of the hash tree of the namespace as of a transaction (see TreeHash).
+type TreeDigest struct {
+	Root  string
+	Tx    uint64
+	Files int64
+	Dirs  int64
+}
+
+// TreeRoot is a root of the hash tree recorded under a name, e.g. when the tree is certified.
+type TreeRoot struct {
+	Name      string
+	Tx        uint64
+	Root      string
+	CreatedAt time.Time
+}
+
+// TreeHash computes a hash tree over the namespace as of a transaction, 0 standing for the last one, and returns its
+// root, in hexadecimal. Each directory is hashed over its entries sorted by name: the name, the mode, the owner, the
+// size (of the files) and the modification time of each child, and the hash of the child, i.e. the checksum of the
+// content of a file or symlink (see contentChecksum), or the hash of a directory. The root hashes the root directory
+// likewise, so that any change to the names, the attributes or the contents changes it. The access and change times,
+// the inumbers and the extended attributes are left out.
+func (idb *ImmuDbClient) TreeHash(ctx context.Context, tx uint64) (*TreeDigest, error) {
+	if tx == 0 {
+		current, err := idb.CurrentTx(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tx = current
+	}
+	period, err := idb.periodAsOf(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &treeHasher{idb: idb, tx: tx, period: period, files: make(map[int64]string), visiting: make(map[int64]bool)}
+	root, err := idb.getInode(ctx, 1, period)
+	if err != nil {
+		return nil, err
+	}
+	dirHash, err := h.hashDir(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TreeDigest{Root: hashNode(treeEntry("", root, dirHash)), Tx: tx, Files: int64(len(h.files)), Dirs: h.dirs + 1}, nil
+}
+
+type treeHasher struct {
+	idb    *ImmuDbClient
+	tx     uint64
+	period string
+	// Hashes of the files, by inumber: those linked several times are read once.
+	files map[int64]string
+	dirs  int64
+	// Directories being hashed, against cycles.
+	visiting map[int64]bool
+}
+
+// hashDir returns the hash of a directory, over its entries.
+func (h *treeHasher) hashDir(ctx context.Context, inumber int64) (string, error) {
+	if h.visiting[inumber] {
+		return "", fmt.Errorf("directory %d contains itself", inumber)
+	}
+	h.visiting[inumber] = true
+	defer delete(h.visiting, inumber)
+
+	children, err := h.idb.getChildren(ctx, inumber, h.period)
+	if err != nil {
+		return "", err
+	}
+	var live []fuseutil.Dirent
+	var inumbers []int64
+	for _, child := range children {
+		if child.Type != fuseutil.DT_Unknown {
+			live = append(live, child)
+			inumbers = append(inumbers, int64(child.Inode))
+		}
+	}
+	sort.Slice(live, func(i, j int) bool { return live[i].Name < live[j].Name })
+	inodes, err := h.idb.getInodes(ctx, inumbers, h.period)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, child := range live {
+		inode, ok := inodes[int64(child.Inode)]
+		if !ok {
+			h.idb.log.Warnf("Inode %d not found", child.Inode)
+
+			return "", ErrInodeNotFound
+		}
+		hash, err := h.hashChild(ctx, inode)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(treeEntry(child.Name, inode, hash))
+	}
+
+	return hashNode(b.String()), nil
+}
+
+func (h *treeHasher) hashChild(ctx context.Context, inode *Inode) (string, error) {
+	if os.FileMode(inode.Mode).IsDir() {
+		h.dirs++
+
+		return h.hashDir(ctx, inode.Inumber)
+	}
+	if hash, ok := h.files[inode.Inumber]; ok {
+		return hash, nil
+	}
+	content, err := h.idb.ReadContentAt(ctx, inode.Inumber, h.tx)
+	if err != nil {
+		return "", err
+	}
+	h.files[inode.Inumber] = contentChecksum(content)
+
+	return h.files[inode.Inumber], nil
+}
+
+// treeEntry describes a child of a directory for its hash. The name is prefixed with its length, so that no name
+// can be mistaken for the end of an entry.
+func treeEntry(name string, inode *Inode, hash string) string {
+	size := inode.Size
+	if os.FileMode(inode.Mode).IsDir() {
+		size = 0
+	}
+
+	return fmt.Sprintf("%d:%s %d %d %d %d %s %s\n", len(name), name, inode.Mode, inode.Uid, inode.Gid, size, inode.Mtime.UTC().Format(time.RFC3339Nano), hash)
+}
+
+func hashNode(node string) string {
+	digest := sha256.Sum256([]byte(treeHashVersion + "\n" + node))
+
+	return hex.EncodeToString(digest[:])
+}
+
+// RecordTreeRoot records a root of the hash tree under a name, which can't be taken already.
+func (idb *ImmuDbClient) RecordTreeRoot(ctx context.Context, name string, digest *TreeDigest) (*TreeRoot, error) {
+	root := &TreeRoot{Name: name, Tx: digest.Tx, Root: digest.Root, CreatedAt: time.Now()}
+	err := idb.inTx(ctx, idb.metadataTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+		var existing string
+		err := tx.QueryRowContext(ctx, "SELECT name FROM tree_root WHERE name=?", name).Scan(&existing)
+		if err == nil {
+			return ErrTreeRootExists
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO tree_root(name, tx, root, created_at) VALUES(?, ?, ?, ?)", root.Name, int64(root.Tx), root.Root, root.CreatedAt)
+
+		return err
+	})
+	if err != nil {
+		idb.log.Errorf("could not record tree root %s: %s", name, err)
+
+		return nil, err
+	}
+
+	return root, nil
+}
+
+// GetTreeRoot retrieves a recorded root of the hash tree by name.
+func (idb *ImmuDbClient) GetTreeRoot(ctx context.Context, name string) (*TreeRoot, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	var root TreeRoot
+	var txID int64
+	err := idb.cl.QueryRowContext(ctx, "SELECT name, tx, root, created_at FROM tree_root WHERE name=?", name).Scan(&root.Name, &txID, &root.Root, &root.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrTreeRootNotFound
+	}
+	if err != nil {
+		idb.log.Errorf("could not get tree root %s: %s", name, err)
+
+		return nil, wrapErr(err)
+	}
+	root.Tx = uint64(txID)
+
+	return &root, nil
+}
@@ -0,0 +1,307 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// Limits of the transactions of a migration: they are committed once either is reached.
+const (
+	migrateBatchFiles = 32
+	migrateBatchBytes = 4 << 20
+)
+
+// MigrateStats summarizes a migration.
+type MigrateStats struct {
+	Dirs    int64
+	Files   int64
+	Bytes   int64
+	Skipped []string
+}
+
+// migrateEntry is a directory or file to migrate.
+type migrateEntry struct {
+	path    string
+	info    fs.FileInfo
+	inumber int64
+	// Entries of a directory.
+	children []fuseutil.Dirent
+}
+
+// AllocateInumbers reserves n consecutive inumbers, returning the first one together with the generation
+// to assign to the new inodes.
+func (idb *ImmuDbClient) AllocateInumbers(ctx context.Context, n int64) (first int64, generation int64, err error) {
+	err = idb.inTx(ctx, idb.metadataTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+		last, gen, err := readAllocator(ctx, tx)
+		if err != nil {
+			return err
+		}
+		if last > math.MaxInt64-n {
+			return ErrNoInumbers
+		}
+
+		_, err = tx.ExecContext(ctx, "UPSERT INTO inumber_allocator(id, last_inumber, generation) VALUES(1, ?, ?)", last+n, gen)
+		if err != nil {
+			return err
+		}
+		first, generation = last+1, gen
+
+		return nil
+	})
+	if err != nil {
+		idb.log.Errorf("could not allocate %d inumbers: %s", n, err)
+
+		return -1, 0, err
+	}
+
+	return first, generation, nil
+}
+
+// Migrate imports a local directory tree into the directory at target, which must exist. It is much faster
+// than copying onto a mount: inumbers are allocated at once, and inodes are written by parallel workers,
+// several per transaction. The imported entries are linked into target last, once everything has been
+// written: should the migration fail, the inodes already written are left as orphans (see Fsck).
+// Symbolic links and special files are skipped; quotas are not enforced.
+func (idb *ImmuDbClient) Migrate(ctx context.Context, src string, target string, workers int) (*MigrateStats, error) {
+	dir, _, err := idb.LookUpPath(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.isDir() {
+		return nil, fmt.Errorf("%s: %w", target, syscall.ENOTDIR)
+	}
+
+	// Walk the tree, remembering the entries of every directory.
+	src = filepath.Clean(src)
+	stats := &MigrateStats{}
+	var entries []*migrateEntry
+	dirs := map[string]*migrateEntry{}
+	err = filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			stats.Skipped = append(stats.Skipped, path)
+
+			return nil
+		}
+
+		e := &migrateEntry{path: path, info: info}
+		entries = append(entries, e)
+		if info.IsDir() {
+			dirs[path] = e
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The root of the tree is not imported itself: its entries are added to target.
+	if len(entries) == 0 || !entries[0].info.IsDir() {
+		return nil, fmt.Errorf("%s: %w", src, syscall.ENOTDIR)
+	}
+	root := entries[0]
+	entries = entries[1:]
+	if len(entries) == 0 {
+		return stats, nil
+	}
+
+	existing, err := idb.GetChildren(ctx, dir.Inumber)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, e := range existing {
+		if e.Type != fuseutil.DT_Unknown {
+			names[e.Name] = true
+		}
+	}
+
+	first, generation, err := idb.AllocateInumbers(ctx, int64(len(entries)))
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range entries {
+		e.inumber = first + int64(i)
+
+		parent := dirs[filepath.Dir(e.path)]
+		if parent == root && names[e.info.Name()] {
+			return nil, fmt.Errorf("%s: %w", filepath.Join(target, e.info.Name()), syscall.EEXIST)
+		}
+		parent.children = append(parent.children, fuseutil.Dirent{
+			Offset: fuseops.DirOffset(len(parent.children) + 1),
+			Inode:  fuseops.InodeID(e.inumber),
+			Name:   e.info.Name(),
+			Type:   direntType(e.info.Mode()),
+		})
+	}
+
+	// Write the inodes in parallel.
+	jobs := make(chan *migrateEntry)
+	errs := make(chan error, workers)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			s, err := idb.migrateWorker(ctx, jobs, generation, dir.Project)
+			if err != nil {
+				errs <- err
+				cancel()
+
+				return
+			}
+			mu.Lock()
+			stats.Dirs += s.Dirs
+			stats.Files += s.Files
+			stats.Bytes += s.Bytes
+			mu.Unlock()
+		}()
+	}
+
+feed:
+	for _, e := range entries {
+		select {
+		case jobs <- e:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		idb.log.Errorf("could not migrate %s: %s", src, err)
+
+		return nil, err
+	default:
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Link the tree into target.
+	dir.Mtime = time.Now()
+	dir.Ctime = dir.Mtime
+	err = idb.UpdateChildren(ctx, dir, func(dirents []fuseutil.Dirent) ([]fuseutil.Dirent, error) {
+		for _, child := range root.children {
+			for _, e := range dirents {
+				if e.Type != fuseutil.DT_Unknown && e.Name == child.Name {
+					return nil, fuse.EEXIST
+				}
+			}
+			child.Offset = fuseops.DirOffset(len(dirents) + 1)
+			dirents = append(dirents, child)
+		}
+
+		return dirents, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// migrateWorker writes the inodes received from jobs, in batches.
+func (idb *ImmuDbClient) migrateWorker(ctx context.Context, jobs <-chan *migrateEntry, generation int64, project int64) (*MigrateStats, error) {
+	stats := &MigrateStats{}
+	var inodes []*Inode
+	var contents [][]byte
+	var size int
+
+	flush := func() error {
+		if len(inodes) == 0 {
+			return nil
+		}
+		err := idb.inTx(ctx, idb.writeTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+			for i, inode := range inodes {
+				isDir := os.FileMode(inode.Mode).IsDir()
+				q := idb.contentOf(ctx, tx, isDir)
+				var err error
+				if isDir {
+					_, err = q.ExecContext(ctx, "UPSERT INTO content(inumber, content) VALUES(?, ?)", inode.Inumber, contents[i])
+				} else {
+					err = idb.upsertFileContent(ctx, q, inode.Inumber, contents[i])
+				}
+				if err != nil {
+					return err
+				}
+				if err := writeInode(ctx, tx, inode); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		inodes, contents, size = inodes[:0], contents[:0], 0
+
+		return err
+	}
+
+	for e := range jobs {
+		inode := &Inode{
+			Inumber:    e.inumber,
+			Nlink:      1,
+			Mode:       int64(e.info.Mode()),
+			Atime:      e.info.ModTime(),
+			Mtime:      e.info.ModTime(),
+			Ctime:      e.info.ModTime(),
+			Crtime:     time.Now(),
+			Generation: generation,
+			Project:    project,
+		}
+		if st, ok := e.info.Sys().(*syscall.Stat_t); ok {
+			inode.Uid, inode.Gid = int64(idb.squash.uid(st.Uid)), int64(idb.squash.gid(st.Gid))
+		}
+
+		var content []byte
+		var err error
+		if e.info.IsDir() {
+			stats.Dirs++
+			content, err = marshalDirents(append([]fuseutil.Dirent{}, e.children...))
+		} else {
+			stats.Files++
+			content, err = os.ReadFile(e.path)
+			inode.Size = int64(len(content))
+			stats.Bytes += inode.Size
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		inodes = append(inodes, inode)
+		contents = append(contents, content)
+		size += len(content)
+		if len(inodes) == migrateBatchFiles || size >= migrateBatchBytes {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return stats, flush()
+}
@@ -0,0 +1,151 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// kernelNotifier pushes invalidations to the kernel, outside of the replies to its requests: it is the FUSE
+// connection of the mount.
+type kernelNotifier interface {
+	InvalidateNode(inode fuseops.InodeID, off int64, size int64) error
+	InvalidateEntry(parent fuseops.InodeID, name string) error
+	NotifyDelete(parent fuseops.InodeID, child fuseops.InodeID, name string) error
+}
+
+var _ kernelNotifier = (*fuse.Connection)(nil)
+
+// notifyingServer hands the connection it serves to the filesystem, so that the changes reported by the watcher
+// reach the kernel.
+type notifyingServer struct {
+	fuse.Server
+	fs *Immufs
+}
+
+// NotifyingServer wraps the server of the filesystem so that the changes made by other mounts, as reported by the
+// watcher, are pushed to the kernel: the attributes, contents and entries it cached are dropped right away, and
+// the removals are reported to inotify watchers.
+func (fs *Immufs) NotifyingServer(server fuse.Server) fuse.Server {
+	return &notifyingServer{Server: server, fs: fs}
+}
+
+func (s *notifyingServer) ServeOps(c *fuse.Connection) {
+	s.fs.mu.Lock()
+	s.fs.notifier = c
+	s.fs.mu.Unlock()
+
+	s.Server.ServeOps(c)
+}
+
+// notifyKernel pushes to the kernel the changes to the given inodes since sinceTx: the inodes are invalidated, and
+// the entries of the directories among them are compared with those they had as of sinceTx. The entries removed
+// are notified as deleted, and those added are invalidated, dropping the negative lookups of the kernel. Errors
+// are only logged: the kernel caches still expire.
+//
+// It must be called without holding fs.mu: the kernel may wait for operations in progress on the same inodes.
+func (fs *Immufs) notifyKernel(n kernelNotifier, inumbers []int64, sinceTx uint64) {
+	ctx := context.Background()
+
+	for _, inumber := range inumbers {
+		fs.notifyErr(n.InvalidateNode(fuseops.InodeID(inumber), 0, 0), "inode %d", inumber)
+	}
+
+	inodes, err := fs.idb.GetInodes(ctx, inumbers)
+	if err != nil {
+		fs.log.Warnf("could not get the changed inodes: %s", err)
+
+		return
+	}
+	var dirs []int64
+	for inumber, inode := range inodes {
+		if os.FileMode(inode.Mode).IsDir() {
+			dirs = append(dirs, inumber)
+		}
+	}
+	if len(dirs) == 0 {
+		return
+	}
+	period := fmt.Sprintf("UNTIL TX %d", sinceTx)
+	existed, err := fs.idb.getInodes(ctx, dirs, period)
+	if err != nil {
+		fs.log.Warnf("could not get the changed directories as of tx %d: %s", sinceTx, err)
+
+		return
+	}
+
+	for _, inumber := range dirs {
+		var before []fuseutil.Dirent
+		if _, ok := existed[inumber]; ok {
+			if before, err = fs.idb.getChildren(ctx, inumber, period); err != nil {
+				fs.log.Warnf("could not get the entries of inode %d as of tx %d: %s", inumber, sinceTx, err)
+
+				continue
+			}
+		}
+		after, err := fs.idb.getChildren(ctx, inumber, "")
+		if err != nil {
+			fs.log.Warnf("could not get the entries of inode %d: %s", inumber, err)
+
+			continue
+		}
+
+		parent := fuseops.InodeID(inumber)
+		removed, added := diffEntries(before, after)
+		for _, e := range removed {
+			if err := n.NotifyDelete(parent, e.Inode, e.Name); err != nil {
+				// Not the inode the kernel knows by this name: drop the name only.
+				fs.notifyErr(n.InvalidateEntry(parent, e.Name), "entry %q of inode %d", e.Name, inumber)
+			}
+		}
+		for _, e := range added {
+			fs.notifyErr(n.InvalidateEntry(parent, e.Name), "entry %q of inode %d", e.Name, inumber)
+		}
+	}
+}
+
+// notifyErr logs the error of a notification. ENOENT is expected: the kernel does not know the inode or entry.
+func (fs *Immufs) notifyErr(err error, format string, args ...any) {
+	if err != nil && !errors.Is(err, syscall.ENOENT) {
+		fs.log.Debugf("could not invalidate %s: %s", fmt.Sprintf(format, args...), err)
+	}
+}
+
+// diffEntries returns the entries of before which are not in after, by name and inode, and the entries of after
+// which are not in before. The unused entries are ignored.
+func diffEntries(before, after []fuseutil.Dirent) ([]fuseutil.Dirent, []fuseutil.Dirent) {
+	names := make(map[string]fuseops.InodeID, len(before))
+	for _, e := range before {
+		if e.Type != fuseutil.DT_Unknown {
+			names[e.Name] = e.Inode
+		}
+	}
+
+	var added []fuseutil.Dirent
+	for _, e := range after {
+		if e.Type == fuseutil.DT_Unknown {
+			continue
+		}
+		if inode, ok := names[e.Name]; ok && inode == e.Inode {
+			delete(names, e.Name)
+
+			continue
+		}
+		added = append(added, e)
+	}
+
+	var removed []fuseutil.Dirent
+	for _, e := range before {
+		if inode, ok := names[e.Name]; ok && inode == e.Inode && e.Type != fuseutil.DT_Unknown {
+			removed = append(removed, e)
+		}
+	}
+
+	return removed, added
+}
@@ -0,0 +1,64 @@
+package fs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// Special values of KernelOps in the configuration.
+const (
+	kernelOpsAll  = "all"
+	kernelOpsNone = "none"
+)
+
+// pidOps are the operations checking the process they come from, sorted.
+var pidOps = []string{
+	"CreateFile", "Fallocate", "FlushFile", "ForgetInode", "GetInodeAttributes", "GetXattr", "ListXattr",
+	"LookUpInode", "MkDir", "MkNode", "OpenDir", "OpenFile", "ReadDir", "ReadFile", "RemoveXattr", "Rename", "RmDir",
+	"SetInodeAttributes", "SetXattr", "Unlink", "WriteFile",
+}
+
+// defaultKernelOps are the operations the kernel issues on its own, without a calling process: forgetting inodes,
+// the internal lookups (e.g. of exported filesystems) and the writeback of the page cache.
+var defaultKernelOps = []string{"FlushFile", "ForgetInode", "GetInodeAttributes", "LookUpInode", "ReadFile", "WriteFile"}
+
+// newKernelOps returns the operations served without a calling process, PID 0, as configured: the defaults if
+// none are, all of them with "all", and none of them with "none".
+func newKernelOps(ops []string) (map[string]bool, error) {
+	if len(ops) == 0 {
+		ops = defaultKernelOps
+	}
+	allowed := make(map[string]bool)
+	for _, op := range ops {
+		switch op {
+		case kernelOpsAll:
+			for _, op := range pidOps {
+				allowed[op] = true
+			}
+		case kernelOpsNone:
+		default:
+			i := sort.SearchStrings(pidOps, op)
+			if i == len(pidOps) || pidOps[i] != op {
+				return nil, fmt.Errorf("unknown operation %q, expected %s, %s or one of %s", op, kernelOpsAll, kernelOpsNone, strings.Join(pidOps, ", "))
+			}
+			allowed[op] = true
+		}
+	}
+
+	return allowed, nil
+}
+
+// checkPid rejects the operations without a calling process, unless they are allowed to come from the kernel
+// itself (see KernelOps in the configuration).
+func (fs *Immufs) checkPid(api string, opCtx fuseops.OpContext) error {
+	if opCtx.Pid != 0 || fs.kernelOps[api] {
+		return nil
+	}
+	fs.log.WithField("API", api).Warningf("Invalid PID 0")
+
+	return fuse.EINVAL
+}
@@ -0,0 +1,108 @@
+package fs
+
+import (
+	"context"
+
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/sirupsen/logrus"
+)
+
+// Maximum number of inodes read ahead per directory listed, so that listing a huge directory does not evict the
+// whole cache.
+const maxPrefetch = 4096
+
+// Directories waiting for their inodes to be read ahead. Directories listed while the queue is full are skipped.
+const prefetchQueue = 16
+
+type prefetchJob struct {
+	ctx      context.Context
+	inumbers []int64
+}
+
+// prefetcher reads ahead, in background, the inodes of the entries of the directories listed, into the cache of
+// the mount: the lookups and the attributes following a listing (e.g. ls -l, find) are then served from memory.
+// The inodes are read in batches, so it requires a backend reading several inodes at once (see inodesGetter).
+type prefetcher struct {
+	cache  *cachedBackend
+	getter inodesGetter
+	log    *logrus.Entry
+
+	jobs chan prefetchJob
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newPrefetcher(cache *cachedBackend, getter inodesGetter, log *logrus.Entry) *prefetcher {
+	return &prefetcher{
+		cache:  cache,
+		getter: getter,
+		log:    log.WithField("component", "prefetcher"),
+		jobs:   make(chan prefetchJob, prefetchQueue),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start runs the prefetcher in background.
+func (p *prefetcher) Start() {
+	go p.run()
+}
+
+// Stop terminates the prefetcher and waits for the current batch, if any, to be read.
+func (p *prefetcher) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+// enqueue schedules the inodes of the entries of a directory to be read ahead, on behalf of the caller of ctx.
+// It never blocks.
+func (p *prefetcher) enqueue(ctx context.Context, children []fuseutil.Dirent) {
+	var inumbers []int64
+	for _, child := range children {
+		if child.Type == fuseutil.DT_Unknown {
+			continue
+		}
+		inumbers = append(inumbers, int64(child.Inode))
+		if len(inumbers) == maxPrefetch {
+			break
+		}
+	}
+	if len(inumbers) == 0 {
+		return
+	}
+
+	select {
+	case p.jobs <- prefetchJob{ctx: detach(ctx), inumbers: inumbers}:
+	default:
+	}
+}
+
+func (p *prefetcher) run() {
+	defer close(p.done)
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case job := <-p.jobs:
+			p.prefetch(job)
+		}
+	}
+}
+
+// prefetch reads the inodes not cached yet. Errors are only logged: the inodes are read again when looked up.
+func (p *prefetcher) prefetch(job prefetchJob) {
+	inumbers, epoch := p.cache.uncachedInodes(job.inumbers)
+	if len(inumbers) == 0 {
+		return
+	}
+
+	inodes, err := p.getter.GetInodes(job.ctx, inumbers)
+	if err != nil {
+		p.log.Warnf("could not read ahead %d inodes: %s", len(inumbers), err)
+
+		return
+	}
+	n := p.cache.putInodes(inodes, epoch)
+	p.log.Debugf("read ahead %d inodes, %d cached", len(inodes), n)
+}
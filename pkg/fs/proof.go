@@ -0,0 +1,116 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/codenotary/immudb/embedded/store"
+	"github.com/codenotary/immudb/pkg/api/schema"
+	"github.com/codenotary/immudb/pkg/client"
+	"github.com/jacobsa/fuse/fuseutil"
+	"google.golang.org/grpc"
+)
+
+// ContentProof is the content of a file, or the entries of a directory, as of a transaction, verified with
+// immudb proofs.
+type ContentProof struct {
+	// Transaction which wrote the content, and its hash (Alh), verified against the last state of the database
+	// known to the client.
+	Tx     uint64
+	TxHash [32]byte
+	// The content, as written by Tx. A content moved to the tier is fetched from there, and checked against the
+	// checksum proven.
+	Content []byte
+}
+
+// Children returns the entries of a directory whose content was verified.
+func (p *ContentProof) Children() ([]fuseutil.Dirent, error) {
+	return unmarshalDirents(p.Content)
+}
+
+// atTxService makes the verifiable SQL reads of a client return the rows as written by a transaction, rather
+// than their current version: the client does not let VerifyRow ask for them.
+type atTxService struct {
+	schema.ImmuServiceClient
+	tx uint64
+}
+
+func (s atTxService) VerifiableSQLGet(ctx context.Context, in *schema.VerifiableSQLGetRequest, opts ...grpc.CallOption) (*schema.VerifiableSQLEntry, error) {
+	in.SqlGetRequest.AtTx = s.tx
+
+	return s.ImmuServiceClient.VerifiableSQLGet(ctx, in, opts...)
+}
+
+// VerifyContentAt reads the content of a file, or the entries of a directory, as of a transaction, and verifies
+// it with immudb proofs: the inclusion of the content row in the transaction which wrote it, and the consistency
+// of this transaction with the last state of the database the client has verified.
+//
+// The contents stored in a separate database are not supported: the transactions of the filesystem don't
+// identify their revisions.
+func (idb *ImmuDbClient) VerifyContentAt(ctx context.Context, inumber int64, isDir bool, tx uint64) (*ContentProof, error) {
+	if idb.contentApart && !isDir {
+		return nil, fmt.Errorf("content of file %d, stored in a separate database: %w", inumber, ErrNotSupported)
+	}
+
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	writtenAt, err := idb.lastContentWrite(ctx, inumber, 1, tx)
+	if err != nil {
+		return nil, err
+	}
+	if writtenAt == 0 {
+		return nil, fmt.Errorf("content of inode %d as of tx %d: %w", inumber, tx, ErrInodeNotFound)
+	}
+
+	proof := &ContentProof{Tx: writtenAt}
+	var checksum, tier sql.NullString
+	err = idb.withImmuClient(ctx, func(cl client.ImmuClient) error {
+		res, err := cl.SQLQuery(ctx, fmt.Sprintf("SELECT inumber, content, checksum, tier FROM content SINCE TX %d UNTIL TX %d WHERE inumber=@inumber", writtenAt, writtenAt),
+			map[string]interface{}{"inumber": inumber}, true)
+		if err != nil {
+			return err
+		}
+		if len(res.Rows) != 1 {
+			return fmt.Errorf("content of inode %d as of tx %d: %w", inumber, writtenAt, ErrInodeNotFound)
+		}
+		row := res.Rows[0]
+
+		service := cl.GetServiceClient()
+		cl.WithServiceClient(atTxService{ImmuServiceClient: service, tx: writtenAt})
+		err = verifyRow(ctx, cl, row, "content", []string{"inumber"})
+		cl.WithServiceClient(service)
+		if err != nil {
+			return err
+		}
+
+		verified, err := cl.VerifiedTxByID(ctx, writtenAt)
+		if err != nil {
+			return err
+		}
+		proof.TxHash = schema.TxHeaderFromProto(verified.Header).Alh()
+		proof.Content, _ = schema.RawValue(row.Values[1]).([]byte)
+		checksum.String, checksum.Valid = schema.RawValue(row.Values[2]).(string)
+		tier.String, tier.Valid = schema.RawValue(row.Values[3]).(string)
+
+		return nil
+	})
+	if err != nil {
+		idb.log.Errorf("could not verify the content of inode %d as of tx %d: %s", inumber, tx, err)
+		if errors.Is(err, store.ErrCorruptedData) {
+			idb.verifyFailures.Add(1)
+		}
+
+		return nil, wrapErr(err)
+	}
+	// A content moved to the tier is verified by the checksum of the row proven.
+	if tier.Valid {
+		if proof.Content, err = idb.readTier(ctx, inumber, tier.String, checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	return proof, nil
+}
@@ -0,0 +1,99 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PathProvenance summarizes the provenance of the file, or directory, at a path (see ProvenanceOf).
+type PathProvenance struct {
+	Path    string
+	Inumber int64
+	Dir     bool
+	Size    int64
+	// Last transaction of the filesystem, as of which the summary is made.
+	CurrentTx uint64
+
+	// Revisions of the content, or of the entries, kept in the history, and the transactions which wrote the
+	// first and the last of them, with their time. Zero for the contents stored in a separate database.
+	Revisions int
+	FirstTx   uint64
+	FirstTime time.Time
+	LastTx    uint64
+	LastTime  time.Time
+	// Operation which wrote the last revision, if recorded, and its signature, if signed.
+	LastWriter *AuditRecord
+	Signature  *ContentSignature
+
+	// Checksum of the current content (see contentChecksum), verified against the one written with it.
+	SHA256 string
+	// Proof of the content row written by LastTx, or the reason it could not be verified.
+	Proof     *ContentProof
+	VerifyErr error
+}
+
+// ProvenanceOf summarizes the provenance of the file, or directory, at a path: its revisions, the operation
+// which wrote the last one, the checksum of its content and the verification of the content row with immudb
+// proofs. The writes journaled since the content row (see --journal) are not covered by the proof.
+func (idb *ImmuDbClient) ProvenanceOf(ctx context.Context, path string) (*PathProvenance, error) {
+	current, err := idb.CurrentTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inode, _, err := idb.LookUpPath(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	p := &PathProvenance{Path: path, Inumber: inode.Inumber, Dir: isDir(inode), Size: inode.Size, CurrentTx: current}
+	content, err := idb.readContent(ctx, inode.Inumber, "")
+	if err != nil {
+		return nil, err
+	}
+	p.SHA256 = contentChecksum(content)
+
+	if idb.contentApart && !p.Dir {
+		p.VerifyErr = fmt.Errorf("content of file %d, stored in a separate database: %w", inode.Inumber, ErrNotSupported)
+
+		return p, nil
+	}
+
+	// Counted from the newest revision backwards, without reading them.
+	for to := current; to > 0; {
+		tx, err := idb.lastContentWrite(ctx, inode.Inumber, 1, to)
+		if err != nil {
+			return nil, err
+		}
+		if tx == 0 {
+			break
+		}
+		if p.Revisions == 0 {
+			p.LastTx = tx
+		}
+		p.Revisions++
+		p.FirstTx, to = tx, tx-1
+	}
+	if p.Revisions == 0 {
+		// Inlined in the inode row (see --inline-threshold).
+		return p, nil
+	}
+	if p.FirstTime, err = idb.TxTime(ctx, p.FirstTx); err != nil {
+		return nil, err
+	}
+	last := Revision{Tx: p.LastTx}
+	if _, err := idb.contentWrittenBy(ctx, inode.Inumber, p.LastTx, &last); err != nil {
+		return nil, err
+	}
+	if p.LastTime, err = idb.TxTime(ctx, p.LastTx); err != nil {
+		return nil, err
+	}
+	if p.LastWriter, err = idb.Provenance(ctx, inode.Inumber, p.LastTx, 0); err != nil {
+		return nil, err
+	}
+	p.Signature = last.Signature
+
+	p.Proof, p.VerifyErr = idb.VerifyContentAt(ctx, inode.Inumber, p.Dir, current)
+
+	return p, nil
+}
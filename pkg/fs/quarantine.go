@@ -0,0 +1,131 @@
+package fs
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrChecksumMismatch is returned when the content of a file read does not match the checksum written with it.
+var ErrChecksumMismatch = errors.New("content does not match its checksum")
+
+// QuarantineEntry records a file whose content did not match its checksum when read.
+type QuarantineEntry struct {
+	Inumber int64
+	// Checksums written with the content, and computed from the content read.
+	Expected   string
+	Actual     string
+	DetectedAt time.Time
+	// Path of the file, if still reachable from the root (see ListQuarantine).
+	Path string
+}
+
+// contentChecksum returns the checksum written with the content of a file: its SHA-256 digest, in hexadecimal.
+// The content of a file is stored, and verified, as a single chunk.
+func contentChecksum(content []byte) string {
+	digest := sha256.Sum256(content)
+
+	return hex.EncodeToString(digest[:])
+}
+
+// upsertFileContent writes the content of a file or symlink together with its checksum, and its signature if the
+// mount signs its writes. The entries of the directories have neither. With a blob store, the content is written to
+// the tier instead, and immudb only gets its checksum, naming its object.
+func (idb *ImmuDbClient) upsertFileContent(ctx context.Context, q querier, inumber int64, content []byte) error {
+	checksum := contentChecksum(content)
+	cols, values := "inumber, content, checksum", []any{inumber, content, checksum}
+	if idb.blobStore {
+		if err := idb.putTier(ctx, checksum, content); err != nil {
+			return err
+		}
+		cols, values = "inumber, content, checksum, tier", []any{inumber, nil, checksum, checksum}
+	}
+	if idb.signer != nil {
+		cols, values = cols+", signature, signer", append(values, idb.signer.sign(inumber, content), idb.signer.signer)
+	}
+	marks := strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")
+	_, err := q.ExecContext(ctx, fmt.Sprintf("UPSERT INTO content(%s) VALUES(%s)", cols, marks), values...)
+
+	return err
+}
+
+// verifyChecksum checks the content of a file read against the checksum written with it, if any: the contents
+// written before checksums existed have none. A mismatching file is quarantined, and ErrChecksumMismatch returned.
+func (idb *ImmuDbClient) verifyChecksum(ctx context.Context, inumber int64, content []byte, checksum sql.NullString) error {
+	if !checksum.Valid {
+		return nil
+	}
+	actual := contentChecksum(content)
+	if actual == checksum.String {
+		return nil
+	}
+
+	idb.log.Errorf("content of file %d does not match its checksum: expected %s, got %s", inumber, checksum.String, actual)
+	idb.verifyFailures.Add(1)
+	e := &QuarantineEntry{Inumber: inumber, Expected: checksum.String, Actual: actual, DetectedAt: time.Now()}
+	if err := idb.quarantine(ctx, e); err != nil {
+		idb.log.Errorf("could not quarantine file %d: %s", inumber, err)
+	}
+
+	return fmt.Errorf("file %d: %w", inumber, ErrChecksumMismatch)
+}
+
+// quarantine records a file whose content did not match its checksum. Only the last mismatch of a file is
+// listed; the former ones stay in the history of the quarantine table.
+func (idb *ImmuDbClient) quarantine(ctx context.Context, e *QuarantineEntry) error {
+	ctx, cancel := withTimeout(detach(ctx), idb.metadataTimeout.Load())
+	defer cancel()
+
+	_, err := idb.cl.ExecContext(ctx, "UPSERT INTO quarantine(inumber, expected, actual, detected_at) VALUES(?, ?, ?, ?)",
+		e.Inumber, e.Expected, e.Actual, e.DetectedAt)
+
+	return wrapErr(err)
+}
+
+// ListQuarantine returns the files quarantined, ordered by inumber, with their current path. The files which are
+// no longer reachable from the root have no path.
+func (idb *ImmuDbClient) ListQuarantine(ctx context.Context) ([]QuarantineEntry, error) {
+	entries, err := idb.listQuarantine(ctx)
+	if err != nil || len(entries) == 0 {
+		return entries, err
+	}
+
+	paths, err := idb.treePaths(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Path = paths[entries[i].Inumber]
+	}
+
+	return entries, nil
+}
+
+func (idb *ImmuDbClient) listQuarantine(ctx context.Context) ([]QuarantineEntry, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	res, err := idb.cl.QueryContext(ctx, "SELECT inumber, expected, actual, detected_at FROM quarantine ORDER BY inumber")
+	if err != nil {
+		idb.log.Errorf("could not list quarantine: %s", err)
+
+		return nil, wrapErr(err)
+	}
+	defer res.Close()
+
+	var entries []QuarantineEntry
+	for res.Next() {
+		var e QuarantineEntry
+		if err := res.Scan(&e.Inumber, &e.Expected, &e.Actual, &e.DetectedAt); err != nil {
+			return nil, wrapErr(err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, wrapErr(res.Err())
+}
@@ -0,0 +1,334 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse"
+)
+
+var ErrQuotaNotFound = errors.New("quota not found")
+
+// Quota limits the bytes and inodes owned by a uid. A zero limit means unlimited. As the inodes are owned by the uid
+// of the mount creating them, whatever the calling process, and keep it, this is the quota of the mounts of that uid.
+type Quota struct {
+	Uid       uint32
+	MaxBytes  int64
+	MaxInodes int64
+}
+
+// QuotaUsage reports the bytes and inodes owned by a user.
+type QuotaUsage struct {
+	Uid    uint32
+	Bytes  int64
+	Inodes int64
+}
+
+// GetQuota returns the limits of the given user, or ErrQuotaNotFound if the user has none.
+func (idb *ImmuDbClient) GetQuota(ctx context.Context, uid uint32) (*Quota, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	q := Quota{Uid: uid}
+	err := idb.cl.QueryRowContext(ctx, "SELECT max_bytes, max_inodes FROM user_quota WHERE uid=?", int64(uid)).Scan(&q.MaxBytes, &q.MaxInodes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrQuotaNotFound
+	}
+	if err != nil {
+		idb.log.Errorf("could not get quota of uid %d: %s", uid, err)
+
+		return nil, wrapErr(err)
+	}
+
+	return &q, nil
+}
+
+// SetQuota creates or replaces the limits of a user.
+func (idb *ImmuDbClient) SetQuota(ctx context.Context, q Quota) error {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	_, err := idb.cl.ExecContext(ctx, "UPSERT INTO user_quota(uid, max_bytes, max_inodes) VALUES(?, ?, ?)", int64(q.Uid), q.MaxBytes, q.MaxInodes)
+	if err != nil {
+		idb.log.Errorf("could not set quota of uid %d: %s", q.Uid, err)
+	}
+
+	return wrapErr(err)
+}
+
+// DeleteQuota removes the limits of a user.
+func (idb *ImmuDbClient) DeleteQuota(ctx context.Context, uid uint32) error {
+	if _, err := idb.GetQuota(ctx, uid); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	_, err := idb.cl.ExecContext(ctx, "DELETE FROM user_quota WHERE uid=?", int64(uid))
+	if err != nil {
+		idb.log.Errorf("could not delete quota of uid %d: %s", uid, err)
+	}
+
+	return wrapErr(err)
+}
+
+// ListQuotas returns the limits of all users, ordered by uid.
+func (idb *ImmuDbClient) ListQuotas(ctx context.Context) ([]Quota, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	res, err := idb.cl.QueryContext(ctx, "SELECT uid, max_bytes, max_inodes FROM user_quota ORDER BY uid")
+	if err != nil {
+		idb.log.Errorf("could not list quotas: %s", err)
+
+		return nil, wrapErr(err)
+	}
+	defer res.Close()
+
+	var quotas []Quota
+	for res.Next() {
+		var q Quota
+		var uid int64
+		if err := res.Scan(&uid, &q.MaxBytes, &q.MaxInodes); err != nil {
+			return nil, wrapErr(err)
+		}
+		q.Uid = uint32(uid)
+		quotas = append(quotas, q)
+	}
+
+	return quotas, wrapErr(res.Err())
+}
+
+// GetUsage computes the bytes and inodes owned by a user.
+func (idb *ImmuDbClient) GetUsage(ctx context.Context, uid uint32) (*QuotaUsage, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	var inodes, bytes sql.NullInt64
+	err := idb.cl.QueryRowContext(ctx, "SELECT COUNT(*), SUM(size) FROM inode WHERE uid=?", int64(uid)).Scan(&inodes, &bytes)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		idb.log.Errorf("could not get usage of uid %d: %s", uid, err)
+
+		return nil, wrapErr(err)
+	}
+
+	return &QuotaUsage{Uid: uid, Bytes: bytes.Int64, Inodes: inodes.Int64}, nil
+}
+
+// ListUsage computes the bytes and inodes owned by every user, ordered by uid.
+func (idb *ImmuDbClient) ListUsage(ctx context.Context) ([]QuotaUsage, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	// immudb can only group by indexed columns: aggregate here.
+	res, err := idb.cl.QueryContext(ctx, "SELECT uid, size FROM inode")
+	if err != nil {
+		idb.log.Errorf("could not list usage: %s", err)
+
+		return nil, wrapErr(err)
+	}
+	defer res.Close()
+
+	byUid := make(map[uint32]*QuotaUsage)
+	for res.Next() {
+		var uid, size int64
+		if err := res.Scan(&uid, &size); err != nil {
+			return nil, wrapErr(err)
+		}
+		u, ok := byUid[uint32(uid)]
+		if !ok {
+			u = &QuotaUsage{Uid: uint32(uid)}
+			byUid[uint32(uid)] = u
+		}
+		u.Bytes += size
+		u.Inodes++
+	}
+	if err := res.Err(); err != nil {
+		return nil, wrapErr(err)
+	}
+
+	usage := make([]QuotaUsage, 0, len(byUid))
+	for _, u := range byUid {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Uid < usage[j].Uid })
+
+	return usage, nil
+}
+
+// Limits and usage are cached by the mount, and reloaded periodically to account for
+// the changes made through other mounts or the quota command.
+const quotaRefreshInterval = 30 * time.Second
+
+// Virtual extended attributes reporting the quota of the owner of an inode. They are not listed.
+const (
+	quotaXattrPrefix      = virtualXattrPrefix + "quota."
+	quotaXattrBytesUsed   = quotaXattrPrefix + "bytes_used"
+	quotaXattrBytesLimit  = quotaXattrPrefix + "bytes_limit"
+	quotaXattrInodesUsed  = quotaXattrPrefix + "inodes_used"
+	quotaXattrInodesLimit = quotaXattrPrefix + "inodes_limit"
+)
+
+// quotaKey identifies the quota of a user, or of a directory tree (project).
+type quotaKey struct {
+	project bool
+	id      int64
+}
+
+// quotaEntry caches the limits and usage of a user or of a directory tree.
+type quotaEntry struct {
+	limited   bool
+	maxBytes  int64
+	maxInodes int64
+
+	// Usage, loaded only when needed.
+	hasUsage bool
+	bytes    int64
+	inodes   int64
+
+	loaded time.Time
+}
+
+// loadLimits reads the limits of a quota from immudb.
+func (fs *Immufs) loadLimits(key quotaKey) (*quotaEntry, error) {
+	e := &quotaEntry{loaded: time.Now()}
+
+	var err error
+	if key.project {
+		var q *DirQuota
+		if q, err = fs.idb.GetDirQuota(context.TODO(), key.id); err == nil {
+			e.limited, e.maxBytes, e.maxInodes = true, q.MaxBytes, q.MaxInodes
+		}
+	} else {
+		var q *Quota
+		if q, err = fs.idb.GetQuota(context.TODO(), uint32(key.id)); err == nil {
+			e.limited, e.maxBytes, e.maxInodes = true, q.MaxBytes, q.MaxInodes
+		}
+	}
+	if err != nil && !errors.Is(err, ErrQuotaNotFound) {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// getQuota returns the cached quota of a user or directory tree, loading the usage as well if needed.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) getQuota(key quotaKey, withUsage bool) (*quotaEntry, error) {
+	// No quotas without immudb.
+	if fs.idb == nil {
+		return &quotaEntry{}, nil
+	}
+
+	e, ok := fs.quotas[key]
+	if !ok || time.Since(e.loaded) > quotaRefreshInterval {
+		fs.counters.quotaMisses.Add(1)
+		var err error
+		if e, err = fs.loadLimits(key); err != nil {
+			return nil, err
+		}
+		fs.quotas[key] = e
+	} else {
+		fs.counters.quotaHits.Add(1)
+	}
+
+	// Computing the usage requires a scan: skip it when there are no limits.
+	if !e.hasUsage && (withUsage || e.limited) {
+		if key.project {
+			u, err := fs.idb.GetDirUsage(context.TODO(), key.id)
+			if err != nil {
+				return nil, err
+			}
+			e.bytes, e.inodes = u.Bytes, u.Inodes
+		} else {
+			u, err := fs.idb.GetUsage(context.TODO(), uint32(key.id))
+			if err != nil {
+				return nil, err
+			}
+			e.bytes, e.inodes = u.Bytes, u.Inodes
+		}
+		e.hasUsage = true
+	}
+
+	return e, nil
+}
+
+// checkQuota fails with EDQUOT if the given additional inodes and bytes exceed the quota
+// of their owner, or of the directory tree (project) they belong to.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) checkQuota(api string, uid uint32, project int64, inodes, bytes int64) error {
+	keys := []quotaKey{{id: int64(uid)}}
+	if project != 0 {
+		keys = append(keys, quotaKey{project: true, id: project})
+	}
+
+	for _, key := range keys {
+		e, err := fs.getQuota(key, false)
+		if err != nil {
+			return err
+		}
+		if !e.limited {
+			continue
+		}
+
+		if (inodes > 0 && e.maxInodes > 0 && e.inodes+inodes > e.maxInodes) ||
+			(bytes > 0 && e.maxBytes > 0 && e.bytes+bytes > e.maxBytes) {
+			if key.project {
+				fs.log.WithField("API", api).Warningf("quota of directory %d exceeded", key.id)
+			} else {
+				fs.log.WithField("API", api).Warningf("quota of uid %d exceeded", key.id)
+			}
+
+			return syscall.EDQUOT
+		}
+	}
+
+	return nil
+}
+
+// chargeQuota accounts for the inodes and bytes (possibly negative) acquired by uid, within the given project.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) chargeQuota(uid uint32, project int64, inodes, bytes int64) {
+	keys := []quotaKey{{id: int64(uid)}, {project: true, id: project}}
+	for _, key := range keys {
+		if e, ok := fs.quotas[key]; ok && e.hasUsage {
+			e.inodes += inodes
+			e.bytes += bytes
+		}
+	}
+}
+
+// quotaXattr returns the value of a virtual quota attribute for the given user.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) quotaXattr(uid uint32, name string) ([]byte, error) {
+	e, err := fs.getQuota(quotaKey{id: int64(uid)}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	var v int64
+	switch name {
+	case quotaXattrBytesUsed:
+		v = e.bytes
+	case quotaXattrBytesLimit:
+		v = e.maxBytes
+	case quotaXattrInodesUsed:
+		v = e.inodes
+	case quotaXattrInodesLimit:
+		v = e.maxInodes
+	default:
+		return nil, fuse.ENOATTR
+	}
+
+	return []byte(strconv.FormatInt(v, 10)), nil
+}
@@ -0,0 +1,56 @@
+package fs
+
+import (
+	"immufs/pkg/config"
+)
+
+// Reload applies the runtime settings of a configuration re-read while mounted: the timeouts of the queries, the
+// size of the cache and the intervals of the background flushes and compactions. Enabling or disabling the cache or
+// a background activity needs a remount: such changes are only logged, as are those of the other settings, ignored.
+func (fs *Immufs) Reload(cfg *config.Config) {
+	if fs.timeouts != nil {
+		fs.timeouts.setTimeouts(cfg)
+	}
+
+	switch {
+	case fs.cache != nil && cfg.CacheSize > 0:
+		if budget := cfg.CacheSize << 20; budget != fs.cache.status().Budget {
+			fs.cache.setBudget(budget)
+			fs.log.Infof("cache size set to %d MiB", cfg.CacheSize)
+		}
+	case fs.cache != nil || cfg.CacheSize > 0:
+		fs.log.Warnf("enabling or disabling the cache needs a remount")
+	}
+
+	switch {
+	case fs.attrFlusher != nil && cfg.AttrFlushInterval > 0:
+		if cfg.AttrFlushInterval != fs.attrFlusher.interval.Load() {
+			fs.attrFlusher.setInterval(cfg.AttrFlushInterval)
+			fs.log.Infof("attribute flush interval set to %s", cfg.AttrFlushInterval)
+		}
+	case fs.attrFlusher != nil || (cfg.AttrFlushInterval > 0 && !fs.readOnly):
+		fs.log.Warnf("enabling or disabling the coalescing of the attribute writes needs a remount")
+	}
+
+	switch {
+	case fs.maintainer != nil && (cfg.IndexFlushInterval > 0 || cfg.IndexCompactInterval > 0):
+		flushInterval, compactInterval := fs.maintainer.intervals()
+		if cfg.IndexFlushInterval != flushInterval || cfg.IndexCompactInterval != compactInterval {
+			fs.maintainer.setIntervals(cfg.IndexFlushInterval, cfg.IndexCompactInterval)
+			fs.log.Infof("index flush interval set to %s, index compaction interval to %s",
+				cfg.IndexFlushInterval, cfg.IndexCompactInterval)
+		}
+	case fs.maintainer != nil || ((cfg.IndexFlushInterval > 0 || cfg.IndexCompactInterval > 0) && !fs.readOnly):
+		fs.log.Warnf("enabling or disabling the index maintenance needs a remount")
+	}
+
+	switch {
+	case fs.compactor != nil && cfg.JournalCompactInterval > 0:
+		if cfg.JournalCompactInterval != fs.compactor.currentInterval() {
+			fs.compactor.setInterval(cfg.JournalCompactInterval)
+			fs.log.Infof("journal compaction interval set to %s", cfg.JournalCompactInterval)
+		}
+	case fs.compactor != nil || (cfg.JournalCompactInterval > 0 && !fs.readOnly):
+		fs.log.Warnf("enabling or disabling the journal compaction needs a remount")
+	}
+}
@@ -0,0 +1,256 @@
+package fs
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/codenotary/immudb/pkg/api/schema"
+	"github.com/codenotary/immudb/pkg/client"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var (
+	ErrBackupCorrupted = errors.New("backup altered or corrupted")
+	ErrBackupNotFound  = errors.New("backup not found")
+	ErrRestoreNotEmpty = errors.New("the database already contains a filesystem")
+)
+
+// Number of rows restored per transaction, to stay within the immudb transaction size.
+const restoreBatchSize = 64
+
+// backupLine tells the lines of a dump apart: the header has a format, the rows a table.
+type backupLine struct {
+	Format string `json:"format"`
+	Table  string `json:"table"`
+}
+
+// backupReader reads the lines of a dump.
+type backupReader struct {
+	r *bufio.Reader
+}
+
+// next returns the next line, both as it is and parsed. It returns io.EOF at the end of the dump.
+func (br *backupReader) next() ([]byte, *backupLine, error) {
+	raw, err := br.r.ReadBytes('\n')
+	if err == io.EOF && len(raw) > 0 {
+		return nil, nil, fmt.Errorf("%w: truncated line", ErrBackupCorrupted)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var line backupLine
+	if err := json.Unmarshal(raw, &line); err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrBackupCorrupted, err)
+	}
+
+	return raw, &line, nil
+}
+
+// checkBackup reads a whole dump, checking its digest against the trailer, which it returns.
+func checkBackup(r io.Reader) (*backupTrailer, error) {
+	br := &backupReader{r: bufio.NewReader(r)}
+	digest := sha256.New()
+
+	raw, line, err := br.next()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: empty", ErrBackupCorrupted)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var header backupHeader
+	if err := json.Unmarshal(raw, &header); err != nil || line.Format != backupFormat || header.Version != backupVersion {
+		return nil, fmt.Errorf("%w: not an immufs backup (version %d)", ErrBackupCorrupted, backupVersion)
+	}
+
+	var rows int64
+	for {
+		digest.Write(raw)
+
+		raw, line, err = br.next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%w: trailer not found", ErrBackupCorrupted)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line.Table != "" {
+			rows++
+
+			continue
+		}
+
+		var trailer backupTrailer
+		if err := json.Unmarshal(raw, &trailer); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrBackupCorrupted, err)
+		}
+		if hex.EncodeToString(digest.Sum(nil)) != trailer.Digest || rows != trailer.Rows {
+			return nil, fmt.Errorf("%w: digest or number of rows do not match", ErrBackupCorrupted)
+		}
+		if _, _, err := br.next(); err != io.EOF {
+			return nil, fmt.Errorf("%w: data after the trailer", ErrBackupCorrupted)
+		}
+
+		return &trailer, nil
+	}
+}
+
+// Restore loads a dump produced by Backup into an empty database, in which database.sql has been loaded.
+// The digest of the dump is checked before anything is written, and every row restored is verified
+// with immudb proofs once committed. The returned information allows to check the dump against the
+// backup table of the database it was taken from (see CheckBackup).
+func (idb *ImmuDbClient) Restore(ctx context.Context, r io.ReadSeeker) (*BackupInfo, error) {
+	trailer, err := checkBackup(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var inodes int64
+	if err := idb.cl.QueryRowContext(ctx, "SELECT COUNT(*) FROM inode").Scan(&inodes); err != nil {
+		return nil, wrapErr(err)
+	}
+	if inodes > 0 {
+		return nil, ErrRestoreNotEmpty
+	}
+
+	keys := make(map[string][]string)
+	for _, table := range tables {
+		keys[table.name] = table.keys
+	}
+
+	br := &backupReader{r: bufio.NewReader(r)}
+	if _, _, err := br.next(); err != nil {
+		return nil, err
+	}
+	var batch []backupRow
+	for {
+		raw, line, err := br.next()
+		if err != nil {
+			return nil, err
+		}
+		if line.Table != "" {
+			if _, ok := keys[line.Table]; !ok {
+				return nil, fmt.Errorf("%w: unknown table %s", ErrBackupCorrupted, line.Table)
+			}
+			var row backupRow
+			if err := json.Unmarshal(raw, &row); err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrBackupCorrupted, err)
+			}
+			batch = append(batch, row)
+		}
+		if len(batch) == restoreBatchSize || (line.Table == "" && len(batch) > 0) {
+			if err := idb.restoreRows(ctx, batch, keys); err != nil {
+				idb.log.Errorf("could not restore rows: %s", err)
+
+				return nil, err
+			}
+			batch = batch[:0]
+		}
+		if line.Table == "" {
+			return &trailer.BackupInfo, nil
+		}
+	}
+}
+
+// restoreRows writes rows of a dump within a single transaction, then verifies them.
+func (idb *ImmuDbClient) restoreRows(ctx context.Context, batch []backupRow, keys map[string][]string) error {
+	rows := make([]*schema.Row, len(batch))
+	for i, br := range batch {
+		rows[i] = &schema.Row{}
+		if err := protojson.Unmarshal(br.Row, rows[i]); err != nil {
+			return fmt.Errorf("%w: %s", ErrBackupCorrupted, err)
+		}
+		if len(rows[i].Columns) == 0 || len(rows[i].Columns) != len(rows[i].Values) {
+			return fmt.Errorf("%w: malformed row of %s", ErrBackupCorrupted, br.Table)
+		}
+	}
+
+	err := idb.inTx(ctx, idb.writeTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+		for i, row := range rows {
+			cols := make([]string, len(row.Columns))
+			values := make([]any, len(row.Values))
+			for j := range row.Columns {
+				cols[j] = columnName(row.Columns[j])
+				values[j] = schema.RawValue(row.Values[j])
+			}
+			marks := strings.TrimSuffix(strings.Repeat("?,", len(cols)), ",")
+			query := fmt.Sprintf("UPSERT INTO %s(%s) VALUES(%s)", batch[i].Table, strings.Join(cols, ", "), marks)
+			if _, err := tx.ExecContext(ctx, query, values...); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return idb.withImmuClient(ctx, func(cl client.ImmuClient) error {
+		for i, row := range rows {
+			if err := verifyRow(ctx, cl, row, batch[i].Table, keys[batch[i].Table]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// CheckBackup looks a backup up by digest, verifying the record with immudb proofs. A dump whose digest
+// is found has not been altered since it was taken.
+func (idb *ImmuDbClient) CheckBackup(ctx context.Context, digest string) (*BackupInfo, error) {
+	var info *BackupInfo
+	err := idb.withImmuClient(ctx, func(cl client.ImmuClient) error {
+		res, err := cl.SQLQuery(ctx, "SELECT digest, tx, row_count, created_at FROM backup WHERE digest=@digest",
+			map[string]interface{}{"digest": digest}, true)
+		if err != nil {
+			return err
+		}
+		if len(res.Rows) == 0 {
+			return ErrBackupNotFound
+		}
+		row := res.Rows[0]
+		if err := verifyRow(ctx, cl, row, "backup", []string{"digest"}); err != nil {
+			return err
+		}
+
+		info = &BackupInfo{
+			Database: cl.GetOptions().Database,
+			Digest:   schema.RawValue(row.Values[0]).(string),
+			Tx:       uint64(schema.RawValue(row.Values[1]).(int64)),
+			Rows:     schema.RawValue(row.Values[2]).(int64),
+		}
+		info.CreatedAt, _ = schema.RawValue(row.Values[3]).(time.Time)
+
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrBackupNotFound) {
+		idb.log.Errorf("could not check backup %s: %s", digest, err)
+	}
+
+	return info, wrapErr(err)
+}
+
+// ReadBackupInfo checks the digest of a dump, and returns the information in its trailer.
+func ReadBackupInfo(r io.Reader) (*BackupInfo, error) {
+	trailer, err := checkBackup(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return &trailer.BackupInfo, nil
+}
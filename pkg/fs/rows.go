@@ -0,0 +1,79 @@
+package fs
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// inodeColumns are the columns of the inode table read by immufs. Queries name them rather than selecting *,
+// so that the columns added by a later schema don't break the mounts of older versions.
+var inodeColumns = []string{
+	"inumber", "size", "nlink", "mode", "atime", "mtime", "ctime", "crtime", "uid", "gid",
+	"to_be_deleted", "generation", "project", "sealed", "inline_content",
+}
+
+// inodeSelect is the list of the columns of an inode row, for SELECT queries.
+var inodeSelect = strings.Join(inodeColumns, ", ")
+
+// inodeRow receives an inode row. The columns added after the first schema are NULL in the rows written
+// before they existed.
+type inodeRow struct {
+	inode      Inode
+	crtime     sql.NullTime
+	generation sql.NullInt64
+	project    sql.NullInt64
+	sealed     sql.NullBool
+}
+
+// fields returns the destinations of the columns of the row, by name.
+func (r *inodeRow) fields() map[string]any {
+	return map[string]any{
+		"inumber":       &r.inode.Inumber,
+		"size":          &r.inode.Size,
+		"nlink":         &r.inode.Nlink,
+		"mode":          &r.inode.Mode,
+		"atime":         &r.inode.Atime,
+		"mtime":         &r.inode.Mtime,
+		"ctime":         &r.inode.Ctime,
+		"crtime":        &r.crtime,
+		"uid":           &r.inode.Uid,
+		"gid":           &r.inode.Gid,
+		"to_be_deleted": &r.inode.ToBeDeleted,
+		"generation":    &r.generation,
+		"project":       &r.project,
+		"sealed":        &r.sealed,
+		// NULL unless the content of the file is inlined.
+		"inline_content": &r.inode.inline,
+	}
+}
+
+// toInode returns the inode read.
+func (r *inodeRow) toInode() *Inode {
+	inode := r.inode
+	inode.Crtime = birthTime(r.crtime.Time, inode.Ctime)
+	inode.Generation = r.generation.Int64
+	inode.Project = r.project.Int64
+	inode.Sealed = r.sealed.Bool
+
+	return &inode
+}
+
+// scanNamed scans the current row of res into the fields named after its columns, whatever their order.
+// Columns without a field, e.g. added by a later schema or the _rev of a history query, are skipped.
+func scanNamed(res *sql.Rows, fields map[string]any) error {
+	cols, err := res.Columns()
+	if err != nil {
+		return err
+	}
+
+	dest := make([]any, len(cols))
+	for i, col := range cols {
+		if field, ok := fields[col]; ok {
+			dest[i] = field
+		} else {
+			dest[i] = new(any)
+		}
+	}
+
+	return res.Scan(dest...)
+}
@@ -0,0 +1,433 @@
+package fs
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// Size of the buffers used to read directories and files through a session.
+const sessionBufferSize = 128 << 10
+
+// Session accesses a filesystem by path, without the kernel: every operation is served by the same handlers
+// as through a mount (hence quotas, WORM, trash and auditing apply), as if requested by the current process.
+// As the kernel would, the session forgets the inodes it looked up once each operation completes.
+type Session struct {
+	fs    fuseutil.FileSystem
+	opCtx fuseops.OpContext
+}
+
+// NewSession returns a session on a filesystem, e.g. an Immufs, possibly audited.
+func NewSession(filesystem fuseutil.FileSystem) *Session {
+	return &Session{
+		fs:    filesystem,
+		opCtx: fuseops.OpContext{Pid: uint32(os.Getpid())},
+	}
+}
+
+// FileInfo describes a file found through a session. It implements io/fs.FileInfo.
+type FileInfo struct {
+	name       string
+	Inode      fuseops.InodeID
+	Attributes fuseops.InodeAttributes
+}
+
+func (fi *FileInfo) Name() string       { return fi.name }
+func (fi *FileInfo) Size() int64        { return int64(fi.Attributes.Size) }
+func (fi *FileInfo) Mode() os.FileMode  { return fi.Attributes.Mode }
+func (fi *FileInfo) ModTime() time.Time { return fi.Attributes.Mtime }
+func (fi *FileInfo) IsDir() bool        { return fi.Attributes.Mode.IsDir() }
+func (fi *FileInfo) Sys() any           { return &fi.Attributes }
+
+// splitPath returns the names making up a path, relative to the root of the filesystem.
+func splitPath(p string) []string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return nil
+	}
+
+	return strings.Split(p, "/")
+}
+
+// release forgets the inodes looked up by an operation.
+func (s *Session) release(ctx context.Context, ids []fuseops.InodeID) {
+	for _, id := range ids {
+		s.fs.ForgetInode(ctx, &fuseops.ForgetInodeOp{Inode: id, N: 1, OpContext: s.opCtx})
+	}
+}
+
+// resolve looks up the names of a path from the root. The inodes looked up are appended to looked,
+// which the caller must release, even on failure.
+func (s *Session) resolve(ctx context.Context, names []string, looked *[]fuseops.InodeID) (*FileInfo, error) {
+	op := &fuseops.GetInodeAttributesOp{Inode: fuseops.RootInodeID, OpContext: s.opCtx}
+	if err := s.fs.GetInodeAttributes(ctx, op); err != nil {
+		return nil, err
+	}
+	fi := &FileInfo{name: "/", Inode: fuseops.RootInodeID, Attributes: op.Attributes}
+
+	for _, name := range names {
+		if !fi.IsDir() {
+			return nil, syscall.ENOTDIR
+		}
+		op := &fuseops.LookUpInodeOp{Parent: fi.Inode, Name: name, OpContext: s.opCtx}
+		if err := s.fs.LookUpInode(ctx, op); err != nil {
+			return nil, err
+		}
+		*looked = append(*looked, op.Entry.Child)
+		fi = &FileInfo{name: name, Inode: op.Entry.Child, Attributes: op.Entry.Attributes}
+	}
+
+	return fi, nil
+}
+
+// Stat returns the description of a file.
+func (s *Session) Stat(ctx context.Context, name string) (*FileInfo, error) {
+	var looked []fuseops.InodeID
+	defer func() { s.release(ctx, looked) }()
+
+	fi, err := s.resolve(ctx, splitPath(name), &looked)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	return fi, nil
+}
+
+// ReadDir returns the entries of a directory, in the order they are stored.
+func (s *Session) ReadDir(ctx context.Context, name string) ([]*FileInfo, error) {
+	var looked []fuseops.InodeID
+	defer func() { s.release(ctx, looked) }()
+
+	dir, err := s.resolve(ctx, splitPath(name), &looked)
+	if err == nil && !dir.IsDir() {
+		err = syscall.ENOTDIR
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	return s.readDir(ctx, dir.Inode, name)
+}
+
+// readDir returns the entries of the directory with the given inode ID, found at name.
+func (s *Session) readDir(ctx context.Context, dir fuseops.InodeID, name string) ([]*FileInfo, error) {
+	dirents, err := s.readDirents(ctx, dir)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: name, Err: err}
+	}
+
+	entries := make([]*FileInfo, 0, len(dirents))
+	for _, d := range dirents {
+		op := &fuseops.GetInodeAttributesOp{Inode: d.Inode, OpContext: s.opCtx}
+		if err := s.fs.GetInodeAttributes(ctx, op); err != nil {
+			// Removed meanwhile.
+			if err == syscall.ENOENT {
+				continue
+			}
+
+			return nil, &os.PathError{Op: "readdir", Path: path.Join(name, d.Name), Err: err}
+		}
+		entries = append(entries, &FileInfo{name: d.Name, Inode: d.Inode, Attributes: op.Attributes})
+	}
+
+	return entries, nil
+}
+
+// readDirents reads all the entries of a directory, as returned to the kernel.
+func (s *Session) readDirents(ctx context.Context, dir fuseops.InodeID) ([]fuseutil.Dirent, error) {
+	open := &fuseops.OpenDirOp{Inode: dir, OpContext: s.opCtx}
+	if err := s.fs.OpenDir(ctx, open); err != nil {
+		return nil, err
+	}
+	defer s.fs.ReleaseDirHandle(ctx, &fuseops.ReleaseDirHandleOp{Handle: open.Handle, OpContext: s.opCtx})
+
+	var dirents []fuseutil.Dirent
+	buf := make([]byte, sessionBufferSize)
+	var offset fuseops.DirOffset
+	for {
+		op := &fuseops.ReadDirOp{Inode: dir, Handle: open.Handle, Offset: offset, Dst: buf, OpContext: s.opCtx}
+		if err := s.fs.ReadDir(ctx, op); err != nil {
+			return nil, err
+		}
+		if op.BytesRead == 0 {
+			return dirents, nil
+		}
+		for p := buf[:op.BytesRead]; len(p) > 0; {
+			var d fuseutil.Dirent
+			d, p = ParseDirent(p)
+			dirents = append(dirents, d)
+			offset = d.Offset
+		}
+	}
+}
+
+// ParseDirent decodes the first entry written by fuseutil.WriteDirent, returning the rest of the buffer.
+// Entries are written in host order, i.e. little-endian on the platforms supported by jacobsa/fuse.
+func ParseDirent(p []byte) (fuseutil.Dirent, []byte) {
+	const direntSize = 8 + 8 + 4 + 4
+	namelen := int(binary.LittleEndian.Uint32(p[16:]))
+	d := fuseutil.Dirent{
+		Inode:  fuseops.InodeID(binary.LittleEndian.Uint64(p)),
+		Offset: fuseops.DirOffset(binary.LittleEndian.Uint64(p[8:])),
+		Type:   fuseutil.DirentType(binary.LittleEndian.Uint32(p[20:])),
+		Name:   string(p[direntSize : direntSize+namelen]),
+	}
+	n := direntSize + namelen
+	if n%8 != 0 {
+		n += 8 - n%8
+	}
+
+	return d, p[n:]
+}
+
+// ReadFile returns the content of a file.
+func (s *Session) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	var looked []fuseops.InodeID
+	defer func() { s.release(ctx, looked) }()
+
+	fi, err := s.resolve(ctx, splitPath(name), &looked)
+	if err == nil && fi.IsDir() {
+		err = syscall.EISDIR
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: err}
+	}
+
+	open := &fuseops.OpenFileOp{Inode: fi.Inode, OpenFlags: syscall.O_RDONLY, OpContext: s.opCtx}
+	if err := s.fs.OpenFile(ctx, open); err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer s.fs.ReleaseFileHandle(ctx, &fuseops.ReleaseFileHandleOp{Handle: open.Handle, OpContext: s.opCtx})
+
+	// Every read fetches the whole content from immudb: read as much as possible at once.
+	// A short read tells the end of the file.
+	var content []byte
+	buf := make([]byte, sessionBufferSize+fi.Size())
+	for {
+		op := &fuseops.ReadFileOp{Inode: fi.Inode, Handle: open.Handle, Offset: int64(len(content)), Dst: buf, OpContext: s.opCtx}
+		if err := s.fs.ReadFile(ctx, op); err != nil {
+			return nil, &os.PathError{Op: "read", Path: name, Err: err}
+		}
+		content = append(content, buf[:op.BytesRead]...)
+		if op.BytesRead < len(buf) {
+			return content, nil
+		}
+	}
+}
+
+// WriteFile writes data to a file, creating it with the given mode if needed. The directory containing
+// it must exist. The content of an existing file is replaced.
+func (s *Session) WriteFile(ctx context.Context, name string, data []byte, mode os.FileMode) error {
+	var looked []fuseops.InodeID
+	defer func() { s.release(ctx, looked) }()
+
+	names := splitPath(name)
+	if len(names) == 0 {
+		return &os.PathError{Op: "write", Path: name, Err: syscall.EISDIR}
+	}
+	parent, err := s.resolve(ctx, names[:len(names)-1], &looked)
+	if err == nil && !parent.IsDir() {
+		err = syscall.ENOTDIR
+	}
+	if err != nil {
+		return &os.PathError{Op: "write", Path: name, Err: err}
+	}
+
+	// Open the file, or create it.
+	var inode fuseops.InodeID
+	var handle fuseops.HandleID
+	var oldSize uint64
+	lookUp := &fuseops.LookUpInodeOp{Parent: parent.Inode, Name: names[len(names)-1], OpContext: s.opCtx}
+	switch err := s.fs.LookUpInode(ctx, lookUp); err {
+	case nil:
+		looked = append(looked, lookUp.Entry.Child)
+		if lookUp.Entry.Attributes.Mode.IsDir() {
+			return &os.PathError{Op: "write", Path: name, Err: syscall.EISDIR}
+		}
+		open := &fuseops.OpenFileOp{Inode: lookUp.Entry.Child, OpenFlags: syscall.O_WRONLY, OpContext: s.opCtx}
+		if err := s.fs.OpenFile(ctx, open); err != nil {
+			return &os.PathError{Op: "open", Path: name, Err: err}
+		}
+		inode, handle, oldSize = lookUp.Entry.Child, open.Handle, lookUp.Entry.Attributes.Size
+	case syscall.ENOENT:
+		create := &fuseops.CreateFileOp{Parent: parent.Inode, Name: names[len(names)-1], Mode: mode, OpContext: s.opCtx}
+		if err := s.fs.CreateFile(ctx, create); err != nil {
+			return &os.PathError{Op: "create", Path: name, Err: err}
+		}
+		looked = append(looked, create.Entry.Child)
+		inode, handle = create.Entry.Child, create.Handle
+	default:
+		return &os.PathError{Op: "write", Path: name, Err: err}
+	}
+	defer s.fs.ReleaseFileHandle(ctx, &fuseops.ReleaseFileHandleOp{Handle: handle, OpContext: s.opCtx})
+
+	// The content is written at once, then truncated if the file was longer.
+	if len(data) > 0 {
+		if err := s.fs.WriteFile(ctx, &fuseops.WriteFileOp{Inode: inode, Handle: handle, Data: data, OpContext: s.opCtx}); err != nil {
+			return &os.PathError{Op: "write", Path: name, Err: err}
+		}
+	}
+	if oldSize > uint64(len(data)) {
+		size := uint64(len(data))
+		op := &fuseops.SetInodeAttributesOp{Inode: inode, Handle: &handle, Size: &size, OpContext: s.opCtx}
+		if err := s.fs.SetInodeAttributes(ctx, op); err != nil {
+			return &os.PathError{Op: "truncate", Path: name, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// Mkdir creates a directory. The directory containing it must exist.
+func (s *Session) Mkdir(ctx context.Context, name string, mode os.FileMode) error {
+	var looked []fuseops.InodeID
+	defer func() { s.release(ctx, looked) }()
+
+	names := splitPath(name)
+	if len(names) == 0 {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EEXIST}
+	}
+	parent, err := s.resolve(ctx, names[:len(names)-1], &looked)
+	if err == nil && !parent.IsDir() {
+		err = syscall.ENOTDIR
+	}
+	if err == nil {
+		op := &fuseops.MkDirOp{Parent: parent.Inode, Name: names[len(names)-1], Mode: mode | os.ModeDir, OpContext: s.opCtx}
+		if err = s.fs.MkDir(ctx, op); err == nil {
+			looked = append(looked, op.Entry.Child)
+		}
+	}
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// MkdirAll creates a directory together with the missing directories containing it.
+func (s *Session) MkdirAll(ctx context.Context, name string, mode os.FileMode) error {
+	names := splitPath(name)
+	for i := range names {
+		dir := "/" + strings.Join(names[:i+1], "/")
+		err := s.Mkdir(ctx, dir, mode)
+		if err != nil && !os.IsExist(err) {
+			return err
+		}
+		if os.IsExist(err) {
+			fi, err := s.Stat(ctx, dir)
+			if err != nil {
+				return err
+			}
+			if !fi.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Remove removes a file or an empty directory.
+func (s *Session) Remove(ctx context.Context, name string) error {
+	var looked []fuseops.InodeID
+	defer func() { s.release(ctx, looked) }()
+
+	names := splitPath(name)
+	if len(names) == 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+	fi, err := s.resolve(ctx, names, &looked)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	// The parent has been looked up right before the file.
+	var parent fuseops.InodeID = fuseops.RootInodeID
+	if len(looked) > 1 {
+		parent = looked[len(looked)-2]
+	}
+	if fi.IsDir() {
+		err = s.fs.RmDir(ctx, &fuseops.RmDirOp{Parent: parent, Name: fi.name, OpContext: s.opCtx})
+	} else {
+		err = s.fs.Unlink(ctx, &fuseops.UnlinkOp{Parent: parent, Name: fi.name, OpContext: s.opCtx})
+	}
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// RemoveAll removes a file or a directory together with its content. A missing file is not an error.
+func (s *Session) RemoveAll(ctx context.Context, name string) error {
+	fi, err := s.Stat(ctx, name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		entries, err := s.ReadDir(ctx, name)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := s.RemoveAll(ctx, path.Join(name, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.Remove(ctx, name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// setAttributes changes the attributes of a file, set by set.
+func (s *Session) setAttributes(ctx context.Context, opName string, name string, set func(fi *FileInfo, op *fuseops.SetInodeAttributesOp)) error {
+	var looked []fuseops.InodeID
+	defer func() { s.release(ctx, looked) }()
+
+	fi, err := s.resolve(ctx, splitPath(name), &looked)
+	if err == nil {
+		op := &fuseops.SetInodeAttributesOp{Inode: fi.Inode, OpContext: s.opCtx}
+		set(fi, op)
+		err = s.fs.SetInodeAttributes(ctx, op)
+	}
+	if err != nil {
+		return &os.PathError{Op: opName, Path: name, Err: err}
+	}
+
+	return nil
+}
+
+// Chmod changes the permissions of a file, as well as its setuid, setgid and sticky bits.
+func (s *Session) Chmod(ctx context.Context, name string, mode os.FileMode) error {
+	return s.setAttributes(ctx, "chmod", name, func(fi *FileInfo, op *fuseops.SetInodeAttributesOp) {
+		m := fi.Mode()&os.ModeType | mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)
+		op.Mode = &m
+	})
+}
+
+// Chown changes the owner of a file. Note that the files served by Immufs are owned by the configured uid and gid.
+func (s *Session) Chown(ctx context.Context, name string, uid, gid uint32) error {
+	return s.setAttributes(ctx, "chown", name, func(fi *FileInfo, op *fuseops.SetInodeAttributesOp) {
+		op.Uid, op.Gid = &uid, &gid
+	})
+}
+
+// Chtimes changes the access and modification times of a file.
+func (s *Session) Chtimes(ctx context.Context, name string, atime, mtime time.Time) error {
+	return s.setAttributes(ctx, "chtimes", name, func(fi *FileInfo, op *fuseops.SetInodeAttributesOp) {
+		op.Atime, op.Mtime = &atime, &mtime
+	})
+}
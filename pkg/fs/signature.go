@@ -0,0 +1,68 @@
+package fs
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// ContentSignature is the signature of a content write, by the key of the mount which wrote it (see SigningKey in
+// the configuration).
+type ContentSignature struct {
+	// Public key of the mount, in hexadecimal: auditors map it to the host holding the private key.
+	Signer string `json:"signer"`
+	// Whether the signature matches the content written and its inumber.
+	Valid bool `json:"valid"`
+}
+
+// contentSigner signs the contents written by a mount.
+type contentSigner struct {
+	key    ed25519.PrivateKey
+	signer string
+}
+
+// loadSigningKey reads an Ed25519 private key in PEM format (PKCS #8), as generated by
+// "openssl genpkey -algorithm ed25519".
+func loadSigningKey(path string) (*contentSigner, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 private key", path)
+	}
+
+	return &contentSigner{key: edKey, signer: hex.EncodeToString(edKey.Public().(ed25519.PublicKey))}, nil
+}
+
+// signedMessage is what is signed for a content write: the inumber and the checksum of the content, so that a
+// signature can't be moved to another file.
+func signedMessage(inumber int64, content []byte) []byte {
+	return []byte(fmt.Sprintf("immufs content %d %s", inumber, contentChecksum(content)))
+}
+
+func (s *contentSigner) sign(inumber int64, content []byte) []byte {
+	return ed25519.Sign(s.key, signedMessage(inumber, content))
+}
+
+// verifySignature checks the signature of a content write against the public key of its signer.
+func verifySignature(inumber int64, content []byte, signer string, signature []byte) bool {
+	key, err := hex.DecodeString(signer)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return false
+	}
+
+	return ed25519.Verify(ed25519.PublicKey(key), signedMessage(inumber, content), signature)
+}
@@ -0,0 +1,120 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	ErrSnapshotExists   = errors.New("snapshot already exists")
+)
+
+// Snapshot gives a human readable name to a transaction, i.e. to the state of the whole filesystem at that time.
+type Snapshot struct {
+	Name      string
+	Tx        uint64
+	CreatedAt time.Time
+}
+
+// CreateSnapshot records a snapshot of the filesystem as of the last committed transaction.
+func (idb *ImmuDbClient) CreateSnapshot(ctx context.Context, name string) (*Snapshot, error) {
+	txID, err := idb.CurrentTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &Snapshot{
+		Name:      name,
+		Tx:        txID,
+		CreatedAt: time.Now(),
+	}
+	err = idb.inTx(ctx, idb.metadataTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+		var existing string
+		err := tx.QueryRowContext(ctx, "SELECT name FROM snapshot WHERE name=?", name).Scan(&existing)
+		if err == nil {
+			return ErrSnapshotExists
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "INSERT INTO snapshot(name, tx, created_at) VALUES(?, ?, ?)", snap.Name, int64(snap.Tx), snap.CreatedAt)
+
+		return err
+	})
+	if err != nil {
+		idb.log.Errorf("could not create snapshot %s: %s", name, err)
+
+		return nil, err
+	}
+
+	return snap, nil
+}
+
+// GetSnapshot retrieves a snapshot by name.
+func (idb *ImmuDbClient) GetSnapshot(ctx context.Context, name string) (*Snapshot, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	var snap Snapshot
+	var txID int64
+	err := idb.cl.QueryRowContext(ctx, "SELECT name, tx, created_at FROM snapshot WHERE name=?", name).Scan(&snap.Name, &txID, &snap.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSnapshotNotFound
+	}
+	if err != nil {
+		idb.log.Errorf("could not get snapshot %s: %s", name, err)
+
+		return nil, wrapErr(err)
+	}
+	snap.Tx = uint64(txID)
+
+	return &snap, nil
+}
+
+// ListSnapshots returns all the snapshots, ordered by name.
+func (idb *ImmuDbClient) ListSnapshots(ctx context.Context) ([]Snapshot, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	res, err := idb.cl.QueryContext(ctx, "SELECT name, tx, created_at FROM snapshot ORDER BY name")
+	if err != nil {
+		idb.log.Errorf("could not list snapshots: %s", err)
+
+		return nil, wrapErr(err)
+	}
+	defer res.Close()
+
+	var snaps []Snapshot
+	for res.Next() {
+		var snap Snapshot
+		var txID int64
+		if err := res.Scan(&snap.Name, &txID, &snap.CreatedAt); err != nil {
+			return nil, wrapErr(err)
+		}
+		snap.Tx = uint64(txID)
+		snaps = append(snaps, snap)
+	}
+
+	return snaps, wrapErr(res.Err())
+}
+
+// DeleteSnapshot removes a snapshot. The transaction it refers to, as the whole history, is not affected.
+func (idb *ImmuDbClient) DeleteSnapshot(ctx context.Context, name string) error {
+	if _, err := idb.GetSnapshot(ctx, name); err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	_, err := idb.cl.ExecContext(ctx, "DELETE FROM snapshot WHERE name=?", name)
+	if err != nil {
+		idb.log.Errorf("could not delete snapshot %s: %s", name, err)
+	}
+
+	return wrapErr(err)
+}
@@ -0,0 +1,31 @@
+package fs
+
+import "immufs/pkg/config"
+
+// rootSquash maps the uid and gid 0 to anonymous ones before they are recorded as the owner of an inode, so that
+// a local root can't leave files owned by root in the immutable history (see RootSquash in the configuration).
+type rootSquash struct {
+	enabled bool
+	anonUid uint32
+	anonGid uint32
+}
+
+func newRootSquash(cfg *config.Config) rootSquash {
+	return rootSquash{enabled: cfg.RootSquash, anonUid: cfg.AnonUid, anonGid: cfg.AnonGid}
+}
+
+func (s rootSquash) uid(uid uint32) uint32 {
+	if s.enabled && uid == 0 {
+		return s.anonUid
+	}
+
+	return uid
+}
+
+func (s rootSquash) gid(gid uint32) uint32 {
+	if s.enabled && gid == 0 {
+		return s.anonGid
+	}
+
+	return gid
+}
@@ -0,0 +1,203 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"immufs/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// immudb doesn't tell the size of its rows, so that it is estimated: an inode row takes about inodeRowBytes
+// with its index entries, and a content row its content plus contentRowBytes.
+const (
+	inodeRowBytes   = 160
+	contentRowBytes = 32
+)
+
+// TableStats describes the space taken in immudb by a table.
+type TableStats struct {
+	// Rows of the table, and revisions of the rows kept by immudb, the current ones included.
+	Rows      int64 `json:"rows"`
+	Revisions int64 `json:"revisions"`
+
+	// Approximate bytes taken by the current rows, and by all the revisions.
+	Bytes         int64 `json:"bytes"`
+	RevisionBytes int64 `json:"revision_bytes"`
+}
+
+// StorageStats describes the space taken in immudb by the filesystem. Since immudb never deletes anything,
+// the revisions tell how the database grows.
+type StorageStats struct {
+	Inode   TableStats `json:"inode"`
+	Content TableStats `json:"content"`
+
+	// Time the statistics were gathered at.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// countRows returns the rows of a table of the database, and the revisions of its rows.
+func countRows(ctx context.Context, db *sql.DB, table string) (rows int64, revisions int64, err error) {
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&rows); err != nil {
+		return 0, 0, wrapErr(err)
+	}
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM (HISTORY OF "+table+")").Scan(&revisions); err != nil {
+		return 0, 0, wrapErr(err)
+	}
+
+	return rows, revisions, nil
+}
+
+// StorageStats counts the rows and the revisions of the inode and content tables, and estimates their size.
+// The current content is as big as the files; the past revisions of a content are assumed to be, on average,
+// as big as the current ones. Counting the revisions scans the history of the tables: it is slow on big
+// databases.
+func (idb *ImmuDbClient) StorageStats(ctx context.Context) (*StorageStats, error) {
+	stats := &StorageStats{UpdatedAt: time.Now()}
+
+	var err error
+	stats.Inode.Rows, stats.Inode.Revisions, err = countRows(ctx, idb.cl, "inode")
+	if err != nil {
+		return nil, err
+	}
+	stats.Content.Rows, stats.Content.Revisions, err = countRows(ctx, idb.cl, "content")
+	if err != nil {
+		return nil, err
+	}
+	// The entries of the directories are stored with the inodes: both databases hold contents.
+	if idb.contentApart {
+		rows, revisions, err := countRows(ctx, idb.content, "content")
+		if err != nil {
+			return nil, err
+		}
+		stats.Content.Rows += rows
+		stats.Content.Revisions += revisions
+	}
+
+	var size sql.NullInt64
+	if err := idb.cl.QueryRowContext(ctx, "SELECT SUM(size) FROM inode").Scan(&size); err != nil {
+		return nil, wrapErr(err)
+	}
+
+	stats.Inode.Bytes = stats.Inode.Rows * inodeRowBytes
+	stats.Inode.RevisionBytes = stats.Inode.Revisions * inodeRowBytes
+	stats.Content.Bytes = size.Int64 + stats.Content.Rows*contentRowBytes
+	if stats.Content.Rows > 0 {
+		stats.Content.RevisionBytes = stats.Content.Revisions * (stats.Content.Bytes / stats.Content.Rows)
+	}
+
+	return stats, nil
+}
+
+var (
+	storageRows = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "immufs_storage_rows",
+		Help: "Rows of the immudb tables of the filesystem: the current ones, or all the revisions kept by immudb.",
+	}, []string{"table", "revisions"})
+	storageBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "immufs_storage_bytes",
+		Help: "Approximate bytes taken by the immudb tables of the filesystem: by the current rows, or by all the revisions.",
+	}, []string{"table", "revisions"})
+	storageUpdated = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "immufs_storage_updated_timestamp_seconds",
+		Help: "Time the storage metrics were last gathered at.",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(storageRows, storageBytes, storageUpdated)
+}
+
+func exportTableStats(table string, ts TableStats) {
+	storageRows.WithLabelValues(table, "current").Set(float64(ts.Rows))
+	storageRows.WithLabelValues(table, "all").Set(float64(ts.Revisions))
+	storageBytes.WithLabelValues(table, "current").Set(float64(ts.Bytes))
+	storageBytes.WithLabelValues(table, "all").Set(float64(ts.RevisionBytes))
+}
+
+// storageMonitor periodically gathers the storage statistics, reported by .immufs/stats and exported as metrics.
+type storageMonitor struct {
+	idb      *ImmuDbClient
+	log      *logrus.Entry
+	interval time.Duration
+
+	// Last statistics gathered, nil until the first run completes.
+	//
+	// GUARDED_BY(mu)
+	last *StorageStats
+	mu   sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newStorageMonitor(idb *ImmuDbClient, log *logrus.Entry, interval time.Duration) *storageMonitor {
+	return &storageMonitor{
+		idb:      idb,
+		log:      log.WithField("component", "storage"),
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start gathers the statistics in background, at once and then at every interval.
+func (m *storageMonitor) Start() {
+	go m.run()
+}
+
+// Stop terminates the monitor and waits for the current run, if any, to return.
+func (m *storageMonitor) Stop() {
+	close(m.stop)
+	<-m.done
+}
+
+// status returns the last statistics gathered, nil if none.
+func (m *storageMonitor) status() *StorageStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.last
+}
+
+func (m *storageMonitor) update() {
+	stats, err := m.idb.StorageStats(context.Background())
+	if err != nil {
+		// Tried again at the next tick.
+		m.log.Errorf("could not gather storage statistics: %s", err)
+
+		return
+	}
+
+	exportTableStats("inode", stats.Inode)
+	exportTableStats("content", stats.Content)
+	storageUpdated.Set(float64(stats.UpdatedAt.Unix()))
+	m.log.Infof("inode: %d rows, %d revisions, ~%d bytes; content: %d rows, %d revisions, ~%d bytes",
+		stats.Inode.Rows, stats.Inode.Revisions, stats.Inode.RevisionBytes,
+		stats.Content.Rows, stats.Content.Revisions, stats.Content.RevisionBytes)
+
+	m.mu.Lock()
+	m.last = stats
+	m.mu.Unlock()
+}
+
+func (m *storageMonitor) run() {
+	defer close(m.done)
+
+	tick, stopTick := ticker(m.interval)
+	defer stopTick()
+
+	m.update()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-tick:
+			m.update()
+		}
+	}
+}
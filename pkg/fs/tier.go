@@ -0,0 +1,467 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"immufs/pkg/metrics"
+	"immufs/pkg/sigv4"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNoTier is returned when reading a content moved to the tier by a client which is not configured with it.
+var ErrNoTier = errors.New("content moved to the tier, which is not configured")
+
+var (
+	tierMovedFiles = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "immufs_tier_moved_files_total",
+		Help: "Files whose content was moved to the tier.",
+	})
+	tierMovedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "immufs_tier_moved_bytes_total",
+		Help: "Bytes of the contents moved to the tier.",
+	})
+	tierReads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "immufs_tier_reads_total",
+		Help: "Contents read from the tier, by result (ok or error).",
+	}, []string{"result"})
+	tierWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "immufs_tier_writes_total",
+		Help: "Contents written to the tier, moved or written in the blob store mode, by result (ok or error).",
+	}, []string{"result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(tierMovedFiles, tierMovedBytes, tierReads, tierWrites)
+}
+
+// tierStore keeps the contents moved out of immudb, by key.
+type tierStore interface {
+	put(ctx context.Context, key string, content []byte) error
+	get(ctx context.Context, key string) ([]byte, error)
+}
+
+// Schemes of the tier URLs: S3 over HTTPS and plain HTTP, and a local directory.
+const (
+	tierScheme     = "s3"
+	tierSchemeHTTP = "s3+http"
+	tierSchemeFile = "file"
+
+	// Region signed when the URL sets none, accepted by MinIO.
+	defaultTierRegion = "us-east-1"
+)
+
+// newTierStore returns the store of a tier URL, an S3 bucket (see newS3Store) or a local directory, as
+// file:///path. The errors don't quote the URL, which may hold a secret key.
+func newTierStore(rawURL string) (tierStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.New("invalid tier url")
+	}
+	if u.Scheme == tierSchemeFile {
+		return newDirStore(u.Path)
+	}
+
+	return newS3Store(rawURL)
+}
+
+// s3Store is a bucket of an object storage speaking the S3 API (AWS, MinIO...), addressed with path-style URLs and
+// authenticated with Signature Version 4.
+type s3Store struct {
+	endpoint  string
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	client    *http.Client
+}
+
+// newS3Store returns the store of a tier URL, s3://ACCESS_KEY:SECRET_KEY@host[:port]/bucket[/prefix][?region=...],
+// or s3+http:// for plain HTTP. The errors don't quote the URL, which holds the secret key.
+func newS3Store(rawURL string) (*s3Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.New("invalid tier url")
+	}
+	s := &s3Store{region: defaultTierRegion, client: &http.Client{}}
+	switch u.Scheme {
+	case tierScheme:
+		s.endpoint = "https://" + u.Host
+	case tierSchemeHTTP:
+		s.endpoint = "http://" + u.Host
+	default:
+		return nil, fmt.Errorf("tier url: unsupported scheme %q, expecting %s, %s or %s", u.Scheme, tierScheme, tierSchemeHTTP, tierSchemeFile)
+	}
+	if u.Host == "" {
+		return nil, errors.New("tier url: no host")
+	}
+	s.bucket, s.prefix, _ = strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if s.bucket == "" {
+		return nil, errors.New("tier url: no bucket")
+	}
+	if s.prefix != "" {
+		s.prefix += "/"
+	}
+	if u.User != nil {
+		s.accessKey = u.User.Username()
+		s.secretKey, _ = u.User.Password()
+	}
+	for name, values := range u.Query() {
+		if name != "region" {
+			return nil, fmt.Errorf("tier url: unknown parameter %s", name)
+		}
+		s.region = values[0]
+	}
+
+	return s, nil
+}
+
+// do sends a request for an object, signed if the store has credentials, and returns the body of the response.
+func (s *s3Store) do(ctx context.Context, method, key string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint+"/"+s.bucket+"/"+sigv4.Escape(s.prefix+key, false), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if s.accessKey != "" {
+		sigv4.Sign(req, s.accessKey, s.secretKey, s.region, "s3", body, time.Now())
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		// The S3 errors are XML documents with a code.
+		var s3Err struct {
+			Code string `xml:"Code"`
+		}
+		if xml.Unmarshal(data, &s3Err) == nil && s3Err.Code != "" {
+			return nil, fmt.Errorf("%s %s: %s (%s)", method, key, res.Status, s3Err.Code)
+		}
+
+		return nil, fmt.Errorf("%s %s: %s", method, key, res.Status)
+	}
+
+	return data, nil
+}
+
+func (s *s3Store) put(ctx context.Context, key string, content []byte) error {
+	_, err := s.do(ctx, http.MethodPut, key, content)
+
+	return err
+}
+
+func (s *s3Store) get(ctx context.Context, key string) ([]byte, error) {
+	return s.do(ctx, http.MethodGet, key, nil)
+}
+
+// dirStore keeps the objects in files under a local directory, e.g. a mounted NFS share, named like those of the
+// disk cache.
+type dirStore struct {
+	dir string
+}
+
+func newDirStore(dir string) (*dirStore, error) {
+	if dir == "" {
+		return nil, errors.New("tier url: no directory")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("tier directory: %w", err)
+	}
+
+	return &dirStore{dir: dir}, nil
+}
+
+func (s *dirStore) path(key string) string {
+	if len(key) < 2 {
+		return filepath.Join(s.dir, key)
+	}
+
+	return filepath.Join(s.dir, key[:2], key)
+}
+
+// put writes the file of an object, synced before immudb refers to it.
+func (s *dirStore) put(ctx context.Context, key string, content []byte) error {
+	return writeFileAtomic(s.path(key), content, true)
+}
+
+func (s *dirStore) get(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(s.path(key))
+}
+
+// putTier writes a content to the tier, as the object key.
+func (idb *ImmuDbClient) putTier(ctx context.Context, key string, content []byte) error {
+	if err := idb.tier.put(ctx, key, content); err != nil {
+		tierWrites.WithLabelValues("error").Inc()
+		idb.log.Errorf("could not write content %s to the tier: %s", key, err)
+
+		return wrapErr(err)
+	}
+	tierWrites.WithLabelValues("ok").Inc()
+
+	return nil
+}
+
+// readTier reads a content moved to the tier, and checks it against the checksum kept in immudb.
+func (idb *ImmuDbClient) readTier(ctx context.Context, inumber int64, key string, checksum sql.NullString) ([]byte, error) {
+	if idb.tier == nil {
+		return nil, fmt.Errorf("file %d: %w", inumber, ErrNoTier)
+	}
+	content, err := idb.tier.get(ctx, key)
+	if err != nil {
+		tierReads.WithLabelValues("error").Inc()
+		idb.log.Errorf("could not read file %d content from the tier: %s", inumber, err)
+
+		return nil, wrapErr(err)
+	}
+	tierReads.WithLabelValues("ok").Inc()
+
+	return content, idb.verifyChecksum(ctx, inumber, content, checksum)
+}
+
+// resolveContent returns the content of a row of the content table, read from the tier if moved there, and checked
+// against its checksum.
+func (idb *ImmuDbClient) resolveContent(ctx context.Context, inumber int64, content []byte, checksum, tier sql.NullString) ([]byte, error) {
+	if tier.Valid {
+		return idb.readTier(ctx, inumber, tier.String, checksum)
+	}
+
+	return content, idb.verifyChecksum(ctx, inumber, content, checksum)
+}
+
+// TierPolicy selects the files whose content is moved to the tier (see TierAfter in the configuration).
+type TierPolicy struct {
+	// Files neither modified nor accessed for this long.
+	After time.Duration
+	// Files smaller than this many bytes are left in immudb.
+	MinSize int64
+	// Only report the files which would be moved.
+	DryRun bool
+}
+
+// TierReport describes a run of the tiering policy.
+type TierReport struct {
+	// Files whose content was moved, and its total size.
+	Files int
+	Bytes int64
+}
+
+// TierColdFiles moves the content of the files selected by the policy to the tier, one transaction per file: the
+// content column is emptied, while the checksum and the metadata stay in immudb. The object is named after the
+// checksum, so that the same content is stored once, and the files read it back transparently. The files with
+// journaled writes are left until compacted (see CompactJournal).
+func (idb *ImmuDbClient) TierColdFiles(ctx context.Context, policy TierPolicy) (*TierReport, error) {
+	if idb.tier == nil {
+		return nil, ErrNoTier
+	}
+	if idb.contentApart {
+		return nil, fmt.Errorf("contents stored in a separate database: %w", ErrNotSupported)
+	}
+	if policy.After <= 0 {
+		return nil, errors.New("the age of the files to move must be positive")
+	}
+	cutoff := time.Now().Add(-policy.After)
+
+	candidates, err := idb.tierCandidates(ctx, cutoff, policy.MinSize)
+	if err != nil {
+		return nil, err
+	}
+	report := &TierReport{}
+	for _, inumber := range candidates {
+		size, err := idb.tierFile(ctx, inumber, cutoff, policy.DryRun)
+		// A damaged content is quarantined rather than moved (see ListQuarantine).
+		if errors.Is(err, ErrChecksumMismatch) {
+			continue
+		}
+		if err != nil {
+			idb.log.Errorf("could not move file %d to the tier: %s", inumber, err)
+
+			return report, err
+		}
+		if size < 0 {
+			continue
+		}
+		report.Files++
+		report.Bytes += size
+		if !policy.DryRun {
+			tierMovedFiles.Inc()
+			tierMovedBytes.Add(float64(size))
+		}
+	}
+
+	return report, nil
+}
+
+// tierCandidates returns the files big enough not modified since cutoff. Whether they were accessed since, and
+// their content, are checked by tierFile.
+func (idb *ImmuDbClient) tierCandidates(ctx context.Context, cutoff time.Time, minSize int64) ([]int64, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	res, err := idb.cl.QueryContext(ctx, "SELECT inumber, mode FROM inode WHERE size >= ? AND mtime < ?", minSize, cutoff)
+	if err != nil {
+		idb.log.Errorf("could not list the files to move to the tier: %s", err)
+
+		return nil, wrapErr(err)
+	}
+	defer res.Close()
+
+	var inumbers []int64
+	for res.Next() {
+		var inumber, mode int64
+		if err := res.Scan(&inumber, &mode); err != nil {
+			return nil, wrapErr(err)
+		}
+		if os.FileMode(mode).IsRegular() {
+			inumbers = append(inumbers, inumber)
+		}
+	}
+
+	return inumbers, wrapErr(res.Err())
+}
+
+// tierFile moves the content of a file to the tier, within a transaction checking that the file is still cold. It
+// returns the size of the content moved, or -1 if the file was skipped: written or accessed since cutoff, already
+// moved, inlined (see InlineThreshold in the configuration), or with journaled writes.
+func (idb *ImmuDbClient) tierFile(ctx context.Context, inumber int64, cutoff time.Time, dryRun bool) (int64, error) {
+	size := int64(-1)
+	err := idb.inTx(ctx, idb.writeTimeout.Load(), func(ctx context.Context, tx *sql.Tx) error {
+		size = -1
+
+		var atime, mtime sql.NullTime
+		err := tx.QueryRowContext(ctx, "SELECT atime, mtime FROM inode WHERE inumber=?", inumber).Scan(&atime, &mtime)
+		if errors.Is(err, sql.ErrNoRows) || (atime.Valid && atime.Time.After(cutoff)) || (mtime.Valid && mtime.Time.After(cutoff)) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var content []byte
+		var checksum, tier sql.NullString
+		err = tx.QueryRowContext(ctx, "SELECT content, checksum, tier FROM content WHERE inumber=?", inumber).Scan(&content, &checksum, &tier)
+		if errors.Is(err, sql.ErrNoRows) || tier.Valid {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if idb.journal {
+			deltas, err := queryDeltas(ctx, tx, inumber, "")
+			if err != nil || len(deltas) > 0 {
+				return err
+			}
+		}
+		// The contents written before the checksums get one.
+		if err := idb.verifyChecksum(ctx, inumber, content, checksum); err != nil {
+			return err
+		}
+		size = int64(len(content))
+		if dryRun {
+			return nil
+		}
+
+		key := contentChecksum(content)
+		if err := idb.putTier(ctx, key, content); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE content SET content=NULL, checksum=?, tier=? WHERE inumber=?", key, key, inumber)
+
+		return err
+	})
+
+	return size, err
+}
+
+// tierer periodically applies the tiering policy on behalf of a long-lived mount.
+type tierer struct {
+	idb      *ImmuDbClient
+	log      *logrus.Entry
+	policy   TierPolicy
+	interval time.Duration
+
+	// Completion time of the last successful run.
+	//
+	// GUARDED_BY(mu)
+	lastRun time.Time
+	mu      sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newTierer(idb *ImmuDbClient, log *logrus.Entry, interval time.Duration, policy TierPolicy) *tierer {
+	return &tierer{
+		idb:      idb,
+		log:      log.WithField("component", "tierer"),
+		policy:   policy,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start runs the tiering in background.
+func (t *tierer) Start() {
+	go t.run()
+}
+
+// Stop terminates the tiering and waits for the current run, if any, to return.
+func (t *tierer) Stop() {
+	close(t.stop)
+	<-t.done
+}
+
+// status returns the completion time of the last run (zero if none).
+func (t *tierer) status() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.lastRun
+}
+
+func (t *tierer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	// Errors are only logged: the files left are moved at the next tick.
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			report, err := t.idb.TierColdFiles(context.Background(), t.policy)
+			if err != nil {
+				continue
+			}
+			t.mu.Lock()
+			t.lastRun = time.Now()
+			t.mu.Unlock()
+			if report.Files > 0 {
+				t.log.Infof("moved %d files (%d bytes) to the tier", report.Files, report.Bytes)
+			}
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package fs
+
+import (
+	"context"
+	"time"
+
+	"immufs/pkg/tracing"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// Time given to the exporter to send the last spans once the filesystem is destroyed.
+const traceShutdownTimeout = 10 * time.Second
+
+// tracedFS records a span for every operation served by the wrapped filesystem. The immudb queries run on
+// behalf of an operation are recorded as its children.
+type tracedFS struct {
+	fuseutil.FileSystem
+}
+
+// NewTracedFileSystem wraps fs so that its operations are traced. Spans are exported until fs is destroyed.
+func NewTracedFileSystem(fs fuseutil.FileSystem) fuseutil.FileSystem {
+	return &tracedFS{fs}
+}
+
+func startOp(ctx context.Context, op string, id fuseops.InodeID, attrs ...tracing.Attribute) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, "fuse."+op, tracing.KindServer,
+		append([]tracing.Attribute{tracing.Int64("fuse.inumber", int64(id))}, attrs...)...)
+}
+
+func nameAttr(name string) tracing.Attribute {
+	return tracing.String("fuse.name", name)
+}
+
+func bytesAttr(n int) tracing.Attribute {
+	return tracing.Int64("fuse.bytes", int64(n))
+}
+
+func (t *tracedFS) StatFS(ctx context.Context, op *fuseops.StatFSOp) error {
+	ctx, span := tracing.Start(ctx, "fuse.StatFS", tracing.KindServer)
+	err := t.FileSystem.StatFS(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) LookUpInode(ctx context.Context, op *fuseops.LookUpInodeOp) error {
+	ctx, span := startOp(ctx, "LookUpInode", op.Parent, nameAttr(op.Name))
+	err := t.FileSystem.LookUpInode(ctx, op)
+	if err == nil {
+		span.SetAttributes(tracing.Int64("fuse.child", int64(op.Entry.Child)))
+	}
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) GetInodeAttributes(ctx context.Context, op *fuseops.GetInodeAttributesOp) error {
+	ctx, span := startOp(ctx, "GetInodeAttributes", op.Inode)
+	err := t.FileSystem.GetInodeAttributes(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) SetInodeAttributes(ctx context.Context, op *fuseops.SetInodeAttributesOp) error {
+	ctx, span := startOp(ctx, "SetInodeAttributes", op.Inode)
+	err := t.FileSystem.SetInodeAttributes(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) ForgetInode(ctx context.Context, op *fuseops.ForgetInodeOp) error {
+	ctx, span := startOp(ctx, "ForgetInode", op.Inode)
+	err := t.FileSystem.ForgetInode(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) BatchForget(ctx context.Context, op *fuseops.BatchForgetOp) error {
+	ctx, span := tracing.Start(ctx, "fuse.BatchForget", tracing.KindServer, tracing.Int64("fuse.entries", int64(len(op.Entries))))
+	err := t.FileSystem.BatchForget(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) MkDir(ctx context.Context, op *fuseops.MkDirOp) error {
+	ctx, span := startOp(ctx, "MkDir", op.Parent, nameAttr(op.Name))
+	err := t.FileSystem.MkDir(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) MkNode(ctx context.Context, op *fuseops.MkNodeOp) error {
+	ctx, span := startOp(ctx, "MkNode", op.Parent, nameAttr(op.Name))
+	err := t.FileSystem.MkNode(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) CreateFile(ctx context.Context, op *fuseops.CreateFileOp) error {
+	ctx, span := startOp(ctx, "CreateFile", op.Parent, nameAttr(op.Name))
+	err := t.FileSystem.CreateFile(ctx, op)
+	if err == nil {
+		span.SetAttributes(tracing.Int64("fuse.child", int64(op.Entry.Child)))
+	}
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) CreateLink(ctx context.Context, op *fuseops.CreateLinkOp) error {
+	ctx, span := startOp(ctx, "CreateLink", op.Parent, nameAttr(op.Name), tracing.Int64("fuse.target", int64(op.Target)))
+	err := t.FileSystem.CreateLink(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) CreateSymlink(ctx context.Context, op *fuseops.CreateSymlinkOp) error {
+	ctx, span := startOp(ctx, "CreateSymlink", op.Parent, nameAttr(op.Name))
+	err := t.FileSystem.CreateSymlink(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) Rename(ctx context.Context, op *fuseops.RenameOp) error {
+	ctx, span := startOp(ctx, "Rename", op.OldParent, nameAttr(op.OldName),
+		tracing.Int64("fuse.new_parent", int64(op.NewParent)), tracing.String("fuse.new_name", op.NewName))
+	err := t.FileSystem.Rename(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) RmDir(ctx context.Context, op *fuseops.RmDirOp) error {
+	ctx, span := startOp(ctx, "RmDir", op.Parent, nameAttr(op.Name))
+	err := t.FileSystem.RmDir(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) Unlink(ctx context.Context, op *fuseops.UnlinkOp) error {
+	ctx, span := startOp(ctx, "Unlink", op.Parent, nameAttr(op.Name))
+	err := t.FileSystem.Unlink(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) OpenDir(ctx context.Context, op *fuseops.OpenDirOp) error {
+	ctx, span := startOp(ctx, "OpenDir", op.Inode)
+	err := t.FileSystem.OpenDir(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) ReadDir(ctx context.Context, op *fuseops.ReadDirOp) error {
+	ctx, span := startOp(ctx, "ReadDir", op.Inode, tracing.Int64("fuse.offset", int64(op.Offset)))
+	err := t.FileSystem.ReadDir(ctx, op)
+	span.SetAttributes(bytesAttr(op.BytesRead))
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) ReleaseDirHandle(ctx context.Context, op *fuseops.ReleaseDirHandleOp) error {
+	ctx, span := tracing.Start(ctx, "fuse.ReleaseDirHandle", tracing.KindServer)
+	err := t.FileSystem.ReleaseDirHandle(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) OpenFile(ctx context.Context, op *fuseops.OpenFileOp) error {
+	ctx, span := startOp(ctx, "OpenFile", op.Inode)
+	err := t.FileSystem.OpenFile(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) ReadFile(ctx context.Context, op *fuseops.ReadFileOp) error {
+	ctx, span := startOp(ctx, "ReadFile", op.Inode, tracing.Int64("fuse.offset", op.Offset))
+	err := t.FileSystem.ReadFile(ctx, op)
+	span.SetAttributes(bytesAttr(op.BytesRead))
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) WriteFile(ctx context.Context, op *fuseops.WriteFileOp) error {
+	ctx, span := startOp(ctx, "WriteFile", op.Inode, tracing.Int64("fuse.offset", op.Offset), bytesAttr(len(op.Data)))
+	err := t.FileSystem.WriteFile(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) SyncFile(ctx context.Context, op *fuseops.SyncFileOp) error {
+	ctx, span := startOp(ctx, "SyncFile", op.Inode)
+	err := t.FileSystem.SyncFile(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) FlushFile(ctx context.Context, op *fuseops.FlushFileOp) error {
+	ctx, span := startOp(ctx, "FlushFile", op.Inode)
+	err := t.FileSystem.FlushFile(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) ReleaseFileHandle(ctx context.Context, op *fuseops.ReleaseFileHandleOp) error {
+	ctx, span := tracing.Start(ctx, "fuse.ReleaseFileHandle", tracing.KindServer)
+	err := t.FileSystem.ReleaseFileHandle(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) ReadSymlink(ctx context.Context, op *fuseops.ReadSymlinkOp) error {
+	ctx, span := startOp(ctx, "ReadSymlink", op.Inode)
+	err := t.FileSystem.ReadSymlink(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) RemoveXattr(ctx context.Context, op *fuseops.RemoveXattrOp) error {
+	ctx, span := startOp(ctx, "RemoveXattr", op.Inode, nameAttr(op.Name))
+	err := t.FileSystem.RemoveXattr(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) GetXattr(ctx context.Context, op *fuseops.GetXattrOp) error {
+	ctx, span := startOp(ctx, "GetXattr", op.Inode, nameAttr(op.Name))
+	err := t.FileSystem.GetXattr(ctx, op)
+	span.SetAttributes(bytesAttr(op.BytesRead))
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) ListXattr(ctx context.Context, op *fuseops.ListXattrOp) error {
+	ctx, span := startOp(ctx, "ListXattr", op.Inode)
+	err := t.FileSystem.ListXattr(ctx, op)
+	span.SetAttributes(bytesAttr(op.BytesRead))
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) SetXattr(ctx context.Context, op *fuseops.SetXattrOp) error {
+	ctx, span := startOp(ctx, "SetXattr", op.Inode, nameAttr(op.Name), bytesAttr(len(op.Value)))
+	err := t.FileSystem.SetXattr(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) Fallocate(ctx context.Context, op *fuseops.FallocateOp) error {
+	ctx, span := startOp(ctx, "Fallocate", op.Inode, tracing.Int64("fuse.offset", int64(op.Offset)),
+		tracing.Int64("fuse.length", int64(op.Length)))
+	err := t.FileSystem.Fallocate(ctx, op)
+	span.Finish(err)
+
+	return err
+}
+
+func (t *tracedFS) Destroy() {
+	t.FileSystem.Destroy()
+
+	ctx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+	defer cancel()
+	tracing.Shutdown(ctx)
+}
@@ -0,0 +1,233 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+var ErrNotInTrash = errors.New("entry not found in trash")
+
+// Name of the directory, at the root of the filesystem, where unlinked files are moved to.
+const trashDirName = ".trash"
+
+// TrashEntry records where a file moved to the trash was unlinked from.
+type TrashEntry struct {
+	Inumber   int64
+	Parent    int64
+	Name      string
+	DeletedAt time.Time
+}
+
+// AddTrash records a file moved to the trash.
+func (idb *ImmuDbClient) AddTrash(ctx context.Context, e *TrashEntry) error {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	_, err := idb.cl.ExecContext(ctx, "UPSERT INTO trash(inumber, parent, name, deleted_at) VALUES(?, ?, ?, ?)", e.Inumber, e.Parent, e.Name, e.DeletedAt)
+	if err != nil {
+		idb.log.Errorf("could not add inode %d to trash: %s", e.Inumber, err)
+	}
+
+	return wrapErr(err)
+}
+
+// GetTrash returns the trash record of a file, or ErrNotInTrash.
+func (idb *ImmuDbClient) GetTrash(ctx context.Context, inumber int64) (*TrashEntry, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	e := TrashEntry{Inumber: inumber}
+	err := idb.cl.QueryRowContext(ctx, "SELECT parent, name, deleted_at FROM trash WHERE inumber=?", inumber).Scan(&e.Parent, &e.Name, &e.DeletedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotInTrash
+	}
+	if err != nil {
+		idb.log.Errorf("could not get trash entry %d: %s", inumber, err)
+
+		return nil, wrapErr(err)
+	}
+
+	return &e, nil
+}
+
+// ListTrash returns the files in the trash, ordered by inumber.
+func (idb *ImmuDbClient) ListTrash(ctx context.Context) ([]TrashEntry, error) {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	res, err := idb.cl.QueryContext(ctx, "SELECT inumber, parent, name, deleted_at FROM trash ORDER BY inumber")
+	if err != nil {
+		idb.log.Errorf("could not list trash: %s", err)
+
+		return nil, wrapErr(err)
+	}
+	defer res.Close()
+
+	var entries []TrashEntry
+	for res.Next() {
+		var e TrashEntry
+		if err := res.Scan(&e.Inumber, &e.Parent, &e.Name, &e.DeletedAt); err != nil {
+			return nil, wrapErr(err)
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, wrapErr(res.Err())
+}
+
+// DeleteTrash forgets the trash record of a file, e.g. once it is deleted for good.
+func (idb *ImmuDbClient) DeleteTrash(ctx context.Context, inumber int64) error {
+	ctx, cancel := withTimeout(ctx, idb.metadataTimeout.Load())
+	defer cancel()
+
+	_, err := idb.cl.ExecContext(ctx, "DELETE FROM trash WHERE inumber=?", inumber)
+	if err != nil {
+		idb.log.Errorf("could not delete trash entry %d: %s", inumber, err)
+	}
+
+	return wrapErr(err)
+}
+
+// RestoreTrash moves a file from the trash back to the directory it was unlinked from, under its original name.
+func (idb *ImmuDbClient) RestoreTrash(ctx context.Context, inumber int64) error {
+	e, err := idb.GetTrash(ctx, inumber)
+	if err != nil {
+		return err
+	}
+	trash, _, err := idb.LookUpPath(ctx, trashDirName)
+	if err != nil {
+		return err
+	}
+	parent, err := idb.GetInode(ctx, e.Parent)
+	if err != nil {
+		return fmt.Errorf("original directory %d: %w", e.Parent, err)
+	}
+	child, err := idb.GetInode(ctx, inumber)
+	if err != nil {
+		return err
+	}
+
+	// The entry may have been renamed within the trash.
+	children, err := trash.getChildren()
+	if err != nil {
+		return err
+	}
+	var trashName string
+	for _, d := range children {
+		if d.Type != fuseutil.DT_Unknown && int64(d.Inode) == inumber {
+			trashName = d.Name
+		}
+	}
+	if trashName == "" {
+		return ErrNotInTrash
+	}
+
+	if err := parent.AddChild(fuseops.InodeID(inumber), e.Name, direntType(os.FileMode(child.Mode))); err != nil {
+		return err
+	}
+	if err := trash.RemoveChild(trashName); err != nil {
+		return err
+	}
+
+	return idb.DeleteTrash(ctx, inumber)
+}
+
+// trashName returns the name of an unlinked file within the trash: the inumber makes it unique.
+func trashName(inumber int64, name string) string {
+	n := fmt.Sprintf("%d-%s", inumber, name)
+	if len(n) > maxNameLen {
+		n = strings.ToValidUTF8(n[:maxNameLen], "")
+	}
+
+	return n
+}
+
+// getTrashDir returns the trash directory, creating it if needed.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) getTrashDir(ctx context.Context) (*Inode, error) {
+	root, err := fs.getInode(ctx, fuseops.RootInodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _, ok, err := root.LookUpChild(trashDirName)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return fs.getInode(ctx, id)
+	}
+
+	now := time.Now()
+	attrs := fuseops.InodeAttributes{
+		Nlink:  1,
+		Atime:  now,
+		Mtime:  now,
+		Ctime:  now,
+		Crtime: now,
+		Mode:   0700 | os.ModeDir,
+		Uid:    fs.uid,
+		Gid:    fs.gid,
+	}
+	id, trash, err := fs.allocateInode(ctx, attrs, root.Project)
+	if err != nil {
+		return nil, err
+	}
+	if err := root.AddChild(id, trashDirName, fuseutil.DT_Directory); err != nil {
+		return nil, err
+	}
+	fs.log.Infof("trash directory created")
+
+	return trash, nil
+}
+
+// moveToTrash unlinks a file from its parent by moving it to the trash directory. Files unlinked
+// from the trash itself are deleted for good.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) moveToTrash(parent *Inode, name string, child *Inode) (trashed bool, err error) {
+	ctx := parent.opContext()
+	trash, err := fs.getTrashDir(ctx)
+	if err != nil {
+		return false, err
+	}
+	if parent.Inumber == trash.Inumber {
+		if err := fs.idb.DeleteTrash(ctx, child.Inumber); err != nil {
+			return false, err
+		}
+
+		return false, nil
+	}
+
+	if err := trash.AddChild(fuseops.InodeID(child.Inumber), trashName(child.Inumber, name), direntType(os.FileMode(child.Mode))); err != nil {
+		return false, err
+	}
+	e := &TrashEntry{
+		Inumber:   child.Inumber,
+		Parent:    parent.Inumber,
+		Name:      name,
+		DeletedAt: time.Now(),
+	}
+	if err := fs.idb.AddTrash(ctx, e); err != nil {
+		return false, err
+	}
+	if err := parent.RemoveChild(name); err != nil {
+		return false, err
+	}
+
+	child.Ctime = e.DeletedAt
+	if err := child.write(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
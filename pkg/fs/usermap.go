@@ -0,0 +1,232 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"immufs/pkg/tracing"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// UserCredentials are the immudb credentials the operations of a local uid are served with.
+type UserCredentials struct {
+	User     string
+	Password string
+}
+
+// ParseUserMap parses the entries of the user-map option, "<uid>=<immudb user>:<password>", into the immudb
+// credentials of each uid. The password may contain colons, the user may not.
+func ParseUserMap(entries []string) (map[uint32]UserCredentials, error) {
+	users := make(map[uint32]UserCredentials, len(entries))
+	for i, entry := range entries {
+		// The entries are not quoted in the errors, not to disclose the passwords.
+		uidStr, creds, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid user mapping #%d, expecting <uid>=<user>:<password>", i+1)
+		}
+		uid, err := strconv.ParseUint(uidStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uid %q in user mapping", uidStr)
+		}
+		user, password, ok := strings.Cut(creds, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("invalid user mapping of uid %d, expecting <uid>=<user>:<password>", uid)
+		}
+		if _, ok := users[uint32(uid)]; ok {
+			return nil, fmt.Errorf("uid %d mapped twice", uid)
+		}
+		users[uint32(uid)] = UserCredentials{User: user, Password: password}
+	}
+
+	return users, nil
+}
+
+// userPools are the connection pools of an immudb user: the one of the mount, or one a uid is mapped to.
+type userPools struct {
+	user string
+	// Pools of the database of the inodes, and of the database of the content: cl, unless stored apart.
+	cl      *sql.DB
+	content *sql.DB
+}
+
+// callerKey is the key of the uid of the process an operation is served for, in its context.
+type callerKey struct{}
+
+// withCaller returns a context telling that the operations are served for the given uid.
+func withCaller(ctx context.Context, uid uint32) context.Context {
+	return context.WithValue(ctx, callerKey{}, uid)
+}
+
+// callerOf returns the uid the operations of ctx are served for, if known.
+func callerOf(ctx context.Context) (uint32, bool) {
+	uid, ok := ctx.Value(callerKey{}).(uint32)
+
+	return uid, ok
+}
+
+// detach returns a background context carrying the span and the caller of ctx only, for the queries run later
+// on behalf of an operation (see Inode.opContext).
+func detach(ctx context.Context) context.Context {
+	detached := tracing.Detach(ctx)
+	if uid, ok := callerOf(ctx); ok {
+		detached = withCaller(detached, uid)
+	}
+
+	return detached
+}
+
+// pools returns the connection pools serving the caller of ctx: those of the immudb user its uid is mapped to,
+// or those of the mount for the operations of other uids, and those not run on behalf of a process.
+func (idb *ImmuDbClient) pools(ctx context.Context) userPools {
+	if uid, ok := callerOf(ctx); ok {
+		if p, ok := idb.userPools[uid]; ok {
+			return p
+		}
+	}
+
+	return userPools{user: idb.user, cl: idb.cl, content: idb.content}
+}
+
+// mappedFS serves the operations of the wrapped filesystem with the immudb credentials of the uid of the calling
+// process, as mapped by the user-map option.
+type mappedFS struct {
+	fuseutil.FileSystem
+	fs *Immufs
+}
+
+// NewMappedFileSystem wraps fs so that the operations of the processes of the mapped uids reach immudb as their
+// immudb user. The uid of a process is read from /proc for every operation, unless told by its context (see
+// WithCredentials).
+func NewMappedFileSystem(wrapped fuseutil.FileSystem, fs *Immufs) fuseutil.FileSystem {
+	return &mappedFS{FileSystem: wrapped, fs: fs}
+}
+
+// caller returns the context of an operation of the given process, telling its uid. Processes whose
+// credentials can't be read, e.g. because they exited already, are served as the mount.
+func (m *mappedFS) caller(ctx context.Context, api string, opCtx fuseops.OpContext) context.Context {
+	uid, _, err := callerCreds(ctx, opCtx.Pid)
+	if err != nil || uid < 0 {
+		m.fs.log.WithField("API", api).Debugf("could not read the credentials of PID %d: %v", opCtx.Pid, err)
+
+		return ctx
+	}
+
+	return withCaller(ctx, uint32(uid))
+}
+
+func (m *mappedFS) LookUpInode(ctx context.Context, op *fuseops.LookUpInodeOp) error {
+	return m.FileSystem.LookUpInode(m.caller(ctx, "LookUpInode", op.OpContext), op)
+}
+
+func (m *mappedFS) GetInodeAttributes(ctx context.Context, op *fuseops.GetInodeAttributesOp) error {
+	return m.FileSystem.GetInodeAttributes(m.caller(ctx, "GetInodeAttributes", op.OpContext), op)
+}
+
+func (m *mappedFS) SetInodeAttributes(ctx context.Context, op *fuseops.SetInodeAttributesOp) error {
+	return m.FileSystem.SetInodeAttributes(m.caller(ctx, "SetInodeAttributes", op.OpContext), op)
+}
+
+func (m *mappedFS) ForgetInode(ctx context.Context, op *fuseops.ForgetInodeOp) error {
+	return m.FileSystem.ForgetInode(m.caller(ctx, "ForgetInode", op.OpContext), op)
+}
+
+func (m *mappedFS) BatchForget(ctx context.Context, op *fuseops.BatchForgetOp) error {
+	return m.FileSystem.BatchForget(m.caller(ctx, "BatchForget", op.OpContext), op)
+}
+
+func (m *mappedFS) MkDir(ctx context.Context, op *fuseops.MkDirOp) error {
+	return m.FileSystem.MkDir(m.caller(ctx, "MkDir", op.OpContext), op)
+}
+
+func (m *mappedFS) MkNode(ctx context.Context, op *fuseops.MkNodeOp) error {
+	return m.FileSystem.MkNode(m.caller(ctx, "MkNode", op.OpContext), op)
+}
+
+func (m *mappedFS) CreateFile(ctx context.Context, op *fuseops.CreateFileOp) error {
+	return m.FileSystem.CreateFile(m.caller(ctx, "CreateFile", op.OpContext), op)
+}
+
+func (m *mappedFS) CreateLink(ctx context.Context, op *fuseops.CreateLinkOp) error {
+	return m.FileSystem.CreateLink(m.caller(ctx, "CreateLink", op.OpContext), op)
+}
+
+func (m *mappedFS) CreateSymlink(ctx context.Context, op *fuseops.CreateSymlinkOp) error {
+	return m.FileSystem.CreateSymlink(m.caller(ctx, "CreateSymlink", op.OpContext), op)
+}
+
+func (m *mappedFS) Rename(ctx context.Context, op *fuseops.RenameOp) error {
+	return m.FileSystem.Rename(m.caller(ctx, "Rename", op.OpContext), op)
+}
+
+func (m *mappedFS) RmDir(ctx context.Context, op *fuseops.RmDirOp) error {
+	return m.FileSystem.RmDir(m.caller(ctx, "RmDir", op.OpContext), op)
+}
+
+func (m *mappedFS) Unlink(ctx context.Context, op *fuseops.UnlinkOp) error {
+	return m.FileSystem.Unlink(m.caller(ctx, "Unlink", op.OpContext), op)
+}
+
+func (m *mappedFS) OpenDir(ctx context.Context, op *fuseops.OpenDirOp) error {
+	return m.FileSystem.OpenDir(m.caller(ctx, "OpenDir", op.OpContext), op)
+}
+
+func (m *mappedFS) ReadDir(ctx context.Context, op *fuseops.ReadDirOp) error {
+	return m.FileSystem.ReadDir(m.caller(ctx, "ReadDir", op.OpContext), op)
+}
+
+func (m *mappedFS) ReleaseDirHandle(ctx context.Context, op *fuseops.ReleaseDirHandleOp) error {
+	return m.FileSystem.ReleaseDirHandle(m.caller(ctx, "ReleaseDirHandle", op.OpContext), op)
+}
+
+func (m *mappedFS) OpenFile(ctx context.Context, op *fuseops.OpenFileOp) error {
+	return m.FileSystem.OpenFile(m.caller(ctx, "OpenFile", op.OpContext), op)
+}
+
+func (m *mappedFS) ReadFile(ctx context.Context, op *fuseops.ReadFileOp) error {
+	return m.FileSystem.ReadFile(m.caller(ctx, "ReadFile", op.OpContext), op)
+}
+
+func (m *mappedFS) WriteFile(ctx context.Context, op *fuseops.WriteFileOp) error {
+	return m.FileSystem.WriteFile(m.caller(ctx, "WriteFile", op.OpContext), op)
+}
+
+func (m *mappedFS) SyncFile(ctx context.Context, op *fuseops.SyncFileOp) error {
+	return m.FileSystem.SyncFile(m.caller(ctx, "SyncFile", op.OpContext), op)
+}
+
+func (m *mappedFS) FlushFile(ctx context.Context, op *fuseops.FlushFileOp) error {
+	return m.FileSystem.FlushFile(m.caller(ctx, "FlushFile", op.OpContext), op)
+}
+
+func (m *mappedFS) ReleaseFileHandle(ctx context.Context, op *fuseops.ReleaseFileHandleOp) error {
+	return m.FileSystem.ReleaseFileHandle(m.caller(ctx, "ReleaseFileHandle", op.OpContext), op)
+}
+
+func (m *mappedFS) ReadSymlink(ctx context.Context, op *fuseops.ReadSymlinkOp) error {
+	return m.FileSystem.ReadSymlink(m.caller(ctx, "ReadSymlink", op.OpContext), op)
+}
+
+func (m *mappedFS) RemoveXattr(ctx context.Context, op *fuseops.RemoveXattrOp) error {
+	return m.FileSystem.RemoveXattr(m.caller(ctx, "RemoveXattr", op.OpContext), op)
+}
+
+func (m *mappedFS) GetXattr(ctx context.Context, op *fuseops.GetXattrOp) error {
+	return m.FileSystem.GetXattr(m.caller(ctx, "GetXattr", op.OpContext), op)
+}
+
+func (m *mappedFS) ListXattr(ctx context.Context, op *fuseops.ListXattrOp) error {
+	return m.FileSystem.ListXattr(m.caller(ctx, "ListXattr", op.OpContext), op)
+}
+
+func (m *mappedFS) SetXattr(ctx context.Context, op *fuseops.SetXattrOp) error {
+	return m.FileSystem.SetXattr(m.caller(ctx, "SetXattr", op.OpContext), op)
+}
+
+func (m *mappedFS) Fallocate(ctx context.Context, op *fuseops.FallocateOp) error {
+	return m.FileSystem.Fallocate(m.caller(ctx, "Fallocate", op.OpContext), op)
+}
@@ -0,0 +1,215 @@
+package fs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strconv"
+
+	"github.com/jacobsa/fuse"
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// ContentRevisions returns the revisions of a file content, oldest first. Every write creates a revision.
+func (idb *ImmuDbClient) ContentRevisions(ctx context.Context, inumber int64) ([]int64, error) {
+	ctx, cancel := withTimeout(ctx, idb.readTimeout.Load())
+	defer cancel()
+
+	res, err := idb.content.QueryContext(ctx, "SELECT _rev FROM (HISTORY OF content) WHERE inumber=?", inumber)
+	if err != nil {
+		idb.log.Errorf("could not get file %d revisions: %s", inumber, err)
+
+		return nil, wrapErr(err)
+	}
+	defer res.Close()
+
+	var revs []int64
+	for res.Next() {
+		var rev int64
+		if err := res.Scan(&rev); err != nil {
+			return nil, wrapErr(err)
+		}
+		revs = append(revs, rev)
+	}
+
+	return revs, wrapErr(res.Err())
+}
+
+// ReadContentRevision reads a file content as of the given revision.
+func (idb *ImmuDbClient) ReadContentRevision(ctx context.Context, inumber int64, rev int64) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx, idb.readTimeout.Load())
+	defer cancel()
+
+	var content []byte
+	var checksum, tier sql.NullString
+	err := idb.content.QueryRowContext(ctx, "SELECT content, checksum, tier FROM (HISTORY OF content) WHERE inumber=? AND _rev=?", inumber, rev).
+		Scan(&content, &checksum, &tier)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		idb.log.Errorf("could not read file %d content at revision %d: %s", inumber, rev, err)
+
+		return nil, wrapErr(err)
+	}
+	if tier.Valid {
+		return idb.readTier(ctx, inumber, tier.String, checksum)
+	}
+
+	return content, nil
+}
+
+// Past versions of a file are looked up as "<name>@v<revision>", and served read-only. They are given
+// inode IDs from versionInodeBase on, which the inumber allocator can't reach in practice.
+const versionInodeBase fuseops.InodeID = 1 << 62
+
+// Virtual extended attribute reporting the last revision of a file. It is not listed.
+const versionsXattr = "user.immufs.versions"
+
+var versionName = regexp.MustCompile(`^(.+)@v([0-9]+)$`)
+
+// versionInode is a past version of a file, as known to the kernel.
+type versionInode struct {
+	inumber int64
+	rev     int64
+	size    int64
+}
+
+func isVersionInode(id fuseops.InodeID) bool {
+	return id >= versionInodeBase && id < controlDirInode
+}
+
+// revisionRetained tells whether a revision can be accessed, according to the retention policy.
+func (fs *Immufs) revisionRetained(rev int64, revs []int64) bool {
+	if len(revs) == 0 || rev < revs[0] || rev > revs[len(revs)-1] {
+		return false
+	}
+
+	return fs.versionRetention == 0 || rev > revs[len(revs)-1]-int64(fs.versionRetention)
+}
+
+// lookUpVersion resolves names such as "file.txt@v3" within parent. handled is false if name
+// does not refer to a version.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) lookUpVersion(parent *Inode, name string) (entry fuseops.ChildInodeEntry, handled bool, err error) {
+	m := versionName.FindStringSubmatch(name)
+	if m == nil {
+		return entry, false, nil
+	}
+	rev, err := strconv.ParseInt(m[2], 10, 64)
+	if err != nil {
+		return entry, false, nil
+	}
+
+	childID, _, ok, err := parent.LookUpChild(m[1])
+	if err != nil || !ok {
+		return entry, ok, err
+	}
+	ctx := parent.opContext()
+	child, err := fs.getInode(ctx, childID)
+	if err != nil {
+		return entry, true, err
+	}
+	if !child.isFile() || fs.idb == nil {
+		return entry, false, nil
+	}
+
+	revs, err := fs.idb.ContentRevisions(ctx, child.Inumber)
+	if err != nil {
+		return entry, true, err
+	}
+	if !fs.revisionRetained(rev, revs) {
+		fs.log.WithField("API", "LookupInode").Warningf("Revision %d of inode %d not available", rev, child.Inumber)
+
+		return entry, true, fuse.ENOENT
+	}
+	content, err := fs.idb.ReadContentRevision(ctx, child.Inumber, rev)
+	if err != nil {
+		return entry, true, err
+	}
+
+	v := versionInode{inumber: child.Inumber, rev: rev, size: int64(len(content))}
+	id, ok := fs.versionIDs[v]
+	if !ok {
+		fs.nextVersionInode++
+		id = versionInodeBase + fs.nextVersionInode
+		fs.versionIDs[v] = id
+		fs.versions[id] = v
+	}
+
+	entry.Child = id
+	entry.Attributes = versionAttributes(child, v)
+	entry.AttributesExpiration = fs.attrExpiration()
+	entry.EntryExpiration = fs.entryExpiration()
+
+	return entry, true, nil
+}
+
+// versionAttributes returns the attributes of a version: those of the file, but read-only.
+func versionAttributes(file *Inode, v versionInode) fuseops.InodeAttributes {
+	attrs := file.Attributes()
+	attrs.Size = uint64(v.size)
+	attrs.Mode &^= 0222
+	attrs.Nlink = 1
+
+	return attrs
+}
+
+// getVersion returns a version known to the kernel, together with its file.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) getVersion(id fuseops.InodeID) (*Inode, versionInode, error) {
+	v, ok := fs.versions[id]
+	if !ok {
+		return nil, v, fuse.ENOENT
+	}
+	file, err := fs.backend.GetInode(context.TODO(), v.inumber)
+
+	return file, v, err
+}
+
+// forgetVersion releases the ID of a version forgotten by the kernel.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) forgetVersion(id fuseops.InodeID) {
+	if v, ok := fs.versions[id]; ok {
+		delete(fs.versions, id)
+		delete(fs.versionIDs, v)
+	}
+}
+
+// readVersion returns the content of a version.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) readVersion(id fuseops.InodeID) ([]byte, error) {
+	v, ok := fs.versions[id]
+	if !ok {
+		return nil, fuse.ENOENT
+	}
+
+	return fs.idb.ReadContentRevision(context.TODO(), v.inumber, v.rev)
+}
+
+// versionsXattrValue returns the last revision of a file.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) versionsXattrValue(file *Inode) ([]byte, error) {
+	if !file.isFile() || fs.idb == nil {
+		return nil, fuse.ENOATTR
+	}
+	revs, err := fs.idb.ContentRevisions(file.opContext(), file.Inumber)
+	if err != nil {
+		return nil, err
+	}
+
+	var last int64
+	if len(revs) > 0 {
+		last = revs[len(revs)-1]
+	}
+
+	return []byte(strconv.FormatInt(last, 10)), nil
+}
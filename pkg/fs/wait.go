@@ -0,0 +1,81 @@
+package fs
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"immufs/pkg/config"
+
+	"github.com/codenotary/immudb/pkg/client"
+	"github.com/sirupsen/logrus"
+)
+
+// Backoff between the attempts of WaitForBackend, doubled after each of them up to waitMaxBackoff.
+const (
+	waitMinBackoff = time.Second
+	waitMaxBackoff = 30 * time.Second
+)
+
+// WaitForBackend waits until the immudb servers of the configuration accept a session, for at most
+// cfg.WaitForBackend, e.g. when a mount starts at boot before immudb: the attempts are retried with an exponential
+// backoff, whatever the error. It returns right away when WaitForBackend is zero, or for the memory backend.
+func WaitForBackend(ctx context.Context, cfg *config.Config, logger *logrus.Logger) error {
+	if cfg.WaitForBackend <= 0 || cfg.Backend == BackendMemory {
+		return nil
+	}
+	log := logger.WithFields(logrus.Fields{"component": "immudb client"})
+	deadline := time.Now().Add(cfg.WaitForBackend)
+
+	opts := clientOptions(cfg)
+	servers := []*client.Options{opts}
+	if cfg.ContentImmudb != "" && cfg.ContentImmudb != cfg.Immudb {
+		contentOpts := *opts
+		contentOpts.Address = cfg.ContentImmudb
+		if cfg.ContentDatabase != "" {
+			contentOpts.Database = cfg.ContentDatabase
+		}
+		servers = append(servers, &contentOpts)
+	}
+
+	for _, opts := range servers {
+		// The database may not exist yet if it is to be created.
+		if cfg.CreateDatabase {
+			opts.Database = defaultDatabase
+		}
+		for backoff := waitMinBackoff; ; backoff *= 2 {
+			if backoff > waitMaxBackoff {
+				backoff = waitMaxBackoff
+			}
+			err := pingImmudb(ctx, opts, cfg.MetadataTimeout)
+			if err == nil {
+				break
+			}
+			if time.Now().Add(backoff).After(deadline) {
+				return fmt.Errorf("immudb %s not available after %s: %w", opts.Address, cfg.WaitForBackend, err)
+			}
+			log.Warnf("immudb %s not available, retrying in %s: %s", opts.Address, backoff, err)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+	}
+
+	return nil
+}
+
+// pingImmudb opens a session to immudb, and closes it.
+func pingImmudb(ctx context.Context, opts *client.Options, timeout time.Duration) error {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	cl := client.NewClient().WithOptions(opts)
+	if err := cl.OpenSession(ctx, []byte(opts.Username), []byte(opts.Password), opts.Database); err != nil {
+		return wrapErr(err)
+	}
+
+	return cl.CloseSession(ctx)
+}
@@ -0,0 +1,99 @@
+package fs
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// txWatcher polls immudb for new transactions, which may have been committed by other mounts
+// of the same database, and reports the inodes they touched.
+type txWatcher struct {
+	idb      *ImmuDbClient
+	log      *logrus.Entry
+	interval time.Duration
+
+	// Called with the inumbers changed since the previous poll, and the last transaction seen by it.
+	onChange func(inumbers []int64, sinceTx uint64)
+
+	lastTx uint64
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func newTxWatcher(idb *ImmuDbClient, log *logrus.Entry, interval time.Duration, onChange func([]int64, uint64)) *txWatcher {
+	return &txWatcher{
+		idb:      idb,
+		log:      log.WithField("component", "tx watcher"),
+		interval: interval,
+		onChange: onChange,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start records the current transaction and starts polling in background.
+func (w *txWatcher) Start(ctx context.Context) error {
+	tx, err := w.idb.CurrentTx(ctx)
+	if err != nil {
+		return err
+	}
+	w.lastTx = tx
+
+	go w.run()
+
+	return nil
+}
+
+// Stop terminates the polling and waits for it to return.
+func (w *txWatcher) Stop() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *txWatcher) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.poll()
+		}
+	}
+}
+
+// poll looks for transactions committed after the last seen one. Errors are only logged:
+// the same transactions will be looked at again during the next poll.
+func (w *txWatcher) poll() {
+	ctx := context.Background()
+
+	tx, err := w.idb.CurrentTx(ctx)
+	if err != nil {
+		w.log.Warnf("could not get current transaction: %s", err)
+
+		return
+	}
+	if tx <= w.lastTx {
+		return
+	}
+
+	inumbers, err := w.idb.ChangedInodes(ctx, w.lastTx+1)
+	if err != nil {
+		w.log.Warnf("could not get changes since tx %d: %s", w.lastTx+1, err)
+
+		return
+	}
+
+	w.log.Debugf("transactions %d-%d touched %d inodes", w.lastTx+1, tx, len(inumbers))
+	sinceTx := w.lastTx
+	w.lastTx = tx
+	if len(inumbers) > 0 {
+		w.onChange(inumbers, sinceTx)
+	}
+}
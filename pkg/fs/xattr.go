@@ -0,0 +1,77 @@
+package fs
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// Extended attributes reserved to the processes with CAP_SYS_ADMIN, e.g. trusted.overlay.* (see xattr(7)).
+const trustedXattrPrefix = "trusted."
+
+// Capabilities of an executable, cleared when it is written or chowned (see capabilities(7)).
+const capabilityXattr = "security.capability"
+
+// CAP_SYS_ADMIN (see capabilities(7)).
+const capSysAdmin = 21
+
+// processCaps returns the effective capabilities of a process, as found in /proc.
+func processCaps(pid uint32) (uint64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if value, ok := strings.CutPrefix(sc.Text(), "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, fmt.Errorf("no effective capabilities for PID %d", pid)
+}
+
+// capable tells whether the process of an operation has a capability. Processes whose capabilities can't be read
+// have none.
+func (fs *Immufs) capable(api string, opCtx fuseops.OpContext, capability uint) bool {
+	caps, err := processCaps(opCtx.Pid)
+	if err != nil {
+		fs.log.WithField("API", api).Warningf("could not read the capabilities of PID %d: %s", opCtx.Pid, err)
+
+		return false
+	}
+
+	return caps&(1<<capability) != 0
+}
+
+// clearCapabilities removes the capabilities of a file written or chowned, as the kernel does for the filesystems
+// it manages. The files found without capabilities are remembered, so that they are looked for once.
+//
+// LOCKS_REQUIRED(fs.mu)
+func (fs *Immufs) clearCapabilities(inode *Inode) error {
+	id := fuseops.InodeID(inode.Inumber)
+	if fs.noCapabilities[id] {
+		return nil
+	}
+
+	_, err := inode.GetXattr(capabilityXattr)
+	if err == nil {
+		err = inode.RemoveXattr(capabilityXattr)
+	}
+	if err != nil && !errors.Is(err, ErrXattrNotFound) {
+		return err
+	}
+	fs.noCapabilities[id] = true
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package historyapi
+
+// Annotate attributes each line of the last of the revisions of a text file, oldest first, to the revision which
+// last wrote it: origins[i] is the index in revs of the revision lines[i] comes from. The lines of the first revision
+// are all attributed to it. Binary contents are not annotated: binary is true instead.
+func Annotate(revs [][]byte) (lines []string, origins []int, binary bool) {
+	for i, content := range revs {
+		if isBinary(content) {
+			return nil, nil, true
+		}
+		cur := splitLines(content)
+		if i == 0 {
+			lines, origins = cur, make([]int, len(cur))
+
+			continue
+		}
+
+		next := make([]int, 0, len(cur))
+		old := 0
+		for _, e := range diffLines(lines, cur) {
+			switch e.kind {
+			case ' ':
+				next = append(next, origins[old])
+				old++
+			case '-':
+				old++
+			case '+':
+				next = append(next, i)
+			}
+		}
+		lines, origins = cur, next
+	}
+
+	return lines, origins, false
+}
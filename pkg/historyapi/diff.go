@@ -0,0 +1,201 @@
+package historyapi
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Lines of context around the changes of a diff.
+	diffContext = 3
+
+	// Largest number of cells of the table computing the longest common subsequence of two revisions,
+	// past their common prefix and suffix. Beyond it, the lines in between are reported as replaced.
+	maxDiffCells = 1 << 22
+
+	// Number of bytes looked at to tell binary content apart.
+	sniffLen = 8000
+)
+
+// An edit of the line diff: kept (' '), removed ('-') or added ('+').
+type edit struct {
+	kind byte
+	line string
+}
+
+// isBinary tells whether content can't be diffed line by line.
+func isBinary(content []byte) bool {
+	head := content
+	if len(head) > sniffLen {
+		head = head[:sniffLen]
+	}
+
+	return bytes.IndexByte(head, 0) >= 0 || !utf8.Valid(content)
+}
+
+// splitLines splits text into lines, each keeping its newline but the last one if missing.
+func splitLines(text []byte) []string {
+	lines := strings.SplitAfter(string(text), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// diffLines returns the edits turning a into b, from a longest common subsequence of their lines.
+func diffLines(a, b []string) []edit {
+	var prefix, suffix []edit
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, edit{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append(suffix, edit{' ', a[len(a)-1]})
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	edits := prefix
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			edits = append(edits, edit{'-', line})
+		}
+		for _, line := range b {
+			edits = append(edits, edit{'+', line})
+		}
+	} else {
+		edits = append(edits, lcsEdits(a, b)...)
+	}
+	for i := len(suffix) - 1; i >= 0; i-- {
+		edits = append(edits, suffix[i])
+	}
+
+	return edits
+}
+
+func lcsEdits(a, b []string) []edit {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	width := len(b) + 1
+	lcs := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+			case lcs[(i+1)*width+j] >= lcs[i*width+j+1]:
+				lcs[i*width+j] = lcs[(i+1)*width+j]
+			default:
+				lcs[i*width+j] = lcs[i*width+j+1]
+			}
+		}
+	}
+
+	var edits []edit
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case lcs[(i+1)*width+j] >= lcs[i*width+j+1]:
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, edit{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, edit{'+', b[j]})
+	}
+
+	return edits
+}
+
+// UnifiedDiff formats the changes turning the content a into b as a unified diff, empty if they are equal.
+// Binary contents are not diffed: binary is true instead.
+func UnifiedDiff(fromName, toName string, a, b []byte) (diff string, binary bool) {
+	if isBinary(a) || isBinary(b) {
+		return "", true
+	}
+
+	return unifiedDiff(fromName, toName, splitLines(a), splitLines(b)), false
+}
+
+// unifiedDiff formats the edits turning a into b as a unified diff, empty if they are equal.
+func unifiedDiff(fromName, toName string, a, b []string) string {
+	edits := diffLines(a, b)
+
+	var out strings.Builder
+	// Line numbers of a and b before each edit.
+	aLine, bLine := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	for i, e := range edits {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if e.kind != '+' {
+			aLine[i+1]++
+		}
+		if e.kind != '-' {
+			bLine[i+1]++
+		}
+	}
+
+	for start := 0; start < len(edits); {
+		// Look for the next change, then extend the hunk while changes are close enough.
+		first := start
+		for first < len(edits) && edits[first].kind == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		last := first
+		for i := first + 1; i < len(edits) && i <= last+2*diffContext+1; i++ {
+			if edits[i].kind != ' ' {
+				last = i
+			}
+		}
+
+		from := first - diffContext
+		if from < start {
+			from = start
+		}
+		to := last + diffContext + 1
+		if to > len(edits) {
+			to = len(edits)
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aLine[from], aLine[to]), hunkRange(bLine[from], bLine[to]))
+		for _, e := range edits[from:to] {
+			out.WriteByte(e.kind)
+			out.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = to
+	}
+
+	return out.String()
+}
+
+// hunkRange formats the lines [from, to) of a hunk header, numbered from 1. An empty range is
+// numbered after the line it follows.
+func hunkRange(from, to int) string {
+	switch to - from {
+	case 0:
+		return fmt.Sprintf("%d,0", from)
+	case 1:
+		return fmt.Sprintf("%d", from+1)
+	}
+
+	return fmt.Sprintf("%d,%d", from+1, to-from)
+}
@@ -0,0 +1,393 @@
+// Package historyapi serves the namespace of an immufs filesystem and its revision history as JSON over HTTP.
+// It is read-only, and works on immudb directly: the filesystem needs not be mounted.
+package historyapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"immufs/pkg/fs"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Prefix of the paths of the API.
+const apiPrefix = "/api/v1/"
+
+var (
+	errBadRequest = errors.New("bad request")
+	errIsDir      = errors.New("is a directory")
+	errNotFile    = errors.New("not a regular file")
+)
+
+// Server serves the history API:
+//
+//	GET /api/v1/tx                          last committed transaction
+//	GET /api/v1/tree/<path>?tx=N            attributes of a file, and the entries of a directory
+//	GET /api/v1/content/<path>?tx=N|rev=R   content of a file
+//	GET /api/v1/revisions/<path>?tx=N       revisions of a file content
+//	GET /api/v1/diff/<path>?from=R&to=R     unified diff between two revisions of a file
+//
+// Paths are resolved as of transaction tx, the last committed one if not given.
+type Server struct {
+	idb *fs.ImmuDbClient
+	log *logrus.Entry
+}
+
+// NewServer returns the history server of the filesystem stored by idb.
+func NewServer(idb *fs.ImmuDbClient, logger *logrus.Logger) *Server {
+	return &Server{
+		idb: idb,
+		log: logger.WithField("component", "historyapi"),
+	}
+}
+
+// inode is the JSON representation of an inode.
+type inode struct {
+	Inumber int64     `json:"inumber"`
+	Type    string    `json:"type"`
+	Mode    string    `json:"mode"`
+	Size    int64     `json:"size"`
+	Uid     int64     `json:"uid"`
+	Gid     int64     `json:"gid"`
+	Mtime   time.Time `json:"mtime"`
+	Ctime   time.Time `json:"ctime"`
+	Crtime  time.Time `json:"crtime"`
+}
+
+type entry struct {
+	Name string `json:"name"`
+	inode
+}
+
+type txResponse struct {
+	Tx uint64 `json:"tx"`
+}
+
+type treeResponse struct {
+	Path    string  `json:"path"`
+	Tx      uint64  `json:"tx"`
+	Inode   inode   `json:"inode"`
+	Entries []entry `json:"entries,omitempty"`
+}
+
+type revisionsResponse struct {
+	Path      string  `json:"path"`
+	Tx        uint64  `json:"tx"`
+	Inumber   int64   `json:"inumber"`
+	Revisions []int64 `json:"revisions"`
+}
+
+type diffResponse struct {
+	Path    string `json:"path"`
+	Inumber int64  `json:"inumber"`
+	From    int64  `json:"from"`
+	To      int64  `json:"to"`
+	// Binary contents are not diffed: only their sizes are reported.
+	Binary   bool   `json:"binary"`
+	FromSize int    `json:"fromSize"`
+	ToSize   int    `json:"toSize"`
+	Diff     string `json:"diff,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func newInode(in *fs.Inode) inode {
+	mode := os.FileMode(in.Mode)
+	typ := "other"
+	switch {
+	case mode.IsDir():
+		typ = "dir"
+	case mode.IsRegular():
+		typ = "file"
+	case mode&os.ModeSymlink != 0:
+		typ = "symlink"
+	}
+
+	return inode{
+		Inumber: in.Inumber,
+		Type:    typ,
+		Mode:    mode.String(),
+		Size:    in.Size,
+		Uid:     in.Uid,
+		Gid:     in.Gid,
+		Mtime:   in.Mtime,
+		Ctime:   in.Ctime,
+		Crtime:  in.Attributes().Crtime,
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.log.Infof("--> %s %s", r.Method, r.URL.Path)
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, apiPrefix) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "not found"})
+
+		return
+	}
+
+	endpoint, p, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+	p = path.Clean("/" + p)
+	var err error
+	switch endpoint {
+	case "tx":
+		err = s.serveTx(w, r)
+	case "tree":
+		err = s.serveTree(w, r, p)
+	case "content":
+		err = s.serveContent(w, r, p)
+	case "revisions":
+		err = s.serveRevisions(w, r, p)
+	case "diff":
+		err = s.serveDiff(w, r, p)
+	default:
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "not found"})
+
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, err)
+	}
+}
+
+func (s *Server) serveTx(w http.ResponseWriter, r *http.Request) error {
+	tx, err := s.idb.CurrentTx(r.Context())
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, txResponse{Tx: tx})
+}
+
+func (s *Server) serveTree(w http.ResponseWriter, r *http.Request, p string) error {
+	tx, in, err := s.lookUp(r, p)
+	if err != nil {
+		return err
+	}
+
+	resp := treeResponse{Path: p, Tx: tx, Inode: newInode(in)}
+	if os.FileMode(in.Mode).IsDir() {
+		children, err := s.idb.ReadDirAsOf(r.Context(), in, tx)
+		if err != nil {
+			return err
+		}
+		resp.Entries = make([]entry, 0, len(children))
+		for _, child := range children {
+			resp.Entries = append(resp.Entries, entry{Name: child.Name, inode: newInode(child.Inode)})
+		}
+	}
+
+	return writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) serveContent(w http.ResponseWriter, r *http.Request, p string) error {
+	query := r.URL.Query()
+	tx, in, err := s.lookUp(r, p)
+	if err != nil {
+		return err
+	}
+	if os.FileMode(in.Mode).IsDir() {
+		return errIsDir
+	}
+
+	var content []byte
+	if query.Has("rev") {
+		rev, err := strconv.ParseInt(query.Get("rev"), 10, 64)
+		if err != nil || rev < 1 {
+			return fmt.Errorf("%w: invalid revision %q", errBadRequest, query.Get("rev"))
+		}
+		content, err = s.idb.ReadContentRevision(r.Context(), in.Inumber, rev)
+		if err != nil {
+			return err
+		}
+	} else {
+		content, err = s.idb.ReadContentAt(r.Context(), in.Inumber, tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(content))
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Header().Set("X-Immufs-Tx", strconv.FormatUint(tx, 10))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, err = w.Write(content)
+	}
+
+	return err
+}
+
+func (s *Server) serveRevisions(w http.ResponseWriter, r *http.Request, p string) error {
+	tx, in, err := s.lookUp(r, p)
+	if err != nil {
+		return err
+	}
+	if !os.FileMode(in.Mode).IsRegular() {
+		return errNotFile
+	}
+
+	revs, err := s.idb.ContentRevisions(r.Context(), in.Inumber)
+	if err != nil {
+		return err
+	}
+	if revs == nil {
+		revs = []int64{}
+	}
+
+	return writeJSON(w, http.StatusOK, revisionsResponse{Path: p, Tx: tx, Inumber: in.Inumber, Revisions: revs})
+}
+
+// serveDiff compares two revisions of a file, the last one and the one before by default. Revision 0 is
+// the empty file, before the first write.
+func (s *Server) serveDiff(w http.ResponseWriter, r *http.Request, p string) error {
+	query := r.URL.Query()
+	_, in, err := s.lookUp(r, p)
+	if err != nil {
+		return err
+	}
+	if !os.FileMode(in.Mode).IsRegular() {
+		return errNotFile
+	}
+
+	revs, err := s.idb.ContentRevisions(r.Context(), in.Inumber)
+	if err != nil {
+		return err
+	}
+	var last int64
+	if len(revs) > 0 {
+		last = revs[len(revs)-1]
+	}
+	to, err := revisionParam(query.Get("to"), last)
+	if err != nil {
+		return err
+	}
+	from, err := revisionParam(query.Get("from"), to-1)
+	if err != nil {
+		return err
+	}
+	if from < 0 {
+		from = 0
+	}
+
+	a, err := s.readRevision(r.Context(), in.Inumber, from)
+	if err != nil {
+		return err
+	}
+	b, err := s.readRevision(r.Context(), in.Inumber, to)
+	if err != nil {
+		return err
+	}
+
+	resp := diffResponse{Path: p, Inumber: in.Inumber, From: from, To: to, FromSize: len(a), ToSize: len(b)}
+	resp.Diff, resp.Binary = UnifiedDiff(fmt.Sprintf("%s@v%d", p, from), fmt.Sprintf("%s@v%d", p, to), a, b)
+
+	return writeJSON(w, http.StatusOK, resp)
+}
+
+func revisionParam(value string, def int64) (int64, error) {
+	if value == "" {
+		return def, nil
+	}
+	rev, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || rev < 0 {
+		return 0, fmt.Errorf("%w: invalid revision %q", errBadRequest, value)
+	}
+
+	return rev, nil
+}
+
+func (s *Server) readRevision(ctx context.Context, inumber int64, rev int64) ([]byte, error) {
+	if rev == 0 {
+		return nil, nil
+	}
+
+	return s.idb.ReadContentRevision(ctx, inumber, rev)
+}
+
+// lookUp resolves a path as of the transaction of the request, which is returned.
+func (s *Server) lookUp(r *http.Request, p string) (uint64, *fs.Inode, error) {
+	var tx uint64
+	if value := r.URL.Query().Get("tx"); value != "" {
+		var err error
+		if tx, err = strconv.ParseUint(value, 10, 64); err != nil || tx == 0 {
+			return 0, nil, fmt.Errorf("%w: invalid transaction %q", errBadRequest, value)
+		}
+	} else {
+		// Pin the last transaction, so that all the queries of the request see the same state.
+		var err error
+		if tx, err = s.idb.CurrentTx(r.Context()); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	in, err := s.idb.LookUpPathAsOf(r.Context(), p, tx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return tx, in, nil
+}
+
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var errno syscall.Errno
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, fs.ErrInodeNotFound), errors.Is(err, fs.ErrRevisionNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errBadRequest), errors.Is(err, errIsDir), errors.Is(err, errNotFile),
+		errors.Is(err, fs.ErrTxNotCommitted), errors.As(err, &errno) && errno == syscall.ENOTDIR:
+		status = http.StatusBadRequest
+	}
+
+	if status == http.StatusInternalServerError {
+		s.log.Errorf("%s %s failed: %s", r.Method, r.URL.Path, err)
+	} else {
+		s.log.Warnf("%s %s: %s", r.Method, r.URL.Path, err)
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	return json.NewEncoder(w).Encode(v)
+}
+
+// Serve runs the server on addr until ctx is done.
+func (s *Server) Serve(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           s,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	s.log.Infof("serving the history API on %s", addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
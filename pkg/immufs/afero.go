@@ -0,0 +1,131 @@
+package immufs
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"immufs/pkg/fs"
+
+	"github.com/spf13/afero"
+)
+
+// Afero returns the filesystem as an afero.Fs, for the applications and test suites which abstract their
+// filesystem with it. Operations are not bound to a context, and symbolic links are not followed.
+func (f *FS) Afero() afero.Fs {
+	return &aferoFs{session: f.session}
+}
+
+type aferoFs struct {
+	session *fs.Session
+}
+
+func (a *aferoFs) Name() string {
+	return "immufs"
+}
+
+func (a *aferoFs) Create(name string) (afero.File, error) {
+	return a.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, createMode)
+}
+
+func (a *aferoFs) Open(name string) (afero.File, error) {
+	return a.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (a *aferoFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := a.session.OpenFile(context.Background(), name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &aferoFile{File: f}, nil
+}
+
+func (a *aferoFs) Mkdir(name string, perm os.FileMode) error {
+	return a.session.Mkdir(context.Background(), name, perm)
+}
+
+func (a *aferoFs) MkdirAll(path string, perm os.FileMode) error {
+	return a.session.MkdirAll(context.Background(), path, perm)
+}
+
+func (a *aferoFs) Remove(name string) error {
+	return a.session.Remove(context.Background(), name)
+}
+
+func (a *aferoFs) RemoveAll(path string) error {
+	return a.session.RemoveAll(context.Background(), path)
+}
+
+func (a *aferoFs) Rename(oldname, newname string) error {
+	return a.session.Rename(context.Background(), oldname, newname)
+}
+
+func (a *aferoFs) Stat(name string) (os.FileInfo, error) {
+	fi, err := a.session.Stat(context.Background(), name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fi, nil
+}
+
+func (a *aferoFs) Chmod(name string, mode os.FileMode) error {
+	return a.session.Chmod(context.Background(), name, mode)
+}
+
+func (a *aferoFs) Chown(name string, uid, gid int) error {
+	return a.session.Chown(context.Background(), name, uint32(uid), uint32(gid))
+}
+
+func (a *aferoFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return a.session.Chtimes(context.Background(), name, atime, mtime)
+}
+
+// aferoFile adapts a File to afero.File.
+type aferoFile struct {
+	*fs.File
+}
+
+func (f *aferoFile) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return fi, nil
+}
+
+// Readdir returns the next count entries of a directory as os.File.Readdir does: all the remaining
+// ones if count <= 0.
+func (f *aferoFile) Readdir(count int) ([]os.FileInfo, error) {
+	entries, err := f.File.ReadDir(count)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, len(entries))
+	for i, fi := range entries {
+		infos[i] = fi
+	}
+
+	return infos, nil
+}
+
+func (f *aferoFile) Readdirnames(n int) ([]string, error) {
+	entries, err := f.File.ReadDir(n)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, len(entries))
+	for i, fi := range entries {
+		names[i] = fi.Name()
+	}
+
+	return names, nil
+}
+
+func (f *aferoFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
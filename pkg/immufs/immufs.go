@@ -0,0 +1,194 @@
+// Package immufs embeds an immufs filesystem in a Go program, without mounting it: files are created,
+// read and written by path, through the same code as a mount, hence with the same semantics.
+//
+//	fsys, err := immufs.Open(ctx, &cfg, logger)
+//	if err != nil {
+//		return err
+//	}
+//	defer fsys.Close()
+//
+//	f, err := fsys.Create(ctx, "/reports/q3.txt")
+//	...
+package immufs
+
+import (
+	"context"
+	"os"
+
+	"immufs/pkg/config"
+	"immufs/pkg/fs"
+	"immufs/pkg/tracing"
+
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/sirupsen/logrus"
+)
+
+// Mode of the files created by Create.
+const createMode = 0644
+
+type (
+	// File is an open file or directory. It implements io.Reader, io.ReaderAt, io.Writer, io.WriterAt and io.Seeker.
+	File = fs.File
+	// FileInfo describes a file. It implements io/fs.FileInfo.
+	FileInfo = fs.FileInfo
+)
+
+// FS is an immufs filesystem opened by a Go program. Operations are served as if requested by the
+// current process: quotas, WORM, trash and auditing apply as configured.
+type FS struct {
+	immufs     *fs.Immufs
+	filesystem fuseutil.FileSystem
+	session    *fs.Session
+}
+
+// Open connects to the immudb database of the configuration, creating the filesystem if needed.
+// The Mountpoint of the configuration is ignored.
+func Open(ctx context.Context, cfg *config.Config, logger *logrus.Logger) (*FS, error) {
+	traced, err := tracing.Setup(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	immufs, err := fs.NewImmufs(ctx, cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	var filesystem fuseutil.FileSystem = immufs
+	if cfg.AuditLog {
+		filesystem = fs.NewAuditedFileSystem(immufs)
+	}
+	if traced {
+		filesystem = fs.NewTracedFileSystem(filesystem)
+	}
+
+	return &FS{
+		immufs:     immufs,
+		filesystem: filesystem,
+		session:    fs.NewSession(filesystem),
+	}, nil
+}
+
+// Close disconnects from immudb. Files still open can't be used anymore.
+func (f *FS) Close() error {
+	f.filesystem.Destroy()
+
+	return nil
+}
+
+// Immufs returns the underlying filesystem, e.g. to serve the admin API.
+func (f *FS) Immufs() *fs.Immufs {
+	return f.immufs
+}
+
+// Create creates a file, or truncates it if it exists, and opens it for reading and writing.
+func (f *FS) Create(ctx context.Context, name string) (*File, error) {
+	return f.session.OpenFile(ctx, name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, createMode)
+}
+
+// Open opens a file or a directory for reading.
+func (f *FS) Open(ctx context.Context, name string) (*File, error) {
+	return f.session.OpenFile(ctx, name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens a file with the flags of os.OpenFile, creating it with the given mode if needed.
+func (f *FS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (*File, error) {
+	return f.session.OpenFile(ctx, name, flag, perm)
+}
+
+// Stat returns the description of a file. Symbolic links are not followed.
+func (f *FS) Stat(ctx context.Context, name string) (*FileInfo, error) {
+	return f.session.Stat(ctx, name)
+}
+
+// ReadDir returns the entries of a directory, in the order they are stored.
+func (f *FS) ReadDir(ctx context.Context, name string) ([]*FileInfo, error) {
+	return f.session.ReadDir(ctx, name)
+}
+
+// ReadFile returns the content of a file.
+func (f *FS) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	return f.session.ReadFile(ctx, name)
+}
+
+// WriteFile replaces the content of a file, creating it with the given mode if needed.
+func (f *FS) WriteFile(ctx context.Context, name string, data []byte, perm os.FileMode) error {
+	return f.session.WriteFile(ctx, name, data, perm)
+}
+
+// Mkdir creates a directory.
+func (f *FS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return f.session.Mkdir(ctx, name, perm)
+}
+
+// MkdirAll creates a directory together with the missing directories containing it.
+func (f *FS) MkdirAll(ctx context.Context, name string, perm os.FileMode) error {
+	return f.session.MkdirAll(ctx, name, perm)
+}
+
+// Remove removes a file or an empty directory.
+func (f *FS) Remove(ctx context.Context, name string) error {
+	return f.session.Remove(ctx, name)
+}
+
+// Rename moves a file or a directory, replacing the target if any.
+func (f *FS) Rename(ctx context.Context, oldname, newname string) error {
+	return f.session.Rename(ctx, oldname, newname)
+}
+
+// History returns the revisions of a file content, oldest first. Every write creates a revision.
+func (f *FS) History(ctx context.Context, name string) ([]int64, error) {
+	fi, err := f.regularFile(ctx, "history", name)
+	if err != nil {
+		return nil, err
+	}
+	if f.immufs.Client() == nil {
+		return nil, &os.PathError{Op: "history", Path: name, Err: fs.ErrNotSupported}
+	}
+
+	return f.immufs.Client().ContentRevisions(ctx, int64(fi.Inode))
+}
+
+// ReadRevision returns the content of a file as of one of its revisions.
+func (f *FS) ReadRevision(ctx context.Context, name string, rev int64) ([]byte, error) {
+	fi, err := f.regularFile(ctx, "read", name)
+	if err != nil {
+		return nil, err
+	}
+	if f.immufs.Client() == nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: fs.ErrNotSupported}
+	}
+	content, err := f.immufs.Client().ReadContentRevision(ctx, int64(fi.Inode), rev)
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: err}
+	}
+
+	return content, nil
+}
+
+// ReadFileAsOf returns the content of the file found at name as of a transaction.
+func (f *FS) ReadFileAsOf(ctx context.Context, name string, tx uint64) ([]byte, error) {
+	idb := f.immufs.Client()
+	if idb == nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: fs.ErrNotSupported}
+	}
+	inode, err := idb.LookUpPathAsOf(ctx, name, tx)
+	if err == nil && !os.FileMode(inode.Mode).IsRegular() {
+		err = os.ErrInvalid
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "read", Path: name, Err: err}
+	}
+
+	return idb.ReadContentAt(ctx, inode.Inumber, tx)
+}
+
+func (f *FS) regularFile(ctx context.Context, op string, name string) (*FileInfo, error) {
+	fi, err := f.session.Stat(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrInvalid}
+	}
+
+	return fi, nil
+}
@@ -0,0 +1,296 @@
+package immufs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"sort"
+	"syscall"
+	"time"
+
+	"immufs/pkg/fs"
+)
+
+// IOFS returns the filesystem as an io/fs.FS, which also implements io/fs.ReadDirFS, io/fs.ReadFileFS and
+// io/fs.StatFS, so that standard code such as http.FileServer or fs.WalkDir works on it. Files are opened
+// read-only, and operations are not bound to a context.
+func (f *FS) IOFS() iofs.FS {
+	return &liveFS{session: f.session}
+}
+
+// IOFSAsOf returns the filesystem as of a transaction as an io/fs.FS, which also implements io/fs.ReadDirFS,
+// io/fs.ReadFileFS and io/fs.StatFS. It reads immudb directly: files are loaded whole when opened.
+func (f *FS) IOFSAsOf(tx uint64) iofs.FS {
+	return &txFS{idb: f.immufs.Client(), tx: tx}
+}
+
+// fsPath converts a name of io/fs into a path of the filesystem.
+func fsPath(op, name string) (string, error) {
+	if !iofs.ValidPath(name) {
+		return "", &iofs.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+	}
+
+	return "/" + name, nil
+}
+
+// pathError returns err as a *iofs.PathError on name, errors of the filesystem converted.
+func pathError(op, name string, err error) error {
+	var pe *os.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	if errors.Is(err, fs.ErrInodeNotFound) {
+		err = iofs.ErrNotExist
+	}
+
+	return &iofs.PathError{Op: op, Path: name, Err: err}
+}
+
+func sortEntries(entries []iofs.DirEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+}
+
+// liveFS is the current state of the filesystem, accessed through a session.
+type liveFS struct {
+	session *fs.Session
+}
+
+func (l *liveFS) Open(name string) (iofs.File, error) {
+	p, err := fsPath("open", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := l.session.OpenFile(context.Background(), p, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+
+	return &liveFile{File: f, name: name}, nil
+}
+
+func (l *liveFS) Stat(name string) (iofs.FileInfo, error) {
+	p, err := fsPath("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := l.session.Stat(context.Background(), p)
+	if err != nil {
+		return nil, pathError("stat", name, err)
+	}
+
+	return fi, nil
+}
+
+func (l *liveFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	p, err := fsPath("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := l.session.ReadDir(context.Background(), p)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+
+	entries := make([]iofs.DirEntry, len(infos))
+	for i, fi := range infos {
+		entries[i] = iofs.FileInfoToDirEntry(fi)
+	}
+	sortEntries(entries)
+
+	return entries, nil
+}
+
+func (l *liveFS) ReadFile(name string) ([]byte, error) {
+	p, err := fsPath("read", name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := l.session.ReadFile(context.Background(), p)
+	if err != nil {
+		return nil, pathError("read", name, err)
+	}
+
+	return content, nil
+}
+
+// liveFile adapts a File to io/fs.File and io/fs.ReadDirFile.
+type liveFile struct {
+	*fs.File
+	name string
+}
+
+func (f *liveFile) Stat() (iofs.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, pathError("stat", f.name, err)
+	}
+
+	return fi, nil
+}
+
+func (f *liveFile) ReadDir(n int) ([]iofs.DirEntry, error) {
+	infos, err := f.File.ReadDir(n)
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+
+		return nil, pathError("readdir", f.name, err)
+	}
+
+	entries := make([]iofs.DirEntry, len(infos))
+	for i, fi := range infos {
+		entries[i] = iofs.FileInfoToDirEntry(fi)
+	}
+
+	return entries, nil
+}
+
+// txFS is the filesystem as of a transaction.
+type txFS struct {
+	idb *fs.ImmuDbClient
+	tx  uint64
+}
+
+// inodeInfo describes an inode as of a transaction. It implements io/fs.FileInfo.
+type inodeInfo struct {
+	name  string
+	inode *fs.Inode
+}
+
+func (fi *inodeInfo) Name() string       { return fi.name }
+func (fi *inodeInfo) Size() int64        { return fi.inode.Size }
+func (fi *inodeInfo) Mode() os.FileMode  { return os.FileMode(fi.inode.Mode) }
+func (fi *inodeInfo) ModTime() time.Time { return fi.inode.Mtime }
+func (fi *inodeInfo) IsDir() bool        { return fi.Mode().IsDir() }
+func (fi *inodeInfo) Sys() any           { return fi.inode }
+
+func (t *txFS) lookUp(op, name string) (*inodeInfo, error) {
+	p, err := fsPath(op, name)
+	if err != nil {
+		return nil, err
+	}
+	// The past of the filesystem is only kept by immudb.
+	if t.idb == nil {
+		return nil, &iofs.PathError{Op: op, Path: name, Err: fs.ErrNotSupported}
+	}
+	inode, err := t.idb.LookUpPathAsOf(context.Background(), p, t.tx)
+	if err != nil {
+		return nil, pathError(op, name, err)
+	}
+
+	return &inodeInfo{name: path.Base(name), inode: inode}, nil
+}
+
+func (t *txFS) Open(name string) (iofs.File, error) {
+	fi, err := t.lookUp("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		entries, err := t.readDir(fi)
+		if err != nil {
+			return nil, pathError("open", name, err)
+		}
+
+		return &txDir{info: fi, entries: entries}, nil
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, &iofs.PathError{Op: "open", Path: name, Err: iofs.ErrInvalid}
+	}
+	content, err := t.idb.ReadContentAt(context.Background(), fi.inode.Inumber, t.tx)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+
+	return &txFile{info: fi, Reader: bytes.NewReader(content)}, nil
+}
+
+func (t *txFS) Stat(name string) (iofs.FileInfo, error) {
+	return t.lookUp("stat", name)
+}
+
+func (t *txFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	fi, err := t.lookUp("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, &iofs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	entries, err := t.readDir(fi)
+	if err != nil {
+		return nil, pathError("readdir", name, err)
+	}
+
+	return entries, nil
+}
+
+func (t *txFS) ReadFile(name string) ([]byte, error) {
+	fi, err := t.lookUp("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, &iofs.PathError{Op: "read", Path: name, Err: iofs.ErrInvalid}
+	}
+	content, err := t.idb.ReadContentAt(context.Background(), fi.inode.Inumber, t.tx)
+	if err != nil {
+		return nil, pathError("read", name, err)
+	}
+
+	return content, nil
+}
+
+// readDir returns the entries of a directory, sorted by name.
+func (t *txFS) readDir(dir *inodeInfo) ([]iofs.DirEntry, error) {
+	children, err := t.idb.ReadDirAsOf(context.Background(), dir.inode, t.tx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]iofs.DirEntry, len(children))
+	for i, child := range children {
+		entries[i] = iofs.FileInfoToDirEntry(&inodeInfo{name: child.Name, inode: child.Inode})
+	}
+
+	return entries, nil
+}
+
+// txFile is a file as of a transaction, its content loaded in memory.
+type txFile struct {
+	*bytes.Reader
+	info *inodeInfo
+}
+
+func (f *txFile) Stat() (iofs.FileInfo, error) { return f.info, nil }
+func (f *txFile) Close() error                 { return nil }
+
+// txDir is a directory as of a transaction, its entries listed when opened.
+type txDir struct {
+	info    *inodeInfo
+	entries []iofs.DirEntry
+}
+
+func (d *txDir) Stat() (iofs.FileInfo, error) { return d.info, nil }
+func (d *txDir) Close() error                 { return nil }
+
+func (d *txDir) Read(p []byte) (int, error) {
+	return 0, &iofs.PathError{Op: "read", Path: d.info.name, Err: syscall.EISDIR}
+}
+
+func (d *txDir) ReadDir(n int) ([]iofs.DirEntry, error) {
+	if n > 0 && len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n:n]
+	d.entries = d.entries[n:]
+
+	return entries, nil
+}
@@ -0,0 +1,61 @@
+// Package metrics exposes the metrics of the filesystem over HTTP, in the Prometheus exposition format.
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sirupsen/logrus"
+)
+
+// Registry holds the metrics served by Serve. Packages register their metrics at init.
+var Registry = prometheus.NewRegistry()
+
+// handler writes the metrics of Registry in the format accepted by the client.
+func handler(w http.ResponseWriter, r *http.Request) {
+	families, err := Registry.Gather()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	format := expfmt.Negotiate(r.Header)
+	w.Header().Set("Content-Type", string(format))
+	enc := expfmt.NewEncoder(w, format)
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			return
+		}
+	}
+}
+
+// Serve serves the metrics on /metrics at addr, e.g. 127.0.0.1:9100, until ctx is done.
+func Serve(ctx context.Context, addr string, logger *logrus.Logger) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handler)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	logger.WithField("component", "metrics").Infof("serving metrics on http://%s/metrics", l.Addr())
+	if err := server.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
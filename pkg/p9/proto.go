@@ -0,0 +1,229 @@
+package p9
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/jacobsa/fuse/fuseops"
+)
+
+// The 9P2000.L dialect, the one of the Linux v9fs client, is the only version supported.
+const protocolVersion = "9P2000.L"
+
+// Types of the messages served. The type of a reply is the type of its request plus one.
+const (
+	tLerror   = 6
+	tStatfs   = 8
+	tLopen    = 12
+	tLcreate  = 14
+	tMknod    = 18
+	tGetattr  = 24
+	tSetattr  = 26
+	tReaddir  = 40
+	tFsync    = 50
+	tMkdir    = 72
+	tRenameat = 74
+	tUnlinkat = 76
+	tVersion  = 100
+	tAttach   = 104
+	tFlush    = 108
+	tWalk     = 110
+	tRead     = 116
+	tWrite    = 118
+	tClunk    = 120
+	tRemove   = 122
+)
+
+const (
+	// Largest number of names walked by a single message.
+	maxWalkNames = 16
+
+	// Size of the header of a message, and of the header of the read replies up to their data.
+	headerSize     = 4 + 1 + 2
+	readHeaderSize = headerSize + 4
+
+	// Types of qid.
+	qtDir     = 0x80
+	qtSymlink = 0x02
+	qtFile    = 0x00
+
+	// Attributes returned by getattr: all the basic ones.
+	getattrBasic = 0x000007ff
+
+	// Attributes changed by setattr.
+	setattrMode     = 0x00000001
+	setattrUID      = 0x00000002
+	setattrGID      = 0x00000004
+	setattrSize     = 0x00000008
+	setattrAtime    = 0x00000010
+	setattrMtime    = 0x00000020
+	setattrAtimeSet = 0x00000080
+	setattrMtimeSet = 0x00000100
+
+	// Flags of lopen, as defined by Linux whatever the platform of the server.
+	dotlWronly  = 00000001
+	dotlRdwr    = 00000002
+	dotlAccmode = 00000003
+	dotlTrunc   = 00001000
+	dotlAppend  = 00002000
+
+	// Flag of unlinkat removing a directory.
+	atRemoveDir = 0x200
+
+	// Magic number of v9fs filesystems, reported by statfs.
+	v9fsMagic = 0x01021997
+)
+
+var errShortMessage = errors.New("short 9P message")
+
+// qid is the identifier of a file as seen by the server: its path is the inode ID.
+type qid struct {
+	typ     uint8
+	version uint32
+	path    uint64
+}
+
+func newQid(inode fuseops.InodeID, mode os.FileMode) qid {
+	q := qid{typ: qtFile, path: uint64(inode)}
+	switch {
+	case mode.IsDir():
+		q.typ = qtDir
+	case mode&os.ModeSymlink != 0:
+		q.typ = qtSymlink
+	}
+
+	return q
+}
+
+// unixMode converts a file mode to the mode of Linux, as expected by the clients.
+func unixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	switch {
+	case mode.IsDir():
+		m |= syscall.S_IFDIR
+	case mode&os.ModeSymlink != 0:
+		m |= syscall.S_IFLNK
+	case mode&os.ModeNamedPipe != 0:
+		m |= syscall.S_IFIFO
+	case mode&os.ModeSocket != 0:
+		m |= syscall.S_IFSOCK
+	case mode&os.ModeCharDevice != 0:
+		m |= syscall.S_IFCHR
+	case mode&os.ModeDevice != 0:
+		m |= syscall.S_IFBLK
+	default:
+		m |= syscall.S_IFREG
+	}
+	if mode&os.ModeSetuid != 0 {
+		m |= syscall.S_ISUID
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= syscall.S_ISGID
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= syscall.S_ISVTX
+	}
+
+	return m
+}
+
+// fileMode converts a mode of Linux to a file mode.
+func fileMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0777)
+	switch m & syscall.S_IFMT {
+	case syscall.S_IFDIR:
+		mode |= os.ModeDir
+	case syscall.S_IFLNK:
+		mode |= os.ModeSymlink
+	case syscall.S_IFIFO:
+		mode |= os.ModeNamedPipe
+	case syscall.S_IFSOCK:
+		mode |= os.ModeSocket
+	case syscall.S_IFCHR:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case syscall.S_IFBLK:
+		mode |= os.ModeDevice
+	}
+	if m&syscall.S_ISUID != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&syscall.S_ISGID != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&syscall.S_ISVTX != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode
+}
+
+// decoder reads the fields of a message, little-endian as all of 9P. The first error is kept,
+// and the following fields read as zero or empty.
+type decoder struct {
+	p   []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil || len(d.p) < n {
+		d.err = errShortMessage
+		if n > 8 {
+			return nil
+		}
+
+		return make([]byte, n)
+	}
+	b := d.p[:n]
+	d.p = d.p[n:]
+
+	return b
+}
+
+func (d *decoder) u8() uint8   { return d.next(1)[0] }
+func (d *decoder) u16() uint16 { return binary.LittleEndian.Uint16(d.next(2)) }
+func (d *decoder) u32() uint32 { return binary.LittleEndian.Uint32(d.next(4)) }
+func (d *decoder) u64() uint64 { return binary.LittleEndian.Uint64(d.next(8)) }
+
+func (d *decoder) str() string {
+	return string(d.next(int(d.u16())))
+}
+
+// data reads a count followed by as many bytes.
+func (d *decoder) data() []byte {
+	return d.next(int(d.u32()))
+}
+
+// encoder appends the fields of a message.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) u8(v uint8)   { e.b = append(e.b, v) }
+func (e *encoder) u16(v uint16) { e.b = binary.LittleEndian.AppendUint16(e.b, v) }
+func (e *encoder) u32(v uint32) { e.b = binary.LittleEndian.AppendUint32(e.b, v) }
+func (e *encoder) u64(v uint64) { e.b = binary.LittleEndian.AppendUint64(e.b, v) }
+
+func (e *encoder) str(s string) {
+	e.u16(uint16(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) qid(q qid) {
+	e.u8(q.typ)
+	e.u32(q.version)
+	e.u64(q.path)
+}
+
+func (e *encoder) time(t time.Time) {
+	e.u64(uint64(t.Unix()))
+	e.u64(uint64(t.Nanosecond()))
+}
+
+// data appends a count followed by as many bytes.
+func (e *encoder) data(p []byte) {
+	e.u32(uint32(len(p)))
+	e.b = append(e.b, p...)
+}
@@ -0,0 +1,926 @@
+// Package p9 exposes an immufs filesystem over the 9P2000.L protocol, which the Linux v9fs client
+// mounts without FUSE, e.g. inside containers and WSL2.
+package p9
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"immufs/pkg/fs"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Largest message exchanged, whatever the client asks for.
+	maxMessageSize = 1 << 20
+
+	// Block size reported by getattr.
+	blockSize = 4096
+)
+
+// Server serves a filesystem over 9P2000.L. Every message is served by the same handlers as through
+// a mount (hence quotas, WORM, trash and auditing apply), as if requested by the process which connected
+// to the server, with its credentials.
+// Symbolic and hard links, extended attributes and locks are not supported.
+type Server struct {
+	fs  fuseutil.FileSystem
+	log *logrus.Entry
+}
+
+// NewServer returns a server of a filesystem, e.g. an Immufs, possibly audited.
+func NewServer(filesystem fuseutil.FileSystem, logger *logrus.Logger) *Server {
+	return &Server{
+		fs:  filesystem,
+		log: logger.WithField("component", "9p"),
+	}
+}
+
+// Serve runs the server on the unix socket at addr, possibly prefixed with "unix:", until ctx is done.
+// There is no authentication: the clients are told apart by the credentials of their socket, hence no
+// TCP address.
+func (s *Server) Serve(ctx context.Context, addr string) error {
+	addr, unixPrefix := strings.CutPrefix(addr, "unix:")
+	if _, _, err := net.SplitHostPort(addr); err == nil && !unixPrefix {
+		return fmt.Errorf("9P is only served on unix sockets, not on %s", addr)
+	}
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	s.log.Infof("serving 9P on %s", addr)
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+		go s.serveConn(ctx, nc)
+	}
+}
+
+// fid is a file of the filesystem, as designated by a client.
+type fid struct {
+	inode fuseops.InodeID
+	mode  os.FileMode
+
+	// Opened by lopen or lcreate. Files are read and written, and directories listed, through their handle.
+	open   bool
+	handle fuseops.HandleID
+}
+
+// conn is the session of a client. Its messages are served in order.
+type conn struct {
+	s     *Server
+	msize uint32
+	fids  map[uint32]*fid
+
+	// Process which connected, whose credentials the messages are served with.
+	opCtx fuseops.OpContext
+
+	// Number of fids designating each inode, and number of lookups to forget once none does,
+	// as the kernel does for the inodes of a mount.
+	refs    map[fuseops.InodeID]int
+	lookups map[fuseops.InodeID]uint64
+}
+
+func (s *Server) serveConn(ctx context.Context, nc net.Conn) {
+	cred, err := peerCred(nc)
+	if err != nil {
+		s.log.Errorf("could not read the credentials of the client: %s", err)
+		nc.Close()
+
+		return
+	}
+	s.log.Infof("client connected: PID %d, uid %d, gid %d", cred.Pid, cred.Uid, cred.Gid)
+	ctx = fs.WithCredentials(ctx, cred.Uid, cred.Gid)
+	c := &conn{
+		s:       s,
+		msize:   maxMessageSize,
+		fids:    make(map[uint32]*fid),
+		opCtx:   fuseops.OpContext{Pid: uint32(cred.Pid)},
+		refs:    make(map[fuseops.InodeID]int),
+		lookups: make(map[fuseops.InodeID]uint64),
+	}
+	defer c.clunkAll(ctx)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		nc.Close()
+	}()
+
+	r := bufio.NewReader(nc)
+	for {
+		msg, err := c.readMessage(r)
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil {
+				s.log.Errorf("could not read message from %s: %s", nc.RemoteAddr(), err)
+			}
+
+			return
+		}
+		if _, err := nc.Write(c.handle(ctx, msg)); err != nil {
+			s.log.Errorf("could not reply to %s: %s", nc.RemoteAddr(), err)
+
+			return
+		}
+	}
+}
+
+// peerCred returns the credentials of the process which connected a unix socket, as of the connection.
+func peerCred(nc net.Conn) (*syscall.Ucred, error) {
+	uc, ok := nc.(*net.UnixConn)
+	if !ok {
+		return nil, fmt.Errorf("not a unix socket: %s", nc.RemoteAddr())
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *syscall.Ucred
+	cerr := raw.Control(func(fd uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if cerr != nil {
+		return nil, cerr
+	}
+
+	return cred, err
+}
+
+func (c *conn) readMessage(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(size[:])
+	if n < headerSize || n > c.msize {
+		return nil, errors.New("invalid message size")
+	}
+	msg := make([]byte, n)
+	copy(msg, size[:])
+	if _, err := io.ReadFull(r, msg[4:]); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// handle serves a message and returns the reply.
+func (c *conn) handle(ctx context.Context, msg []byte) []byte {
+	d := &decoder{p: msg[4:]}
+	typ := d.u8()
+	tag := d.u16()
+	e := &encoder{b: make([]byte, headerSize, 64)}
+	c.s.log.Debugf("--> message %d, tag %d", typ, tag)
+
+	var err error
+	switch typ {
+	case tVersion:
+		err = c.version(ctx, d, e)
+	case tAttach:
+		err = c.attach(ctx, d, e)
+	case tWalk:
+		err = c.walk(ctx, d, e)
+	case tClunk:
+		err = c.clunkFid(ctx, d)
+	case tRemove:
+		// Files are removed with unlinkat, which the client falls back from.
+		if err = c.clunkFid(ctx, d); err == nil {
+			err = syscall.EOPNOTSUPP
+		}
+	case tFlush:
+		// Messages are served in order: the flushed one has been replied to.
+		d.u16()
+		err = d.err
+	case tStatfs:
+		err = c.statfs(ctx, d, e)
+	case tGetattr:
+		err = c.getattr(ctx, d, e)
+	case tSetattr:
+		err = c.setattr(ctx, d)
+	case tLopen:
+		err = c.lopen(ctx, d, e)
+	case tLcreate:
+		err = c.lcreate(ctx, d, e)
+	case tRead:
+		err = c.read(ctx, d, e)
+	case tWrite:
+		err = c.write(ctx, d, e)
+	case tReaddir:
+		err = c.readdir(ctx, d, e)
+	case tFsync:
+		// Writes are committed to immudb as they are served.
+		_, err = c.fid(d.u32())
+	case tMkdir:
+		err = c.mkdir(ctx, d, e)
+	case tMknod:
+		err = c.mknod(ctx, d, e)
+	case tRenameat:
+		err = c.renameat(ctx, d)
+	case tUnlinkat:
+		err = c.unlinkat(ctx, d)
+	default:
+		err = syscall.EOPNOTSUPP
+	}
+	if err != nil {
+		c.s.log.Debugf("message %d failed: %s", typ, err)
+		e.b = e.b[:headerSize]
+		e.u32(errno(err))
+		typ = tLerror
+	}
+
+	binary.LittleEndian.PutUint32(e.b, uint32(len(e.b)))
+	e.b[4] = typ + 1
+	binary.LittleEndian.PutUint16(e.b[5:], tag)
+
+	return e.b
+}
+
+// errno returns the error number reported to the client: the one returned by the filesystem, if any.
+func errno(err error) uint32 {
+	var e syscall.Errno
+	if errors.As(err, &e) {
+		return uint32(e)
+	}
+	if errors.Is(err, errShortMessage) {
+		return uint32(syscall.EINVAL)
+	}
+
+	return uint32(syscall.EIO)
+}
+
+// fid returns the file designated by a fid.
+func (c *conn) fid(num uint32) (*fid, error) {
+	f, ok := c.fids[num]
+	if !ok {
+		return nil, syscall.EBADF
+	}
+
+	return f, nil
+}
+
+// dir returns the directory designated by a fid.
+func (c *conn) dir(num uint32) (*fid, error) {
+	f, err := c.fid(num)
+	if err == nil && !f.mode.IsDir() {
+		err = syscall.ENOTDIR
+	}
+
+	return f, err
+}
+
+// newFid checks that a fid designates no file yet.
+func (c *conn) newFid(num uint32) error {
+	if _, ok := c.fids[num]; ok {
+		return syscall.EBADF
+	}
+
+	return nil
+}
+
+// lookUp looks up a name in a directory. The lookup is forgotten once no fid designates the inode:
+// the caller must settle it.
+func (c *conn) lookUp(ctx context.Context, parent fuseops.InodeID, name string) (*fid, error) {
+	op := &fuseops.LookUpInodeOp{Parent: parent, Name: name, OpContext: c.opCtx}
+	if err := c.s.fs.LookUpInode(ctx, op); err != nil {
+		return nil, err
+	}
+	c.lookups[op.Entry.Child]++
+
+	return &fid{inode: op.Entry.Child, mode: op.Entry.Attributes.Mode}, nil
+}
+
+// lookUpAll looks up names from a directory, stopping at the first failure. It returns the files found,
+// which the caller must settle.
+func (c *conn) lookUpAll(ctx context.Context, dir *fid, names []string) ([]*fid, error) {
+	var walked []*fid
+	for _, name := range names {
+		if !dir.mode.IsDir() {
+			return walked, syscall.ENOTDIR
+		}
+		f, err := c.lookUp(ctx, dir.inode, name)
+		if err != nil {
+			return walked, err
+		}
+		walked = append(walked, f)
+		dir = f
+	}
+
+	return walked, nil
+}
+
+// ref records a fid designating an inode.
+func (c *conn) ref(inode fuseops.InodeID) {
+	c.refs[inode]++
+}
+
+// unref records a fid no longer designating an inode, and settles it.
+func (c *conn) unref(ctx context.Context, inode fuseops.InodeID) {
+	if c.refs[inode]--; c.refs[inode] <= 0 {
+		delete(c.refs, inode)
+	}
+	c.settle(ctx, inode)
+}
+
+// settle forgets the lookups of an inode no fid designates.
+func (c *conn) settle(ctx context.Context, inode fuseops.InodeID) {
+	n := c.lookups[inode]
+	if n == 0 || c.refs[inode] > 0 {
+		return
+	}
+	delete(c.lookups, inode)
+	c.s.fs.ForgetInode(ctx, &fuseops.ForgetInodeOp{Inode: inode, N: n, OpContext: c.opCtx})
+}
+
+// clunk releases a fid, and the handle of its file if open.
+func (c *conn) clunk(ctx context.Context, num uint32) error {
+	f, err := c.fid(num)
+	if err != nil {
+		return err
+	}
+	delete(c.fids, num)
+
+	switch {
+	case f.open && f.mode.IsDir():
+		err = c.s.fs.ReleaseDirHandle(ctx, &fuseops.ReleaseDirHandleOp{Handle: f.handle, OpContext: c.opCtx})
+	case f.open:
+		err = c.s.fs.ReleaseFileHandle(ctx, &fuseops.ReleaseFileHandleOp{Handle: f.handle, OpContext: c.opCtx})
+	}
+	c.unref(ctx, f.inode)
+
+	return err
+}
+
+// clunkAll releases all the fids, when the session ends.
+func (c *conn) clunkAll(ctx context.Context) {
+	for num := range c.fids {
+		c.clunk(ctx, num)
+	}
+}
+
+func (c *conn) clunkFid(ctx context.Context, d *decoder) error {
+	num := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+
+	return c.clunk(ctx, num)
+}
+
+func (c *conn) version(ctx context.Context, d *decoder, e *encoder) error {
+	msize := d.u32()
+	version := d.str()
+	if d.err != nil {
+		return d.err
+	}
+
+	// A new session starts.
+	c.clunkAll(ctx)
+	c.msize = maxMessageSize
+	if msize < c.msize {
+		c.msize = msize
+	}
+	if version != protocolVersion {
+		version = "unknown"
+	}
+	e.u32(c.msize)
+	e.str(version)
+
+	return nil
+}
+
+// attach designates the root of the filesystem, or the directory named by aname.
+func (c *conn) attach(ctx context.Context, d *decoder, e *encoder) error {
+	num := d.u32()
+	d.u32() // afid: the client is known by the credentials of its socket.
+	d.str() // uname, and n_uname: likewise.
+	aname := d.str()
+	d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	if err := c.newFid(num); err != nil {
+		return err
+	}
+
+	op := &fuseops.GetInodeAttributesOp{Inode: fuseops.RootInodeID, OpContext: c.opCtx}
+	if err := c.s.fs.GetInodeAttributes(ctx, op); err != nil {
+		return err
+	}
+	root := &fid{inode: fuseops.RootInodeID, mode: op.Attributes.Mode}
+
+	var names []string
+	if p := strings.Trim(path.Clean("/"+aname), "/"); p != "" {
+		names = strings.Split(p, "/")
+	}
+	walked, err := c.lookUpAll(ctx, root, names)
+	if err == nil {
+		if len(walked) > 0 {
+			root = walked[len(walked)-1]
+		}
+		if !root.mode.IsDir() {
+			err = syscall.ENOTDIR
+		}
+	}
+	if err == nil {
+		c.fids[num] = root
+		c.ref(root.inode)
+		e.qid(newQid(root.inode, root.mode))
+	}
+	for _, f := range walked {
+		c.settle(ctx, f.inode)
+	}
+
+	return err
+}
+
+// walk designates with newfid the file found by walking names from fid. If a name is not found, the
+// files found up to it are returned, and newfid is left unused.
+func (c *conn) walk(ctx context.Context, d *decoder, e *encoder) error {
+	num := d.u32()
+	newNum := d.u32()
+	names := make([]string, d.u16())
+	for i := range names {
+		names[i] = d.str()
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(names) > maxWalkNames {
+		return syscall.EINVAL
+	}
+	f, err := c.fid(num)
+	if err != nil {
+		return err
+	}
+	if newNum != num {
+		if err := c.newFid(newNum); err != nil {
+			return err
+		}
+	}
+
+	walked, err := c.lookUpAll(ctx, f, names)
+	defer func() {
+		for _, w := range walked {
+			c.settle(ctx, w.inode)
+		}
+	}()
+	if err != nil && len(walked) == 0 {
+		return err
+	}
+
+	e.u16(uint16(len(walked)))
+	for _, w := range walked {
+		e.qid(newQid(w.inode, w.mode))
+	}
+	if len(walked) < len(names) {
+		return nil
+	}
+
+	nf := &fid{inode: f.inode, mode: f.mode}
+	if len(walked) > 0 {
+		nf = walked[len(walked)-1]
+	}
+	c.ref(nf.inode)
+	if newNum == num {
+		if err := c.clunk(ctx, num); err != nil {
+			return err
+		}
+	}
+	c.fids[newNum] = nf
+
+	return nil
+}
+
+func (c *conn) statfs(ctx context.Context, d *decoder, e *encoder) error {
+	_, err := c.fid(d.u32())
+	if d.err != nil {
+		return d.err
+	}
+	if err != nil {
+		return err
+	}
+
+	op := &fuseops.StatFSOp{}
+	if err := c.s.fs.StatFS(ctx, op); err != nil {
+		return err
+	}
+	e.u32(v9fsMagic)
+	e.u32(op.BlockSize)
+	e.u64(op.Blocks)
+	e.u64(op.BlocksFree)
+	e.u64(op.BlocksAvailable)
+	e.u64(op.Inodes + op.InodesFree)
+	e.u64(op.InodesFree)
+	e.u64(0) // fsid
+	e.u32(255)
+
+	return nil
+}
+
+func (c *conn) getattr(ctx context.Context, d *decoder, e *encoder) error {
+	f, err := c.fid(d.u32())
+	d.u64() // request_mask: the basic attributes are always returned.
+	if d.err != nil {
+		return d.err
+	}
+	if err != nil {
+		return err
+	}
+
+	op := &fuseops.GetInodeAttributesOp{Inode: f.inode, OpContext: c.opCtx}
+	if err := c.s.fs.GetInodeAttributes(ctx, op); err != nil {
+		return err
+	}
+	a := op.Attributes
+	f.mode = a.Mode
+
+	e.u64(getattrBasic)
+	e.qid(newQid(f.inode, a.Mode))
+	e.u32(unixMode(a.Mode))
+	e.u32(a.Uid)
+	e.u32(a.Gid)
+	e.u64(uint64(a.Nlink))
+	e.u64(0) // rdev
+	e.u64(a.Size)
+	e.u64(blockSize)
+	e.u64((a.Size + 511) / 512)
+	e.time(a.Atime)
+	e.time(a.Mtime)
+	e.time(a.Ctime)
+	e.time(a.Crtime)
+	e.u64(0) // gen
+	e.u64(0) // data_version
+
+	return nil
+}
+
+func (c *conn) setattr(ctx context.Context, d *decoder) error {
+	f, err := c.fid(d.u32())
+	valid := d.u32()
+	mode := d.u32()
+	uid := d.u32()
+	gid := d.u32()
+	size := d.u64()
+	atime := time.Unix(int64(d.u64()), int64(d.u64()))
+	mtime := time.Unix(int64(d.u64()), int64(d.u64()))
+	if d.err != nil {
+		return d.err
+	}
+	if err != nil {
+		return err
+	}
+
+	op := &fuseops.SetInodeAttributesOp{Inode: f.inode, OpContext: c.opCtx}
+	if valid&setattrMode != 0 {
+		m := f.mode&os.ModeType | fileMode(mode)&^os.ModeType
+		op.Mode = &m
+	}
+	if valid&setattrUID != 0 {
+		op.Uid = &uid
+	}
+	if valid&setattrGID != 0 {
+		op.Gid = &gid
+	}
+	if valid&setattrAtime != 0 {
+		if valid&setattrAtimeSet == 0 {
+			atime = time.Now()
+		}
+		op.Atime = &atime
+	}
+	if valid&setattrMtime != 0 {
+		if valid&setattrMtimeSet == 0 {
+			mtime = time.Now()
+		}
+		op.Mtime = &mtime
+	}
+	if valid&setattrSize != 0 {
+		op.Size = &size
+	}
+
+	// Truncating requires a handle, as with ftruncate: files are opened for the time of a truncate.
+	if f.open && !f.mode.IsDir() {
+		op.Handle = &f.handle
+	} else if op.Size != nil && f.mode.IsRegular() {
+		open := &fuseops.OpenFileOp{Inode: f.inode, OpenFlags: syscall.O_WRONLY, OpContext: c.opCtx}
+		if err := c.s.fs.OpenFile(ctx, open); err != nil {
+			return err
+		}
+		defer c.s.fs.ReleaseFileHandle(ctx, &fuseops.ReleaseFileHandleOp{Handle: open.Handle, OpContext: c.opCtx})
+		op.Handle = &open.Handle
+	}
+
+	return c.s.fs.SetInodeAttributes(ctx, op)
+}
+
+func (c *conn) lopen(ctx context.Context, d *decoder, e *encoder) error {
+	f, err := c.fid(d.u32())
+	flags := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	if err != nil {
+		return err
+	}
+	if f.open {
+		return syscall.EBADF
+	}
+
+	switch {
+	case f.mode.IsDir():
+		if flags&dotlAccmode != 0 {
+			return syscall.EISDIR
+		}
+		op := &fuseops.OpenDirOp{Inode: f.inode, OpContext: c.opCtx}
+		if err := c.s.fs.OpenDir(ctx, op); err != nil {
+			return err
+		}
+		f.handle = op.Handle
+	case f.mode.IsRegular():
+		op := &fuseops.OpenFileOp{Inode: f.inode, OpContext: c.opCtx}
+		switch flags & dotlAccmode {
+		case dotlWronly:
+			op.OpenFlags = syscall.O_WRONLY
+		case dotlRdwr:
+			op.OpenFlags = syscall.O_RDWR
+		}
+		if flags&dotlAppend != 0 {
+			op.OpenFlags |= syscall.O_APPEND
+		}
+		if err := c.s.fs.OpenFile(ctx, op); err != nil {
+			return err
+		}
+		if flags&dotlTrunc != 0 && flags&dotlAccmode != 0 {
+			var size uint64
+			truncate := &fuseops.SetInodeAttributesOp{Inode: f.inode, Handle: &op.Handle, Size: &size, OpContext: c.opCtx}
+			if err := c.s.fs.SetInodeAttributes(ctx, truncate); err != nil {
+				c.s.fs.ReleaseFileHandle(ctx, &fuseops.ReleaseFileHandleOp{Handle: op.Handle, OpContext: c.opCtx})
+
+				return err
+			}
+		}
+		f.handle = op.Handle
+	default:
+		return syscall.EOPNOTSUPP
+	}
+	f.open = true
+
+	e.qid(newQid(f.inode, f.mode))
+	e.u32(0) // iounit: as much as fits in a message.
+
+	return nil
+}
+
+// lcreate creates a file in the directory designated by fid, which then designates the file, open.
+func (c *conn) lcreate(ctx context.Context, d *decoder, e *encoder) error {
+	f, err := c.dir(d.u32())
+	name := d.str()
+	d.u32() // flags: the file is open for reading and writing.
+	mode := d.u32()
+	d.u32() // gid
+	if d.err != nil {
+		return d.err
+	}
+	if err != nil {
+		return err
+	}
+	if f.open {
+		return syscall.EBADF
+	}
+
+	op := &fuseops.CreateFileOp{Parent: f.inode, Name: name, Mode: fileMode(mode) &^ os.ModeType, OpContext: c.opCtx}
+	if err := c.s.fs.CreateFile(ctx, op); err != nil {
+		return err
+	}
+	c.lookups[op.Entry.Child]++
+	c.ref(op.Entry.Child)
+	c.unref(ctx, f.inode)
+	f.inode, f.mode = op.Entry.Child, op.Entry.Attributes.Mode
+	f.open, f.handle = true, op.Handle
+
+	e.qid(newQid(f.inode, f.mode))
+	e.u32(0)
+
+	return nil
+}
+
+// openFile returns the open file designated by a fid.
+func (c *conn) openFile(num uint32) (*fid, error) {
+	f, err := c.fid(num)
+	if err != nil {
+		return nil, err
+	}
+	if f.mode.IsDir() {
+		return nil, syscall.EISDIR
+	}
+	if !f.open {
+		return nil, syscall.EBADF
+	}
+
+	return f, nil
+}
+
+func (c *conn) read(ctx context.Context, d *decoder, e *encoder) error {
+	f, err := c.openFile(d.u32())
+	offset := d.u64()
+	count := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	if err != nil {
+		return err
+	}
+
+	if limit := c.msize - readHeaderSize; count > limit {
+		count = limit
+	}
+	op := &fuseops.ReadFileOp{Inode: f.inode, Handle: f.handle, Offset: int64(offset), Dst: make([]byte, count), OpContext: c.opCtx}
+	if err := c.s.fs.ReadFile(ctx, op); err != nil {
+		return err
+	}
+	e.data(op.Dst[:op.BytesRead])
+
+	return nil
+}
+
+func (c *conn) write(ctx context.Context, d *decoder, e *encoder) error {
+	f, err := c.openFile(d.u32())
+	offset := d.u64()
+	data := d.data()
+	if d.err != nil {
+		return d.err
+	}
+	if err != nil {
+		return err
+	}
+
+	op := &fuseops.WriteFileOp{Inode: f.inode, Handle: f.handle, Offset: int64(offset), Data: data, OpContext: c.opCtx}
+	if err := c.s.fs.WriteFile(ctx, op); err != nil {
+		return err
+	}
+	e.u32(uint32(len(data)))
+
+	return nil
+}
+
+// readdir returns the entries of a directory from an offset, as returned with the previous entries.
+func (c *conn) readdir(ctx context.Context, d *decoder, e *encoder) error {
+	f, err := c.dir(d.u32())
+	offset := d.u64()
+	count := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	if err != nil {
+		return err
+	}
+	if !f.open {
+		return syscall.EBADF
+	}
+
+	// An entry takes at least as much room as returned to the kernel: the entries read fit in count.
+	if limit := c.msize - readHeaderSize; count > limit {
+		count = limit
+	}
+	op := &fuseops.ReadDirOp{Inode: f.inode, Handle: f.handle, Offset: fuseops.DirOffset(offset), Dst: make([]byte, count), OpContext: c.opCtx}
+	if err := c.s.fs.ReadDir(ctx, op); err != nil {
+		return err
+	}
+
+	entries := &encoder{}
+	for p := op.Dst[:op.BytesRead]; len(p) > 0; {
+		var dirent fuseutil.Dirent
+		dirent, p = fs.ParseDirent(p)
+		q := qid{typ: qtFile, path: uint64(dirent.Inode)}
+		switch dirent.Type {
+		case fuseutil.DT_Directory:
+			q.typ = qtDir
+		case fuseutil.DT_Link:
+			q.typ = qtSymlink
+		}
+		entries.qid(q)
+		entries.u64(uint64(dirent.Offset))
+		entries.u8(uint8(dirent.Type))
+		entries.str(dirent.Name)
+	}
+	e.data(entries.b)
+
+	return nil
+}
+
+func (c *conn) mkdir(ctx context.Context, d *decoder, e *encoder) error {
+	f, err := c.dir(d.u32())
+	name := d.str()
+	mode := d.u32()
+	d.u32() // gid
+	if d.err != nil {
+		return d.err
+	}
+	if err != nil {
+		return err
+	}
+
+	op := &fuseops.MkDirOp{Parent: f.inode, Name: name, Mode: fileMode(mode)&^os.ModeType | os.ModeDir, OpContext: c.opCtx}
+	if err := c.s.fs.MkDir(ctx, op); err != nil {
+		return err
+	}
+	c.lookups[op.Entry.Child]++
+	c.settle(ctx, op.Entry.Child)
+	e.qid(newQid(op.Entry.Child, op.Entry.Attributes.Mode))
+
+	return nil
+}
+
+func (c *conn) mknod(ctx context.Context, d *decoder, e *encoder) error {
+	f, err := c.dir(d.u32())
+	name := d.str()
+	mode := d.u32()
+	d.u32() // major
+	d.u32() // minor
+	d.u32() // gid
+	if d.err != nil {
+		return d.err
+	}
+	if err != nil {
+		return err
+	}
+
+	op := &fuseops.MkNodeOp{Parent: f.inode, Name: name, Mode: fileMode(mode), OpContext: c.opCtx}
+	if err := c.s.fs.MkNode(ctx, op); err != nil {
+		return err
+	}
+	c.lookups[op.Entry.Child]++
+	c.settle(ctx, op.Entry.Child)
+	e.qid(newQid(op.Entry.Child, op.Entry.Attributes.Mode))
+
+	return nil
+}
+
+func (c *conn) renameat(ctx context.Context, d *decoder) error {
+	oldDir, oldErr := c.dir(d.u32())
+	oldName := d.str()
+	newDir, newErr := c.dir(d.u32())
+	newName := d.str()
+	if d.err != nil {
+		return d.err
+	}
+	if oldErr != nil {
+		return oldErr
+	}
+	if newErr != nil {
+		return newErr
+	}
+
+	return c.s.fs.Rename(ctx, &fuseops.RenameOp{
+		OldParent: oldDir.inode,
+		OldName:   oldName,
+		NewParent: newDir.inode,
+		NewName:   newName,
+		OpContext: c.opCtx,
+	})
+}
+
+func (c *conn) unlinkat(ctx context.Context, d *decoder) error {
+	f, err := c.dir(d.u32())
+	name := d.str()
+	flags := d.u32()
+	if d.err != nil {
+		return d.err
+	}
+	if err != nil {
+		return err
+	}
+
+	if flags&atRemoveDir != 0 {
+		return c.s.fs.RmDir(ctx, &fuseops.RmDirOp{Parent: f.inode, Name: name, OpContext: c.opCtx})
+	}
+
+	return c.s.fs.Unlink(ctx, &fuseops.UnlinkOp{Parent: f.inode, Name: name, OpContext: c.opCtx})
+}
@@ -0,0 +1,67 @@
+// Package profiling serves the runtime profiles of the process (CPU, heap, goroutines, mutexes...) over HTTP,
+// in the format of net/http/pprof, e.g. to diagnose lock contention in a mount.
+package profiling
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sampling rates of the contention profiles while served: one mutex contention event out of mutexProfileFraction,
+// and one blocking event per blockProfileRate nanoseconds spent blocked.
+const (
+	mutexProfileFraction = 5
+	blockProfileRate     = int(time.Millisecond)
+)
+
+// Serve serves the profiles on /debug/pprof/ at addr until ctx is done. The profiles expose the internals of the
+// process, so that addr must be a loopback address, e.g. 127.0.0.1:6060.
+func Serve(ctx context.Context, addr string, logger *logrus.Logger) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("profiles can only be served on a loopback address, not %s", addr)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	// The mutex and block profiles are empty unless sampled.
+	runtime.SetMutexProfileFraction(mutexProfileFraction)
+	runtime.SetBlockProfileRate(blockProfileRate)
+	defer runtime.SetMutexProfileFraction(0)
+	defer runtime.SetBlockProfileRate(0)
+
+	logger.WithField("component", "profiling").Infof("serving profiles on http://%s/debug/pprof/", l.Addr())
+	if err := server.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"net/http"
+	"strings"
+	"time"
+
+	"immufs/pkg/sigv4"
+)
+
+// Signature Version 4, the only authentication scheme supported: the Authorization header is checked
+// against the credentials of the gateway. Presigned URLs and streaming (chunked) payloads are not supported.
+const (
+	sigV4Algorithm  = sigv4.Algorithm
+	sigV4TimeFormat = sigv4.TimeFormat
+	unsignedPayload = sigv4.UnsignedPayload
+
+	// Largest difference allowed between the time of a request and the time of the gateway.
+	maxClockSkew = 15 * time.Minute
+)
+
+// sigV4Auth is the content of a Signature Version 4 Authorization header.
+type sigV4Auth struct {
+	accessKey     string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+}
+
+func parseAuthorization(header string) (*sigV4Auth, error) {
+	algorithm, fields, ok := strings.Cut(header, " ")
+	if !ok || algorithm != sigV4Algorithm {
+		return nil, errAccessDenied
+	}
+
+	auth := &sigV4Auth{}
+	for _, field := range strings.Split(fields, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch key {
+		case "Credential":
+			scope := strings.Split(value, "/")
+			if len(scope) != 5 || scope[4] != "aws4_request" {
+				return nil, errAccessDenied
+			}
+			auth.accessKey, auth.date, auth.region, auth.service = scope[0], scope[1], scope[2], scope[3]
+		case "SignedHeaders":
+			auth.signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			auth.signature = value
+		}
+	}
+	if auth.accessKey == "" || len(auth.signedHeaders) == 0 || auth.signature == "" {
+		return nil, errAccessDenied
+	}
+
+	return auth, nil
+}
+
+// authenticate checks the signature of a request. It returns the hash of the payload the client signed,
+// to be checked once the body has been read, or unsignedPayload.
+func (g *Gateway) authenticate(r *http.Request) (string, error) {
+	auth, err := parseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return "", err
+	}
+	if auth.accessKey != g.accessKey {
+		return "", errInvalidAccessKey
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	t, err := time.Parse(sigV4TimeFormat, amzDate)
+	if err != nil || !strings.HasPrefix(amzDate, auth.date) {
+		return "", errAccessDenied
+	}
+	if skew := time.Since(t); skew > maxClockSkew || skew < -maxClockSkew {
+		return "", errRequestTimeTooSkew
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if strings.HasPrefix(payloadHash, "STREAMING-") {
+		return "", errNotImplemented
+	}
+	if payloadHash == "" {
+		payloadHash = unsignedPayload
+	}
+
+	if !hmac.Equal([]byte(g.sign(r, auth, amzDate, payloadHash)), []byte(auth.signature)) {
+		return "", errSignatureMismatch
+	}
+
+	return payloadHash, nil
+}
+
+// sign computes the signature of a request with the secret key of the gateway.
+func (g *Gateway) sign(r *http.Request, auth *sigV4Auth, amzDate string, payloadHash string) string {
+	scope := sigv4.Scope{Date: auth.date, Region: auth.region, Service: auth.service}
+
+	return sigv4.Signature(r, g.secretKey, scope, auth.signedHeaders, amzDate, payloadHash)
+}
@@ -0,0 +1,571 @@
+// Package s3 exposes an immufs filesystem over a minimal subset of the Amazon S3 API.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"immufs/pkg/fs"
+	"immufs/pkg/sigv4"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Largest number of keys returned by a listing, as for S3.
+	maxListKeys = 1000
+
+	// Region reported to the clients: it is never checked.
+	gatewayRegion = "us-east-1"
+
+	// Modes of the files and directories created through the gateway.
+	objectMode = 0644
+	bucketMode = 0755
+)
+
+var bucketNameRE = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// Gateway serves a filesystem over the S3 API, with path-style URLs: buckets are the directories at
+// the root of the filesystem, and the key of an object is the path of a file within its bucket. The
+// directories on the path of a key are created on upload. Multipart uploads, versioning, ACLs and
+// the other S3 features are not supported.
+type Gateway struct {
+	session *fs.Session
+	log     *logrus.Entry
+
+	// Credentials of the Signature Version 4 requests. Requests are not authenticated if empty.
+	accessKey string
+	secretKey string
+}
+
+// NewGateway returns a gateway serving the filesystem of a session.
+func NewGateway(session *fs.Session, logger *logrus.Logger, accessKey, secretKey string) *Gateway {
+	return &Gateway{
+		session:   session,
+		log:       logger.WithField("component", "s3"),
+		accessKey: accessKey,
+		secretKey: secretKey,
+	}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.log.Infof("--> %s %s", r.Method, r.URL.Path)
+
+	payloadHash := unsignedPayload
+	if g.accessKey != "" {
+		var err error
+		if payloadHash, err = g.authenticate(r); err != nil {
+			g.writeError(w, r, err, errAccessDenied)
+
+			return
+		}
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	var err error
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		err = g.listBuckets(r.Context(), w)
+	case bucket == "":
+		err = errMethodNotAllowed
+	case !bucketNameRE.MatchString(bucket):
+		err = errInvalidBucketName
+	case key == "":
+		err = g.serveBucket(w, r, bucket)
+	default:
+		err = g.serveObject(w, r, bucket, key, payloadHash)
+	}
+	if err != nil {
+		g.writeError(w, r, err, errNoSuchKey)
+	}
+}
+
+func (g *Gateway) serveBucket(w http.ResponseWriter, r *http.Request, bucket string) error {
+	query := r.URL.Query()
+	switch r.Method {
+	case http.MethodGet:
+		switch {
+		case query.Has("location"):
+			if err := g.checkBucket(r.Context(), bucket); err != nil {
+				return err
+			}
+
+			return writeXML(w, http.StatusOK, locationConstraint{Xmlns: xmlns, Region: gatewayRegion})
+		case hasSubresource(query):
+			return errNotImplemented
+		}
+
+		return g.listObjects(w, r, bucket)
+	case http.MethodHead:
+		return g.checkBucket(r.Context(), bucket)
+	case http.MethodPut:
+		if hasSubresource(query) {
+			return errNotImplemented
+		}
+		if err := g.session.Mkdir(r.Context(), "/"+bucket, bucketMode); err != nil {
+			if isExist(err) {
+				return errBucketExists
+			}
+
+			return err
+		}
+		w.Header().Set("Location", "/"+bucket)
+
+		return nil
+	case http.MethodDelete:
+		if err := g.checkBucket(r.Context(), bucket); err != nil {
+			return err
+		}
+		if err := g.session.Remove(r.Context(), "/"+bucket); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+		return nil
+	}
+
+	return errMethodNotAllowed
+}
+
+// hasSubresource tells whether a request addresses a subresource of a bucket or of an object, e.g. ?acl.
+func hasSubresource(query url.Values) bool {
+	for _, sub := range []string{"acl", "cors", "lifecycle", "policy", "tagging", "versioning", "versions", "uploads", "uploadId", "partNumber", "delete", "website", "replication", "encryption", "object-lock", "retention", "legal-hold"} {
+		if query.Has(sub) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkBucket fails with NoSuchBucket unless the bucket exists.
+func (g *Gateway) checkBucket(ctx context.Context, bucket string) error {
+	fi, err := g.session.Stat(ctx, "/"+bucket)
+	if isNotExist(err) || (err == nil && !fi.IsDir()) {
+		return errNoSuchBucket
+	}
+
+	return err
+}
+
+func (g *Gateway) listBuckets(ctx context.Context, w http.ResponseWriter) error {
+	entries, err := g.session.ReadDir(ctx, "/")
+	if err != nil {
+		return err
+	}
+
+	res := listAllMyBucketsResult{Xmlns: xmlns, Owner: gatewayOwner}
+	for _, e := range entries {
+		// Hidden directories, e.g. .trash, are not valid bucket names.
+		if e.IsDir() && bucketNameRE.MatchString(e.Name()) {
+			res.Buckets = append(res.Buckets, bucketEntry{Name: e.Name(), CreationDate: e.Attributes.Crtime.UTC()})
+		}
+	}
+	sort.Slice(res.Buckets, func(i, j int) bool { return res.Buckets[i].Name < res.Buckets[j].Name })
+
+	return writeXML(w, http.StatusOK, res)
+}
+
+// validKey tells whether a key can be mapped to a path: its components can't be empty, "." nor "..".
+// A trailing slash is allowed, as used by clients to create "folders".
+func validKey(key string) bool {
+	if len(key) > 1024 {
+		return false
+	}
+	for _, name := range strings.Split(strings.TrimSuffix(key, "/"), "/") {
+		if name == "" || name == "." || name == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+// etag returns the entity tag of a file. It is not the MD5 digest of the content, which would have to
+// be read, but it changes whenever the content does.
+func etag(fi *fs.FileInfo) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%d-%d-%d", fi.Inode, fi.ModTime().UnixNano(), fi.Size())))
+
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (g *Gateway) serveObject(w http.ResponseWriter, r *http.Request, bucket, key, payloadHash string) error {
+	if !validKey(key) {
+		return errInvalidKey
+	}
+	if hasSubresource(r.URL.Query()) || r.Header.Get("X-Amz-Copy-Source") != "" {
+		return errNotImplemented
+	}
+	if err := g.checkBucket(r.Context(), bucket); err != nil {
+		return err
+	}
+
+	p := "/" + bucket + "/" + key
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return g.getObject(w, r, p, strings.HasSuffix(key, "/"))
+	case http.MethodPut:
+		return g.putObject(w, r, p, strings.HasSuffix(key, "/"), payloadHash)
+	case http.MethodDelete:
+		return g.deleteObject(w, r, p, strings.HasSuffix(key, "/"))
+	}
+
+	return errMethodNotAllowed
+}
+
+func (g *Gateway) getObject(w http.ResponseWriter, r *http.Request, p string, folder bool) error {
+	fi, err := g.session.Stat(r.Context(), p)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() != folder {
+		return errNoSuchKey
+	}
+
+	w.Header().Set("ETag", etag(fi))
+	w.Header().Set("Last-Modified", fi.ModTime().UTC().Format(http.TimeFormat))
+	if r.Method == http.MethodHead || folder {
+		w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+		if folder {
+			w.Header().Set("Content-Length", "0")
+		}
+		w.WriteHeader(http.StatusOK)
+
+		return nil
+	}
+
+	content, err := g.session.ReadFile(r.Context(), p)
+	if err != nil {
+		return err
+	}
+	// Conditional and range requests are handled by ServeContent.
+	http.ServeContent(w, r, path.Base(p), fi.ModTime(), bytes.NewReader(content))
+
+	return nil
+}
+
+func (g *Gateway) putObject(w http.ResponseWriter, r *http.Request, p string, folder bool, payloadHash string) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return errInvalidArgument
+	}
+	if payloadHash != unsignedPayload && payloadHash != sigv4.HexSHA256(body) {
+		return errContentSHA256Mismatch
+	}
+	if digest := r.Header.Get("Content-Md5"); digest != "" {
+		expected, err := base64.StdEncoding.DecodeString(digest)
+		if err != nil {
+			return errInvalidDigest
+		}
+		if sum := md5.Sum(body); !bytes.Equal(expected, sum[:]) {
+			return errBadDigest
+		}
+	}
+
+	ctx := r.Context()
+	if folder {
+		if len(body) > 0 {
+			return errKeyConflict
+		}
+		if err := g.session.MkdirAll(ctx, p, bucketMode); err != nil {
+			return err
+		}
+	} else {
+		if err := g.session.MkdirAll(ctx, path.Dir(p), bucketMode); err != nil {
+			return err
+		}
+		if err := g.session.WriteFile(ctx, p, body, objectMode); err != nil {
+			return err
+		}
+	}
+
+	fi, err := g.session.Stat(ctx, p)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", etag(fi))
+
+	return nil
+}
+
+// deleteObject removes a file, or an empty directory if the key ends with a slash. As for S3, removing a missing
+// key is not an error. The directories left empty are kept: they are listed as common prefixes.
+func (g *Gateway) deleteObject(w http.ResponseWriter, r *http.Request, p string, folder bool) error {
+	fi, err := g.session.Stat(r.Context(), p)
+	if err == nil && fi.IsDir() == folder {
+		err = g.session.Remove(r.Context(), p)
+	}
+	if err != nil && !isNotExist(err) && !(folder && isNotEmpty(err)) {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+
+	return nil
+}
+
+// lister collects the keys of a bucket, in lexicographic order, as requested by a listing.
+type lister struct {
+	g      *Gateway
+	bucket string
+
+	prefix    string
+	delimiter string
+	after     string
+	maxKeys   int
+
+	objects   []object
+	prefixes  []commonPrefix
+	last      string
+	truncated bool
+}
+
+// add records a key or a common prefix. It returns false once enough keys have been found.
+func (l *lister) add(item string, fi *fs.FileInfo) bool {
+	if item == l.last {
+		// Common prefixes are found as many times as the keys they group.
+		return true
+	}
+	if len(l.objects)+len(l.prefixes) == l.maxKeys {
+		l.truncated = true
+
+		return false
+	}
+
+	if fi == nil {
+		l.prefixes = append(l.prefixes, commonPrefix{Prefix: item})
+	} else {
+		l.objects = append(l.objects, object{
+			Key:          item,
+			LastModified: fi.ModTime().UTC(),
+			ETag:         etag(fi),
+			Size:         fi.Size(),
+			StorageClass: "STANDARD",
+		})
+	}
+	l.last = item
+
+	return true
+}
+
+// walk visits the keys under a directory, given as a key prefix ending with a slash, or empty for the bucket.
+// Directories are only read if they can hold keys to be listed. It returns false once enough keys have been found.
+func (l *lister) walk(ctx context.Context, dir string) (bool, error) {
+	entries, err := l.g.session.ReadDir(ctx, "/"+l.bucket+"/"+dir)
+	if err != nil {
+		return false, err
+	}
+
+	// Sorting by key, directories being followed by a slash, gives the lexicographic order of all the keys.
+	keys := make([]string, len(entries))
+	for i, e := range entries {
+		keys[i] = dir + e.Name()
+		if e.IsDir() {
+			keys[i] += "/"
+		}
+	}
+	sort.Sort(byKey{keys, entries})
+
+	for i, e := range entries {
+		key := keys[i]
+		if e.IsDir() {
+			// Skip the directories holding no key with the prefix, or only keys before the marker.
+			if !strings.HasPrefix(key, l.prefix) && !strings.HasPrefix(l.prefix, key) {
+				continue
+			}
+			if key <= l.after && !strings.HasPrefix(l.after, key) {
+				continue
+			}
+			// All the keys of the directory share the same common prefix.
+			if l.delimiter == "/" && len(key) > len(l.prefix) && strings.HasPrefix(key, l.prefix) {
+				if key > l.after && !l.add(key, nil) {
+					return false, nil
+				}
+
+				continue
+			}
+			if more, err := l.walk(ctx, key); !more || err != nil {
+				return more, err
+			}
+
+			continue
+		}
+
+		if !strings.HasPrefix(key, l.prefix) {
+			continue
+		}
+		item, fi := key, e
+		if l.delimiter != "" {
+			if j := strings.Index(key[len(l.prefix):], l.delimiter); j >= 0 {
+				item, fi = key[:len(l.prefix)+j+len(l.delimiter)], nil
+			}
+		}
+		if item > l.after && !l.add(item, fi) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+type byKey struct {
+	keys    []string
+	entries []*fs.FileInfo
+}
+
+func (b byKey) Len() int           { return len(b.keys) }
+func (b byKey) Less(i, j int) bool { return b.keys[i] < b.keys[j] }
+func (b byKey) Swap(i, j int) {
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+	b.entries[i], b.entries[j] = b.entries[j], b.entries[i]
+}
+
+// listObjects serves both versions of ListObjects.
+func (g *Gateway) listObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	if err := g.checkBucket(r.Context(), bucket); err != nil {
+		return err
+	}
+
+	query := r.URL.Query()
+	l := &lister{
+		g:         g,
+		bucket:    bucket,
+		prefix:    query.Get("prefix"),
+		delimiter: query.Get("delimiter"),
+		maxKeys:   maxListKeys,
+	}
+	if s := query.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return errInvalidArgument
+		}
+		if n < maxListKeys {
+			l.maxKeys = n
+		}
+	}
+
+	v2 := query.Get("list-type") == "2"
+	res := listBucketResult{
+		Xmlns:     xmlns,
+		Name:      bucket,
+		Prefix:    query.Get("prefix"),
+		Delimiter: l.delimiter,
+		MaxKeys:   l.maxKeys,
+	}
+	if v2 {
+		res.StartAfter = query.Get("start-after")
+		res.ContinuationToken = query.Get("continuation-token")
+		l.after = res.StartAfter
+		if res.ContinuationToken != "" {
+			after, err := base64.RawURLEncoding.DecodeString(res.ContinuationToken)
+			if err != nil {
+				return errInvalidArgument
+			}
+			l.after = string(after)
+		}
+	} else {
+		marker := query.Get("marker")
+		res.Marker = &marker
+		l.after = marker
+	}
+
+	if _, err := l.walk(r.Context(), ""); err != nil {
+		return err
+	}
+
+	res.IsTruncated = l.truncated
+	res.Contents, res.CommonPrefixes = l.objects, l.prefixes
+	if v2 {
+		count := len(l.objects) + len(l.prefixes)
+		res.KeyCount = &count
+		if l.truncated {
+			res.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(l.last))
+		}
+	} else if l.truncated {
+		res.NextMarker = l.last
+	}
+
+	if query.Get("encoding-type") == "url" {
+		res.EncodingType = "url"
+		res.Prefix = encodeKey(res.Prefix)
+		res.Delimiter = encodeKey(res.Delimiter)
+		res.StartAfter = encodeKey(res.StartAfter)
+		res.NextMarker = encodeKey(res.NextMarker)
+		for i := range res.Contents {
+			res.Contents[i].Key = encodeKey(res.Contents[i].Key)
+		}
+		for i := range res.CommonPrefixes {
+			res.CommonPrefixes[i].Prefix = encodeKey(res.CommonPrefixes[i].Prefix)
+		}
+	}
+
+	return writeXML(w, http.StatusOK, res)
+}
+
+// encodeKey encodes a key as requested by encoding-type=url.
+func encodeKey(key string) string {
+	return strings.ReplaceAll(url.QueryEscape(key), "%2F", "/")
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) error {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+
+	return nil
+}
+
+// writeError reports an error to the client. Missing files are reported with notFound.
+func (g *Gateway) writeError(w http.ResponseWriter, r *http.Request, err error, notFound *apiError) {
+	e := toAPIError(err, notFound)
+	if e == errInternal {
+		g.log.Errorf("%s %s failed: %s", r.Method, r.URL.Path, err)
+	} else {
+		g.log.Warnf("%s %s: %s", r.Method, r.URL.Path, e)
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(e.Status)
+
+		return
+	}
+
+	writeXML(w, e.Status, errorResponse{Code: e.Code, Message: e.Message, Resource: r.URL.Path})
+}
+
+// Serve runs the gateway on addr until ctx is done.
+func (g *Gateway) Serve(ctx context.Context, addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           g,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	g.log.Infof("serving S3 requests on %s", addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+)
+
+const xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+// The buckets are owned by the user the filesystem is served as.
+var gatewayOwner = owner{ID: "immufs", DisplayName: "immufs"}
+
+type bucketEntry struct {
+	Name         string    `xml:"Name"`
+	CreationDate time.Time `xml:"CreationDate"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Owner   owner         `xml:"Owner"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+type object struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	StorageClass string    `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listBucketResult is the response to both versions of ListObjects: the fields of the other version are left empty.
+type listBucketResult struct {
+	XMLName        xml.Name       `xml:"ListBucketResult"`
+	Xmlns          string         `xml:"xmlns,attr"`
+	Name           string         `xml:"Name"`
+	Prefix         string         `xml:"Prefix"`
+	Delimiter      string         `xml:"Delimiter,omitempty"`
+	EncodingType   string         `xml:"EncodingType,omitempty"`
+	MaxKeys        int            `xml:"MaxKeys"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	Contents       []object       `xml:"Contents"`
+	CommonPrefixes []commonPrefix `xml:"CommonPrefixes"`
+
+	// Version 1.
+	Marker     *string `xml:"Marker"`
+	NextMarker string  `xml:"NextMarker,omitempty"`
+
+	// Version 2.
+	KeyCount              *int   `xml:"KeyCount"`
+	ContinuationToken     string `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string `xml:"StartAfter,omitempty"`
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Region  string   `xml:",chardata"`
+}
+
+// apiError is an error as reported to S3 clients.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errAccessDenied          = &apiError{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errSignatureMismatch     = &apiError{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided"}
+	errInvalidAccessKey      = &apiError{http.StatusForbidden, "InvalidAccessKeyId", "The access key you provided does not exist"}
+	errRequestTimeTooSkew    = &apiError{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large"}
+	errContentSHA256Mismatch = &apiError{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed"}
+	errBadDigest             = &apiError{http.StatusBadRequest, "BadDigest", "The digest you specified did not match what we received"}
+	errInvalidDigest         = &apiError{http.StatusBadRequest, "InvalidDigest", "The digest you specified is not valid"}
+	errInvalidBucketName     = &apiError{http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid"}
+	errInvalidKey            = &apiError{http.StatusBadRequest, "InvalidArgument", "Object keys can't have empty, '.' or '..' components"}
+	errInvalidArgument       = &apiError{http.StatusBadRequest, "InvalidArgument", "Invalid argument"}
+	errNoSuchBucket          = &apiError{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"}
+	errNoSuchKey             = &apiError{http.StatusNotFound, "NoSuchKey", "The specified key does not exist"}
+	errBucketExists          = &apiError{http.StatusConflict, "BucketAlreadyOwnedByYou", "The bucket already exists"}
+	errBucketNotEmpty        = &apiError{http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty"}
+	errKeyConflict           = &apiError{http.StatusConflict, "InvalidArgument", "The key conflicts with a directory or a file of the filesystem"}
+	errMethodNotAllowed      = &apiError{http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource"}
+	errNotImplemented        = &apiError{http.StatusNotImplemented, "NotImplemented", "A header or parameter you provided implies functionality that is not implemented"}
+	errQuotaExceeded         = &apiError{http.StatusForbidden, "QuotaExceeded", "The quota of the owner or of the directory has been exceeded"}
+	errInternal              = &apiError{http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again."}
+)
+
+// toAPIError converts an error returned by the filesystem. Missing files are reported with notFound.
+func toAPIError(err error, notFound *apiError) *apiError {
+	var e *apiError
+	if errors.As(err, &e) {
+		return e
+	}
+
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return errInternal
+	}
+	switch errno {
+	case syscall.ENOENT:
+		return notFound
+	case syscall.EEXIST, syscall.ENOTDIR, syscall.EISDIR:
+		return errKeyConflict
+	case syscall.ENOTEMPTY:
+		return errBucketNotEmpty
+	case syscall.EACCES, syscall.EPERM, syscall.EROFS:
+		return errAccessDenied
+	case syscall.EDQUOT, syscall.ENOSPC:
+		return errQuotaExceeded
+	case syscall.EINVAL, syscall.ENAMETOOLONG:
+		return errInvalidArgument
+	}
+
+	return errInternal
+}
+
+// isNotExist tells whether the filesystem reported a missing file.
+func isNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist)
+}
+
+// isExist tells whether the filesystem reported an existing file.
+func isExist(err error) bool {
+	return errors.Is(err, os.ErrExist)
+}
+
+// isNotEmpty tells whether the filesystem reported a directory which is not empty.
+func isNotEmpty(err error) bool {
+	return errors.Is(err, syscall.ENOTEMPTY)
+}
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
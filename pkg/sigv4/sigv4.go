@@ -0,0 +1,151 @@
+// Package sigv4 computes the AWS Signature Version 4 of HTTP requests, as checked by the S3 gateway and sent to the
+// object storage of the tier.
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Algorithm  = "AWS4-HMAC-SHA256"
+	TimeFormat = "20060102T150405Z"
+	// Payload hash of the requests whose body is not signed.
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+	// Format of the date of the credential scope.
+	dateFormat = "20060102"
+)
+
+// Scope is the credential scope of a signature.
+type Scope struct {
+	Date    string
+	Region  string
+	Service string
+}
+
+func (s Scope) String() string {
+	return strings.Join([]string{s.Date, s.Region, s.Service, "aws4_request"}, "/")
+}
+
+// Signature computes the signature of a request with a secret key, given the headers signed, the X-Amz-Date of the
+// request and the hash of its payload.
+func Signature(r *http.Request, secretKey string, scope Scope, signedHeaders []string, amzDate string, payloadHash string) string {
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		Escape(r.URL.Path, false),
+		canonicalQuery(r.URL.Query()),
+		canonicalHeaders(r, signedHeaders),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{Algorithm, amzDate, scope.String(), HexSHA256([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), scope.Date)
+	for _, s := range []string{scope.Region, scope.Service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// Sign sets the Authorization header of a request, signing its host, its date and its payload.
+func Sign(r *http.Request, accessKey, secretKey, region, service string, payload []byte, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(TimeFormat)
+	payloadHash := HexSHA256(payload)
+	r.Header.Set("X-Amz-Date", amzDate)
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	scope := Scope{Date: now.Format(dateFormat), Region: region, Service: service}
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		Algorithm, accessKey, scope, strings.Join(signed, ";"), Signature(r, secretKey, scope, signed, amzDate, payloadHash)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+
+	return h.Sum(nil)
+}
+
+// HexSHA256 returns the hash of a payload, as signed.
+func HexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Escape encodes a string as required by Signature Version 4: all but the unreserved characters are
+// percent-encoded, slashes included unless they separate the components of a path.
+func Escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// canonicalQuery returns the query parameters sorted by name, then by value.
+func canonicalQuery(query url.Values) string {
+	var params [][2]string
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, [2]string{Escape(key, true), Escape(value, true)})
+		}
+	}
+	sort.Slice(params, func(i, j int) bool {
+		if params[i][0] != params[j][0] {
+			return params[i][0] < params[j][0]
+		}
+
+		return params[i][1] < params[j][1]
+	})
+
+	pairs := make([]string, len(params))
+	for i, p := range params {
+		pairs[i] = p[0] + "=" + p[1]
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+func canonicalHeaders(r *http.Request, signed []string) string {
+	var b strings.Builder
+	for _, name := range signed {
+		// net/http moves the Host and Content-Length headers out of r.Header.
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+			if value == "" {
+				value = r.URL.Host
+			}
+		case "content-length":
+			value = strconv.FormatInt(r.ContentLength, 10)
+		default:
+			value = strings.Join(r.Header.Values(name), ",")
+		}
+		b.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+
+	return b.String()
+}
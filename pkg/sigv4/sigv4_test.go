@@ -0,0 +1,84 @@
+package sigv4
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Credentials and scope of the AWS Signature Version 4 test suite.
+const (
+	testAccessKey = "AKIDEXAMPLE"
+	testSecretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	testAmzDate   = "20150830T123600Z"
+)
+
+var testScope = Scope{Date: "20150830", Region: "us-east-1", Service: "service"}
+
+func newTestRequest(t *testing.T, method string) *http.Request {
+	t.Helper()
+
+	r, err := http.NewRequest(method, "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("X-Amz-Date", testAmzDate)
+
+	return r
+}
+
+func TestSignatureVectors(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		signature string
+	}{
+		{"get-vanilla", http.MethodGet, "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"},
+		{"post-vanilla", http.MethodPost, "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRequest(t, tt.method)
+			got := Signature(r, testSecretKey, testScope, []string{"host", "x-amz-date"}, testAmzDate, HexSHA256(nil))
+			if got != tt.signature {
+				t.Errorf("signature = %s, want %s", got, tt.signature)
+			}
+		})
+	}
+}
+
+func TestBadSignatureRejected(t *testing.T) {
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	r := newTestRequest(t, http.MethodPut)
+	Sign(r, testAccessKey, testSecretKey, testScope.Region, testScope.Service, []byte("content"), now)
+
+	_, signature, ok := strings.Cut(r.Header.Get("Authorization"), "Signature=")
+	if !ok {
+		t.Fatalf("no signature in %q", r.Header.Get("Authorization"))
+	}
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	verify := func(r *http.Request, secretKey string) bool {
+		return Signature(r, secretKey, testScope, signed, testAmzDate, r.Header.Get("X-Amz-Content-Sha256")) == signature
+	}
+
+	if !verify(r, testSecretKey) {
+		t.Fatal("signature of Sign not verified")
+	}
+	if verify(r, "not the secret key") {
+		t.Error("signature verified with another secret key")
+	}
+
+	tampered := r.Clone(r.Context())
+	tampered.URL.Path = "/other"
+	if verify(tampered, testSecretKey) {
+		t.Error("signature verified for another path")
+	}
+
+	tampered = r.Clone(r.Context())
+	tampered.Header.Set("X-Amz-Content-Sha256", HexSHA256([]byte("other content")))
+	if verify(tampered, testSecretKey) {
+		t.Error("signature verified for another payload")
+	}
+}
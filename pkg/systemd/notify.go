@@ -0,0 +1,81 @@
+// Package systemd notifies systemd of the state of the process (see sd_notify(3)), so that units of Type=notify
+// know when a mount is ready or stopping, and restart it when its watchdog is not pinged anymore.
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// States sent by Notify.
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends a state to systemd, through the socket named by NOTIFY_SOCKET. It does nothing when the process is
+// not run by systemd, i.e. without NOTIFY_SOCKET.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Abstract sockets are named with a leading @.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+
+	return err
+}
+
+// WatchdogInterval returns the interval within which systemd expects the watchdog to be pinged (WatchdogSec= of the
+// unit), zero if the watchdog is not enabled for the process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog pings the watchdog at half the interval systemd expects, until ctx is done, as long as check returns:
+// a check hanging, e.g. on a lock held by an operation which never completes, stops the pings, so that systemd
+// restarts the process. It returns right away if the watchdog is not enabled.
+func RunWatchdog(ctx context.Context, check func(), logger *logrus.Logger) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	logger.Infof("pinging the systemd watchdog every %s", interval/2)
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		check()
+		if err := Notify(Watchdog); err != nil {
+			logger.Warnf("could not ping the systemd watchdog: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
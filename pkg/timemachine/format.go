@@ -0,0 +1,154 @@
+package timemachine
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"immufs/pkg/fs"
+
+	"github.com/jacobsa/fuse/fuseutil"
+)
+
+// Formats of the content printed.
+const (
+	// Hexadecimal, in the log (the default).
+	FormatHex = "hex"
+	// As is, on the standard output.
+	FormatRaw = "raw"
+	// Base64, on the standard output.
+	FormatBase64 = "base64"
+	// A JSON object describing the inode and its content, on the standard output.
+	FormatJSON = "json"
+)
+
+// Formats lists the formats of the content.
+var Formats = []string{FormatRaw, FormatHex, FormatJSON, FormatBase64}
+
+// ValidFormat tells whether a format is one of Formats.
+func ValidFormat(format string) bool {
+	for _, f := range Formats {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// report is the JSON output: an inode as of a transaction, with its content.
+type report struct {
+	Inumber int64 `json:"inumber"`
+	// The state reported is the one before Tx, i.e. as of AsOfTx, committed at TxTime.
+	Tx     int64     `json:"tx"`
+	AsOfTx uint64    `json:"as_of_tx"`
+	TxTime time.Time `json:"tx_time"`
+
+	Dir   bool      `json:"dir"`
+	Mode  string    `json:"mode"`
+	Size  int64     `json:"size"`
+	Uid   int64     `json:"uid"`
+	Gid   int64     `json:"gid"`
+	Atime time.Time `json:"atime"`
+	Mtime time.Time `json:"mtime"`
+	Ctime time.Time `json:"ctime"`
+
+	// The content, in base64, and its SHA-256 digest. The content of a directory is its entries, in JSON format,
+	// also reported as such.
+	Content []byte            `json:"content"`
+	SHA256  string            `json:"sha256"`
+	Entries []fuseutil.Dirent `json:"entries,omitempty"`
+
+	Verified *verification `json:"verified,omitempty"`
+	// Operation which wrote the content, if recorded in the audit table.
+	WrittenBy *provenance `json:"written_by,omitempty"`
+}
+
+// verification is the result of Options.Verify.
+type verification struct {
+	Tx     uint64 `json:"tx"`
+	TxHash string `json:"tx_hash"`
+}
+
+// provenance is an audit record of the operation which wrote a content.
+type provenance struct {
+	Tx     uint64    `json:"tx,omitempty"`
+	Time   time.Time `json:"time"`
+	Op     string    `json:"op"`
+	Uid    int64     `json:"uid"`
+	Gid    int64     `json:"gid"`
+	Pid    uint32    `json:"pid"`
+	DBUser string    `json:"db_user,omitempty"`
+}
+
+func newProvenance(rec *fs.AuditRecord, tx uint64) *provenance {
+	if rec == nil {
+		return nil
+	}
+
+	return &provenance{Tx: tx, Time: rec.Time, Op: rec.Op, Uid: rec.Uid, Gid: rec.Gid, Pid: rec.Pid, DBUser: rec.DBUser}
+}
+
+// String describes the operation for the log.
+func (p *provenance) String() string {
+	s := fmt.Sprintf("%s by uid=%d gid=%d pid=%d", p.Op, p.Uid, p.Gid, p.Pid)
+	if p.DBUser != "" {
+		s += " as immudb user " + p.DBUser
+	}
+
+	return s + " at " + p.Time.Format(time.RFC3339)
+}
+
+func newReport(inode *fs.Inode, tx int64, txTime time.Time, content []byte, children []fuseutil.Dirent, proof *fs.ContentProof, writtenBy *provenance) *report {
+	digest := sha256.Sum256(content)
+	r := &report{
+		Inumber: inode.Inumber,
+		Tx:      tx,
+		AsOfTx:  uint64(tx - 1),
+		TxTime:  txTime,
+		Dir:     os.FileMode(inode.Mode).IsDir(),
+		Mode:    os.FileMode(inode.Mode).String(),
+		Size:    inode.Size,
+		Uid:     inode.Uid,
+		Gid:     inode.Gid,
+		Atime:   inode.Atime,
+		Mtime:   inode.Mtime,
+		Ctime:   inode.Ctime,
+		Content: content,
+		SHA256:  hex.EncodeToString(digest[:]),
+		Entries: children,
+
+		WrittenBy: writtenBy,
+	}
+	if proof != nil {
+		r.Verified = &verification{Tx: proof.Tx, TxHash: hex.EncodeToString(proof.TxHash[:])}
+	}
+
+	return r
+}
+
+// printContent writes a content to w in one of the formats written to the standard output.
+func printContent(w io.Writer, format string, content []byte, r *report) error {
+	switch format {
+	case FormatRaw:
+		_, err := w.Write(content)
+
+		return err
+	case FormatBase64:
+		_, err := fmt.Fprintln(w, base64.StdEncoding.EncodeToString(content))
+
+		return err
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(r)
+	default:
+		return fmt.Errorf("format %s is not written to the standard output", format)
+	}
+}
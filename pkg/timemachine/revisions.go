@@ -0,0 +1,143 @@
+package timemachine
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"immufs/pkg/fs"
+)
+
+// Layouts accepted for the bounds of the revisions listed given as times.
+var boundLayouts = []string{time.RFC3339, "2006-01-02"}
+
+// parseBound returns the transaction a bound of the revisions listed stands for: a transaction, or a time. A
+// lower bound stands for the first transaction committed at or after the time, an upper bound for the last one
+// committed at or before it.
+func parseBound(ctx context.Context, idb *fs.ImmuDbClient, bound string, upper bool) (uint64, error) {
+	if tx, err := strconv.ParseUint(bound, 10, 64); err == nil {
+		return tx, nil
+	}
+	for _, layout := range boundLayouts {
+		t, err := time.ParseInLocation(layout, bound, time.Local)
+		if err != nil {
+			continue
+		}
+		if layout == "2006-01-02" && upper {
+			// The whole day.
+			t = t.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		if upper {
+			return idb.TxAt(ctx, t)
+		}
+		// Transaction times are truncated to the second.
+		tx, err := idb.TxAt(ctx, t.Add(-time.Second))
+
+		return tx + 1, err
+	}
+
+	return 0, fmt.Errorf("invalid bound %q, expecting a transaction or a time", bound)
+}
+
+// revisionOutput is a revision in the JSON output.
+type revisionOutput struct {
+	Tx        uint64      `json:"tx"`
+	Time      time.Time   `json:"time"`
+	Size      int64       `json:"size"`
+	SHA256    string      `json:"sha256"`
+	WrittenBy *provenance `json:"written_by,omitempty"`
+	// Signature of the revision, if written by a mount signing its writes.
+	Signature *fs.ContentSignature `json:"signature,omitempty"`
+}
+
+func newRevisionOutput(rev fs.Revision) revisionOutput {
+	return revisionOutput{
+		Tx:        rev.Tx,
+		Time:      rev.Time,
+		Size:      rev.Size,
+		SHA256:    hex.EncodeToString(rev.SHA256[:]),
+		WrittenBy: newProvenance(rev.Provenance, 0),
+		Signature: rev.Signature,
+	}
+}
+
+// signerOf describes the signature of a revision in the tables and the log.
+func signerOf(rev *revisionOutput) string {
+	switch {
+	case rev.Signature == nil:
+		return "-"
+	case !rev.Signature.Valid:
+		return rev.Signature.Signer + " (INVALID)"
+	default:
+		return rev.Signature.Signer
+	}
+}
+
+// ListRevisions prints the revisions of a file written between two bounds, transactions or times (RFC 3339 or
+// YYYY-MM-DD), open-ended if empty: a table, or a JSON array with FormatJSON.
+func (m *TimeMachine) ListRevisions(ctx context.Context, inumber int64, from, to string, opts Options) error {
+	idb := m.idb
+	fromTx, toTx := uint64(1), uint64(0)
+	var err error
+	if from != "" {
+		if fromTx, err = parseBound(ctx, idb, from, false); err != nil {
+			return err
+		}
+	}
+	if to != "" {
+		if toTx, err = parseBound(ctx, idb, to, true); err != nil {
+			return err
+		}
+	}
+	if toTx == 0 {
+		if toTx, err = idb.CurrentTx(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Files deleted since are listed as files.
+	isDir := false
+	inode, err := idb.GetInodeAt(ctx, inumber, toTx)
+	if err == nil {
+		isDir = os.FileMode(inode.Mode).IsDir()
+	} else if !errors.Is(err, fs.ErrInodeNotFound) {
+		return err
+	}
+
+	revs, err := idb.RevisionsBetween(ctx, inumber, isDir, fromTx, toTx)
+	if err != nil {
+		return err
+	}
+
+	out := make([]revisionOutput, len(revs))
+	for i, rev := range revs {
+		out[i] = newRevisionOutput(rev)
+	}
+	if opts.Format == FormatJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(out)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TX\tTIME\tSIZE\tSHA256\tOP\tUID\tGID\tPID\tUSER\tSIGNER")
+	for i := range out {
+		rev := &out[i]
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t", rev.Tx, rev.Time.Format(time.RFC3339), rev.Size, rev.SHA256)
+		if p := rev.WrittenBy; p != nil {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\t", p.Op, p.Uid, p.Gid, p.Pid, p.DBUser)
+		} else {
+			fmt.Fprint(w, "-\t-\t-\t-\t-\t")
+		}
+		fmt.Fprintln(w, signerOf(rev))
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,125 @@
+// Package timemachine reads the files of the filesystem as of past transactions, for file content verification:
+// their content before a transaction, the revisions written in a window or as they are committed, and an
+// interactive browser of the namespace. It is served by the history subcommand.
+package timemachine
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"immufs/pkg/fs"
+
+	"github.com/jacobsa/fuse/fuseutil"
+	"github.com/sirupsen/logrus"
+)
+
+// Options tell what is printed and how.
+type Options struct {
+	// Format of the content, one of Formats.
+	Format string
+	// Print the content as text rather than in hexadecimal, with FormatHex.
+	Text bool
+	// Verify the content shown with immudb proofs.
+	Verify bool
+	// Print the diff of each revision watched against the previous one instead of its content.
+	Diff bool
+}
+
+// TimeMachine prints the history of the files of a database: contents go to the standard output or to the log,
+// depending on the format, and everything else to the log.
+type TimeMachine struct {
+	idb *fs.ImmuDbClient
+	log *logrus.Logger
+}
+
+func New(idb *fs.ImmuDbClient, log *logrus.Logger) *TimeMachine {
+	return &TimeMachine{idb: idb, log: log}
+}
+
+// Show prints the content of a file, or the entries of a directory, before a transaction, i.e. as of the
+// previous one.
+func (m *TimeMachine) Show(ctx context.Context, inumber int64, tx int64, opts Options) error {
+	idb := m.idb
+
+	// The state before a transaction is the one as of the previous transaction (0 is the current state).
+	if tx <= 1 {
+		m.log.Infof("No entries found for file %d at TX=%d", inumber, tx)
+
+		return nil
+	}
+	inode, err := idb.GetInodeAt(ctx, inumber, uint64(tx-1))
+	if errors.Is(err, fs.ErrInodeNotFound) {
+		m.log.Infof("No entries found for file %d at TX=%d", inumber, tx)
+
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The content of a directory is its entries, in JSON format. Verified contents are shown as verified.
+	isDir := os.FileMode(inode.Mode).IsDir()
+	var proof *fs.ContentProof
+	var content []byte
+	var children []fuseutil.Dirent
+	if opts.Verify {
+		if proof, err = idb.VerifyContentAt(ctx, inumber, isDir, uint64(tx-1)); err != nil {
+			return err
+		}
+	}
+	switch {
+	case isDir:
+		if proof != nil {
+			children, err = proof.Children()
+		} else {
+			children, err = idb.GetChildrenAt(ctx, inumber, uint64(tx-1))
+		}
+		if err == nil {
+			content, err = json.Marshal(children)
+		}
+	case proof != nil:
+		content = proof.Content
+	default:
+		content, err = idb.ReadContentAt(ctx, inumber, uint64(tx-1))
+	}
+	if err != nil {
+		return err
+	}
+
+	// Who wrote the content, if the operation was recorded. It is extra information: failures are only logged.
+	writtenBy, writtenAt, err := idb.ContentProvenance(ctx, inumber, isDir, uint64(tx-1))
+	if err != nil && !errors.Is(err, fs.ErrNotSupported) {
+		m.log.Warnf("Could not get the provenance of file %d: %v", inumber, err)
+	}
+	prov := newProvenance(writtenBy, writtenAt)
+
+	// Scripts get the content alone on the standard output, or everything in JSON.
+	switch {
+	case opts.Format == FormatJSON:
+		txTime, err := idb.TxTime(ctx, uint64(tx-1))
+		if err != nil {
+			return err
+		}
+
+		return printContent(os.Stdout, opts.Format, content, newReport(inode, tx, txTime, content, children, proof, prov))
+	case opts.Format != FormatHex:
+		if err := printContent(os.Stdout, opts.Format, content, nil); err != nil {
+			return err
+		}
+	case opts.Text:
+		m.log.Infof("Before TX=%d the file content was:\n%s", tx, string(content))
+	default:
+		m.log.Infof("Before TX=%d the file content was:\n%v", tx, hex.EncodeToString(content))
+	}
+	if prov != nil {
+		m.log.Infof("Written by TX=%d: %s", prov.Tx, prov)
+	}
+	if proof != nil {
+		m.log.Infof("Verified: written by TX=%d, whose hash is %s", proof.Tx, hex.EncodeToString(proof.TxHash[:]))
+	}
+
+	return nil
+}
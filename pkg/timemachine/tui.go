@@ -0,0 +1,539 @@
+package timemachine
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+
+	"immufs/pkg/fs"
+
+	"golang.org/x/term"
+)
+
+// view is what the browser shows.
+type view int
+
+const (
+	// The entries of a directory.
+	viewDir view = iota
+	// The revisions of the content of a file.
+	viewRevisions
+	// The content of a file, or of one of its revisions.
+	viewContent
+)
+
+const browserHelp = "↑↓ move  → open  ← back  [ ] previous/next tx  t go to tx  r revisions  q quit"
+
+// browser is an interactive terminal UI browsing the namespace as of any transaction, the revisions of the files
+// and their content.
+type browser struct {
+	ctx context.Context
+	idb *fs.ImmuDbClient
+	in  *bufio.Reader
+	out *bufio.Writer
+
+	// Transaction browsed, and last committed transaction.
+	tx     uint64
+	lastTx uint64
+
+	// Directory browsed, relative to the root, and its entries as of tx.
+	dir     string
+	entries []fs.DirEntry
+
+	// File previewed or whose revisions are listed.
+	file fs.DirEntry
+	revs []int64
+
+	// Content previewed, split in lines, and the view to return to.
+	title       string
+	lines       []string
+	contentBack view
+
+	view   view
+	cursor int
+	top    int
+	status string
+}
+
+// Browse runs a terminal UI browsing the namespace as of any transaction until the user quits.
+func (m *TimeMachine) Browse(ctx context.Context) error {
+	idb := m.idb
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("the interactive mode needs a terminal")
+	}
+	lastTx, err := idb.CurrentTx(ctx)
+	if err != nil {
+		return err
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+
+	b := &browser{
+		ctx:    ctx,
+		idb:    idb,
+		in:     bufio.NewReader(os.Stdin),
+		out:    bufio.NewWriter(os.Stdout),
+		tx:     lastTx,
+		lastTx: lastTx,
+	}
+	// Alternate screen, hidden cursor.
+	b.out.WriteString("\x1b[?1049h\x1b[?25l")
+	defer func() {
+		b.out.WriteString("\x1b[?25h\x1b[?1049l")
+		b.out.Flush()
+	}()
+
+	b.loadDir("")
+	for {
+		b.draw()
+		key, err := b.readKey()
+		if err != nil {
+			return err
+		}
+		if !b.handle(key) {
+			return nil
+		}
+	}
+}
+
+// size returns the number of columns of the terminal, and the number of rows available to the view.
+func (b *browser) size() (int, int) {
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+	// Header and status lines.
+	rows := height - 2
+	if rows < 1 {
+		rows = 1
+	}
+
+	return width, rows
+}
+
+// items returns the lines of the current view, and whether they can be selected.
+func (b *browser) items() ([]string, bool) {
+	switch b.view {
+	case viewRevisions:
+		items := make([]string, len(b.revs))
+		for i, rev := range b.revs {
+			items[i] = fmt.Sprintf("revision %d", rev)
+		}
+
+		return items, true
+	case viewContent:
+		return b.lines, false
+	default:
+		items := make([]string, len(b.entries))
+		for i, e := range b.entries {
+			mode := os.FileMode(e.Inode.Mode)
+			name := e.Name
+			if mode.IsDir() {
+				name += "/"
+			}
+			items[i] = fmt.Sprintf("%s %10d  %s  %s", mode, e.Inode.Size, e.Inode.Mtime.Format("2006-01-02 15:04:05"), name)
+		}
+
+		return items, true
+	}
+}
+
+func (b *browser) header() string {
+	switch b.view {
+	case viewRevisions:
+		return fmt.Sprintf("revisions of %s", b.filePath())
+	case viewContent:
+		return b.title
+	default:
+		return fmt.Sprintf("tx %d/%d  /%s", b.tx, b.lastTx, b.dir)
+	}
+}
+
+func (b *browser) draw() {
+	width, rows := b.size()
+	items, selectable := b.items()
+
+	b.out.WriteString("\x1b[H\x1b[2J")
+	b.out.WriteString("\x1b[7m" + fit(b.header(), width) + "\x1b[0m\r\n")
+	for i := b.top; i < b.top+rows; i++ {
+		if i < len(items) {
+			line := fit(items[i], width)
+			if selectable && i == b.cursor {
+				line = "\x1b[7m" + line + "\x1b[0m"
+			}
+			b.out.WriteString(line)
+		}
+		b.out.WriteString("\r\n")
+	}
+	status := b.status
+	if status == "" {
+		status = browserHelp
+	}
+	b.out.WriteString(fit(status, width))
+	b.out.Flush()
+	b.status = ""
+}
+
+// fit makes a line printable on a row of the given width: control characters are replaced, and the line is
+// truncated.
+func fit(line string, width int) string {
+	var sb strings.Builder
+	n := 0
+	for _, r := range line {
+		if r == '\t' {
+			r = ' '
+		} else if r < ' ' || r == 0x7f || r == utf8.RuneError {
+			r = '.'
+		}
+		if n == width {
+			break
+		}
+		sb.WriteRune(r)
+		n++
+	}
+
+	return sb.String()
+}
+
+// readKey returns the next key pressed: a character, or the name of a special key.
+func (b *browser) readKey() (string, error) {
+	r, _, err := b.in.ReadRune()
+	if err != nil {
+		return "", err
+	}
+	switch r {
+	case '\r', '\n':
+		return "enter", nil
+	case 0x7f, 0x08:
+		return "backspace", nil
+	case 0x03:
+		// Ctrl-C, not turned into a signal in raw mode.
+		return "q", nil
+	case 0x1b:
+	default:
+		return string(r), nil
+	}
+
+	// Escape sequences of the cursor keys are sent at once, unlike a lone escape.
+	if b.in.Buffered() == 0 {
+		return "esc", nil
+	}
+	seq := ""
+	for b.in.Buffered() > 0 {
+		c, err := b.in.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		seq += string(c)
+		if len(seq) > 1 && (c >= 'A' && c <= 'Z' || c == '~') {
+			break
+		}
+	}
+	switch seq {
+	case "[A", "OA":
+		return "up", nil
+	case "[B", "OB":
+		return "down", nil
+	case "[C", "OC":
+		return "right", nil
+	case "[D", "OD":
+		return "left", nil
+	case "[5~":
+		return "pgup", nil
+	case "[6~":
+		return "pgdown", nil
+	case "[H", "OH", "[1~":
+		return "home", nil
+	case "[F", "OF", "[4~":
+		return "end", nil
+	default:
+		return "esc", nil
+	}
+}
+
+// handle applies a key to the browser. It returns false when the user quits.
+func (b *browser) handle(key string) bool {
+	items, _ := b.items()
+	_, rows := b.size()
+
+	switch key {
+	case "q":
+		if b.view == viewDir {
+			return false
+		}
+		b.back()
+	case "esc", "left", "h", "backspace":
+		b.back()
+	case "up", "k":
+		b.move(-1, len(items), rows)
+	case "down", "j":
+		b.move(1, len(items), rows)
+	case "pgup":
+		b.move(-rows, len(items), rows)
+	case "pgdown", " ":
+		b.move(rows, len(items), rows)
+	case "home", "g":
+		b.move(-len(items), len(items), rows)
+	case "end", "G":
+		b.move(len(items), len(items), rows)
+	case "enter", "right", "l":
+		b.open()
+	case "r":
+		if b.view == viewDir {
+			b.listRevisions()
+		}
+	case "[":
+		if b.view == viewDir && b.tx > 1 {
+			b.goTo(b.tx - 1)
+		}
+	case "]":
+		if b.view == viewDir && b.tx < b.lastTx {
+			b.goTo(b.tx + 1)
+		}
+	case "t":
+		if b.view == viewDir {
+			b.promptTx()
+		}
+	}
+
+	return true
+}
+
+// move moves the cursor of a list of n items, scrolling it to keep the cursor on one of the rows. Content has
+// no cursor: it is scrolled.
+func (b *browser) move(delta, n, rows int) {
+	if b.view == viewContent {
+		b.top += delta
+		if b.top > n-rows {
+			b.top = n - rows
+		}
+		if b.top < 0 {
+			b.top = 0
+		}
+
+		return
+	}
+
+	b.cursor += delta
+	if b.cursor >= n {
+		b.cursor = n - 1
+	}
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+	if b.cursor < b.top {
+		b.top = b.cursor
+	}
+	if b.cursor >= b.top+rows {
+		b.top = b.cursor - rows + 1
+	}
+}
+
+func (b *browser) selected() (fs.DirEntry, bool) {
+	if b.cursor >= len(b.entries) {
+		return fs.DirEntry{}, false
+	}
+
+	return b.entries[b.cursor], true
+}
+
+func (b *browser) filePath() string {
+	return "/" + path.Join(b.dir, b.file.Name)
+}
+
+// loadDir lists a directory as of the transaction browsed, selecting the entry with the given name if any. If the
+// directory doesn't exist as of the transaction, its closest ancestor is listed instead.
+func (b *browser) loadDir(selectName string) {
+	b.view, b.entries, b.cursor, b.top = viewDir, nil, 0, 0
+	for {
+		dir, err := b.idb.LookUpPathAsOf(b.ctx, b.dir, b.tx)
+		if (errors.Is(err, fs.ErrInodeNotFound) || errors.Is(err, syscall.ENOTDIR)) && b.dir != "" {
+			b.status = fmt.Sprintf("/%s does not exist as of tx %d", b.dir, b.tx)
+			selectName, b.dir = path.Base(b.dir), parentDir(b.dir)
+
+			continue
+		}
+		if err != nil {
+			b.status = err.Error()
+
+			return
+		}
+		if b.entries, err = b.idb.ReadDirAsOf(b.ctx, dir, b.tx); err != nil {
+			b.status = err.Error()
+
+			return
+		}
+		break
+	}
+
+	for i, e := range b.entries {
+		if e.Name == selectName {
+			_, rows := b.size()
+			b.move(i, len(b.entries), rows)
+
+			break
+		}
+	}
+}
+
+func parentDir(dir string) string {
+	if parent := path.Dir(dir); parent != "." {
+		return parent
+	}
+
+	return ""
+}
+
+// goTo browses the same directory as of another transaction.
+func (b *browser) goTo(tx uint64) {
+	name := ""
+	if e, ok := b.selected(); ok {
+		name = e.Name
+	}
+	b.tx = tx
+	b.loadDir(name)
+}
+
+func (b *browser) promptTx() {
+	input := ""
+	for {
+		b.status = fmt.Sprintf("go to tx (1-%d): %s", b.lastTx, input)
+		b.draw()
+		key, err := b.readKey()
+		if err != nil || key == "esc" {
+			return
+		}
+		switch {
+		case key == "enter":
+			tx, err := strconv.ParseUint(input, 10, 64)
+			if err != nil || tx < 1 || tx > b.lastTx {
+				b.status = fmt.Sprintf("invalid transaction %q", input)
+
+				return
+			}
+			b.goTo(tx)
+
+			return
+		case key == "backspace" && input != "":
+			input = input[:len(input)-1]
+		case len(key) == 1 && key[0] >= '0' && key[0] <= '9':
+			input += key
+		}
+	}
+}
+
+func (b *browser) open() {
+	switch b.view {
+	case viewDir:
+		e, ok := b.selected()
+		if !ok {
+			return
+		}
+		if os.FileMode(e.Inode.Mode).IsDir() {
+			b.dir = path.Join(b.dir, e.Name)
+			b.loadDir("")
+
+			return
+		}
+		b.file = e
+		content, err := b.idb.ReadContentAt(b.ctx, e.Inode.Inumber, b.tx)
+		if err != nil {
+			b.status = err.Error()
+
+			return
+		}
+		b.showContent(fmt.Sprintf("%s as of tx %d", b.filePath(), b.tx), content)
+	case viewRevisions:
+		if b.cursor >= len(b.revs) {
+			return
+		}
+		rev := b.revs[b.cursor]
+		content, err := b.idb.ReadContentRevision(b.ctx, b.file.Inode.Inumber, rev)
+		if err != nil {
+			b.status = err.Error()
+
+			return
+		}
+		b.showContent(fmt.Sprintf("%s revision %d", b.filePath(), rev), content)
+	}
+}
+
+// showContent previews a content: text as is, anything else as a hex dump.
+func (b *browser) showContent(title string, content []byte) {
+	text := string(content)
+	if !utf8.Valid(content) {
+		text = hex.Dump(content)
+	}
+	b.title = title
+	b.lines = strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	b.contentBack = b.view
+	b.view, b.top = viewContent, 0
+}
+
+func (b *browser) listRevisions() {
+	e, ok := b.selected()
+	if !ok || os.FileMode(e.Inode.Mode).IsDir() {
+		return
+	}
+	revs, err := b.idb.ContentRevisions(b.ctx, e.Inode.Inumber)
+	if err != nil {
+		b.status = err.Error()
+
+		return
+	}
+	b.file, b.revs = e, revs
+	b.view, b.cursor, b.top = viewRevisions, 0, 0
+	// The last revision first: it is the one looked for most often.
+	_, rows := b.size()
+	b.move(len(revs), len(revs), rows)
+}
+
+// back returns to the previous view, or to the parent directory.
+func (b *browser) back() {
+	switch b.view {
+	case viewContent:
+		// The cursor of the list was kept while previewing.
+		b.view = b.contentBack
+		items, _ := b.items()
+		b.restoreCursor(b.cursor, len(items))
+	case viewRevisions:
+		b.view = viewDir
+		b.restoreCursor(b.indexOfEntry(b.file.Name), len(b.entries))
+	default:
+		if b.dir == "" {
+			return
+		}
+		name := path.Base(b.dir)
+		b.dir = parentDir(b.dir)
+		b.loadDir(name)
+	}
+}
+
+func (b *browser) restoreCursor(i, n int) {
+	_, rows := b.size()
+	b.cursor, b.top = 0, 0
+	b.move(i, n, rows)
+}
+
+func (b *browser) indexOfEntry(name string) int {
+	for i, e := range b.entries {
+		if e.Name == name {
+			return i
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,156 @@
+package timemachine
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"immufs/pkg/fs"
+	"immufs/pkg/historyapi"
+)
+
+// watchOutput is a revision printed by Watch with FormatJSON, one object per line.
+type watchOutput struct {
+	revisionOutput
+	// The content written, or its diff against the previous revision. Binary contents are not diffed.
+	Content []byte `json:"content,omitempty"`
+	Diff    string `json:"diff,omitempty"`
+	Binary  bool   `json:"binary,omitempty"`
+}
+
+// contentAt returns the content of a file, or the entries of a directory in JSON format, as of a transaction:
+// empty if the inode did not exist then.
+func contentAt(ctx context.Context, idb *fs.ImmuDbClient, inumber int64, isDir bool, tx uint64) ([]byte, error) {
+	if !isDir {
+		content, err := idb.ReadContentAt(ctx, inumber, tx)
+		if errors.Is(err, fs.ErrInodeNotFound) {
+			return nil, nil
+		}
+
+		return content, err
+	}
+
+	children, err := idb.GetChildrenAt(ctx, inumber, tx)
+	if errors.Is(err, fs.ErrInodeNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(children)
+}
+
+// Watch polls the transactions committed since it started, and prints every revision of a file they write: its
+// content, or its diff against the previous one. It returns once ctx is done.
+func (m *TimeMachine) Watch(ctx context.Context, inumber int64, interval time.Duration, opts Options) error {
+	idb := m.idb
+	last, err := idb.CurrentTx(ctx)
+	if err != nil {
+		return err
+	}
+	// Files not created yet are watched as files.
+	isDir := false
+	inode, err := idb.GetInodeAt(ctx, inumber, last)
+	if err == nil {
+		isDir = os.FileMode(inode.Mode).IsDir()
+	} else if !errors.Is(err, fs.ErrInodeNotFound) {
+		return err
+	}
+	previous, err := contentAt(ctx, idb, inumber, isDir, last)
+	if err != nil {
+		return err
+	}
+	m.log.Infof("Watching file %d from TX=%d", inumber, last)
+
+	enc := json.NewEncoder(os.Stdout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := idb.CurrentTx(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+		if current <= last {
+			continue
+		}
+		revs, err := idb.RevisionsBetween(ctx, inumber, isDir, last+1, current)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return err
+		}
+
+		for _, rev := range revs {
+			content, err := contentAt(ctx, idb, inumber, isDir, rev.Tx)
+			if err != nil {
+				return err
+			}
+
+			out := watchOutput{revisionOutput: newRevisionOutput(rev)}
+			if opts.Diff {
+				out.Diff, out.Binary = historyapi.UnifiedDiff(fmt.Sprintf("%d@%d", inumber, last), fmt.Sprintf("%d@%d", inumber, rev.Tx), previous, content)
+			} else {
+				out.Content = content
+			}
+			previous, last = content, rev.Tx
+
+			if err := m.printRevision(enc, &out, opts); err != nil {
+				return err
+			}
+		}
+		last = current
+	}
+}
+
+// printRevision prints a revision found by Watch: one JSON object per line with FormatJSON, otherwise a
+// summary in the log followed by the diff, or by the content in the format of opts.
+func (m *TimeMachine) printRevision(enc *json.Encoder, out *watchOutput, opts Options) error {
+	if opts.Format == FormatJSON {
+		return enc.Encode(out)
+	}
+
+	summary := fmt.Sprintf("TX=%d at %s wrote %d bytes, SHA-256 %s", out.Tx, out.Time.Format(time.RFC3339), out.Size, out.SHA256)
+	if out.WrittenBy != nil {
+		summary += ": " + out.WrittenBy.String()
+	}
+	if out.Signature != nil {
+		summary += ", signed by " + signerOf(&out.revisionOutput)
+	}
+
+	switch {
+	case out.Binary:
+		m.log.Infof("%s (binary, not diffed)", summary)
+	case out.Diff != "" || out.Content == nil:
+		m.log.Info(summary)
+		_, err := fmt.Fprint(os.Stdout, out.Diff)
+
+		return err
+	case opts.Format != FormatHex:
+		m.log.Info(summary)
+
+		return printContent(os.Stdout, opts.Format, out.Content, nil)
+	case opts.Text:
+		m.log.Infof("%s:\n%s", summary, string(out.Content))
+	default:
+		m.log.Infof("%s:\n%s", summary, hex.EncodeToString(out.Content))
+	}
+
+	return nil
+}
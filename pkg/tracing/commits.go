@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+
+	"github.com/codenotary/immudb/pkg/api/schema"
+	"github.com/codenotary/immudb/pkg/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// The driver of immudb drops the headers of the transactions it commits: they are caught on their way back from the
+// server instead, by RecordCommits, and kept by session until the span of the statement or transaction takes them.
+var committed sync.Map // session ID -> uint64
+
+// RecordCommits is a gRPC interceptor of the immudb clients which keeps the identifier of the transaction last
+// committed by each session, recorded as db.tx by the spans of the connections of WrapConnector.
+func RecordCommits(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if err := invoker(ctx, method, req, reply, cc, opts...); err != nil {
+		return err
+	}
+
+	var header *schema.TxHeader
+	switch r := reply.(type) {
+	case *schema.CommittedSQLTx:
+		header = r.GetHeader()
+	case *schema.SQLExecResult:
+		if n := len(r.GetTxs()); n > 0 {
+			header = r.GetTxs()[n-1].GetHeader()
+		}
+	}
+	if header == nil {
+		return nil
+	}
+	// Set by the client of the session, which runs its interceptors before those of the options.
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if sessions := md.Get("sessionid"); len(sessions) > 0 {
+			committed.Store(sessions[0], header.GetId())
+		}
+	}
+
+	return nil
+}
+
+// sessionID returns the immudb session of a connection of the driver, if any.
+func sessionID(c driver.Conn) (string, bool) {
+	cl, ok := c.(interface{ GetImmuClient() client.ImmuClient })
+	if !ok {
+		return "", false
+	}
+	session, ok := cl.GetImmuClient().(interface{ GetSessionID() string })
+	if !ok {
+		return "", false
+	}
+	id := session.GetSessionID()
+
+	return id, id != ""
+}
+
+// takeCommitted returns, and forgets, the transaction last committed by the session of a connection.
+func takeCommitted(c driver.Conn) (uint64, bool) {
+	id, ok := sessionID(c)
+	if !ok {
+		return 0, false
+	}
+	tx, ok := committed.LoadAndDelete(id)
+	if !ok {
+		return 0, false
+	}
+
+	return tx.(uint64), true
+}
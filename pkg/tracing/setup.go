@@ -0,0 +1,34 @@
+package tracing
+
+import (
+	"immufs/pkg/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Setup sets the exporters enabled by cfg: the OTLP collector and the slow operation log. It returns whether spans
+// are recorded, i.e. whether the filesystem operations must be traced.
+func Setup(cfg *config.Config, logger *logrus.Logger) (bool, error) {
+	var exporters multiExporter
+	if cfg.OTLPEndpoint != "" {
+		e, err := NewOTLPExporter(cfg.OTLPEndpoint, "immufs", logger)
+		if err != nil {
+			return false, err
+		}
+		exporters = append(exporters, e)
+	}
+	if cfg.SlowOpThreshold > 0 {
+		exporters = append(exporters, NewSlowLogger(cfg.SlowOpThreshold, logger))
+	}
+
+	switch len(exporters) {
+	case 0:
+		return false, nil
+	case 1:
+		SetExporter(exporters[0])
+	default:
+		SetExporter(exporters)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,46 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SlowLogger logs the operations and the queries lasting longer than a threshold, with their attributes, so that
+// pathological directories or a slow immudb can be spotted without a collector. The log entries of the spans of a
+// same operation share their trace id.
+type SlowLogger struct {
+	threshold time.Duration
+	log       *logrus.Entry
+}
+
+// NewSlowLogger returns an exporter logging the spans lasting longer than threshold.
+func NewSlowLogger(threshold time.Duration, logger *logrus.Logger) *SlowLogger {
+	return &SlowLogger{
+		threshold: threshold,
+		log:       logger.WithField("component", "slow-op"),
+	}
+}
+
+func (l *SlowLogger) ExportSpan(s *Span) {
+	d := s.Duration()
+	if d < l.threshold {
+		return
+	}
+
+	fields := logrus.Fields{
+		"duration": d.String(),
+		"trace":    hex.EncodeToString(s.TraceID[:]),
+	}
+	if root := s.Root(); root != s {
+		fields["within"] = root.Name
+	}
+	for _, a := range s.Attributes {
+		fields[a.Key] = a.Value
+	}
+	if s.Err != nil {
+		fields["error"] = s.Err.Error()
+	}
+	l.log.WithFields(fields).Warnf("slow %s", s.Name)
+}
@@ -0,0 +1,158 @@
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// WrapConnector instruments the connections opened by c: every query, statement and transaction run through
+// them gets a span. The connections of the driver are available through the Unwrap method of the wrappers.
+func WrapConnector(c driver.Connector) driver.Connector {
+	return &connector{c}
+}
+
+type connector struct {
+	driver.Connector
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{cn}, nil
+}
+
+type conn struct {
+	driver.Conn
+}
+
+// Unwrap returns the connection of the driver.
+func (c *conn) Unwrap() driver.Conn {
+	return c.Conn
+}
+
+// queryAttributes describes a statement and its arguments. Byte arguments, e.g. file contents, are
+// described by their size.
+func queryAttributes(query string, args []driver.NamedValue) []Attribute {
+	values := make([]string, len(args))
+	for i, arg := range args {
+		if b, ok := arg.Value.([]byte); ok {
+			values[i] = fmt.Sprintf("<%d bytes>", len(b))
+		} else {
+			values[i] = fmt.Sprint(arg.Value)
+		}
+	}
+
+	return []Attribute{
+		String("db.system", "immudb"),
+		String("db.statement", query),
+		String("db.args", "["+strings.Join(values, " ")+"]"),
+	}
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	_, span := Start(ctx, "immudb.Exec", KindClient, queryAttributes(query, args)...)
+	res, err := execer.ExecContext(ctx, query, args)
+	c.recordCommitted(span, err)
+	span.Finish(err)
+
+	return res, err
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	_, span := Start(ctx, "immudb.Query", KindClient, queryAttributes(query, args)...)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	span.Finish(err)
+
+	return rows, err
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	_, span := Start(ctx, "immudb.Tx", KindClient, String("db.system", "immudb"))
+
+	var t driver.Tx
+	var err error
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		t, err = b.BeginTx(ctx, opts)
+	} else {
+		t, err = c.Conn.Begin()
+	}
+	if err != nil {
+		span.Finish(err)
+
+		return nil, err
+	}
+	// Its statements commit nothing until it is.
+	takeCommitted(c.Conn)
+
+	return &tx{Tx: t, conn: c, span: span}, nil
+}
+
+// recordCommitted sets the transaction committed by the last statement or transaction of the connection, if any, on
+// its span.
+func (c *conn) recordCommitted(span *Span, err error) {
+	tx, ok := takeCommitted(c.Conn)
+	if ok && err == nil {
+		span.SetAttributes(Int64("db.tx", int64(tx)))
+	}
+}
+
+func (c *conn) Close() error {
+	takeCommitted(c.Conn)
+
+	return c.Conn.Close()
+}
+
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+
+	return driver.ErrSkip
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+
+	return nil
+}
+
+// tx ends the span of a transaction when it is committed or rolled back.
+type tx struct {
+	driver.Tx
+	conn *conn
+	span *Span
+}
+
+func (t *tx) Commit() error {
+	err := t.Tx.Commit()
+	t.conn.recordCommitted(t.span, err)
+	t.span.SetAttributes(String("db.outcome", "commit"))
+	t.span.Finish(err)
+
+	return err
+}
+
+func (t *tx) Rollback() error {
+	err := t.Tx.Rollback()
+	t.span.SetAttributes(String("db.outcome", "rollback"))
+	t.span.Finish(err)
+
+	return err
+}
@@ -0,0 +1,184 @@
+// Package tracing records the operations of the filesystem as spans, so that a slow path resolution or a slow
+// immudb query can be followed from the FUSE operation down to the queries it runs. Spans are exported to an
+// OpenTelemetry collector over OTLP/HTTP, or logged when slow. Nothing is recorded until an exporter is set.
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Kind tells the role of a span in a trace, with the values of OpenTelemetry.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Attribute is a key and a value describing a span. Values are strings, int64 or bools.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a timed operation. Spans started within a span, through its context, are its children.
+type Span struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	// Zero for the root span of a trace.
+	ParentID [8]byte
+
+	Name       string
+	Kind       Kind
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	// Error the operation failed with, nil if it succeeded.
+	Err error
+
+	parent *Span
+}
+
+// Exporter receives the spans as they end. ExportSpan must not block.
+type Exporter interface {
+	ExportSpan(s *Span)
+}
+
+type exporterHolder struct {
+	e Exporter
+}
+
+var exporter atomic.Pointer[exporterHolder]
+
+// multiExporter hands the spans to several exporters.
+type multiExporter []Exporter
+
+func (m multiExporter) ExportSpan(s *Span) {
+	for _, e := range m {
+		e.ExportSpan(s)
+	}
+}
+
+func (m multiExporter) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, e := range m {
+		if s, ok := e.(interface{ Shutdown(context.Context) error }); ok {
+			errs = append(errs, s.Shutdown(ctx))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// SetExporter sets the exporter receiving all the spans, nil to stop recording them.
+func SetExporter(e Exporter) {
+	if e == nil {
+		exporter.Store(nil)
+
+		return
+	}
+	exporter.Store(&exporterHolder{e: e})
+}
+
+// Shutdown stops recording spans, and flushes those not exported yet if the exporter buffers them.
+func Shutdown(ctx context.Context) error {
+	h := exporter.Swap(nil)
+	if h == nil {
+		return nil
+	}
+	if s, ok := h.e.(interface{ Shutdown(context.Context) error }); ok {
+		return s.Shutdown(ctx)
+	}
+
+	return nil
+}
+
+type spanKey struct{}
+
+// Start starts a span, child of the span of ctx if any, and returns a context carrying it. The span is nil,
+// and ctx returned as is, when spans are not recorded: the methods of Span accept a nil receiver.
+func Start(ctx context.Context, name string, kind Kind, attrs ...Attribute) (context.Context, *Span) {
+	if exporter.Load() == nil {
+		return ctx, nil
+	}
+
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: attrs,
+	}
+	binary.LittleEndian.PutUint64(s.SpanID[:], rand.Uint64())
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		s.TraceID = parent.TraceID
+		s.ParentID = parent.SpanID
+		s.parent = parent
+	} else {
+		binary.LittleEndian.PutUint64(s.TraceID[:8], rand.Uint64())
+		binary.LittleEndian.PutUint64(s.TraceID[8:], rand.Uint64())
+	}
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Detach returns a background context carrying the span of ctx only, for the work done on behalf of an operation
+// which must not be cancelled with it.
+func Detach(ctx context.Context) context.Context {
+	if s, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return context.WithValue(context.Background(), spanKey{}, s)
+	}
+
+	return context.Background()
+}
+
+// SetAttributes adds attributes to the span, e.g. those known once the operation completed.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.Attributes = append(s.Attributes, attrs...)
+}
+
+// Finish ends the span with the outcome of the operation, and hands it to the exporter.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	s.Err = err
+	if h := exporter.Load(); h != nil {
+		h.e.ExportSpan(s)
+	}
+}
+
+// Root returns the span of the operation the span is part of, e.g. the FUSE operation running a query.
+func (s *Span) Root() *Span {
+	for s.parent != nil {
+		s = s.parent
+	}
+
+	return s
+}
+
+// Duration returns how long the operation took.
+func (s *Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
@@ -0,0 +1,289 @@
+// Package volume implements the Docker volume plugin API on top of a mounted immufs filesystem.
+package volume
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Content type of the requests and responses of the plugin API.
+const contentType = "application/vnd.docker.plugins.v1.2+json"
+
+// Volume names accepted by Docker. Hidden directories, such as .immufs and .trash, can't be volumes.
+var volumeNameRE = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// Plugin serves the volumes of a filesystem mounted at a base directory: every directory at the root of the
+// filesystem is a volume, bind-mounted by Docker into the containers using it. Volumes are created and removed
+// through the mount, so that the kernel caches stay consistent, and quotas, --trash and --worm apply.
+type Plugin struct {
+	base string
+	log  *logrus.Entry
+
+	mu sync.Mutex
+	// IDs of the mounts of every volume, by name. Volumes in use can't be removed.
+	mounts map[string]map[string]bool
+}
+
+// NewPlugin returns the plugin serving the volumes of the filesystem mounted at base.
+func NewPlugin(base string, logger *logrus.Logger) *Plugin {
+	return &Plugin{
+		base:   base,
+		log:    logger.WithField("component", "volume"),
+		mounts: make(map[string]map[string]bool),
+	}
+}
+
+type request struct {
+	Name string
+	ID   string
+	Opts map[string]string
+}
+
+type volume struct {
+	Name       string
+	Mountpoint string         `json:",omitempty"`
+	CreatedAt  string         `json:",omitempty"`
+	Status     map[string]any `json:",omitempty"`
+}
+
+type response struct {
+	Mountpoint   string            `json:",omitempty"`
+	Volume       *volume           `json:",omitempty"`
+	Volumes      []volume          `json:",omitempty"`
+	Capabilities map[string]string `json:",omitempty"`
+	Implements   []string          `json:",omitempty"`
+	Err          string
+}
+
+func (p *Plugin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.log.Infof("--> %s", r.URL.Path)
+
+	var req request
+	if r.Method != http.MethodPost {
+		writeResponse(w, http.StatusMethodNotAllowed, &response{Err: "method not allowed"})
+
+		return
+	}
+	// Some calls, e.g. Plugin.Activate, come without a body.
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeResponse(w, http.StatusBadRequest, &response{Err: fmt.Sprintf("invalid request: %s", err)})
+
+		return
+	}
+
+	resp := &response{}
+	var err error
+	switch r.URL.Path {
+	case "/Plugin.Activate":
+		resp.Implements = []string{"VolumeDriver"}
+	case "/VolumeDriver.Capabilities":
+		resp.Capabilities = map[string]string{"Scope": "local"}
+	case "/VolumeDriver.Create":
+		err = p.create(req)
+	case "/VolumeDriver.Remove":
+		err = p.remove(req)
+	case "/VolumeDriver.Mount":
+		resp.Mountpoint, err = p.mount(req)
+	case "/VolumeDriver.Unmount":
+		err = p.unmount(req)
+	case "/VolumeDriver.Path":
+		resp.Mountpoint, err = p.path(req.Name)
+	case "/VolumeDriver.Get":
+		resp.Volume, err = p.get(req.Name)
+	case "/VolumeDriver.List":
+		resp.Volumes, err = p.list()
+	default:
+		writeResponse(w, http.StatusNotFound, &response{Err: "unknown call " + r.URL.Path})
+
+		return
+	}
+	if err != nil {
+		p.log.Warnf("%s %s: %s", r.URL.Path, req.Name, err)
+		resp.Err = err.Error()
+	}
+	writeResponse(w, http.StatusOK, resp)
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp *response) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// dir returns the directory of a volume, within the mount.
+func (p *Plugin) dir(name string) (string, error) {
+	if !volumeNameRE.MatchString(name) {
+		return "", fmt.Errorf("invalid volume name %q", name)
+	}
+
+	return filepath.Join(p.base, name), nil
+}
+
+func (p *Plugin) create(req request) error {
+	if len(req.Opts) > 0 {
+		return errors.New("volumes take no option")
+	}
+	dir, err := p.dir(req.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (p *Plugin) remove(req request) error {
+	dir, err := p.dir(req.Name)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.mounts[req.Name]) > 0 {
+		return fmt.Errorf("volume %s is in use", req.Name)
+	}
+
+	return os.RemoveAll(dir)
+}
+
+func (p *Plugin) mount(req request) (string, error) {
+	dir, err := p.path(req.Name)
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.mounts[req.Name] == nil {
+		p.mounts[req.Name] = make(map[string]bool)
+	}
+	p.mounts[req.Name][req.ID] = true
+
+	return dir, nil
+}
+
+func (p *Plugin) unmount(req request) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.mounts[req.Name], req.ID)
+	if len(p.mounts[req.Name]) == 0 {
+		delete(p.mounts, req.Name)
+	}
+
+	return nil
+}
+
+// path returns the directory of an existing volume.
+func (p *Plugin) path(name string) (string, error) {
+	dir, err := p.dir(name)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("no such volume: %s", name)
+		}
+
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("no such volume: %s", name)
+	}
+
+	return dir, nil
+}
+
+func (p *Plugin) get(name string) (*volume, error) {
+	dir, err := p.path(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return &volume{
+		Name:       name,
+		Mountpoint: dir,
+		CreatedAt:  fi.ModTime().Format(time.RFC3339),
+		Status:     map[string]any{"mounts": len(p.mounts[name])},
+	}, nil
+}
+
+func (p *Plugin) list() ([]volume, error) {
+	entries, err := os.ReadDir(p.base)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := []volume{}
+	for _, e := range entries {
+		if e.IsDir() && volumeNameRE.MatchString(e.Name()) {
+			volumes = append(volumes, volume{Name: e.Name(), Mountpoint: filepath.Join(p.base, e.Name())})
+		}
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].Name < volumes[j].Name })
+
+	return volumes, nil
+}
+
+// Serve runs the plugin on a unix socket until ctx is done, e.g. /run/docker/plugins/immufs.sock where Docker
+// discovers it. A socket left over by a previous run is replaced.
+func (p *Plugin) Serve(ctx context.Context, socket string) error {
+	if conn, err := net.Dial("unix", socket); err == nil {
+		conn.Close()
+
+		return fmt.Errorf("%s is in use by another process", socket)
+	}
+	if err := os.MkdirAll(filepath.Dir(socket), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(socket)
+
+	server := &http.Server{
+		Handler:           p,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	p.log.Infof("serving the Docker volume plugin on %s, volumes in %s", socket, p.base)
+	if err := server.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,19 @@
+module github.com/jacobsa/fuse
+
+go 1.19
+
+require (
+	github.com/detailyang/go-fallocate v0.0.0-20180908115635-432fa640bd2e
+	github.com/jacobsa/oglematchers v0.0.0-20150720000706-141901ea67cd
+	github.com/jacobsa/ogletest v0.0.0-20170503003838-80d50a735a11
+	github.com/jacobsa/syncutil v0.0.0-20180201203307-228ac8e5a6c3
+	github.com/jacobsa/timeutil v0.0.0-20170205232429-577e5acbbcf6
+	github.com/kylelemons/godebug v1.1.0
+	golang.org/x/net v0.0.0-20220526153639-5463443f8c37
+	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a
+)
+
+require (
+	github.com/jacobsa/oglemock v0.0.0-20150831005832-e94d794d06ff // indirect
+	github.com/jacobsa/reqtrace v0.0.0-20150505043853-245c9e0234cb // indirect
+)
@@ -0,0 +1,126 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Added by the fork of immufs, which its go.mod points to with a replace
+// directive: upstream doesn't expose the notifications yet.
+
+package fuse
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/jacobsa/fuse/fuseops"
+	"github.com/jacobsa/fuse/internal/buffer"
+	"github.com/jacobsa/fuse/internal/fusekernel"
+)
+
+// InvalidateNode tells the kernel to drop the attributes of the given inode,
+// and the cached content in [off, off+size) (up to the end of the file if
+// size is 0). A negative off only drops the attributes.
+//
+// It returns ENOENT if the kernel doesn't know the inode.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) InvalidateNode(
+	inode fuseops.InodeID,
+	off int64,
+	size int64) error {
+	out := fusekernel.NotifyInvalInodeOut{
+		Ino: uint64(inode),
+		Off: off,
+		Len: size,
+	}
+
+	return c.notify(
+		fusekernel.NotifyCodeInvalInode,
+		unsafe.Pointer(&out),
+		int(unsafe.Sizeof(out)),
+		"")
+}
+
+// InvalidateEntry tells the kernel to drop the lookup of name in the given
+// directory, and the attributes of the directory.
+//
+// It returns ENOENT if the kernel doesn't know the entry.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) InvalidateEntry(
+	parent fuseops.InodeID,
+	name string) error {
+	out := fusekernel.NotifyInvalEntryOut{
+		Parent:  uint64(parent),
+		Namelen: uint32(len(name)),
+	}
+
+	return c.notify(
+		fusekernel.NotifyCodeInvalEntry,
+		unsafe.Pointer(&out),
+		int(unsafe.Sizeof(out)),
+		name)
+}
+
+// NotifyDelete tells the kernel that name, pointing to child, was removed
+// from the given directory: unlike InvalidateEntry, the inotify watchers are
+// notified of the removal.
+//
+// It returns ENOENT if the kernel doesn't know the entry.
+//
+// LOCKS_EXCLUDED(c.mu)
+func (c *Connection) NotifyDelete(
+	parent fuseops.InodeID,
+	child fuseops.InodeID,
+	name string) error {
+	out := fusekernel.NotifyDeleteOut{
+		Parent:  uint64(parent),
+		Child:   uint64(child),
+		Namelen: uint32(len(name)),
+	}
+
+	return c.notify(
+		fusekernel.NotifyCodeDelete,
+		unsafe.Pointer(&out),
+		int(unsafe.Sizeof(out)),
+		name)
+}
+
+// notify writes a notification to the kernel: a header without unique ID,
+// whose error field holds the code, the payload, and the name if any,
+// NUL-terminated.
+func (c *Connection) notify(
+	code int32,
+	payload unsafe.Pointer,
+	size int,
+	name string) error {
+	if !c.protocol.HasInvalidate() {
+		return syscall.ENOSYS
+	}
+
+	n := buffer.OutMessageHeaderSize + size
+	if name != "" {
+		n += len(name) + 1
+	}
+
+	msg := make([]byte, n)
+	h := (*fusekernel.OutHeader)(unsafe.Pointer(&msg[0]))
+	h.Len = uint32(n)
+	h.Error = code
+
+	copy(msg[buffer.OutMessageHeaderSize:], unsafe.Slice((*byte)(payload), size))
+	if name != "" {
+		copy(msg[buffer.OutMessageHeaderSize+size:], name)
+	}
+
+	return c.writeMessage(msg)
+}
@@ -0,0 +1,6 @@
+//go:build go1.8
+// +build go1.8
+
+package memfs_test
+
+const atLeastGo18 = true